@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"os/signal"
 	"runtime"
 	"runtime/pprof"
 	"sync"
 	"syscall"
+	"time"
 
 	quicwire "github.com/packetdrop/quicwire/internal"
 	"github.com/urfave/cli/v2"
@@ -49,24 +52,90 @@ func qnetRun(cCtx *cli.Context, logger *zap.Logger) error {
 		}
 	}
 
+	configFiles := cCtx.StringSlice("config-file")
+	if cCtx.Bool("encrypt-config") {
+		plaintext, err := os.ReadFile(configFiles[0])
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		key := os.Getenv(quicwire.ConfigKeyEnv)
+		if key == "" {
+			logger.Fatal(quicwire.ConfigKeyEnv + " must hold the passphrase to encrypt the config with")
+		}
+		sealed, err := quicwire.EncryptConfig(plaintext, []byte(key))
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		_, err = os.Stdout.Write(sealed)
+		return err
+	}
+
 	quicwire, err := quicwire.NewQuicWire(
 		logger.Sugar(),
-		cCtx.String("config-file"),
+		configFiles[0],
 		cCtx.Bool("disable-client"),
 		cCtx.Bool("disable-server"),
 	)
 	if err != nil {
 		logger.Fatal(err.Error())
 	}
+	for _, configFile := range configFiles[1:] {
+		quicwire.AddConfigFile(configFile)
+	}
+	if err := quicwire.SetConfigConflicts(cCtx.String("config-conflicts")); err != nil {
+		logger.Fatal(err.Error())
+	}
+	quicwire.SetConfigLimits(cCtx.Int64("config-max-size"), cCtx.Duration("config-timeout"))
+
+	if cCtx.Bool("diagnose") {
+		report, err := quicwire.Diagnose(ctx)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	if peer := cCtx.String("connect-once"); peer != "" {
+		ctx, cancel := context.WithTimeout(ctx, cCtx.Duration("connect-timeout"))
+		defer cancel()
+		if err := quicwire.ConnectOnce(ctx, peer, cCtx.Bool("ping")); err != nil {
+			return cli.Exit(err.Error(), 1)
+		}
+		return nil
+	}
 
 	wg := &sync.WaitGroup{}
 
 	if err := quicwire.Start(ctx, wg); err != nil {
 		logger.Fatal(err.Error())
 	}
-	<-ctx.Done()
+	// SIGHUP reloads the peers of the config files
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+run:
+	for {
+		select {
+		case <-ctx.Done():
+			break run
+		case <-quicwire.Done():
+			break run
+		case <-hup:
+			if _, err := quicwire.Reload(); err != nil {
+				logger.Error("Failed to reload the config", zap.Error(err))
+			}
+		}
+	}
 	quicwire.Stop()
 	wg.Wait()
+	if err := quicwire.Err(); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
 
 	return nil
 }
@@ -93,16 +162,37 @@ func main() {
 	cli.HelpFlag.(*cli.BoolFlag).Usage = "Show help"
 	// flags are stored in the global flags variable
 	app := &cli.App{
-		Name:  "qw",
-		Usage: "Agent to configure encrypted mesh networking using QUIC protocol.",
+		Name:    "qw",
+		Usage:   "Agent to configure encrypted mesh networking using QUIC protocol.",
+		Version: quicwire.Version,
 		Flags: []cli.Flag{
-			&cli.StringFlag{
+			&cli.StringSliceFlag{
 				Name:     "config-file",
-				Value:    "",
-				Usage:    "Quic network configuration file",
+				Usage:    "Quic network configuration file, repeat it to layer files with the later ones taking precedence",
 				Required: true,
 				Category: tunnelOptions,
 			},
+			&cli.StringFlag{
+				Name:     "config-conflicts",
+				Value:    "ignore",
+				Usage:    "Handling of config sources setting a key to different values: ignore, warn or error",
+				Required: false,
+				Category: tunnelOptions,
+			},
+			&cli.Int64Flag{
+				Name:     "config-max-size",
+				Value:    1 << 20,
+				Usage:    "Largest size in bytes of each config file",
+				Required: false,
+				Category: tunnelOptions,
+			},
+			&cli.DurationFlag{
+				Name:     "config-timeout",
+				Value:    5 * time.Second,
+				Usage:    "Longest time spent reading each config file",
+				Required: false,
+				Category: tunnelOptions,
+			},
 			&cli.BoolFlag{
 				Name:     "disable-client",
 				Value:    false,
@@ -117,6 +207,41 @@ func main() {
 				Required: false,
 				Category: tunnelOptions,
 			},
+			&cli.BoolFlag{
+				Name:     "encrypt-config",
+				Value:    false,
+				Usage:    "Print the config file encrypted with the passphrase in " + quicwire.ConfigKeyEnv + " and exit",
+				Required: false,
+				Category: miscOptions,
+			},
+			&cli.BoolFlag{
+				Name:     "diagnose",
+				Value:    false,
+				Usage:    "Print a NAT and peer connectivity report without starting the tunnel",
+				Required: false,
+				Category: miscOptions,
+			},
+			&cli.StringFlag{
+				Name:     "connect-once",
+				Value:    "",
+				Usage:    "Start the node, wait for the peer with this allowed IP to connect, then stop and exit non-zero on failure",
+				Required: false,
+				Category: miscOptions,
+			},
+			&cli.DurationFlag{
+				Name:     "connect-timeout",
+				Value:    30 * time.Second,
+				Usage:    "Time --connect-once waits for the peer",
+				Required: false,
+				Category: miscOptions,
+			},
+			&cli.BoolFlag{
+				Name:     "ping",
+				Value:    false,
+				Usage:    "With --connect-once, also require the peer to echo a probe",
+				Required: false,
+				Category: miscOptions,
+			},
 			&cli.StringFlag{
 				Name:     "cpuprofile",
 				Value:    "",
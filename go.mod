@@ -7,6 +7,8 @@ require github.com/quic-go/quic-go v0.34.0
 require (
 	github.com/cenkalti/backoff/v4 v4.2.1
 	github.com/songgao/water v0.0.0-20200317203138-2b4b6d7c09d8
+	go.opentelemetry.io/otel v1.16.0
+	go.opentelemetry.io/otel/trace v1.16.0
 )
 
 require (
@@ -33,10 +35,10 @@ require (
 	github.com/sirupsen/logrus v1.9.0
 	github.com/urfave/cli/v2 v2.25.3
 	go.uber.org/zap v1.24.0
-	golang.org/x/crypto v0.8.0 // indirect
+	golang.org/x/crypto v0.8.0
 	golang.org/x/exp v0.0.0-20230425010034-47ecfdc1ba53 // indirect
 	golang.org/x/mod v0.10.0 // indirect
 	golang.org/x/net v0.10.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/sys v0.8.0
 	golang.org/x/tools v0.9.0 // indirect
 )
@@ -0,0 +1,149 @@
+package quicwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IP protocol numbers matched by ACL rules
+const (
+	protoICMP = 1
+	protoTCP  = 6
+	protoUDP  = 17
+)
+
+// portRange is an inclusive range of L4 ports
+type portRange struct {
+	lo, hi uint16
+}
+
+var anyPort = portRange{lo: 0, hi: 65535}
+
+func (r portRange) contains(port uint16) bool {
+	return port >= r.lo && port <= r.hi
+}
+
+func (r portRange) isAny() bool {
+	return r == anyPort
+}
+
+// aclRule matches packets by protocol and source and destination port ranges
+type aclRule struct {
+	allow bool
+	// proto is the IP protocol, 0 matches every protocol
+	proto uint8
+	src   portRange
+	dst   portRange
+}
+
+// acl filters the packets received from a peer. Rules are evaluated in
+// order and the first match decides, packets matching no rule are denied.
+// An empty ACL allows everything.
+type acl []aclRule
+
+// parseACL parses rules separated by semicolons, each of the form
+// "<allow|deny> <tcp|udp|icmp|any> [src ports] [dst ports]" where ports are
+// "any", a single port or a range such as 1024-65535
+func parseACL(value string) (acl, error) {
+	var rules acl
+	for _, ruleStr := range strings.Split(value, ";") {
+		fields := strings.Fields(ruleStr)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("invalid ACL rule %q", strings.TrimSpace(ruleStr))
+		}
+		rule := aclRule{src: anyPort, dst: anyPort}
+		switch fields[0] {
+		case "allow":
+			rule.allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("invalid ACL action %q", fields[0])
+		}
+		switch fields[1] {
+		case "any":
+		case "tcp":
+			rule.proto = protoTCP
+		case "udp":
+			rule.proto = protoUDP
+		case "icmp":
+			rule.proto = protoICMP
+		default:
+			return nil, fmt.Errorf("invalid ACL protocol %q", fields[1])
+		}
+		var err error
+		if len(fields) > 2 {
+			if rule.src, err = parsePortRange(fields[2]); err != nil {
+				return nil, err
+			}
+		}
+		if len(fields) > 3 {
+			if rule.dst, err = parsePortRange(fields[3]); err != nil {
+				return nil, err
+			}
+		}
+		if rule.proto != protoTCP && rule.proto != protoUDP && (!rule.src.isAny() || !rule.dst.isAny()) {
+			return nil, fmt.Errorf("ACL rule %q matches ports of a protocol without ports", strings.TrimSpace(ruleStr))
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parsePortRange parses "any", "443" or "1024-65535"
+func parsePortRange(s string) (portRange, error) {
+	if s == "any" {
+		return anyPort, nil
+	}
+	loStr, hiStr, isRange := strings.Cut(s, "-")
+	lo, err := strconv.ParseUint(loStr, 10, 16)
+	if err != nil {
+		return portRange{}, fmt.Errorf("invalid port %q", loStr)
+	}
+	hi := lo
+	if isRange {
+		if hi, err = strconv.ParseUint(hiStr, 10, 16); err != nil {
+			return portRange{}, fmt.Errorf("invalid port %q", hiStr)
+		}
+	}
+	if lo > hi {
+		return portRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return portRange{lo: uint16(lo), hi: uint16(hi)}, nil
+}
+
+// allows reports whether an IPv4 packet passes the ACL
+func (a acl) allows(packet []byte) bool {
+	if len(a) == 0 {
+		return true
+	}
+	// The ports follow the options, if any
+	headerLen, ok := ipv4HeaderLength(packet)
+	if !ok {
+		return false
+	}
+	proto := packet[9]
+	hasPorts := (proto == protoTCP || proto == protoUDP) && len(packet) >= headerLen+4
+	var src, dst uint16
+	if hasPorts {
+		src = binary.BigEndian.Uint16(packet[headerLen:])
+		dst = binary.BigEndian.Uint16(packet[headerLen+2:])
+	}
+
+	for _, rule := range a {
+		if rule.proto != 0 && rule.proto != proto {
+			continue
+		}
+		if !rule.src.isAny() || !rule.dst.isAny() {
+			if !hasPorts || !rule.src.contains(src) || !rule.dst.contains(dst) {
+				continue
+			}
+		}
+		return rule.allow
+	}
+	return false
+}
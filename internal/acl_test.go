@@ -0,0 +1,141 @@
+package quicwire
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+// tcpPacket builds an IPv4 packet carrying a TCP header with the given ports
+func tcpPacket(src, dst uint16) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:], src)
+	binary.BigEndian.PutUint16(header[2:], dst)
+	header[12] = 5 << 4
+	return packettest.IPv4(netip.MustParseAddr("10.1.0.1"), netip.MustParseAddr("10.0.0.1"), protoTCP, nil, header)
+}
+
+// udpPacket builds an IPv4 packet carrying a UDP datagram with the given ports
+func udpPacket(src, dst uint16) []byte {
+	return packettest.UDP(netip.AddrPortFrom(netip.MustParseAddr("10.1.0.1"), src),
+		netip.AddrPortFrom(netip.MustParseAddr("10.0.0.1"), dst), nil)
+}
+
+func TestACLPortRanges(t *testing.T) {
+	rules, err := parseACL("allow udp 1024-65535 53; allow tcp 32768-60999 443; deny any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		packet []byte
+		allow  bool
+	}{
+		{name: "udp source inside the range", packet: udpPacket(40000, 53), allow: true},
+		{name: "udp source at the range start", packet: udpPacket(1024, 53), allow: true},
+		{name: "udp source below the range", packet: udpPacket(1023, 53)},
+		{name: "udp other destination", packet: udpPacket(40000, 54)},
+		{name: "tcp source inside the range", packet: tcpPacket(50000, 443), allow: true},
+		{name: "tcp source at the range end", packet: tcpPacket(60999, 443), allow: true},
+		{name: "tcp source above the range", packet: tcpPacket(61000, 443)},
+		{name: "tcp ports of the udp rule", packet: tcpPacket(40000, 53)},
+		{name: "icmp", packet: packettest.IPv4(netip.MustParseAddr("10.1.0.1"), netip.MustParseAddr("10.0.0.1"), protoICMP, nil, make([]byte, 8))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.allows(tt.packet); got != tt.allow {
+				t.Errorf("allows = %v, want %v", got, tt.allow)
+			}
+		})
+	}
+}
+
+// optionsPacket builds an IPv4 packet with header options carrying the first
+// bytes of a TCP or UDP header with the given ports
+func optionsPacket(proto byte, options []byte, src, dst uint16) []byte {
+	header := make([]byte, 20)
+	binary.BigEndian.PutUint16(header[0:], src)
+	binary.BigEndian.PutUint16(header[2:], dst)
+	return packettest.IPv4(netip.MustParseAddr("10.1.0.1"), netip.MustParseAddr("10.0.0.1"), proto, options, header)
+}
+
+func TestACLIPv4Options(t *testing.T) {
+	rules, err := parseACL("deny udp any 53; allow any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A router alert option, the ports are only found after it
+	routerAlert := []byte{0x94, 0x04, 0, 0}
+	truncated := optionsPacket(protoUDP, routerAlert, 40000, 54)
+	truncated[0] = 0x4f
+	tests := []struct {
+		name   string
+		packet []byte
+		allow  bool
+	}{
+		{name: "udp without options", packet: udpPacket(40000, 53)},
+		{name: "udp with options", packet: optionsPacket(protoUDP, routerAlert, 40000, 53)},
+		{name: "udp with padded options", packet: optionsPacket(protoUDP, []byte{0x01, 0x01, 0x01, 0x01, 0x01}, 40000, 53)},
+		{name: "udp with options to another port", packet: optionsPacket(protoUDP, routerAlert, 40000, 54), allow: true},
+		{name: "tcp with options", packet: optionsPacket(protoTCP, routerAlert, 40000, 53), allow: true},
+		{name: "header length past the packet", packet: truncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.allows(tt.packet); got != tt.allow {
+				t.Errorf("allows = %v, want %v", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestIPv4HeaderLength(t *testing.T) {
+	plain := udpPacket(40000, 53)
+	short := append([]byte(nil), plain...)
+	short[0] = 0x44
+	tests := []struct {
+		name   string
+		packet []byte
+		want   int
+		ok     bool
+	}{
+		{name: "without options", packet: plain, want: 20, ok: true},
+		{name: "with options", packet: optionsPacket(protoUDP, []byte{0x94, 0x04, 0, 0}, 1, 2), want: 24, ok: true},
+		{name: "options padded", packet: optionsPacket(protoUDP, make([]byte, 7), 1, 2), want: 28, ok: true},
+		{name: "header length below the minimum", packet: short},
+		{name: "header length past the packet", packet: append([]byte{0x4f}, plain[1:]...)},
+		{name: "runt", packet: plain[:ipv4HeaderLen-1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ipv4HeaderLength(tt.packet)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ipv4HeaderLength = %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseACLRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		err   string
+	}{
+		{name: "reversed range", value: "allow udp 2000-1000", err: "invalid port range"},
+		{name: "port out of range", value: "allow tcp any 70000", err: "invalid port"},
+		{name: "ports of icmp", value: "allow icmp 1-2", err: "without ports"},
+		{name: "unknown action", value: "permit udp", err: "invalid ACL action"},
+		{name: "too many fields", value: "allow udp any any any", err: "invalid ACL rule"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseACL(tt.value); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package quicwire
+
+import (
+	"runtime"
+
+	"go.uber.org/zap"
+)
+
+// pinForwarding locks the calling goroutine to its OS thread and restricts the
+// thread to the given CPU cores, nothing is done when no cores are given
+func pinForwarding(cpus []int, logger *zap.SugaredLogger) {
+	if len(cpus) == 0 {
+		return
+	}
+	runtime.LockOSThread()
+	if err := setThreadAffinity(cpus); err != nil {
+		logger.Warnf("Forwarding is not pinned: %v", err)
+	}
+}
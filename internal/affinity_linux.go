@@ -0,0 +1,27 @@
+//go:build linux
+
+package quicwire
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// schedSetaffinity sets the CPU affinity of a thread, replaced by tests
+var schedSetaffinity = unix.SchedSetaffinity
+
+// setThreadAffinity restricts the calling OS thread to the given CPU cores.
+// The goroutine must be locked to its thread with runtime.LockOSThread.
+func setThreadAffinity(cpus []int) error {
+	var set unix.CPUSet
+	set.Zero()
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	// pid 0 applies the mask to the calling thread only
+	if err := schedSetaffinity(0, &set); err != nil {
+		return fmt.Errorf("failed to set the CPU affinity to %v: %w", cpus, err)
+	}
+	return nil
+}
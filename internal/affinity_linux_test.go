@@ -0,0 +1,61 @@
+//go:build linux
+
+package quicwire
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+	"golang.org/x/sys/unix"
+)
+
+func TestForwardingPinnedToCPUs(t *testing.T) {
+	var mu sync.Mutex
+	var masks []unix.CPUSet
+	orig := schedSetaffinity
+	schedSetaffinity = func(pid int, set *unix.CPUSet) error {
+		if pid != 0 {
+			t.Errorf("affinity set for pid %d, want 0 for the calling thread", pid)
+		}
+		mu.Lock()
+		masks = append(masks, *set)
+		mu.Unlock()
+		return orig(pid, set)
+	}
+	// Registered first, the hook is restored once the nodes are stopped
+	t.Cleanup(func() { schedSetaffinity = orig })
+
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.forwardingCPUs = []int{0}
+	})
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) },
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("pinned"))
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := b.sink.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Only B pins, its goroutine receiving from the peer forwarded the packet
+	if len(masks) == 0 {
+		t.Fatal("affinity never set for the forwarding goroutines")
+	}
+	for _, set := range masks {
+		if set.Count() != 1 || !set.IsSet(0) {
+			t.Errorf("affinity mask has %d cores, want only core 0", set.Count())
+		}
+	}
+}
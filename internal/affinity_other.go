@@ -0,0 +1,10 @@
+//go:build !linux
+
+package quicwire
+
+import "fmt"
+
+// setThreadAffinity is only supported on Linux
+func setThreadAffinity(cpus []int) error {
+	return fmt.Errorf("CPU affinity is not supported on this platform")
+}
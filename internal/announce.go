@@ -0,0 +1,135 @@
+package quicwire
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const (
+	// maxAnnouncedRoutes is the default of the largest number of routes adopted from a single peer
+	maxAnnouncedRoutes = 16
+	// minAnnouncedPrefixBits rejects announcements of overly broad IPv4 prefixes such as the default route
+	minAnnouncedPrefixBits = 8
+	// minAnnouncedPrefixBits6 rejects announcements of overly broad IPv6 prefixes
+	minAnnouncedPrefixBits6 = 16
+)
+
+// announcedRoutes returns the routes sent to peers in the hello, by default the tunnel address of the node
+func (qn *QuicWire) announcedRoutes() []string {
+	if routes := qn.qc.nodeInterface.announceRoutes; len(routes) > 0 {
+		return routes
+	}
+	addr, err := tunnelAddr(qn.qc.nodeInterface.localEndpoint)
+	if err != nil {
+		return nil
+	}
+	return []string{netip.PrefixFrom(addr, addr.BitLen()).String()}
+}
+
+// maxPeerRoutes returns the largest number of routes adopted from a single peer
+func (qn *QuicWire) maxPeerRoutes() int {
+	if n := qn.qc.nodeInterface.maxPeerRoutes; n > 0 {
+		return n
+	}
+	return maxAnnouncedRoutes
+}
+
+// checkRouteCount rejects announcements of more routes than a peer may
+// serve, the rejected routes are counted
+func (qn *QuicWire) checkRouteCount(routes []string) error {
+	if limit := qn.maxPeerRoutes(); len(routes) > limit {
+		qn.counters.countRejectedRoutes(len(routes))
+		return fmt.Errorf("peer announced %d routes, at most %d are accepted", len(routes), limit)
+	}
+	return nil
+}
+
+// validAnnouncedRoutes filters the routes announced by a peer through the route count and prefix guards
+func (qn *QuicWire) validAnnouncedRoutes(routes []string) ([]string, error) {
+	if err := qn.checkRouteCount(routes); err != nil {
+		return nil, err
+	}
+	var valid []string
+	for _, route := range routes {
+		cidr, err := routePrefix(route)
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid announced route %s: %w", route, err)
+		}
+		minBits := minAnnouncedPrefixBits
+		if prefix.Addr().Is6() {
+			minBits = minAnnouncedPrefixBits6
+		}
+		if prefix.Bits() < minBits {
+			return nil, fmt.Errorf("announced route %s is broader than /%d", prefix, minBits)
+		}
+		// A more specific prefix would win the longest prefix match over the peer routed now
+		if routed, ok := qn.routes.overlapping(prefix); ok {
+			return nil, fmt.Errorf("announced route %s overlaps %s routed to another peer", prefix, routed)
+		}
+		for _, v := range valid {
+			if netip.MustParsePrefix(v).Overlaps(prefix) {
+				return nil, fmt.Errorf("announced route %s overlaps announced route %s", prefix, v)
+			}
+		}
+		valid = append(valid, prefix.String())
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("peer announced no routes")
+	}
+	return valid, nil
+}
+
+// adoptAnnouncedRoutes turns a connection from an unknown peer into a learned
+// peer serving the routes it announced. The routes are removed again when the
+// connection closes.
+func (qn *QuicWire) adoptAnnouncedRoutes(c *Client, remote hello) {
+	if !qn.qc.nodeInterface.acceptAnnouncedRoutes {
+		return
+	}
+	routes, err := qn.validAnnouncedRoutes(remote.Routes)
+	if err != nil {
+		qn.logger.Warnf("Rejected routes announced by %s: %v", c.addr, err)
+		return
+	}
+	peer := Peer{
+		endpoint:   c.addr,
+		allowedIPs: routes,
+		learned:    true,
+	}
+	key := peer.allowedIPs[0]
+
+	if err := qn.routes.addPeer(peer); err != nil {
+		qn.logger.Warnf("Failed to add routes announced by %s: %v", c.addr, err)
+		return
+	}
+	if qn.localIf != nil {
+		if err := qn.installPeerRoutes(qn.localIf.Name(), peer); err != nil {
+			qn.routes.removePeer(key)
+			qn.logger.Warnf("Failed to install routes announced by %s: %v", c.addr, err)
+			return
+		}
+	}
+	qn.mu.Lock()
+	qn.qc.peers = append(qn.qc.peers, peer)
+	qn.clients[key] = c
+	qn.mu.Unlock()
+	qn.logger.Infof("Adopted routes %s announced by %s", strings.Join(routes, ","), c.addr)
+
+	go func() {
+		<-c.connection.Context().Done()
+		qn.mu.RLock()
+		current := qn.clients[key] == c
+		qn.mu.RUnlock()
+		if !current {
+			return
+		}
+		if err := qn.RemovePeer(key); err != nil {
+			qn.logger.Debugf("Failed to remove learned peer %s: %v", key, err)
+		}
+	}()
+}
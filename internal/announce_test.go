@@ -0,0 +1,103 @@
+package quicwire
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestValidAnnouncedRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string
+		// limit is MaxPeerRoutes, 0 keeps the default
+		limit int
+		want  []string
+		err   string
+		// rejected is whether the routes are counted as rejected
+		rejected bool
+	}{
+		{name: "host and prefix", routes: []string{"10.5.0.1", "10.6.0.0/16"}, want: []string{"10.5.0.1/32", "10.6.0.0/16"}},
+		{name: "IPv6", routes: []string{"fd01::/64"}, want: []string{"fd01::/64"}},
+		{name: "none", err: "no routes"},
+		{name: "invalid", routes: []string{"10.5.0.300"}, err: "10.5.0.300"},
+		{name: "default route", routes: []string{"0.0.0.0/0"}, err: "broader than /8"},
+		{name: "broad IPv6", routes: []string{"fd00::/8"}, err: "broader than /16"},
+		{name: "inside a configured prefix", routes: []string{"10.1.2.0/24"}, err: "overlaps 10.1.0.0/16"},
+		{name: "covering a configured prefix", routes: []string{"10.0.0.0/8"}, err: "overlaps"},
+		{name: "configured peer address", routes: []string{"10.0.0.2"}, err: "overlaps"},
+		{name: "overlapping each other", routes: []string{"10.5.0.0/16", "10.5.1.0/24"}, err: "overlaps announced route 10.5.0.0/16"},
+		{name: "too many", routes: strings.Split(strings.Repeat("10.5.0.1,", maxAnnouncedRoutes+1), ",")[:maxAnnouncedRoutes+1], err: "at most", rejected: true},
+		{name: "over the configured limit", routes: []string{"10.5.0.1", "10.5.0.2", "10.5.0.3"}, limit: 2, err: "at most 2", rejected: true},
+		{name: "within the configured limit", routes: []string{"10.5.0.1", "10.5.0.2"}, limit: 2, want: []string{"10.5.0.1/32", "10.5.0.2/32"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, _ := newTestQuicWire(t, Peer{allowedIPs: []string{"10.0.0.2", "10.1.0.0/16"}})
+			qn.qc.nodeInterface.maxPeerRoutes = tt.limit
+			got, err := qn.validAnnouncedRoutes(tt.routes)
+			want := uint64(0)
+			if tt.rejected {
+				want = uint64(len(tt.routes))
+			}
+			if rejected := qn.Stats().RejectedRoutes; rejected != want {
+				t.Errorf("rejected routes = %d, want %d", rejected, want)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("routes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPassiveServerRoutesReturnTraffic(t *testing.T) {
+	mesh := newMemNetwork()
+	hub := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.acceptAnnouncedRoutes = true
+	})
+	spoke := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.announceRoutes = []string{"10.5.0.0/24"}
+	}, newTestPeer(hub.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, spoke.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := hub.peerByAllowedIP("10.5.0.0/24"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hub did not adopt the route announced by the spoke")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.2:5000"), netip.MustParseAddrPort("10.5.0.7:4000"), []byte("return"))
+	if err := hub.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := spoke.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[0], packet) {
+		t.Errorf("spoke received %x, want %x", got[0], packet)
+	}
+}
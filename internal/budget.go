@@ -0,0 +1,56 @@
+package quicwire
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// errBufferBudget is returned when buffering a packet would exceed the node wide buffer budget
+var errBufferBudget = errors.New("buffer budget exhausted")
+
+// bufferBudget accounts the memory held by the datapath buffers of every
+// peer, the batches being coalesced and the packets being reassembled
+type bufferBudget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// newBufferBudget limits the buffered bytes to max, 0 only accounts them
+func newBufferBudget(max int) *bufferBudget {
+	if max < 0 {
+		max = 0
+	}
+	return &bufferBudget{max: int64(max)}
+}
+
+// reserve takes n bytes from the budget, reporting false when they would exceed it
+func (b *bufferBudget) reserve(n int) bool {
+	if b == nil {
+		return true
+	}
+	for {
+		used := b.used.Load()
+		if b.max > 0 && used+int64(n) > b.max {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+int64(n)) {
+			return true
+		}
+	}
+}
+
+// release returns n reserved bytes to the budget
+func (b *bufferBudget) release(n int) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.used.Add(-int64(n))
+}
+
+// inUse returns the bytes currently held by datapath buffers
+func (b *bufferBudget) inUse() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
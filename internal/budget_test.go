@@ -0,0 +1,55 @@
+package quicwire
+
+import "testing"
+
+func TestBufferBudgetBoundsPeers(t *testing.T) {
+	const limit = 4 * maxTunMTU
+	budget := newBufferBudget(limit)
+	data := make([]byte, 1000)
+
+	// Every peer starts the reassembly of three packets, more than the budget holds
+	peers := make([]*reassembler, 8)
+	drops := 0
+	for i := range peers {
+		peers[i] = newReassembler()
+		peers[i].budget = budget
+		peers[i].overBudget = func(int) { drops++ }
+		for p := 0; p < 3; p++ {
+			peers[i].add(fragmentFrames(data, 500, uint32(p*10))[0])
+			if used := budget.inUse(); used > limit {
+				t.Fatalf("peer %d buffers %d bytes, over the budget of %d", i, used, limit)
+			}
+		}
+	}
+	if budget.inUse() != limit {
+		t.Errorf("buffered %d bytes, want the whole budget of %d", budget.inUse(), limit)
+	}
+	// The second peer makes room by giving up its own oldest packets, later peers drop theirs
+	if len(peers[0].pending) != 3 || len(peers[1].pending) != 1 {
+		t.Errorf("pending reassemblies = %d and %d, want 3 and 1", len(peers[0].pending), len(peers[1].pending))
+	}
+	if want := 3 * (len(peers) - 2); drops != want {
+		t.Errorf("dropped %d fragments, want %d", drops, want)
+	}
+
+	// A completed packet returns its buffer to the budget for the other peers
+	bufp, _, ok := peers[0].add(fragmentFrames(data, 500, 0)[1])
+	if !ok {
+		t.Fatal("second fragment did not complete the packet")
+	}
+	packetPool.Put(bufp)
+	if budget.inUse() != limit-maxTunMTU {
+		t.Errorf("buffered %d bytes after a packet completed, want %d", budget.inUse(), limit-maxTunMTU)
+	}
+	peers[7].add(fragmentFrames(data, 500, 100)[0])
+	if len(peers[7].pending) != 1 || drops != 3*(len(peers)-2) {
+		t.Errorf("freed budget not reused: %d pending, %d drops", len(peers[7].pending), drops)
+	}
+
+	for _, r := range peers {
+		r.reset()
+	}
+	if budget.inUse() != 0 {
+		t.Errorf("buffered %d bytes once every peer is gone, want 0", budget.inUse())
+	}
+}
@@ -0,0 +1,124 @@
+package quicwire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	pcapSnapLen  = 65535
+	pcapLinkType = 101 // LINKTYPE_RAW, packets start with the IP header
+
+	// pcapng block types and options
+	pcapngSectionHeader   = 0x0a0d0d0a
+	pcapngInterface       = 0x1
+	pcapngEnhancedPacket  = 0x6
+	pcapngByteOrderMagic  = 0x1a2b3c4d
+	pcapngOptEndOfOptions = 0
+	pcapngOptComment      = 1
+)
+
+// pcapWriter writes packets to a capture file in the pcapng format, packets
+// can carry a comment shown by Wireshark
+type pcapWriter struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+}
+
+// newPcapWriter creates the capture file and writes the section header and
+// the description of the single raw IP interface
+func newPcapWriter(path string) (*pcapWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	pw := &pcapWriter{file: file, w: bufio.NewWriter(file)}
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:6], 1)
+	// An unknown section length
+	binary.LittleEndian.PutUint64(shb[8:16], ^uint64(0))
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:2], pcapLinkType)
+	binary.LittleEndian.PutUint32(idb[4:8], pcapSnapLen)
+	if err := pw.writeBlock(pcapngSectionHeader, shb); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := pw.writeBlock(pcapngInterface, idb); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return pw, pw.w.Flush()
+}
+
+// writeBlock writes a block with the given body, padded to 32 bits
+func (pw *pcapWriter) writeBlock(typ uint32, body []byte) error {
+	length := 12 + len(body)
+	var hdr [8]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], typ)
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(length))
+	if _, err := pw.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := pw.w.Write(body); err != nil {
+		return err
+	}
+	_, err := pw.w.Write(hdr[4:8])
+	return err
+}
+
+// pad4 appends zeros to b up to a multiple of 4 bytes
+func pad4(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// writePacket appends a packet record to the capture
+func (pw *pcapWriter) writePacket(packet []byte) error {
+	return pw.writeAnnotated(packet, "")
+}
+
+// writeAnnotated appends a packet record carrying the comment, if not empty
+func (pw *pcapWriter) writeAnnotated(packet []byte, comment string) error {
+	// Timestamps are in microseconds, the default resolution of the interface
+	ts := uint64(time.Now().UnixMicro())
+	body := make([]byte, 20, 20+len(packet)+len(comment)+12)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(len(packet)))
+	body = pad4(append(body, packet...))
+	if comment != "" {
+		body = binary.LittleEndian.AppendUint16(body, pcapngOptComment)
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(comment)))
+		body = pad4(append(body, comment...))
+		body = binary.LittleEndian.AppendUint32(body, pcapngOptEndOfOptions)
+	}
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if err := pw.writeBlock(pcapngEnhancedPacket, body); err != nil {
+		return err
+	}
+	// Flush every record so the capture is usable while the node runs
+	return pw.w.Flush()
+}
+
+// Close flushes and closes the capture file
+func (pw *pcapWriter) Close() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if err := pw.w.Flush(); err != nil {
+		pw.file.Close()
+		return err
+	}
+	return pw.file.Close()
+}
@@ -0,0 +1,59 @@
+package quicwire
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// certLifetime is the validity period of the certificate generated by a node
+const certLifetime = 365 * 24 * time.Hour
+
+// errClockSkew is returned when a peer certificate is outside of its validity
+// period, which between nodes generating fresh certificates points at a clock
+// being wrong
+var errClockSkew = errors.New("peer certificate is outside of its validity period, check the clocks of both nodes")
+
+// checkCertValidity checks that now lies within the validity period of the
+// certificate, widened by tolerance on both ends
+func checkCertValidity(cert *x509.Certificate, now time.Time, tolerance time.Duration) error {
+	switch {
+	case now.Add(tolerance).Before(cert.NotBefore):
+		return fmt.Errorf("%w: certificate is valid from %s, the local clock is %s behind",
+			errClockSkew, cert.NotBefore.UTC().Format(time.RFC3339), cert.NotBefore.Sub(now).Round(time.Second))
+	case now.Add(-tolerance).After(cert.NotAfter):
+		return fmt.Errorf("%w: certificate expired at %s, the local clock is %s ahead",
+			errClockSkew, cert.NotAfter.UTC().Format(time.RFC3339), now.Sub(cert.NotAfter).Round(time.Second))
+	}
+	return nil
+}
+
+// verifyPeerCertificate is the tls.Config.VerifyPeerCertificate callback of
+// dialed and accepted connections. With a CA the chain of the peer
+// certificate is verified first. With CheckCertValidity the validity period
+// of the peer certificate is checked, then its revocation status if configured.
+func (qn *QuicWire) verifyPeerCertificate(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+	if qn.caPool != nil {
+		var err error
+		if chains, err = verifyChain(rawCerts, qn.caPool); err != nil {
+			qn.noisyLog.Errorf("Refused peer certificate: %v", err)
+			return err
+		}
+	}
+	if qn.qc.nodeInterface.checkCertValidity && len(rawCerts) > 0 {
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if err := checkCertValidity(cert, time.Now(), qn.qc.nodeInterface.certValidityTolerance); err != nil {
+			// TLS alerts carry no details, so tell about the skew here
+			qn.noisyLog.Errorf("Refused peer certificate: %v", err)
+			return err
+		}
+	}
+	if qn.revocation != nil {
+		return qn.revocation.verifyPeerCertificate(rawCerts, chains)
+	}
+	return nil
+}
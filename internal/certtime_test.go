@@ -0,0 +1,79 @@
+package quicwire
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckCertValidity(t *testing.T) {
+	issued := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: issued, NotAfter: issued.Add(certLifetime)}
+	tests := []struct {
+		name      string
+		now       time.Time
+		tolerance time.Duration
+		err       string
+	}{
+		{name: "within the period", now: issued.Add(time.Hour)},
+		{name: "clock behind", now: issued.Add(-48 * time.Hour), err: "48h0m0s behind"},
+		{name: "clock behind within the tolerance", now: issued.Add(-time.Minute), tolerance: 5 * time.Minute},
+		{name: "clock ahead", now: issued.Add(certLifetime + time.Hour), err: "1h0m0s ahead"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCertValidity(cert, tt.now, tt.tolerance)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("error = %v, want none", err)
+				}
+				return
+			}
+			if !errors.Is(err, errClockSkew) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want a clock skew error containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// peerCertAt returns a self-signed certificate generated by a peer whose clock reads issued
+func peerCertAt(t *testing.T, issued time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "quicwire test peer"},
+		NotBefore:    issued,
+		NotAfter:     issued.Add(certLifetime),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestVerifyPeerCertificateClockSkew(t *testing.T) {
+	// The local clock is backdated two days against the peer that just generated its certificate
+	cert := peerCertAt(t, time.Now().Add(48*time.Hour))
+	qn, _ := newTestQuicWire(t)
+	qn.qc.nodeInterface.checkCertValidity = true
+	if err := qn.verifyPeerCertificate([][]byte{cert}, nil); !errors.Is(err, errClockSkew) {
+		t.Errorf("error = %v, want %v", err, errClockSkew)
+	}
+
+	qn.qc.nodeInterface.certValidityTolerance = 72 * time.Hour
+	if err := qn.verifyPeerCertificate([][]byte{cert}, nil); err != nil {
+		t.Errorf("error within the tolerance = %v, want none", err)
+	}
+}
@@ -1,13 +1,22 @@
 package quicwire
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
 	"github.com/songgao/water"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -19,84 +28,387 @@ type Client struct {
 	handler         Handler
 	tunnelInterface *water.Interface
 	connection      quic.Connection
-	logger          *zap.SugaredLogger
+	// outbound is set when this node dialed the connection
+	outbound  bool
+	family    string
+	cpus      []int
+	tracer    logging.Tracer
+	transport Transport
+	resolver  *resolveCache
+	// lastGood is the address the peer was last reached at, dialed when the endpoint fails
+	lastGood *net.UDPAddr
+	// parallelism is the number of candidate addresses dialed at a time, 0
+	// dials them one after the other or, with Happy-Eyeballs, all staggered
+	parallelism      int
+	candidateTimeout time.Duration
+	// idleTimeout is the QUIC max idle timeout, noKeepAlive lets idle connections close after it
+	idleTimeout time.Duration
+	noKeepAlive bool
+	window      *sendWindow
+	// pathMTU is the largest packet sent to the peer, recvMTU the largest
+	// the peer sends, the directions may differ on asymmetric paths
+	pathMTU   atomic.Int32
+	recvMTU   atomic.Int32
+	dialStats DialStats
+	logger    *zap.SugaredLogger
+
+	// maxDatagram is the largest datagram reaching the peer when path MTU
+	// discovery found it smaller than maxDatagramSize, packets are fragmented to it
+	maxDatagram atomic.Int32
+
+	// probes holds the outstanding probes keyed by sequence number
+	probeMu  sync.Mutex
+	probeSeq uint32
+	probes   map[uint32]chan struct{}
+
+	reassembler *reassembler
+	acl         atomic.Pointer[acl]
+	// dropped is told about packets from the peer the client drops
+	dropped func(reason string, size int, packet []byte)
+	// control is told about the control frames and stream bytes exchanged with the peer
+	control func(sent bool, size int)
+	// verifyPeer checks the certificate of the peer on dial, see tls.Config.VerifyPeerCertificate
+	verifyPeer func([][]byte, [][]*x509.Certificate) error
+	coalescer  *coalescer
+	budget     *bufferBudget
+	// compressor compresses the packets sent to the peer, nil when no compressor was negotiated
+	compressor atomic.Pointer[compressor]
+
+	// certificate is presented to the peer on dial, none when nil
+	certificate *tls.Certificate
+
+	// sessionCache keeps the session tickets of the peer to resume the next connection
+	sessionCache tls.ClientSessionCache
+
+	// spanContext is the trace span of the connection setup, firstForwarded
+	// tracks whether the first packet to the peer was traced
+	spanContext    trace.SpanContext
+	firstForwarded atomic.Bool
+
+	// draining is set once the peer said it is shutting down
+	draining atomic.Bool
+	// lastActive is when a packet was last forwarded over the connection, in Unix nanoseconds
+	lastActive atomic.Int64
 }
 
-// NewClient creates a new client
-func NewClient(addr string, localip string, localport int, tunIface *water.Interface, logger *zap.SugaredLogger) *Client {
+// DialStats describes how the connection to the peer was established
+type DialStats struct {
+	Duration time.Duration `json:"duration"`
+	Retries  int           `json:"retries"`
+}
+
+// NewClient creates a new client, it fails when localip is not an IP address
+func NewClient(addr string, localip string, localport int, tunIface *water.Interface, logger *zap.SugaredLogger) (*Client, error) {
 
 	ipAddr := net.ParseIP(localip)
 
 	if ipAddr == nil {
-		logger.Fatalf("Failed to parse IP address %s", localip)
+		return nil, fmt.Errorf("failed to parse IP address %s", localip)
 	}
 	return &Client{
 		addr:            addr,
 		localip:         ipAddr,
 		localport:       localport,
 		tunnelInterface: tunIface,
+		transport:       DefaultTransport(),
+		resolver:        newResolveCache(DefaultResolver(), 0),
+		window:          newSendWindow(0),
 		logger:          logger,
-	}
+		probes:          make(map[uint32]chan struct{}),
+		reassembler:     newReassembler(),
+	}, nil
 }
 
 // AttachHandler attaches a handler to process incoming packets
 func (c *Client) AttachHandler(handler Handler) {
 	c.handler = handler
 	go func() {
-		err := handleMsg(c.tunnelInterface, c.connection, c.handler)
+		pinForwarding(c.cpus, c.logger)
+		err := handleMsg(c)
 		if err != nil {
 			fmt.Printf("handler err: %v", err)
 		}
 	}()
 }
 
+// SetCertificate sets the certificate presented to the peer, peers verifying
+// client certificates refuse dials without one
+func (c *Client) SetCertificate(cert *tls.Certificate) {
+	c.certificate = cert
+}
+
+// SetVerifyPeerCertificate sets an additional check of the peer certificate, such as revocation
+func (c *Client) SetVerifyPeerCertificate(verify func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error) {
+	c.verifyPeer = verify
+}
+
+// SetSessionCache sets the cache of the session tickets the client resumes connections with
+func (c *Client) SetSessionCache(cache tls.ClientSessionCache) {
+	c.sessionCache = cache
+}
+
+// SetACL filters the packets received from the peer, it may be called on a live connection
+func (c *Client) SetACL(rules acl) {
+	c.acl.Store(&rules)
+}
+
+// SetDropHandler sets the function told about packets from the peer dropped
+// by the client, packet is nil when the packet was not received as a whole
+func (c *Client) SetDropHandler(dropped func(reason string, size int, packet []byte)) {
+	c.dropped = dropped
+}
+
+// SetControlHandler sets the function told about the control traffic
+// exchanged with the peer, so it can be counted apart from forwarded packets
+func (c *Client) SetControlHandler(control func(sent bool, size int)) {
+	c.control = control
+}
+
+// countControl reports control traffic to the control handler, if any
+func (c *Client) countControl(sent bool, size int) {
+	if c.control != nil && size > 0 {
+		c.control(sent, size)
+	}
+}
+
+// sendControl sends a control frame to the peer and counts it
+func (c *Client) sendControl(frame []byte) error {
+	if err := c.connection.SendMessage(frame); err != nil {
+		return err
+	}
+	c.countControl(true, len(frame))
+	return nil
+}
+
+// countedStream counts the bytes read and written on a control stream, every
+// read and write counts as a packet
+type countedStream struct {
+	io.ReadWriter
+	c *Client
+}
+
+func (s countedStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriter.Read(p)
+	s.c.countControl(false, n)
+	return n, err
+}
+
+func (s countedStream) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	s.c.countControl(true, n)
+	return n, err
+}
+
+// controlStream wraps a stream carrying control messages so its bytes are counted
+func (c *Client) controlStream(stream io.ReadWriter) io.ReadWriter {
+	return countedStream{ReadWriter: stream, c: c}
+}
+
+// receive passes a packet received from the peer to the handler unless the ACL denies it
+func (c *Client) receive(pc packetContext) error {
+	c.lastActive.Store(time.Now().UnixNano())
+	if rules := c.acl.Load(); !pc.mirrored && rules != nil && !rules.allows(pc.Data) {
+		c.logger.Debugf("ACL of peer %s dropped a packet of %d bytes", c.addr, len(pc.Data))
+		if c.dropped != nil {
+			c.dropped(dropACL, len(pc.Data), pc.Data)
+		}
+		return nil
+	}
+	return c.handler(pc)
+}
+
 // SetConnection sets the currently active connection to the peer
 func (c *Client) SetConnection(conn quic.Connection) {
 	c.connection = conn
 }
 
-// Dial establishes a connection to the peer
-func (c *Client) Dial(udpConn *net.UDPConn) error {
-	tlsConf := &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"some-proto"},
+// setPathMTU records the largest packet the peer reported it can write to its tunnel interface
+func (c *Client) setPathMTU(mtu int) {
+	if mtu <= 0 {
+		return
+	}
+	if c.pathMTU.Swap(int32(mtu)) != int32(mtu) {
+		c.logger.Warnf("Peer %s reported an MTU of %d, larger packets will be dropped", c.addr, mtu)
 	}
+}
 
-	udpAddr, err := net.ResolveUDPAddr("udp", c.addr)
-	if err != nil {
+// SetSendWindow caps the number of unacknowledged datagrams in flight to the peer, 0 disables the cap
+func (c *Client) SetSendWindow(maxInFlight int) {
+	c.window = newSendWindow(maxInFlight)
+}
+
+// SetTransport sets the transport dialing the peer
+func (c *Client) SetTransport(transport Transport) {
+	c.transport = transport
+}
+
+// setResolver sets the cache the endpoint of the peer is resolved through
+func (c *Client) setResolver(resolver *resolveCache) {
+	c.resolver = resolver
+}
+
+// SetIdleTimeout sets the QUIC max idle timeout of dialed connections, 0
+// keeps the QUIC default. Without keep-alives idle connections close after it.
+func (c *Client) SetIdleTimeout(timeout time.Duration, keepAlive bool) {
+	c.idleTimeout = timeout
+	c.noKeepAlive = !keepAlive
+}
+
+// SetTracer sets the QUIC tracer attached to dialed connections
+func (c *Client) SetTracer(tracer logging.Tracer) {
+	c.tracer = tracer
+}
+
+// SetCPUAffinity pins the goroutine receiving from the peer to the given CPU cores
+func (c *Client) SetCPUAffinity(cpus []int) {
+	c.cpus = cpus
+}
+
+// SetCoalescing batches packets to the peer into datagrams of up to maxBatchBytes,
+// a partial batch is sent after flushInterval. A maxBatchBytes of 0 disables batching.
+func (c *Client) SetCoalescing(flushInterval time.Duration, maxBatchBytes int) {
+	if maxBatchBytes <= 0 {
+		c.coalescer = nil
+		return
+	}
+	c.coalescer = newCoalescer(flushInterval, maxBatchBytes, func(batch []byte) error {
+		_, err := c.sendFrame(frameBatch, nil, batch)
 		return err
+	}, func(err error) {
+		c.logger.Debugf("Failed to flush batch to peer %s: %v", c.addr, err)
+	})
+	c.coalescer.budget = c.budget
+}
+
+// SetBufferBudget accounts the pending batches and reassemblies of the client
+// against the node wide buffer budget
+func (c *Client) SetBufferBudget(budget *bufferBudget) {
+	c.budget = budget
+	if c.coalescer != nil {
+		c.coalescer.budget = budget
 	}
+	c.reassembler.budget = budget
+	c.reassembler.overBudget = func(size int) {
+		c.logger.Debugf("Dropped a fragment of %d bytes from peer %s, buffer budget exhausted", size, c.addr)
+		if c.dropped != nil {
+			c.dropped(dropBufferBudget, size, nil)
+		}
+	}
+}
 
-	conn, err := quic.Dial(udpConn, udpAddr, c.addr, tlsConf, &quic.Config{
-		KeepAlivePeriod: 10,
-		EnableDatagrams: true,
-	})
+// SetAddressFamily sets which address family is dialed first when the peer resolves to both
+func (c *Client) SetAddressFamily(family string) {
+	c.family = family
+}
+
+// SetDialParallelism sets the number of candidate addresses of the peer
+// dialed at a time and how long each dial may take, 0 leaves either unset
+func (c *Client) SetDialParallelism(parallelism int, candidateTimeout time.Duration) {
+	c.parallelism = parallelism
+	c.candidateTimeout = candidateTimeout
+}
 
+// SetLastGoodAddr sets the address the peer was last reached at. It is
+// dialed when the endpoint no longer resolves or none of its addresses answers.
+func (c *Client) SetLastGoodAddr(addr *net.UDPAddr) {
+	c.lastGood = addr
+}
+
+// Dial establishes a connection to the peer
+func (c *Client) Dial(udpConn *net.UDPConn) error {
+	return c.DialContext(context.Background(), udpConn)
+}
+
+// DialContext establishes a connection to the peer, giving up when ctx is cancelled
+func (c *Client) DialContext(ctx context.Context, udpConn *net.UDPConn) error {
+	var conn quic.Connection
+	addrs, err := resolvePeerAddrs(ctx, c.resolver, c.addr, c.family, udpConn)
+	if err == nil {
+		if c.family == familyHappyEyeballs || c.parallelism > 1 {
+			conn, err = c.dialRace(ctx, udpConn, addrs)
+		} else {
+			conn, err = c.dialInOrder(ctx, udpConn, addrs)
+		}
+	}
 	if err != nil {
-		return err
+		conn, err = c.dialLastGood(ctx, udpConn, addrs, err)
+		if err != nil {
+			return err
+		}
 	}
 	c.connection = conn
 	return nil
 }
 
+// DialStats returns the connection establishment stats of the client
+func (c *Client) DialStats() DialStats {
+	return c.dialStats
+}
+
+// setDialStats records the time it took to get a connection ready and the retries it needed
+func (c *Client) setDialStats(duration time.Duration, retries int) {
+	c.dialStats.Duration = duration
+	c.dialStats.Retries = retries
+}
+
 // Send converts string to byte array and sends it to the peer
 func (c *Client) Send(data string) error {
+	return c.SendBytes([]byte(data))
+}
+
+// SendBytes sends byte array to the peer, waiting for room in the send window
+func (c *Client) SendBytes(data []byte) error {
 	if c.connection == nil {
 		return fmt.Errorf("Client has no active connection to peer %s", c.addr)
 	}
-	err := c.connection.SendMessage([]byte(data))
-	return err
+	c.lastActive.Store(time.Now().UnixNano())
+	if mtu := int(c.pathMTU.Load()); mtu > 0 && len(data) > mtu {
+		return fmt.Errorf("packet of %d bytes exceeds the MTU of peer %s (%d): %w", len(data), c.addr, mtu, errPacketTooBig)
+	}
+	if c.coalescer != nil && c.coalescer.fits(data) {
+		// Without room in the buffer budget the packet is sent on its own
+		if err := c.coalescer.add(data); !errors.Is(err, errBufferBudget) {
+			return err
+		}
+	}
+	if len(data) <= c.framePayload() {
+		if sent, err := c.sendCompressed(data); sent || err != nil {
+			return err
+		}
+		_, err := c.sendFrame(frameData, nil, data)
+		return err
+	}
+	return c.sendFragments(data)
 }
 
-// SendBytes sends byte array to the peer
-func (c *Client) SendBytes(data []byte) error {
+// SendMirror sends a copy of a packet to a monitoring peer, packets larger than a datagram are not mirrored
+func (c *Client) SendMirror(data []byte) error {
 	if c.connection == nil {
 		return fmt.Errorf("Client has no active connection to peer %s", c.addr)
 	}
-	err := c.connection.SendMessage(data)
+	if len(data) > maxFramePayload {
+		return fmt.Errorf("packet of %d bytes is too large to mirror: %w", len(data), errPacketTooBig)
+	}
+	_, err := c.sendFrame(frameMirror, nil, data)
 	return err
 }
 
+// sendFrame sends a window paced frame, prefix is written ahead of the payload.
+// It returns the sequence number of the frame.
+func (c *Client) sendFrame(typ byte, prefix []byte, payload []byte) (uint32, error) {
+	seq, ackRequest := c.window.acquire()
+	var flags byte
+	if ackRequest {
+		flags = frameFlagAckRequest
+	}
+
+	bufp := framePool.Get().(*[]byte)
+	defer framePool.Put(bufp)
+	buf := appendFrame((*bufp)[:0], typ, flags, seq, prefix)
+	buf = append(buf, payload...)
+	return seq, c.connection.SendMessage(buf)
+}
+
 // SendJSON converts data to json and sends it to the peer
 func (c *Client) SendJSON(data any) error {
 	if c.connection == nil {
@@ -106,5 +418,57 @@ func (c *Client) SendJSON(data any) error {
 	if err != nil {
 		return err
 	}
-	return c.connection.SendMessage(res)
+	return c.SendBytes(res)
+}
+
+// probe sends a datagram of the given size and waits for the peer to echo it,
+// returning the round trip time
+func (c *Client) probe(ctx context.Context, size int) (time.Duration, error) {
+	return c.probeFlags(ctx, size, 0)
+}
+
+// probeFlags sends a probe with the given frame flags, see probe
+func (c *Client) probeFlags(ctx context.Context, size int, flags byte) (time.Duration, error) {
+	if c.connection == nil {
+		return 0, fmt.Errorf("Client has no active connection to peer %s", c.addr)
+	}
+	if size < frameHeaderLen {
+		size = frameHeaderLen
+	}
+
+	reply := make(chan struct{}, 1)
+	c.probeMu.Lock()
+	c.probeSeq++
+	seq := c.probeSeq
+	c.probes[seq] = reply
+	c.probeMu.Unlock()
+	defer func() {
+		c.probeMu.Lock()
+		delete(c.probes, seq)
+		c.probeMu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := c.sendControl(encodeFrame(frameProbe, flags, seq, make([]byte, size-frameHeaderLen))); err != nil {
+		return 0, err
+	}
+	select {
+	case <-reply:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// probeReplied completes the outstanding probe with the given sequence number
+func (c *Client) probeReplied(seq uint32) {
+	c.probeMu.Lock()
+	reply, ok := c.probes[seq]
+	c.probeMu.Unlock()
+	if ok {
+		select {
+		case reply <- struct{}{}:
+		default:
+		}
+	}
 }
@@ -0,0 +1,54 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// failingTransport fails the first fails dials, the others are passed to Transport
+type failingTransport struct {
+	Transport
+	mu    sync.Mutex
+	fails int
+}
+
+func (t *failingTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	t.mu.Lock()
+	fail := t.fails > 0
+	t.fails--
+	t.mu.Unlock()
+	if fail {
+		return nil, &quic.IdleTimeoutError{}
+	}
+	return t.Transport.Dial(ctx, conn, addr, host, tlsConf, conf)
+}
+
+func TestDialStatsRecordsRetries(t *testing.T) {
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", nil)
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(&failingTransport{Transport: DefaultTransport(), fails: 2})
+	})
+	peer := newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2")
+	// A lost connection is redialed right away once, the third dial follows the retry interval
+	peer.priority = 1
+	if err := a.AddPeer(peer); err != nil {
+		t.Fatal(err)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	a.mu.RLock()
+	stats := a.clients["10.0.0.2/32"].DialStats()
+	a.mu.RUnlock()
+	if stats.Retries != 2 {
+		t.Errorf("retries = %d, want 2", stats.Retries)
+	}
+	if stats.Duration < priorityRetryInterval || stats.Duration > priorityRetryInterval+5*time.Second {
+		t.Errorf("dial duration = %s, want about the retry interval of %s", stats.Duration, priorityRetryInterval)
+	}
+}
@@ -0,0 +1,133 @@
+package quicwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultFlushInterval bounds the latency a packet waits for a batch to fill
+	defaultFlushInterval = 250 * time.Microsecond
+	// batchEntryHeaderLen is the length prefix of every packet in a batch
+	batchEntryHeaderLen = 2
+)
+
+// coalescer batches small packets into a single datagram. A batch is sent
+// once it holds maxBytes or when flushInterval elapsed since its first
+// packet, whichever comes first.
+type coalescer struct {
+	mu       sync.Mutex
+	buf      []byte
+	timer    *time.Timer
+	interval time.Duration
+	maxBytes int
+	send     func([]byte) error
+	onError  func(error)
+	// budget accounts the bytes of the pending batch, a batch is flushed early when it is exhausted
+	budget *bufferBudget
+}
+
+func newCoalescer(interval time.Duration, maxBytes int, send func([]byte) error, onError func(error)) *coalescer {
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	if maxBytes > maxFramePayload {
+		maxBytes = maxFramePayload
+	}
+	return &coalescer{
+		buf:      make([]byte, 0, maxBytes),
+		interval: interval,
+		maxBytes: maxBytes,
+		send:     send,
+		onError:  onError,
+	}
+}
+
+// fits reports whether a packet can be batched at all
+func (co *coalescer) fits(packet []byte) bool {
+	return len(packet)+batchEntryHeaderLen <= co.maxBytes
+}
+
+// add queues the packet, flushing the batch first if it does not fit and
+// afterwards if it is full. When the buffer budget has no room for the packet
+// even after flushing, it is not queued and errBufferBudget is returned.
+func (co *coalescer) add(packet []byte) error {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	if len(co.buf)+batchEntryHeaderLen+len(packet) > co.maxBytes {
+		if err := co.flushLocked(); err != nil {
+			return err
+		}
+	}
+	if !co.budget.reserve(batchEntryHeaderLen + len(packet)) {
+		if err := co.flushLocked(); err != nil {
+			return err
+		}
+		if !co.budget.reserve(batchEntryHeaderLen + len(packet)) {
+			return errBufferBudget
+		}
+	}
+	co.buf = binary.BigEndian.AppendUint16(co.buf, uint16(len(packet)))
+	co.buf = append(co.buf, packet...)
+
+	if len(co.buf)+batchEntryHeaderLen >= co.maxBytes {
+		return co.flushLocked()
+	}
+	if co.timer == nil {
+		co.timer = time.AfterFunc(co.interval, co.flushTimer)
+	}
+	return nil
+}
+
+// flushTimer sends the partial batch once the flush interval elapsed
+func (co *coalescer) flushTimer() {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	co.timer = nil
+	if err := co.flushLocked(); err != nil && co.onError != nil {
+		co.onError(err)
+	}
+}
+
+// flush sends the pending batch, if any
+func (co *coalescer) flush() error {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	return co.flushLocked()
+}
+
+func (co *coalescer) flushLocked() error {
+	if co.timer != nil {
+		co.timer.Stop()
+		co.timer = nil
+	}
+	if len(co.buf) == 0 {
+		return nil
+	}
+	err := co.send(co.buf)
+	co.budget.release(len(co.buf))
+	co.buf = co.buf[:0]
+	return err
+}
+
+// splitBatch calls fn for every packet of a received batch
+func splitBatch(payload []byte, fn func([]byte) error) error {
+	for len(payload) > 0 {
+		if len(payload) < batchEntryHeaderLen {
+			return fmt.Errorf("truncated batch entry header")
+		}
+		n := int(binary.BigEndian.Uint16(payload))
+		payload = payload[batchEntryHeaderLen:]
+		if n > len(payload) {
+			return fmt.Errorf("batch entry of %d bytes exceeds the remaining %d bytes", n, len(payload))
+		}
+		if err := fn(payload[:n]); err != nil {
+			return err
+		}
+		payload = payload[n:]
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package quicwire
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestCoalescerFlushesPartialBatch(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	var mu sync.Mutex
+	var batches [][]byte
+	sent := make(chan time.Time, 1)
+	co := newCoalescer(interval, 1000, func(b []byte) error {
+		mu.Lock()
+		batches = append(batches, append([]byte(nil), b...))
+		mu.Unlock()
+		sent <- time.Now()
+		return nil
+	}, nil)
+
+	packet := []byte("small packet")
+	start := time.Now()
+	if err := co.add(packet); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case at := <-sent:
+		if waited := at.Sub(start); waited > interval+100*time.Millisecond {
+			t.Errorf("partial batch sent after %v, want about the flush interval of %v", waited, interval)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial batch never flushed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 1 {
+		t.Fatalf("sent %d batches, want 1", len(batches))
+	}
+	var got [][]byte
+	if err := splitBatch(batches[0], func(p []byte) error {
+		got = append(got, p)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !bytes.Equal(got[0], packet) {
+		t.Errorf("batch holds %q, want only %q", got, packet)
+	}
+}
+
+func TestForwardBatchedPacket(t *testing.T) {
+	mesh := newMemNetwork()
+	batching := func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.maxBatchBytes = 1000
+		qn.qc.nodeInterface.flushInterval = 5 * time.Millisecond
+	}
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", batching)
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", batching, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("alone"))
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got, err := b.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatalf("single packet of a batch not delivered: %v", err)
+	}
+	if !bytes.Equal(got[0], packet) {
+		t.Errorf("peer received %x, want %x", got[0], packet)
+	}
+}
@@ -0,0 +1,181 @@
+package quicwire
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compressorDeflate compresses packets with DEFLATE
+const compressorDeflate = "deflate"
+
+// compressorIDs identify the compressor of a frameCompressed on the wire
+var compressorIDs = map[string]byte{
+	compressorDeflate: 1,
+}
+
+// flateReaders recycles the decompressors of received packets
+var flateReaders = sync.Pool{
+	New: func() any {
+		return flate.NewReader(nil)
+	},
+}
+
+// negotiateCompressor picks the first of the local compressors the peer supports, empty when none is shared
+func negotiateCompressor(local, remote []string) string {
+	for _, name := range local {
+		for _, r := range remote {
+			if name == r {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// compressor compresses the packets sent to a peer and accounts for the savings
+type compressor struct {
+	name string
+	id   byte
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+	w   *flate.Writer
+
+	// bytesIn counts the packet bytes offered for compression, bytesOut the bytes sent for them
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+	cpuTime  atomic.Int64
+}
+
+func newCompressor(name string) (*compressor, error) {
+	id, ok := compressorIDs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported compressor %q", name)
+	}
+	w, err := flate.NewWriter(nil, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	return &compressor{name: name, id: id, w: w}, nil
+}
+
+// compress calls send with the compressed packet, or returns false without
+// calling it when compressing does not make the packet smaller
+func (z *compressor) compress(packet []byte, send func(compressed []byte) error) (bool, error) {
+	start := time.Now()
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	z.buf.Reset()
+	z.w.Reset(&z.buf)
+	_, err := z.w.Write(packet)
+	if err == nil {
+		err = z.w.Close()
+	}
+	z.cpuTime.Add(int64(time.Since(start)))
+	z.bytesIn.Add(uint64(len(packet)))
+	if err != nil || z.buf.Len() >= len(packet) {
+		z.bytesOut.Add(uint64(len(packet)))
+		return false, nil
+	}
+	z.bytesOut.Add(uint64(z.buf.Len()))
+	return true, send(z.buf.Bytes())
+}
+
+// decompress inflates a received packet into buf, returning its length
+func (z *compressor) decompress(id byte, data []byte, buf []byte) (int, error) {
+	if id != compressorIDs[compressorDeflate] {
+		return 0, fmt.Errorf("unknown compressor id %d", id)
+	}
+	start := time.Now()
+	defer func() {
+		if z != nil {
+			z.cpuTime.Add(int64(time.Since(start)))
+		}
+	}()
+	r := flateReaders.Get().(io.ReadCloser)
+	defer flateReaders.Put(r)
+	if err := r.(flate.Resetter).Reset(bytes.NewReader(data), nil); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, buf)
+	if err == nil {
+		// The packet does not fit the buffer
+		return 0, fmt.Errorf("decompressed packet exceeds %d bytes", len(buf))
+	}
+	if err != io.ErrUnexpectedEOF && err != io.EOF {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CompressionStats reports how well the packets sent to a peer compress
+type CompressionStats struct {
+	Algorithm string `json:"algorithm"`
+	BytesIn   uint64 `json:"bytesIn"`
+	BytesOut  uint64 `json:"bytesOut"`
+	// Ratio is the compressed size over the original size, lower is better
+	Ratio float64 `json:"ratio"`
+	// CPUTime is the time spent compressing and decompressing the packets of the peer
+	CPUTime time.Duration `json:"cpuTime"`
+}
+
+// stats returns a snapshot of the compression counters
+func (z *compressor) stats() CompressionStats {
+	s := CompressionStats{
+		Algorithm: z.name,
+		BytesIn:   z.bytesIn.Load(),
+		BytesOut:  z.bytesOut.Load(),
+		CPUTime:   time.Duration(z.cpuTime.Load()),
+	}
+	if s.BytesIn > 0 {
+		s.Ratio = float64(s.BytesOut) / float64(s.BytesIn)
+	}
+	return s
+}
+
+// setCompressor compresses the packets sent to the peer with the negotiated compressor, empty disables compression
+func (c *Client) setCompressor(name string) {
+	if name == "" {
+		c.compressor.Store(nil)
+		return
+	}
+	z, err := newCompressor(name)
+	if err != nil {
+		c.logger.Warnf("Not compressing packets to %s: %v", c.addr, err)
+		return
+	}
+	c.compressor.Store(z)
+}
+
+// sendCompressed sends the packet compressed, it returns false when the packet was not sent
+func (c *Client) sendCompressed(data []byte) (bool, error) {
+	z := c.compressor.Load()
+	if z == nil {
+		return false, nil
+	}
+	return z.compress(data, func(compressed []byte) error {
+		_, err := c.sendFrame(frameCompressed, []byte{z.id}, compressed)
+		return err
+	})
+}
+
+// receiveCompressed decompresses a frameCompressed and hands the packet to the handler
+func (c *Client) receiveCompressed(pc packetContext) error {
+	if len(pc.Data) < 1 {
+		return nil
+	}
+	bufp := packetPool.Get().(*[]byte)
+	defer packetPool.Put(bufp)
+	n, err := c.compressor.Load().decompress(pc.Data[0], pc.Data[1:], *bufp)
+	if err != nil {
+		c.logger.Debugf("Dropped compressed packet from %s: %v", c.addr, err)
+		return nil
+	}
+	pc.Data = (*bufp)[:n]
+	return c.receive(pc)
+}
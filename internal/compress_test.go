@@ -0,0 +1,105 @@
+package quicwire
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestNegotiateCompressor(t *testing.T) {
+	tests := []struct {
+		name          string
+		local, remote []string
+		want          string
+	}{
+		{name: "shared", local: []string{compressorDeflate}, remote: []string{"zstd", compressorDeflate}, want: compressorDeflate},
+		{name: "local preference wins", local: []string{"zstd", compressorDeflate}, remote: []string{compressorDeflate, "zstd"}, want: "zstd"},
+		{name: "none shared", local: []string{compressorDeflate}, remote: []string{"zstd"}},
+		{name: "peer without compression", local: []string{compressorDeflate}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateCompressor(tt.local, tt.remote); got != tt.want {
+				t.Errorf("negotiateCompressor(%v, %v) = %q, want %q", tt.local, tt.remote, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressionNegotiated(t *testing.T) {
+	tests := []struct {
+		name string
+		// peerCompression are the compressors B supports
+		peerCompression []string
+		want            string
+	}{
+		{name: "both compress", peerCompression: []string{compressorDeflate}, want: compressorDeflate},
+		{name: "peer does not compress"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh := newMemNetwork()
+			b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+				qn.SetTransport(mesh.transport())
+				qn.qc.nodeInterface.compression = tt.peerCompression
+			})
+			a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+				qn.SetTransport(mesh.transport())
+				qn.qc.nodeInterface.compression = []string{compressorDeflate}
+			}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+			waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+			// Text compresses well, random bytes are sent as they are
+			src, dst := netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000")
+			random := make([]byte, 1000)
+			rand.New(rand.NewSource(1)).Read(random)
+			packets := [][]byte{
+				packettest.UDP(src, dst, bytes.Repeat([]byte("quicwire "), 110)),
+				packettest.UDP(src, dst, random),
+			}
+			for _, packet := range packets {
+				if err := a.InjectPacket(packet); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, err := b.sink.Wait(ctx, len(packets))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range packets {
+				if !bytes.Equal(got[i], packets[i]) {
+					t.Errorf("packet %d arrived altered", i)
+				}
+			}
+
+			status := a.Status()
+			if len(status) != 1 {
+				t.Fatalf("status of %d peers, want 1", len(status))
+			}
+			stats := status[0].Compression
+			if tt.want == "" {
+				if stats != nil {
+					t.Errorf("compression = %+v, want none", stats)
+				}
+				return
+			}
+			if stats == nil || stats.Algorithm != tt.want {
+				t.Fatalf("compression = %+v, want %s", stats, tt.want)
+			}
+			if total := uint64(len(packets[0]) + len(packets[1])); stats.BytesIn != total {
+				t.Errorf("bytes in = %d, want %d", stats.BytesIn, total)
+			}
+			// The text shrinks to a fraction, the random packet keeps its size
+			if stats.Ratio < 0.5 || stats.Ratio > 0.6 {
+				t.Errorf("ratio = %.2f, want the text packet compressed and the random one not", stats.Ratio)
+			}
+		})
+	}
+}
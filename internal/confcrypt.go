@@ -0,0 +1,98 @@
+package quicwire
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	configSaltLen  = 16
+	configNonceLen = 24
+)
+
+// encryptedConfigMagic starts every encrypted config file. It is followed by
+// the scrypt salt, the secretbox nonce and the sealed config.
+var encryptedConfigMagic = []byte("QWSECRETBOX1\n")
+
+// errConfigKey is returned when an encrypted config can not be opened with the supplied key
+var errConfigKey = errors.New("wrong key or corrupted encrypted config")
+
+// isEncryptedConfig reports whether data starts with the encrypted config header
+func isEncryptedConfig(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedConfigMagic)
+}
+
+// configKey reads the passphrase of encrypted configs from the environment or the key file
+func configKey() ([]byte, error) {
+	if key := os.Getenv(ConfigKeyEnv); key != "" {
+		return []byte(key), nil
+	}
+	if path := os.Getenv(configKeyFileEnv); path != "" {
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the config key file: %w", err)
+		}
+		return []byte(strings.TrimSpace(string(key))), nil
+	}
+	return nil, fmt.Errorf("config is encrypted, set %s or %s", ConfigKeyEnv, configKeyFileEnv)
+}
+
+// deriveConfigKey stretches the passphrase into a secretbox key
+func deriveConfigKey(passphrase, salt []byte) (*[32]byte, error) {
+	derived, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	var key [32]byte
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// EncryptConfig seals a config file with a key derived from the passphrase
+func EncryptConfig(plaintext, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, configSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	var nonce [configNonceLen]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	key, err := deriveConfigKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(nil), encryptedConfigMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce[:]...)
+	return secretbox.Seal(out, plaintext, &nonce, key), nil
+}
+
+// decryptConfig opens an encrypted config file in memory
+func decryptConfig(data, passphrase []byte) ([]byte, error) {
+	data = data[len(encryptedConfigMagic):]
+	if len(data) < configSaltLen+configNonceLen+secretbox.Overhead {
+		return nil, fmt.Errorf("encrypted config is truncated")
+	}
+	salt := data[:configSaltLen]
+	var nonce [configNonceLen]byte
+	copy(nonce[:], data[configSaltLen:])
+	key, err := deriveConfigKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, ok := secretbox.Open(nil, data[configSaltLen+configNonceLen:], &nonce, key)
+	if !ok {
+		return nil, errConfigKey
+	}
+	return plaintext, nil
+}
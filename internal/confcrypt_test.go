@@ -0,0 +1,88 @@
+package quicwire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigEncryptionRoundTrip(t *testing.T) {
+	plaintext := []byte("[Interface]\nListenPort = 51820\n")
+	sealed, err := EncryptConfig(plaintext, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncryptedConfig(sealed) {
+		t.Fatal("sealed config does not start with the encrypted config header")
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Error("sealed config contains the plaintext")
+	}
+
+	got, err := decryptConfig(sealed, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("decrypted %q, want %q", got, plaintext)
+	}
+	if _, err := decryptConfig(sealed, []byte("wrong")); !errors.Is(err, errConfigKey) {
+		t.Errorf("decrypting with a wrong key = %v, want %v", err, errConfigKey)
+	}
+	if _, err := decryptConfig(sealed[:len(encryptedConfigMagic)+4], []byte("secret")); err == nil {
+		t.Error("decrypting a truncated config succeeded")
+	}
+}
+
+func TestPlaintextConfLimits(t *testing.T) {
+	const maxSize = 64
+	t.Setenv(ConfigKeyEnv, "secret")
+	limits := confLimits{maxSize: maxSize, timeout: 5 * time.Second}
+	seal := func(t *testing.T, plaintext []byte) []byte {
+		t.Helper()
+		sealed, err := EncryptConfig(plaintext, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	tests := []struct {
+		name      string
+		plaintext []byte
+		encrypted bool
+		err       string
+	}{
+		{name: "plain within the size", plaintext: bytes.Repeat([]byte("a"), maxSize)},
+		{name: "plain over the size", plaintext: bytes.Repeat([]byte("a"), maxSize+1), err: "maximum size of 64 bytes"},
+		{name: "encrypted within the size", plaintext: bytes.Repeat([]byte("a"), maxSize), encrypted: true},
+		{name: "encrypted over the size", plaintext: bytes.Repeat([]byte("a"), maxSize+1), encrypted: true, err: "maximum size of 64 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.plaintext
+			if tt.encrypted {
+				data = seal(t, tt.plaintext)
+			}
+			r, err := plaintextConf(bytes.NewReader(data), "quicwire.conf", limits)
+			var got []byte
+			if err == nil {
+				got, err = io.ReadAll(r)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error = %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.plaintext) {
+				t.Errorf("read %d bytes, want the %d bytes of the plaintext", len(got), len(tt.plaintext))
+			}
+		})
+	}
+}
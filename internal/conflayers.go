@@ -0,0 +1,272 @@
+package quicwire
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// configEnvPrefix prefixes the environment variables overriding keys of the
+// Interface section, e.g. QUICWIRE_INTERFACE_ListenPort=55381
+const configEnvPrefix = "QUICWIRE_INTERFACE_"
+
+const (
+	// ConfigKeyEnv holds the passphrase of an encrypted config file
+	ConfigKeyEnv = "QUICWIRE_CONFIG_KEY"
+	// configKeyFileEnv names a file holding the passphrase of an encrypted config file
+	configKeyFileEnv = "QUICWIRE_CONFIG_KEY_FILE"
+)
+
+// Handling of config sources setting the same key to different values, the
+// later source wins in every mode
+const (
+	conflictsIgnore = "ignore"
+	conflictsWarn   = "warn"
+	conflictsError  = "error"
+)
+
+// errConfigConflict is returned in the error conflict mode when config sources disagree
+var errConfigConflict = errors.New("config sources disagree")
+
+// confEntry is a key of a section and the source that set it
+type confEntry struct {
+	key    string
+	value  string
+	source string
+}
+
+// confSection is a section merged from every source that contributed to it
+type confSection struct {
+	name    string
+	entries []confEntry
+	// sources are the sources that contributed to the section
+	sources map[string]bool
+}
+
+// value returns the value of key in the section
+func (s *confSection) value(key string) (string, bool) {
+	for _, e := range s.entries {
+		if e.key == key {
+			return e.value, true
+		}
+	}
+	return "", false
+}
+
+// confLayers merges config sources in order of increasing precedence. All
+// Interface sections merge into one, Peer sections merge with the section of
+// an earlier source with the same first allowed IP, the key Reload matches
+// peers by, and are added otherwise. Peer sections of the same source are
+// distinct peers and never merge.
+type confLayers struct {
+	sections  []*confSection
+	conflicts []string
+}
+
+// set stores an entry in the section, recording a conflict when another
+// source set the key to a different value before
+func (l *confLayers) set(section *confSection, e confEntry) {
+	for i, prev := range section.entries {
+		if prev.key != e.key {
+			continue
+		}
+		if prev.source != e.source && prev.value != e.value {
+			l.conflicts = append(l.conflicts, fmt.Sprintf("[%s] %s is %q in %s and %q in %s",
+				section.name, e.key, prev.value, prev.source, e.value, e.source))
+		}
+		section.entries[i] = e
+		return
+	}
+	section.entries = append(section.entries, e)
+}
+
+// peerKey returns the normalized first allowed IP of a Peer section
+func (s *confSection) peerKey() (string, bool) {
+	ips, ok := s.value("AllowedIPs")
+	if !ok {
+		return "", false
+	}
+	first, _, _ := strings.Cut(ips, ",")
+	first = strings.TrimSpace(first)
+	if prefix, err := routePrefix(first); err == nil {
+		return prefix, true
+	}
+	return first, true
+}
+
+// merge adds a section read from source
+func (l *confLayers) merge(section *confSection, source string) {
+	var target *confSection
+	for _, s := range l.sections {
+		if s.name != section.name {
+			continue
+		}
+		if section.name == "Peer" {
+			if s.sources[source] {
+				continue
+			}
+			key, ok := section.peerKey()
+			if prev, _ := s.peerKey(); !ok || prev != key {
+				continue
+			}
+		}
+		target = s
+		break
+	}
+	if target == nil {
+		target = &confSection{name: section.name, sources: make(map[string]bool)}
+		l.sections = append(l.sections, target)
+	}
+	target.sources[source] = true
+	for _, e := range section.entries {
+		l.set(target, e)
+	}
+}
+
+// add reads a config source, lines are split the way parseConf splits them
+func (l *confLayers) add(source string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	var section *confSection
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			if section != nil {
+				l.merge(section, source)
+			}
+			section = &confSection{name: line[1 : len(line)-1]}
+			continue
+		}
+		parts := strings.Split(line, " = ")
+		if len(parts) != 2 || section == nil {
+			continue
+		}
+		section.entries = append(section.entries, confEntry{key: parts[0], value: parts[1], source: source})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	if section != nil {
+		l.merge(section, source)
+	}
+	return nil
+}
+
+// addEnv applies the Interface overrides of the environment, given as KEY=value pairs
+func (l *confLayers) addEnv(environ []string) {
+	section := &confSection{name: "Interface"}
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, configEnvPrefix) || len(name) == len(configEnvPrefix) {
+			continue
+		}
+		section.entries = append(section.entries, confEntry{
+			key:    strings.TrimPrefix(name, configEnvPrefix),
+			value:  value,
+			source: "environment variable " + name,
+		})
+	}
+	if len(section.entries) > 0 {
+		l.merge(section, "environment")
+	}
+}
+
+// render writes the merged configuration in the conf format
+func (l *confLayers) render() []byte {
+	var buf bytes.Buffer
+	for _, s := range l.sections {
+		fmt.Fprintf(&buf, "[%s]\n", s.name)
+		for _, e := range s.entries {
+			fmt.Fprintf(&buf, "%s = %s\n", e.key, e.value)
+		}
+	}
+	return buf.Bytes()
+}
+
+// readQuicConfLayers reads the config files in order of increasing
+// precedence, then the Interface overrides of the environment. Conflicting
+// values are handled according to mode.
+func readQuicConfLayers(qc *QuicConf, files []string, environ []string, mode string, limits confLimits, logger *zap.SugaredLogger) error {
+	var layers confLayers
+	for _, configFile := range files {
+		if err := layerConfFile(&layers, configFile, limits); err != nil {
+			return err
+		}
+	}
+	layers.addEnv(environ)
+
+	switch mode {
+	case conflictsWarn:
+		for _, conflict := range layers.conflicts {
+			logger.Warnf("Config sources disagree, using the later one: %s", conflict)
+		}
+	case conflictsError:
+		if len(layers.conflicts) > 0 {
+			return fmt.Errorf("%w: %s", errConfigConflict, strings.Join(layers.conflicts, "; "))
+		}
+	}
+	return readQuicConfReader(qc, bytes.NewReader(layers.render()), confFormat, limits)
+}
+
+// layerConfFile adds a config file to the layers, encrypted files are decrypted in memory first
+func layerConfFile(layers *confLayers, configFile string, limits confLimits) error {
+	file, err := os.Open(configFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// Reads of pipes and FIFOs time out, regular files do not block
+	file.SetReadDeadline(time.Now().Add(limits.timeout))
+
+	r, err := plaintextConf(file, configFile, limits)
+	if err != nil {
+		return err
+	}
+	return layers.add(configFile, r)
+}
+
+// readConfig reads the configuration of the node from its config files and the environment into qc
+func (qn *QuicWire) readConfig(qc *QuicConf) error {
+	files := append([]string{qn.configFile}, qn.configLayers...)
+	return readQuicConfLayers(qc, files, os.Environ(), qn.configConflicts, qn.configLimits, qn.logger)
+}
+
+// SetConfigLimits bounds the size of each config source and the time spent
+// reading it, it must be called before Start. A limit of 0 keeps the default
+// of 1 MiB and 5s. Parsing the config read is not timed.
+func (qn *QuicWire) SetConfigLimits(maxSize int64, timeout time.Duration) {
+	if maxSize > 0 {
+		qn.configLimits.maxSize = maxSize
+	}
+	if timeout > 0 {
+		qn.configLimits.timeout = timeout
+	}
+}
+
+// AddConfigFile layers a config file over the config file of the node and
+// the files added before, it must be called before Start. Keys set by the
+// file override the earlier files, environment variables override them all.
+func (qn *QuicWire) AddConfigFile(configFile string) {
+	qn.configLayers = append(qn.configLayers, configFile)
+}
+
+// SetConfigConflicts sets how config sources setting a key to different
+// values are handled: ignore (default), warn or error. The later source wins
+// unless an error is returned.
+func (qn *QuicWire) SetConfigConflicts(mode string) error {
+	switch mode {
+	case conflictsIgnore, conflictsWarn, conflictsError:
+		qn.configConflicts = mode
+		return nil
+	}
+	return fmt.Errorf("invalid config conflict mode %q", mode)
+}
@@ -0,0 +1,132 @@
+package quicwire
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestConfLayersMerge(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []string
+		// peers lists the Endpoint of each merged Peer section
+		peers     []string
+		conflicts int
+	}{
+		{
+			name: "later source overrides a peer by its first allowed IP",
+			sources: []string{
+				"[Peer]\nEndpoint = a:1\nAllowedIPs = 10.0.0.2",
+				"[Peer]\nEndpoint = b:1\nAllowedIPs = 10.0.0.2, 10.1.0.0/16",
+			},
+			peers:     []string{"b:1"},
+			conflicts: 2,
+		},
+		{
+			name: "allowed IP forms are normalized",
+			sources: []string{
+				"[Peer]\nEndpoint = a:1\nAllowedIPs = 10.0.0.2/32",
+				"[Peer]\nEndpoint = a:1\nAllowedIPs =  10.0.0.2",
+			},
+			peers:     []string{"a:1"},
+			conflicts: 1,
+		},
+		{
+			name: "peers of one source stay distinct",
+			sources: []string{
+				"[Peer]\nEndpoint = a:1\nAllowedIPs = 10.0.0.2\n[Peer]\nEndpoint = b:1\nAllowedIPs = 10.0.0.2",
+			},
+			peers: []string{"a:1", "b:1"},
+		},
+		{
+			name: "other peers are added",
+			sources: []string{
+				"[Peer]\nEndpoint = a:1\nAllowedIPs = 10.0.0.2",
+				"[Peer]\nEndpoint = b:1\nAllowedIPs = 10.0.0.3",
+			},
+			peers: []string{"a:1", "b:1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var layers confLayers
+			for i, source := range tt.sources {
+				if err := layers.add(string(rune('a'+i)), strings.NewReader(source)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var peers []string
+			for _, s := range layers.sections {
+				if s.name == "Peer" {
+					endpoint, _ := s.value("Endpoint")
+					peers = append(peers, endpoint)
+				}
+			}
+			if strings.Join(peers, " ") != strings.Join(tt.peers, " ") {
+				t.Errorf("peers = %v, want %v", peers, tt.peers)
+			}
+			if len(layers.conflicts) != tt.conflicts {
+				t.Errorf("conflicts = %v, want %d", layers.conflicts, tt.conflicts)
+			}
+		})
+	}
+}
+
+func TestConfLayersEnv(t *testing.T) {
+	var layers confLayers
+	if err := layers.add("file", strings.NewReader("[Interface]\nListenPort = 1\nMTU = 1400")); err != nil {
+		t.Fatal(err)
+	}
+	layers.addEnv([]string{
+		configEnvPrefix + "ListenPort=2",
+		configEnvPrefix + "=ignored",
+		"OTHER_ListenPort=3",
+	})
+	if len(layers.sections) != 1 {
+		t.Fatalf("%d sections, want the Interface sections merged", len(layers.sections))
+	}
+	if port, _ := layers.sections[0].value("ListenPort"); port != "2" {
+		t.Errorf("ListenPort = %s, want the environment to win", port)
+	}
+	if mtu, _ := layers.sections[0].value("MTU"); mtu != "1400" {
+		t.Errorf("MTU = %s, want it kept from the file", mtu)
+	}
+}
+
+func TestReadQuicConfLayers(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.conf")
+	override := filepath.Join(dir, "override.conf")
+	if err := os.WriteFile(base, []byte("[Interface]\nListenPort = 1\n[Peer]\nEndpoint = a:1\nAllowedIPs = 10.0.0.2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(override, []byte("[Interface]\nListenPort = 2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{base, override}
+	tests := []struct {
+		mode string
+		err  error
+	}{
+		{mode: conflictsIgnore},
+		{mode: conflictsWarn},
+		{mode: conflictsError, err: errConfigConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			var qc QuicConf
+			err := readQuicConfLayers(&qc, files, nil, tt.mode, defaultConfLimits, zap.NewNop().Sugar())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && (qc.nodeInterface.listenPort != 2 || len(qc.peers) != 1) {
+				t.Errorf("ListenPort = %d with %d peers, want 2 with 1", qc.nodeInterface.listenPort, len(qc.peers))
+			}
+		})
+	}
+}
@@ -2,16 +2,81 @@ package quicwire
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/nacl/secretbox"
 )
 
+// confFormat is the WireGuard style format of the quicwire configuration file
+const confFormat = "conf"
+
+// minTunMTU is the smallest MTU every IPv4 host must accept
+const minTunMTU = 576
+
+const (
+	// defaultMaxConfigSize bounds the number of bytes read from a config source
+	defaultMaxConfigSize int64 = 1 << 20
+	// defaultConfigReadTimeout bounds the time spent reading a config source
+	defaultConfigReadTimeout = 5 * time.Second
+)
+
+// confLimits bound the size of a config source and the time spent reading it
+type confLimits struct {
+	maxSize int64
+	timeout time.Duration
+}
+
+// defaultConfLimits are the limits of nodes that did not call SetConfigLimits
+var defaultConfLimits = confLimits{maxSize: defaultMaxConfigSize, timeout: defaultConfigReadTimeout}
+
+// readDeadliner is a source whose blocked reads can be timed out, such as an os.File of a pipe
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// reader bounds r by the limits. The read deadline of r, if it has one, is set
+// to the timeout. Regular files do not support deadlines but never block.
+func (l confLimits) reader(r io.Reader) *boundedReader {
+	deadline := time.Now().Add(l.timeout)
+	if d, ok := r.(readDeadliner); ok {
+		d.SetReadDeadline(deadline)
+	}
+	return &boundedReader{r: r, limits: l, remaining: l.maxSize, deadline: deadline}
+}
+
 // Peer represents a peer in the quicwire configuration file
 type Peer struct {
 	allowedIPs          []string
 	endpoint            string
 	persistentKeepalive string
+	maxInFlight         int
+	addressFamily       string
+	priority            int
+	// dialParallelism is the number of addresses of the endpoint dialed at a
+	// time, candidateTimeout bounds the dial of each of them
+	dialParallelism  int
+	candidateTimeout time.Duration
+	// acl filters the packets received from the peer
+	acl acl
+	// masquerade source NATs the traffic from the allowed IPs leaving through the masquerade interface
+	masquerade bool
+	// dscp marks the packets sent to the peer, overriding the DSCP of the node
+	dscp int
+	// required peers must be connected before the node reports it is ready
+	required bool
+	// learned is set for peers adopted from the routes they announced
+	learned bool
+	// logLevel overrides the log level of the node for the connection and forwarding events of the peer
+	logLevel string
 }
 
 // nodeInterface represents the node interface in the quicwire configuration file
@@ -19,6 +84,121 @@ type nodeInterface struct {
 	listenPort    int
 	localEndpoint string
 	localNodeIP   string
+	// connRateLimit is the number of connection attempts accepted per second from a source IP
+	connRateLimit int
+	connRateBurst int
+	mtu           int
+	// acceptAnnouncedRoutes adopts the routes announced by unknown peers connecting to the server
+	acceptAnnouncedRoutes bool
+	announceRoutes        []string
+	// relay forwards packets between peers without the tunnel interface,
+	// maxHops is the hop count of the packets the node sends
+	relay   bool
+	maxHops int
+	// maxPeerRoutes is the largest number of routes adopted from a single peer, 0 uses maxAnnouncedRoutes
+	maxPeerRoutes int
+	// localPackets is how packets to the tunnel address of the node are handled
+	localPackets string
+	// stunServers are the STUN servers probed for the NAT binding
+	stunServers []string
+	// stopTimeout bounds the graceful part of Stop
+	stopTimeout time.Duration
+	// forwardingCPUs are the CPU cores the forwarding goroutines are pinned to
+	forwardingCPUs []int
+	// maxBatchBytes enables batching small packets into datagrams of up to
+	// this size, a partial batch is sent after flushInterval
+	maxBatchBytes int
+	flushInterval time.Duration
+	// maxBufferBytes bounds the memory of the batches and reassemblies of all peers, 0 leaves it unbounded
+	maxBufferBytes int
+	// egressQueueBytes bounds the queue of the packets to each peer and enables fair sending across
+	// peers, 0 sends them from the read loop where a peer slow to acknowledge delays all others
+	egressQueueBytes int
+	// networkID names the mesh, peers exchanging another network ID in the hello are rejected
+	networkID string
+	// connectionToken is a shared secret every peer presents in its hello
+	connectionToken string
+	// statelessRetry validates the source address of new connections with a QUIC retry
+	statelessRetry bool
+	// sessionTicketKeys are the session ticket keys shared with other servers, empty
+	// rotates a random key every sessionTicketRotation
+	sessionTicketKeys     [][32]byte
+	sessionTicketRotation time.Duration
+	// controlAddr is the address the HTTP control API listens on, empty disables it
+	controlAddr string
+	// statsdAddr is the StatsD server metrics are pushed to every statsdInterval, empty disables the push
+	statsdAddr     string
+	statsdInterval time.Duration
+	statsdFormat   string
+	// revocationCRL and revocationOCSP are the sources peer certificates are
+	// checked against, revocationMode decides on undetermined statuses
+	revocationCRL        string
+	revocationCRLRefresh time.Duration
+	revocationOCSP       string
+	revocationMode       string
+	// checkCertValidity refuses peer certificates outside of their validity
+	// period widened by certValidityTolerance, pointing at clock skew
+	checkCertValidity     bool
+	certValidityTolerance time.Duration
+	// caCertificate is the CA the certificates of the peers are verified
+	// against, certificate and privateKey are the signed certificate of the node
+	caCertificate string
+	certificate   string
+	privateKey    string
+	// mode is the device mode of the tunnel interface, tun or tap
+	mode string
+	// etherTypes are the L2 protocols forwarded in TAP mode
+	etherTypes []uint16
+	// maxReconnects is the number of failed dial cycles after which a peer is marked failed
+	maxReconnects int
+	// statsFile is periodically replaced with a snapshot of the stats and recent drops, empty disables it
+	statsFile         string
+	statsFileInterval time.Duration
+	// masqueradeInterface is the interface forwarded traffic is source NATed on, empty disables masquerading.
+	// masquerade source NATs the traffic from the tunnel subnet.
+	masqueradeInterface string
+	masquerade          bool
+	// dscp marks the packets sent from the shared socket, 0 leaves them unmarked
+	dscp int
+	// fwmark is the firewall mark of the shared socket for policy routing, 0 leaves it unset
+	fwmark uint32
+	// noUDPBatching makes QUIC read the shared socket one datagram at a time
+	noUDPBatching bool
+	// compression are the packet compressors offered to peers in order of preference, empty disables compression
+	compression []string
+	// resolveInterval is how long resolved peer endpoints are cached when the resolver does not tell the TTL
+	resolveInterval time.Duration
+	// routeReconcileInterval is how often route views are compared with dialed peers, 0 disables it
+	routeReconcileInterval time.Duration
+	// tunFailure is the policy applied when the tunnel interface fails: stop, keep or recover
+	tunFailure string
+	// tunRetries is the number of times creating the tunnel interface at startup is retried, backing
+	// off from tunRetryInterval, e.g. for nodes started before the tun module is loaded. It also
+	// bounds the attempts of the recover policy, which otherwise retries until the node stops.
+	tunRetries       int
+	tunRetryInterval time.Duration
+	// idleTimeout is the QUIC max idle timeout, idleMode decides whether idled
+	// out connections are redialed right away or on the next packet
+	idleTimeout time.Duration
+	idleMode    string
+	// idleClose is how idle connections are closed in teardown mode: abort or graceful
+	idleClose string
+	// congestionLoss and congestionRTT are the loss rate and RTT past which
+	// a peer is de-preferred once they held for congestionHold, 0 disables them
+	congestionLoss float64
+	congestionRTT  time.Duration
+	congestionHold time.Duration
+	// logDedupWindow is how long repetitive warnings and errors are collapsed
+	logDedupWindow time.Duration
+	// dialConcurrency is the number of peer handshakes running at a time
+	dialConcurrency int
+	// mirrorPeer is the allowed IP of the peer receiving a copy of the traffic matching mirrorCIDRs
+	mirrorPeer  string
+	mirrorCIDRs []string
+	// mirrorCapture is the pcapng file traffic mirrored to this node is written to,
+	// with captureDrops the packets dropped by the node are added with the drop reason
+	mirrorCapture string
+	captureDrops  bool
 }
 
 // QuicConf contains the quicwire configuration file data
@@ -27,19 +207,162 @@ type QuicConf struct {
 	peers         []Peer
 }
 
-func readQuicConf(qc *QuicConf, configFile string) error {
-	file, err := os.Open(configFile)
+// plaintextConf returns a reader of the plaintext of a config file bounded
+// by the limits, encrypted files are decrypted in memory. Only reading the
+// file is timed, the size limit applies to the plaintext.
+func plaintextConf(file io.Reader, configFile string, limits confLimits) (io.Reader, error) {
+	r := bufio.NewReader(file)
+	header, _ := r.Peek(len(encryptedConfigMagic))
+	if !isEncryptedConfig(header) {
+		return limits.reader(r), nil
+	}
+
+	// The file carries the header, salt, nonce and authenticator on top of the plaintext
+	sealed := limits.reader(r)
+	sealed.remaining += int64(len(encryptedConfigMagic) + configSaltLen + configNonceLen + secretbox.Overhead)
+	data, err := io.ReadAll(sealed)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	key, err := configKey()
+	if err != nil {
+		return nil, err
 	}
-	defer file.Close()
+	plaintext, err := decryptConfig(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt config file %s: %w", configFile, err)
+	}
+	return bytes.NewReader(plaintext), nil
+}
 
-	scanner := bufio.NewScanner(file)
+// readQuicConfReader parses the configuration in the given format from r.
+// Sources larger than the size limit or taking longer than the timeout to
+// read are rejected. The timeout bounds reading the source, parsing what was
+// read is not timed.
+func readQuicConfReader(qc *QuicConf, r io.Reader, format string, limits confLimits) error {
+	r = limits.reader(r)
+	switch format {
+	case confFormat:
+		return parseConf(qc, r)
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+}
+
+func parseConf(qc *QuicConf, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
 
 	// Variables to store values from the file
-	var section, localEndpoint, localNodeIP, endpoint, persistentKeepalive string
-	var listenPort int
+	var section, localEndpoint, localNodeIP, endpoint, persistentKeepalive, addressFamily string
+	var mirrorPeer, mirrorCapture, localPackets, networkID, connectionToken, controlAddr, statsdAddr, statsdFormat string
+	var revocationCRL, revocationOCSP, revocationMode, mode, statsFile, idleMode, idleClose, tunFailure, masqueradeInterface string
+	var etherTypes []uint16
+	var mirrorCIDRs, announceRoutes, stunServers, compression []string
+	var acceptAnnouncedRoutes, masquerade, peerMasquerade, required, captureDrops, checkCertValidity, statelessRetry, relay, noUDPBatching bool
+	var listenPort, maxInFlight, priority, connRateLimit, connRateBurst, mtu, dialConcurrency int
 	var allowedIPs []string
+	var peerACL acl
+	var stopTimeout, flushInterval, statsdInterval, revocationCRLRefresh, statsFileInterval, logDedupWindow, idleTimeout, routeReconcileInterval, candidateTimeout, resolveInterval, certValidityTolerance time.Duration
+	var maxBatchBytes, maxBufferBytes, egressQueueBytes, maxReconnects, dscp, peerDSCP, dialParallelism, maxPeerRoutes, maxHops int
+	var forwardingCPUs []int
+	var fwmark uint32
+	var congestionLoss float64
+	var congestionRTT, congestionHold time.Duration
+	var sessionTicketKeys [][32]byte
+	var sessionTicketRotation time.Duration
+	var peerLogLevel string
+	var tunRetries int
+	var caCertificate, certificate, privateKey string
+	var tunRetryInterval time.Duration
+	var err error
+
+	// Store the values of the section that was just read
+	flushSection := func() {
+		switch section {
+		case "Interface":
+			qc.nodeInterface.listenPort = listenPort
+			qc.nodeInterface.localNodeIP = localNodeIP
+			qc.nodeInterface.localEndpoint = localEndpoint
+			qc.nodeInterface.connRateLimit = connRateLimit
+			qc.nodeInterface.connRateBurst = connRateBurst
+			qc.nodeInterface.mtu = mtu
+			qc.nodeInterface.dialConcurrency = dialConcurrency
+			qc.nodeInterface.localPackets = localPackets
+			qc.nodeInterface.networkID = networkID
+			qc.nodeInterface.connectionToken = connectionToken
+			qc.nodeInterface.statelessRetry = statelessRetry
+			qc.nodeInterface.sessionTicketKeys = sessionTicketKeys
+			qc.nodeInterface.sessionTicketRotation = sessionTicketRotation
+			qc.nodeInterface.controlAddr = controlAddr
+			qc.nodeInterface.statsdAddr = statsdAddr
+			qc.nodeInterface.revocationCRL = revocationCRL
+			qc.nodeInterface.checkCertValidity = checkCertValidity
+			qc.nodeInterface.caCertificate = caCertificate
+			qc.nodeInterface.certificate = certificate
+			qc.nodeInterface.privateKey = privateKey
+			qc.nodeInterface.certValidityTolerance = certValidityTolerance
+			qc.nodeInterface.mode = mode
+			qc.nodeInterface.statsFile = statsFile
+			qc.nodeInterface.logDedupWindow = logDedupWindow
+			qc.nodeInterface.idleTimeout = idleTimeout
+			qc.nodeInterface.idleMode = idleMode
+			qc.nodeInterface.idleClose = idleClose
+			qc.nodeInterface.congestionLoss = congestionLoss
+			qc.nodeInterface.congestionRTT = congestionRTT
+			qc.nodeInterface.congestionHold = congestionHold
+			qc.nodeInterface.tunFailure = tunFailure
+			qc.nodeInterface.tunRetries = tunRetries
+			qc.nodeInterface.tunRetryInterval = tunRetryInterval
+			qc.nodeInterface.routeReconcileInterval = routeReconcileInterval
+			qc.nodeInterface.resolveInterval = resolveInterval
+			qc.nodeInterface.compression = compression
+			qc.nodeInterface.masqueradeInterface = masqueradeInterface
+			qc.nodeInterface.masquerade = masquerade
+			qc.nodeInterface.dscp = dscp
+			qc.nodeInterface.fwmark = fwmark
+			qc.nodeInterface.noUDPBatching = noUDPBatching
+			qc.nodeInterface.statsFileInterval = statsFileInterval
+			qc.nodeInterface.etherTypes = etherTypes
+			qc.nodeInterface.revocationCRLRefresh = revocationCRLRefresh
+			qc.nodeInterface.revocationOCSP = revocationOCSP
+			qc.nodeInterface.revocationMode = revocationMode
+			qc.nodeInterface.statsdInterval = statsdInterval
+			qc.nodeInterface.statsdFormat = statsdFormat
+			qc.nodeInterface.stunServers = stunServers
+			qc.nodeInterface.stopTimeout = stopTimeout
+			qc.nodeInterface.forwardingCPUs = forwardingCPUs
+			qc.nodeInterface.maxBatchBytes = maxBatchBytes
+			qc.nodeInterface.maxBufferBytes = maxBufferBytes
+			qc.nodeInterface.egressQueueBytes = egressQueueBytes
+			qc.nodeInterface.maxReconnects = maxReconnects
+			qc.nodeInterface.flushInterval = flushInterval
+			qc.nodeInterface.acceptAnnouncedRoutes = acceptAnnouncedRoutes
+			qc.nodeInterface.announceRoutes = announceRoutes
+			qc.nodeInterface.maxPeerRoutes = maxPeerRoutes
+			qc.nodeInterface.relay = relay
+			qc.nodeInterface.maxHops = maxHops
+			qc.nodeInterface.mirrorPeer = mirrorPeer
+			qc.nodeInterface.mirrorCIDRs = mirrorCIDRs
+			qc.nodeInterface.mirrorCapture = mirrorCapture
+			qc.nodeInterface.captureDrops = captureDrops
+		case "Peer":
+			qc.peers = append(qc.peers, Peer{
+				allowedIPs:          allowedIPs,
+				endpoint:            endpoint,
+				persistentKeepalive: persistentKeepalive,
+				maxInFlight:         maxInFlight,
+				addressFamily:       addressFamily,
+				priority:            priority,
+				acl:                 peerACL,
+				masquerade:          peerMasquerade,
+				dscp:                peerDSCP,
+				required:            required,
+				dialParallelism:     dialParallelism,
+				candidateTimeout:    candidateTimeout,
+				logLevel:            peerLogLevel,
+			})
+		}
+	}
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -53,25 +376,23 @@ func readQuicConf(qc *QuicConf, configFile string) error {
 
 		// Check if the line starts with a section header
 		if line[0] == '[' && line[len(line)-1] == ']' {
-			if section != "" && section == "Interface" {
-				qc.nodeInterface.listenPort = listenPort
-				qc.nodeInterface.localNodeIP = localNodeIP
-				qc.nodeInterface.localEndpoint = localEndpoint
-			}
-
-			if section != "" && section == "Peer" {
-				qc.peers = append(qc.peers, Peer{
-					allowedIPs:          allowedIPs,
-					endpoint:            endpoint,
-					persistentKeepalive: persistentKeepalive,
-				})
-			}
+			flushSection()
 
 			// Extract the section name and print it
 			section = line[1 : len(line)-1]
 
 			// Reset variables for new section
 			allowedIPs = nil
+			maxInFlight = 0
+			addressFamily = ""
+			priority = 0
+			peerACL = nil
+			peerMasquerade = false
+			peerDSCP = 0
+			required = false
+			dialParallelism = 0
+			candidateTimeout = 0
+			peerLogLevel = ""
 
 		} else {
 			// Split the line into key and value parts
@@ -94,35 +415,480 @@ func readQuicConf(qc *QuicConf, configFile string) error {
 			case "LocalEndpoint":
 				localEndpoint = value
 			case "LocalNodeIp":
+				if net.ParseIP(value) == nil {
+					return fmt.Errorf("invalid LocalNodeIp %q", value)
+				}
 				localNodeIP = value
+			case "MTU":
+				mtu, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if mtu < minTunMTU || mtu > maxTunMTU {
+					return fmt.Errorf("MTU %d is outside of the supported range %d-%d", mtu, minTunMTU, maxTunMTU)
+				}
+			case "MirrorPeer":
+				mirrorPeer = value
+			case "MirrorCIDRs":
+				mirrorCIDRs = strings.Split(value, ",")
+			case "MirrorCapture":
+				mirrorCapture = value
+			case "CaptureDrops":
+				captureDrops, err = strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+			case "AcceptAnnouncedRoutes":
+				acceptAnnouncedRoutes, err = strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+			case "AnnounceRoutes":
+				announceRoutes = strings.Split(value, ",")
+			case "Relay":
+				relay, err = strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+			case "MaxHops":
+				maxHops, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if maxHops < 1 || maxHops > maxHopLimit {
+					return fmt.Errorf("MaxHops %d is outside of the supported range 1-%d", maxHops, maxHopLimit)
+				}
+			case "MaxPeerRoutes":
+				maxPeerRoutes, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if maxPeerRoutes < 1 {
+					return fmt.Errorf("MaxPeerRoutes must be at least 1")
+				}
+			case "MaxReconnects":
+				maxReconnects, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if maxReconnects < 0 {
+					return fmt.Errorf("MaxReconnects %d must not be negative", maxReconnects)
+				}
+			case "MaxBatchBytes":
+				maxBatchBytes, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if maxBatchBytes < 0 {
+					return fmt.Errorf("MaxBatchBytes %d must not be negative", maxBatchBytes)
+				}
+			case "MaxBufferBytes":
+				maxBufferBytes, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if maxBufferBytes < 0 {
+					return fmt.Errorf("MaxBufferBytes %d must not be negative", maxBufferBytes)
+				}
+			case "EgressQueueBytes":
+				egressQueueBytes, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if egressQueueBytes < 0 {
+					return fmt.Errorf("EgressQueueBytes %d must not be negative", egressQueueBytes)
+				}
+			case "FlushInterval":
+				flushInterval, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "ForwardingCPUs":
+				forwardingCPUs = nil
+				for _, cpu := range strings.Split(value, ",") {
+					n, err := strconv.Atoi(strings.TrimSpace(cpu))
+					if err != nil {
+						return err
+					}
+					if n < 0 {
+						return fmt.Errorf("invalid CPU %d in ForwardingCPUs", n)
+					}
+					forwardingCPUs = append(forwardingCPUs, n)
+				}
+			case "StopTimeout":
+				stopTimeout, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "StunServers":
+				for _, server := range strings.Split(value, ",") {
+					if server = strings.TrimSpace(server); server != "" {
+						stunServers = append(stunServers, server)
+					}
+				}
+			case "IdleTimeout":
+				idleTimeout, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "IdleMode":
+				switch value {
+				case idleModeRedial, idleModeTeardown:
+					idleMode = value
+				default:
+					return fmt.Errorf("invalid IdleMode %q", value)
+				}
+			case "IdleClose":
+				switch value {
+				case idleCloseAbort, idleCloseGraceful:
+					idleClose = value
+				default:
+					return fmt.Errorf("invalid IdleClose %q", value)
+				}
+			case "CongestionLoss":
+				congestionLoss, err = strconv.ParseFloat(value, 64)
+				if err != nil {
+					return err
+				}
+				if congestionLoss < 0 || congestionLoss > 1 {
+					return fmt.Errorf("CongestionLoss %v is outside of the range 0-1", congestionLoss)
+				}
+			case "CongestionRTT":
+				congestionRTT, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "CongestionHold":
+				congestionHold, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "UDPBatching":
+				batching, err := strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+				noUDPBatching = !batching
+			case "DSCP":
+				n, err := strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if n < 0 || n > maxDSCP {
+					return fmt.Errorf("DSCP %d is outside of the range 0-%d", n, maxDSCP)
+				}
+				if section == "Peer" {
+					peerDSCP = n
+				} else {
+					dscp = n
+				}
+			case "FwMark":
+				n, err := strconv.ParseUint(value, 0, 32)
+				if err != nil {
+					return err
+				}
+				fwmark = uint32(n)
+			case "MasqueradeInterface":
+				masqueradeInterface = value
+			case "Masquerade":
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+				if section == "Peer" {
+					peerMasquerade = b
+				} else {
+					masquerade = b
+				}
+			case "Compression":
+				compression = nil
+				for _, name := range strings.Split(value, ",") {
+					name = strings.TrimSpace(name)
+					if _, ok := compressorIDs[name]; !ok {
+						return fmt.Errorf("invalid compressor %q in Compression", name)
+					}
+					compression = append(compression, name)
+				}
+			case "ResolveInterval":
+				resolveInterval, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "RouteReconcileInterval":
+				routeReconcileInterval, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "TunFailure":
+				switch value {
+				case tunFailureStop, tunFailureKeep, tunFailureRecover:
+					tunFailure = value
+				default:
+					return fmt.Errorf("invalid TunFailure %q", value)
+				}
+			case "TunRetries":
+				tunRetries, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if tunRetries < 0 {
+					return fmt.Errorf("TunRetries %d is negative", tunRetries)
+				}
+			case "TunRetryInterval":
+				tunRetryInterval, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "LogDedupWindow":
+				logDedupWindow, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "StatsFile":
+				statsFile = value
+			case "StatsFileInterval":
+				statsFileInterval, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "Mode":
+				switch value {
+				case modeTUN, modeTAP:
+					mode = value
+				default:
+					return fmt.Errorf("invalid Mode %q", value)
+				}
+			case "EtherTypes":
+				etherTypes, err = parseEtherTypes(value)
+				if err != nil {
+					return err
+				}
+			case "CheckCertValidity":
+				checkCertValidity, err = strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+			case "CertValidityTolerance":
+				certValidityTolerance, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "CACertificate":
+				caCertificate = value
+			case "Certificate":
+				certificate = value
+			case "PrivateKey":
+				privateKey = value
+			case "RevocationCRL":
+				revocationCRL = value
+			case "RevocationCRLRefresh":
+				revocationCRLRefresh, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "RevocationOCSP":
+				revocationOCSP = value
+			case "RevocationMode":
+				switch value {
+				case revocationSoftFail, revocationHardFail:
+					revocationMode = value
+				default:
+					return fmt.Errorf("invalid RevocationMode %q", value)
+				}
+			case "StatsdAddr":
+				statsdAddr = value
+			case "StatsdInterval":
+				statsdInterval, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "StatsdFormat":
+				switch value {
+				case statsdFormatStatsd, statsdFormatDogStatsd:
+					statsdFormat = value
+				default:
+					return fmt.Errorf("invalid StatsdFormat %q", value)
+				}
+			case "NetworkID":
+				networkID = value
+			case "ConnectionToken":
+				connectionToken = value
+			case "StatelessRetry":
+				statelessRetry, err = strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+			case "SessionTicketKeys":
+				sessionTicketKeys, err = parseTicketKeys(value)
+				if err != nil {
+					return err
+				}
+			case "SessionTicketRotation":
+				sessionTicketRotation, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "ControlAddr":
+				controlAddr = value
+			case "LocalPackets":
+				switch value {
+				case localPacketsDrop, localPacketsLoopback:
+					localPackets = value
+				default:
+					return fmt.Errorf("invalid LocalPackets %q", value)
+				}
+			case "DialConcurrency":
+				dialConcurrency, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if dialConcurrency < 0 {
+					return fmt.Errorf("DialConcurrency %d must not be negative", dialConcurrency)
+				}
+			case "ConnRateLimit":
+				connRateLimit, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if connRateLimit < 0 {
+					return fmt.Errorf("ConnRateLimit %d must not be negative", connRateLimit)
+				}
+			case "ConnRateBurst":
+				connRateBurst, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if connRateBurst < 0 {
+					return fmt.Errorf("ConnRateBurst %d must not be negative", connRateBurst)
+				}
 			case "AllowedIPs":
 				allowedIPs = strings.Split(value, ",")
 			case "Endpoint":
 				endpoint = value
 			case "PersistentKeepalive":
 				persistentKeepalive = value
+			case "MaxInFlight":
+				maxInFlight, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if maxInFlight < 0 {
+					return fmt.Errorf("MaxInFlight %d must not be negative", maxInFlight)
+				}
+			case "Priority":
+				priority, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+			case "DialParallelism":
+				dialParallelism, err = strconv.Atoi(value)
+				if err != nil {
+					return err
+				}
+				if dialParallelism < 0 {
+					return fmt.Errorf("invalid DialParallelism %d", dialParallelism)
+				}
+			case "CandidateTimeout":
+				candidateTimeout, err = time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+			case "Required":
+				required, err = strconv.ParseBool(value)
+				if err != nil {
+					return err
+				}
+			case "LogLevel":
+				if _, err := zapcore.ParseLevel(value); err != nil {
+					return err
+				}
+				peerLogLevel = value
+			case "ACL":
+				peerACL, err = parseACL(value)
+				if err != nil {
+					return err
+				}
+			case "AddressFamily":
+				switch value {
+				case familyPreferV4, familyPreferV6, familyHappyEyeballs:
+					addressFamily = value
+				default:
+					return fmt.Errorf("invalid AddressFamily %q", value)
+				}
 			default:
 			}
 
 		}
 	}
-	if section != "" && section == "Interface" {
-		qc.nodeInterface.listenPort = listenPort
-		qc.nodeInterface.localNodeIP = localNodeIP
-		qc.nodeInterface.localEndpoint = localEndpoint
+	flushSection()
+
+	if err := scanner.Err(); err != nil {
+		return err
 	}
 
-	if section != "" && section == "Peer" {
-		qc.peers = append(qc.peers, Peer{
-			allowedIPs:          allowedIPs,
-			endpoint:            endpoint,
-			persistentKeepalive: persistentKeepalive,
-		})
+	if qc.nodeInterface.masqueradeInterface == "" {
+		if qc.nodeInterface.masquerade {
+			return fmt.Errorf("Masquerade needs a MasqueradeInterface")
+		}
+		for _, peer := range qc.peers {
+			if peer.masquerade {
+				return fmt.Errorf("Masquerade of peer %s needs a MasqueradeInterface", peer.endpoint)
+			}
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+	if qc.nodeInterface.captureDrops && qc.nodeInterface.mirrorCapture == "" {
+		return fmt.Errorf("CaptureDrops needs a MirrorCapture")
+	}
+
+	// ACLs and mirroring parse IP headers, they do not apply to Ethernet frames
+	if qc.nodeInterface.mode == modeTAP {
+		if qc.nodeInterface.mirrorPeer != "" {
+			return fmt.Errorf("MirrorPeer is not supported in TAP mode")
+		}
+		if qc.nodeInterface.captureDrops {
+			return fmt.Errorf("CaptureDrops is not supported in TAP mode")
+		}
+		for _, peer := range qc.peers {
+			if len(peer.acl) > 0 {
+				return fmt.Errorf("ACL of peer %s is not supported in TAP mode", peer.endpoint)
+			}
+		}
 	}
 
 	return nil
 }
+
+// boundedReader fails once more than the allowed bytes were read or the
+// deadline passed. The deadline is checked before every read, a source
+// blocking in Read is only interrupted when it supports read deadlines, as
+// pipes and FIFOs opened with os.Open do.
+type boundedReader struct {
+	r         io.Reader
+	limits    confLimits
+	remaining int64
+	deadline  time.Time
+}
+
+func (b *boundedReader) Read(p []byte) (int, error) {
+	if !time.Now().Before(b.deadline) {
+		return 0, b.timeoutErr()
+	}
+	// Read one byte past the limit to tell an exact fit from an oversized source
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.r.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, fmt.Errorf("config exceeds the maximum size of %d bytes", b.limits.maxSize)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, b.timeoutErr()
+	}
+	return n, err
+}
+
+func (b *boundedReader) timeoutErr() error {
+	return fmt.Errorf("reading the config exceeded the timeout of %s", b.limits.timeout)
+}
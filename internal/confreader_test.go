@@ -0,0 +1,154 @@
+package quicwire
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConf(t *testing.T) {
+	conf := `# comment
+[Interface]
+ListenPort = 55381
+LocalEndpoint = 10.0.0.1/24
+LocalNodeIp = 192.168.1.10
+NetworkID = lab
+MaxHops = 4
+TunRetries = 5
+TunRetryInterval = 2s
+
+[Peer]
+Endpoint = 192.168.1.11:55381
+AllowedIPs = 10.0.0.2,10.1.0.0/16
+PersistentKeepalive = 25
+Priority = 2
+
+[Peer]
+Endpoint = 192.168.1.12:55381
+AllowedIPs = 10.0.0.3
+`
+	var qc QuicConf
+	if err := readQuicConfReader(&qc, bytes.NewReader([]byte(conf)), confFormat, defaultConfLimits); err != nil {
+		t.Fatal(err)
+	}
+	ni := qc.nodeInterface
+	if ni.listenPort != 55381 || ni.localEndpoint != "10.0.0.1/24" || ni.localNodeIP != "192.168.1.10" {
+		t.Errorf("interface = %+v", ni)
+	}
+	if ni.networkID != "lab" || ni.maxHops != 4 {
+		t.Errorf("network ID %q, MaxHops %d", ni.networkID, ni.maxHops)
+	}
+	if ni.tunRetries != 5 || ni.tunRetryInterval != 2*time.Second {
+		t.Errorf("TunRetries %d, TunRetryInterval %s", ni.tunRetries, ni.tunRetryInterval)
+	}
+	if len(qc.peers) != 2 {
+		t.Fatalf("read %d peers, want 2", len(qc.peers))
+	}
+	if p := qc.peers[0]; p.endpoint != "192.168.1.11:55381" || strings.Join(p.allowedIPs, ",") != "10.0.0.2,10.1.0.0/16" || p.persistentKeepalive != "25" || p.priority != 2 {
+		t.Errorf("first peer = %+v", p)
+	}
+	// Peer keys do not carry over to the next section
+	if p := qc.peers[1]; p.endpoint != "192.168.1.12:55381" || len(p.allowedIPs) != 1 || p.priority != 0 {
+		t.Errorf("second peer = %+v", p)
+	}
+}
+
+// parseConfError returns the error of parsing the config
+func parseConfError(conf string) error {
+	var qc QuicConf
+	return readQuicConfReader(&qc, bytes.NewReader([]byte(conf)), confFormat, defaultConfLimits)
+}
+
+func TestParseConfRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		conf string
+		err  string
+	}{
+		{name: "port", conf: "[Interface]\nListenPort = port", err: "invalid syntax"},
+		{name: "fwmark", conf: "[Interface]\nFwMark = -1", err: "invalid syntax"},
+		{name: "hops", conf: "[Interface]\nMaxHops = 0", err: "MaxHops 0"},
+		{name: "negative connection rate", conf: "[Interface]\nConnRateLimit = -1", err: "ConnRateLimit"},
+		{name: "negative connection burst", conf: "[Interface]\nConnRateBurst = -5", err: "ConnRateBurst"},
+		{name: "negative dial concurrency", conf: "[Interface]\nDialConcurrency = -2", err: "DialConcurrency"},
+		{name: "negative batch size", conf: "[Interface]\nMaxBatchBytes = -1", err: "MaxBatchBytes"},
+		{name: "negative buffer budget", conf: "[Interface]\nMaxBufferBytes = -1", err: "MaxBufferBytes"},
+		{name: "negative reconnects", conf: "[Interface]\nMaxReconnects = -1", err: "MaxReconnects"},
+		{name: "negative TUN retries", conf: "[Interface]\nTunRetries = -1", err: "TunRetries -1 is negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := parseConfError(tt.conf); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestReadQuicConfReaderFormat(t *testing.T) {
+	var qc QuicConf
+	if err := readQuicConfReader(&qc, bytes.NewReader(nil), "yaml", defaultConfLimits); err == nil {
+		t.Error("read a config in an unsupported format")
+	}
+}
+
+func TestReadQuicConfReaderLimits(t *testing.T) {
+	conf := "[Interface]\nListenPort = 55381\n"
+	tests := []struct {
+		name   string
+		r      func(t *testing.T) io.Reader
+		limits confLimits
+		err    string
+	}{
+		{
+			name:   "within the size",
+			r:      func(*testing.T) io.Reader { return strings.NewReader(conf) },
+			limits: confLimits{maxSize: int64(len(conf)), timeout: time.Second},
+		},
+		{
+			name:   "over the size",
+			r:      func(*testing.T) io.Reader { return strings.NewReader(conf) },
+			limits: confLimits{maxSize: int64(len(conf)) - 1, timeout: time.Second},
+			err:    "maximum size",
+		},
+		{
+			name: "line longer than the parser accepts",
+			r: func(*testing.T) io.Reader {
+				return strings.NewReader("[Interface]\nLocalEndpoint = " + strings.Repeat("1", 128<<10) + "\n")
+			},
+			limits: defaultConfLimits,
+			err:    "too long",
+		},
+		{
+			name: "pipe nobody writes to",
+			r: func(t *testing.T) io.Reader {
+				r, w, err := os.Pipe()
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() {
+					r.Close()
+					w.Close()
+				})
+				return r
+			},
+			limits: confLimits{maxSize: defaultMaxConfigSize, timeout: 20 * time.Millisecond},
+			err:    "timeout",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var qc QuicConf
+			err := readQuicConfReader(&qc, tt.r(t), confFormat, tt.limits)
+			if tt.err == "" && err != nil {
+				t.Errorf("error = %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
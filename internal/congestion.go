@@ -0,0 +1,123 @@
+package quicwire
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// congestionCheckInterval is how often the path stats of connected peers are sampled
+	congestionCheckInterval = 5 * time.Second
+	// defaultCongestionHold is how long the path stats must stay past a
+	// threshold before a peer is de-preferred or preferred again
+	defaultCongestionHold = 30 * time.Second
+	// congestionMinSamples is the fewest packets sent in an interval for its loss rate to count
+	congestionMinSamples = 50
+	// congestionRecoverRatio scales the thresholds a de-preferred peer must
+	// stay below to be preferred again, so a peer hovering around a threshold
+	// does not flap
+	congestionRecoverRatio = 0.5
+)
+
+// congestionEnabled reports whether peers are de-preferred for the loss or RTT of their connection
+func (qn *QuicWire) congestionEnabled() bool {
+	return qn.qc.nodeInterface.congestionLoss > 0 || qn.qc.nodeInterface.congestionRTT > 0
+}
+
+// pathStats returns the RTT and loss of the connection, nil when they are not known
+func (qn *QuicWire) pathStats(c *Client) *pathStats {
+	t, ok := qn.tracer.(*pathTracer)
+	if !ok || c.connection == nil {
+		return nil
+	}
+	return t.connStats(c.connection)
+}
+
+// congestionMonitor decides from samples of the path stats of a connection
+// whether its peer is congested. The peer becomes congested once the loss or
+// RTT stayed at or above their threshold for hold, and recovers once both
+// stayed below congestionRecoverRatio of their threshold for hold.
+type congestionMonitor struct {
+	loss      float64
+	rtt       time.Duration
+	hold      time.Duration
+	congested bool
+
+	prevSent, prevLost uint64
+	// since is when the samples started to disagree with the current state
+	since time.Time
+}
+
+// sample takes the packets sent and lost over the connection so far and its
+// RTT at now. It returns the loss of the interval since the previous sample
+// and whether the congested state changed.
+func (m *congestionMonitor) sample(sent, lost uint64, rtt time.Duration, now time.Time) (loss float64, changed bool) {
+	if sent-m.prevSent >= congestionMinSamples {
+		loss = float64(lost-m.prevLost) / float64(sent-m.prevSent)
+	}
+	m.prevSent, m.prevLost = sent, lost
+
+	scale := 1.0
+	if m.congested {
+		scale = congestionRecoverRatio
+	}
+	bad := m.loss > 0 && loss >= m.loss*scale ||
+		m.rtt > 0 && rtt >= time.Duration(float64(m.rtt)*scale)
+	if bad == m.congested {
+		m.since = time.Time{}
+		return loss, false
+	}
+	if m.since.IsZero() {
+		m.since = now
+	}
+	if now.Sub(m.since) < m.hold {
+		return loss, false
+	}
+	m.congested, m.since = bad, time.Time{}
+	return loss, true
+}
+
+// watchCongestion samples the path stats of the connection to the peer and
+// de-preferences its routes, like MigrateAwayFrom, while congestionMonitor
+// finds it congested. The peer is preferred again when the connection closes.
+func (qn *QuicWire) watchCongestion(ctx context.Context, key string, c *Client) {
+	stats := qn.pathStats(c)
+	if stats == nil {
+		return
+	}
+	ni := qn.qc.nodeInterface
+	hold := ni.congestionHold
+	if hold <= 0 {
+		hold = defaultCongestionHold
+	}
+	m := &congestionMonitor{loss: ni.congestionLoss, rtt: ni.congestionRTT, hold: hold}
+	defer func() {
+		if m.congested {
+			qn.routes.setCongested(key, false)
+		}
+	}()
+
+	ticker := time.NewTicker(congestionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.connection.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		rtt := stats.rtt()
+		loss, changed := m.sample(stats.sent.Load(), stats.lost.Load(), rtt, time.Now())
+		if !changed {
+			continue
+		}
+		qn.routes.setCongested(key, m.congested)
+		if m.congested {
+			qn.logger.Warnf("Peer %s is congested with a loss of %.1f%% and an RTT of %s, moving flows to other peers",
+				key, loss*100, rtt)
+		} else {
+			qn.logger.Infof("Peer %s recovered with a loss of %.1f%% and an RTT of %s, it is preferred again", key, loss*100, rtt)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package quicwire
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestCongestedPeerFlowsShiftWithHysteresis(t *testing.T) {
+	rt, err := newRouteTable([]Peer{
+		{allowedIPs: []string{"10.0.0.2", "10.5.0.0/16"}},
+		{allowedIPs: []string{"10.0.0.3", "10.5.0.0/16"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flows returns the number of 64 flows to 10.5.0.1 each peer takes
+	flows := func() map[string]int {
+		n := map[string]int{}
+		for hash := uint32(0); hash < 64; hash++ {
+			peer, _ := rt.lookup(netip.MustParseAddr("10.5.0.1"), hash, allUsable)
+			n[peer]++
+		}
+		return n
+	}
+	balanced := flows()
+	if balanced["10.0.0.3"] == 0 {
+		t.Fatalf("flows = %v, want them spread over both peers", balanced)
+	}
+
+	m := &congestionMonitor{loss: 0.05, rtt: 250 * time.Millisecond, hold: 30 * time.Second}
+	now := time.Now()
+	var sent, lost uint64
+	// sampleFor feeds the monitor of 10.0.0.3 the loss and RTT every
+	// congestionCheckInterval for d, like watchCongestion
+	sampleFor := func(d time.Duration, loss float64, rtt time.Duration) {
+		for end := now.Add(d); now.Before(end); {
+			now = now.Add(congestionCheckInterval)
+			sent += 100
+			lost += uint64(loss * 100)
+			if _, changed := m.sample(sent, lost, rtt, now); changed {
+				rt.setCongested("10.0.0.3", m.congested)
+			}
+		}
+	}
+	steps := []struct {
+		name string
+		d    time.Duration
+		loss float64
+		rtt  time.Duration
+		// moved is whether the flows of 10.0.0.3 moved to 10.0.0.2 afterwards
+		moved bool
+	}{
+		{name: "healthy", d: time.Minute, rtt: 20 * time.Millisecond},
+		// The hold starts with the first sample past the threshold
+		{name: "lossy for less than the hold", d: 30 * time.Second, loss: 0.2, rtt: 20 * time.Millisecond},
+		{name: "lossy for the hold", d: 5 * time.Second, loss: 0.2, rtt: 20 * time.Millisecond, moved: true},
+		{name: "below the threshold but above half", d: time.Minute, loss: 0.04, rtt: 20 * time.Millisecond, moved: true},
+		{name: "recovered for less than the hold", d: 30 * time.Second, rtt: 20 * time.Millisecond, moved: true},
+		{name: "RTT above half the threshold resets the hold", d: 5 * time.Second, rtt: 200 * time.Millisecond, moved: true},
+		{name: "recovered again for less than the hold", d: 30 * time.Second, rtt: 20 * time.Millisecond, moved: true},
+		{name: "recovered for the hold", d: 5 * time.Second, rtt: 20 * time.Millisecond},
+		{name: "slow for the hold", d: 35 * time.Second, rtt: 300 * time.Millisecond, moved: true},
+	}
+	for _, step := range steps {
+		sampleFor(step.d, step.loss, step.rtt)
+		got := flows()
+		want := balanced
+		if step.moved {
+			want = map[string]int{"10.0.0.2": 64}
+		}
+		if got["10.0.0.2"] != want["10.0.0.2"] || got["10.0.0.3"] != want["10.0.0.3"] {
+			t.Errorf("%s: flows = %v, want %v", step.name, got, want)
+		}
+	}
+}
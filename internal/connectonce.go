@@ -0,0 +1,83 @@
+package quicwire
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// connectOnceProbeSize is the size of the probe echoed by the peer in ConnectOnce
+const connectOnceProbeSize = 256
+
+// WaitPeer blocks until the peer owning the allowed IP is connected. It fails
+// when the node gave up dialing the peer or ctx ends first.
+func (qn *QuicWire) WaitPeer(ctx context.Context, allowedIP string) error {
+	peer, ok := qn.peerByAllowedIP(allowedIP)
+	if !ok {
+		return fmt.Errorf("no peer with allowed IP %s", allowedIP)
+	}
+	key := peer.allowedIPs[0]
+	var failed error
+	err := qn.waitFor(ctx, func() bool {
+		qn.mu.RLock()
+		defer qn.mu.RUnlock()
+		if err, ok := qn.failedPeers[key]; ok {
+			failed = err
+			return true
+		}
+		c, ok := qn.clients[key]
+		return ok && c.connection != nil && c.connection.Context().Err() == nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed != nil {
+		return fmt.Errorf("peer %s failed: %w", allowedIP, failed)
+	}
+	return nil
+}
+
+// PingPeer sends a probe to the peer owning the allowed IP and waits for the
+// echo, returning the round trip time
+func (qn *QuicWire) PingPeer(ctx context.Context, allowedIP string) (time.Duration, error) {
+	peer, ok := qn.peerByAllowedIP(allowedIP)
+	if !ok {
+		return 0, fmt.Errorf("no peer with allowed IP %s", allowedIP)
+	}
+	qn.mu.RLock()
+	c, ok := qn.clients[peer.allowedIPs[0]]
+	qn.mu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("peer %s is not connected", allowedIP)
+	}
+	return c.probe(ctx, connectOnceProbeSize)
+}
+
+// ConnectOnce starts the node, waits until it is ready and the peer owning
+// the allowed IP is connected, optionally pings the peer, and stops the node
+// again. It returns nil when the peer was reached before ctx ended.
+func (qn *QuicWire) ConnectOnce(ctx context.Context, allowedIP string, ping bool) error {
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer qn.Stop()
+	if err := qn.Start(ctx, wg); err != nil {
+		return err
+	}
+	if err := qn.WaitReady(ctx); err != nil {
+		return fmt.Errorf("node did not get ready: %w", err)
+	}
+	if err := qn.WaitPeer(ctx, allowedIP); err != nil {
+		return fmt.Errorf("peer %s did not connect: %w", allowedIP, err)
+	}
+	qn.logger.Infof("Peer %s is connected", allowedIP)
+	if !ping {
+		return nil
+	}
+	rtt, err := qn.PingPeer(ctx, allowedIP)
+	if err != nil {
+		return fmt.Errorf("peer %s did not answer the ping: %w", allowedIP, err)
+	}
+	qn.logger.Infof("Peer %s answered the ping in %s", allowedIP, rtt)
+	return nil
+}
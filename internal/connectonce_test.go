@@ -0,0 +1,84 @@
+package quicwire
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/songgao/water"
+	"go.uber.org/zap"
+)
+
+// stubTunIfaces makes the nodes started by the test create a stub tunnel interface
+func stubTunIfaces(t *testing.T) {
+	t.Helper()
+	orig := newTunIface
+	newTunIface = func(qn *QuicWire) error {
+		qn.tun.Store(&water.Interface{ReadWriteCloser: &stubTun{mtu: 1500, reads: make(chan []byte)}})
+		return nil
+	}
+	t.Cleanup(func() { newTunIface = orig })
+}
+
+func TestConnectOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		peerUp  bool
+		ping    bool
+		wantErr bool
+	}{
+		{name: "reachable", peerUp: true},
+		{name: "reachable with ping", peerUp: true, ping: true},
+		{name: "unreachable", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubTunIfaces(t)
+			mesh := newMemNetwork()
+			endpoint := "127.0.0.2:1"
+			if tt.peerUp {
+				b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+				endpoint = b.udpConn.LocalAddr().String()
+			}
+			// The STUN servers answer at once, so Start does not wait for the probe to time out
+			stunServers := startTestSTUN(t, nil) + "," + startTestSTUN(t, nil)
+			conf := filepath.Join(t.TempDir(), "quicwire.conf")
+			err := os.WriteFile(conf, []byte(`[Interface]
+LocalEndpoint = 10.0.0.1/24
+LocalNodeIp = 127.0.0.1
+StunServers = `+stunServers+`
+
+[Peer]
+Endpoint = `+endpoint+`
+AllowedIPs = 10.0.0.2
+`), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qn, err := NewQuicWire(zap.NewNop().Sugar(), conf, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qn.SetTransport(mesh.transport())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			start := time.Now()
+			err = qn.ConnectOnce(ctx, "10.0.0.2", tt.ping)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want an error: %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("returned after %s, want it bounded by the timeout", elapsed)
+			}
+			// The node is stopped either way
+			select {
+			case <-qn.Done():
+			default:
+				t.Error("node still runs after ConnectOnce returned")
+			}
+		})
+	}
+}
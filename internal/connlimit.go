@@ -0,0 +1,79 @@
+package quicwire
+
+import (
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Application error codes sent to peers when closing a connection
+const (
+	errCodeRateLimited quic.ApplicationErrorCode = 0x1
+)
+
+// maxTrackedSources bounds the number of sources the connection limiter keeps state for
+const maxTrackedSources = 4096
+
+// tokenBucket holds the connection attempt budget of one source
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sourceLimiter rate limits connection attempts per source IP with a token bucket
+type sourceLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// newSourceLimiter allows rate connection attempts per second per source with
+// bursts of up to burst attempts
+func newSourceLimiter(rate int, burst int) *sourceLimiter {
+	if burst < rate {
+		burst = rate
+	}
+	return &sourceLimiter{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the bucket of the source, reporting false when it is empty
+func (l *sourceLimiter) allow(source string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[source]
+	if !ok {
+		if len(l.buckets) >= maxTrackedSources {
+			l.evictFull(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[source] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// evictFull forgets the sources whose bucket has refilled, they behave like new sources
+func (l *sourceLimiter) evictFull(now time.Time) {
+	for source, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, source)
+		}
+	}
+}
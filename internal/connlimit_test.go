@@ -0,0 +1,65 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// dialFrom dials the node at addr from the loopback address ip and reports
+// whether the node kept the connection rather than rejecting it
+func dialFrom(t *testing.T, ip string, addr string) bool {
+	t.Helper()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(ip)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tlsConf := &tls.Config{InsecureSkipVerify: true, NextProtos: []string{defaultALPN}}
+	conn, err := quic.DialContext(ctx, udpConn, raddr, addr, tlsConf, &quic.Config{EnableDatagrams: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseWithError(0, "")
+	select {
+	case <-conn.Context().Done():
+		return false
+	case <-time.After(200 * time.Millisecond):
+		return true
+	}
+}
+
+func TestConnRateLimit(t *testing.T) {
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+		qn.qc.nodeInterface.connRateLimit = 1
+		qn.qc.nodeInterface.connRateBurst = 2
+	})
+	addr := b.udpConn.LocalAddr().String()
+
+	accepted := 0
+	for i := 0; i < 5; i++ {
+		if dialFrom(t, "127.0.0.1", addr) {
+			accepted++
+		}
+	}
+	// The burst and at most a token refilled during the flood get through
+	if accepted < 2 || accepted > 3 {
+		t.Errorf("accepted %d of 5 flooding connections, want the burst of 2", accepted)
+	}
+	if got := b.Stats().RateLimitedConnections; got != uint64(5-accepted) {
+		t.Errorf("counted %d rate limited connections, want %d", got, 5-accepted)
+	}
+	if !dialFrom(t, "127.0.0.3", addr) {
+		t.Error("connection of a well-behaved source was rejected")
+	}
+}
@@ -0,0 +1,81 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"time"
+)
+
+// controlShutdownTimeout bounds the time the control API waits for open requests on stop
+const controlShutdownTimeout = time.Second
+
+// controlHandler serves the control API
+func (qn *QuicWire) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/node", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, qn.NodeStatus())
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, qn.Status())
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, qn.SnapshotStats())
+	})
+	mux.HandleFunc("/drops", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, qn.RecentDrops())
+	})
+	mux.HandleFunc("/routes", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, qn.Routes())
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, qn.VersionInfo())
+	})
+	mux.HandleFunc("/logs", qn.serveLogs)
+	mux.HandleFunc("/flush", qn.serveFlush)
+	mux.HandleFunc("/reload", qn.serveReload)
+	mux.HandleFunc("/loglevels", qn.servePeerLogLevels)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// startControlAPI serves the control API on the configured address until ctx is done
+func (qn *QuicWire) startControlAPI(ctx context.Context) error {
+	addr := qn.qc.nodeInterface.controlAddr
+	if addr == "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           qn.controlHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), controlShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			qn.logger.Errorf("Control API stopped: %v", err)
+		}
+	}()
+	qn.logger.Infof("Control API listening on %s", listener.Addr())
+	return nil
+}
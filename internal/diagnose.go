@@ -0,0 +1,135 @@
+package quicwire
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// diagnoseProbeTimeout bounds the wait for each probe echoed by a peer
+	diagnoseProbeTimeout = 2 * time.Second
+)
+
+// diagnoseProbeSizes are the datagram sizes probed per peer, largest first
+var diagnoseProbeSizes = []int{maxDatagramSize, tunDevMTU + frameHeaderLen, 1024, 512, 256}
+
+// DiagnosticReport describes the connectivity of the node, suitable for bug reports
+type DiagnosticReport struct {
+	Time            time.Time        `json:"time"`
+	Hostname        string           `json:"hostname"`
+	LocalNodeIP     string           `json:"localNodeIP"`
+	ListenPort      int              `json:"listenPort"`
+	NATType         string           `json:"natType"`
+	ExternalBinding string           `json:"externalBinding,omitempty"`
+	STUNError       string           `json:"stunError,omitempty"`
+	TunnelMTU       int              `json:"tunnelMTU"`
+	Peers           []PeerDiagnostic `json:"peers"`
+}
+
+// PeerDiagnostic describes the reachability of a single peer
+type PeerDiagnostic struct {
+	AllowedIPs []string      `json:"allowedIPs"`
+	Endpoint   string        `json:"endpoint"`
+	Reachable  bool          `json:"reachable"`
+	DialTime   time.Duration `json:"dialTime,omitempty"`
+	RTT        time.Duration `json:"rtt,omitempty"`
+	// MaxDatagram is the largest probed datagram the peer echoed back
+	MaxDatagram int    `json:"maxDatagram,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Diagnose probes NAT behaviour and the reachability of every peer without
+// creating the tunnel interface or forwarding traffic. Only a failure to read
+// the config is returned as an error, other failures are part of the report.
+func (qn *QuicWire) Diagnose(ctx context.Context) (DiagnosticReport, error) {
+	if err := qn.readConfig(qn.qc); err != nil {
+		return DiagnosticReport{}, err
+	}
+
+	report := DiagnosticReport{
+		Time:        time.Now(),
+		Hostname:    getHostname(),
+		LocalNodeIP: qn.qc.nodeInterface.localNodeIP,
+		ListenPort:  qn.qc.nodeInterface.listenPort,
+		NATType:     "unknown",
+		TunnelMTU:   qn.tunMTU(),
+	}
+
+	isSymmetric, err := qn.stunServers().isSymmetricNAT(qn.qc.nodeInterface.listenPort)
+	if err != nil {
+		report.STUNError = err.Error()
+	} else {
+		report.NATType = "cone"
+		if isSymmetric {
+			report.NATType = "symmetric"
+		}
+		binding, err := qn.stunServers().portBinding(qn.qc.nodeInterface.listenPort)
+		if err != nil {
+			report.STUNError = err.Error()
+		}
+		report.ExternalBinding = binding
+	}
+
+	// Dial from an ephemeral port so a running instance owning the listen port is not disturbed
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(qn.qc.nodeInterface.localNodeIP)})
+	if err != nil {
+		return report, fmt.Errorf("failed to create diagnostic UDP socket: %w", err)
+	}
+	defer udpConn.Close()
+	if mark := qn.qc.nodeInterface.fwmark; mark != 0 {
+		if err := setSocketMark(udpConn, mark); err != nil {
+			return report, fmt.Errorf("failed to set fwmark %#x on the diagnostic UDP socket: %w", mark, err)
+		}
+	}
+
+	for _, peer := range qn.qc.peers {
+		report.Peers = append(report.Peers, qn.diagnosePeer(ctx, udpConn, peer))
+	}
+	return report, nil
+}
+
+func (qn *QuicWire) diagnosePeer(ctx context.Context, udpConn *net.UDPConn, peer Peer) PeerDiagnostic {
+	pd := PeerDiagnostic{
+		AllowedIPs: peer.allowedIPs,
+		Endpoint:   peer.endpoint,
+	}
+
+	c, err := NewClient(peer.endpoint, qn.qc.nodeInterface.localNodeIP, 0, nil, qn.logger)
+	if err != nil {
+		pd.Error = err.Error()
+		return pd
+	}
+	c.SetAddressFamily(peer.addressFamily)
+	c.SetTransport(qn.transport)
+	c.setResolver(qn.resolver)
+	start := time.Now()
+	if err := c.Dial(udpConn); err != nil {
+		pd.Error = err.Error()
+		return pd
+	}
+	defer c.connection.CloseWithError(0, "diagnostics done")
+	pd.Reachable = true
+	pd.DialTime = time.Since(start)
+
+	// Discard any traffic the peer forwards while it is being probed
+	c.AttachHandler(func(packetContext) error { return nil })
+
+	for _, size := range diagnoseProbeSizes {
+		probeCtx, cancel := context.WithTimeout(ctx, diagnoseProbeTimeout)
+		rtt, err := c.probe(probeCtx, size)
+		cancel()
+		if err != nil {
+			qn.logger.Debugf("Probe of %d bytes to %s failed: %v", size, peer.endpoint, err)
+			continue
+		}
+		pd.RTT = rtt
+		pd.MaxDatagram = size
+		break
+	}
+	if pd.MaxDatagram == 0 {
+		pd.Error = "peer did not echo any probe"
+	}
+	return pd
+}
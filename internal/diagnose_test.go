@@ -0,0 +1,55 @@
+package quicwire
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestDiagnose(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+	stun1 := startTestSTUN(t, mapped)
+	stun2 := startTestSTUN(t, mapped)
+	peer := startEchoPeer(t, "127.0.0.2")
+	conf := fmt.Sprintf(`[Interface]
+ListenPort = 0
+LocalEndpoint = 10.0.0.1/24
+LocalNodeIp = 127.0.0.1
+StunServers = %s, %s
+
+[Peer]
+Endpoint = %s
+AllowedIPs = 10.0.0.2
+`, stun1, stun2, peer)
+	configFile := filepath.Join(t.TempDir(), "quicwire.conf")
+	if err := os.WriteFile(configFile, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	qn, err := NewQuicWire(zap.NewNop().Sugar(), configFile, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := qn.Diagnose(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.NATType != "cone" || report.ExternalBinding != mapped.String() || report.STUNError != "" {
+		t.Errorf("NAT = %s, binding = %s, STUN error = %q, want cone behind %s", report.NATType, report.ExternalBinding, report.STUNError, mapped)
+	}
+	if report.LocalNodeIP != "127.0.0.1" || report.TunnelMTU != tunDevMTU || report.Hostname == "" || report.Time.IsZero() {
+		t.Errorf("report = %+v, want the node fields populated", report)
+	}
+	if len(report.Peers) != 1 {
+		t.Fatalf("got %d peers, want 1", len(report.Peers))
+	}
+	pd := report.Peers[0]
+	if !pd.Reachable || pd.Endpoint != peer || pd.DialTime <= 0 || pd.RTT <= 0 || pd.MaxDatagram == 0 || pd.Error != "" {
+		t.Errorf("peer = %+v, want it reachable with a dial time, RTT and datagram size", pd)
+	}
+}
@@ -0,0 +1,222 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Address family preferences of a peer
+const (
+	familyPreferV4      = "prefer-v4"
+	familyPreferV6      = "prefer-v6"
+	familyHappyEyeballs = "happy-eyeballs"
+)
+
+// happyEyeballsDelay is the head start each candidate gets over the next one (RFC 8305)
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// resolvePeerAddrs resolves the peer endpoint to the addresses the shared
+// socket can reach, ordered by the family preference. Happy-Eyeballs orders
+// IPv6 first and interleaves the families.
+func resolvePeerAddrs(ctx context.Context, resolver *resolveCache, endpoint string, family string, udpConn *net.UDPConn) ([]*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in endpoint %s: %w", endpoint, err)
+	}
+	ipAddrs, err := resolver.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	v4Socket, v6Socket := socketFamilies(udpConn)
+	var v4, v6 []*net.UDPAddr
+	for _, ipAddr := range ipAddrs {
+		addr := &net.UDPAddr{IP: ipAddr.IP, Port: port, Zone: ipAddr.Zone}
+		if ipAddr.IP.To4() != nil {
+			if v4Socket {
+				v4 = append(v4, addr)
+			}
+		} else if v6Socket {
+			v6 = append(v6, addr)
+		}
+	}
+
+	var addrs []*net.UDPAddr
+	switch family {
+	case familyPreferV6:
+		addrs = append(v6, v4...)
+	case familyHappyEyeballs:
+		for i := 0; i < len(v4) || i < len(v6); i++ {
+			if i < len(v6) {
+				addrs = append(addrs, v6[i])
+			}
+			if i < len(v4) {
+				addrs = append(addrs, v4[i])
+			}
+		}
+	default:
+		addrs = append(v4, v6...)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("endpoint %s has no address reachable from %s", endpoint, udpConn.LocalAddr())
+	}
+	return addrs, nil
+}
+
+// socketFamilies reports which address families the socket can send to
+func socketFamilies(udpConn *net.UDPConn) (bool, bool) {
+	local, ok := udpConn.LocalAddr().(*net.UDPAddr)
+	if !ok || local.IP == nil {
+		return true, true
+	}
+	if local.IP.To4() != nil {
+		return true, false
+	}
+	if local.IP.IsUnspecified() {
+		// A socket bound to [::] is dual stack
+		return true, true
+	}
+	return false, true
+}
+
+// dialAddr dials a single address of the peer, giving up after the candidate timeout
+func (c *Client) dialAddr(ctx context.Context, udpConn *net.UDPConn, addr *net.UDPAddr) (quic.Connection, error) {
+	if c.candidateTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.candidateTimeout)
+		defer cancel()
+	}
+	tlsConf := &tls.Config{
+		InsecureSkipVerify:    true,
+		NextProtos:            []string{defaultALPN},
+		VerifyPeerCertificate: c.verifyPeer,
+		ClientSessionCache:    c.sessionCache,
+	}
+	if c.certificate != nil {
+		tlsConf.Certificates = []tls.Certificate{*c.certificate}
+	}
+	return c.transport.Dial(ctx, udpConn, addr, c.addr, tlsConf, &quic.Config{
+		KeepAlivePeriod: keepAlivePeriod(!c.noKeepAlive),
+		MaxIdleTimeout:  c.idleTimeout,
+		EnableDatagrams: true,
+		Tracer:          c.tracer,
+	})
+}
+
+// dialInOrder tries the addresses one after the other
+func (c *Client) dialInOrder(ctx context.Context, udpConn *net.UDPConn, addrs []*net.UDPAddr) (quic.Connection, error) {
+	var err error
+	for _, addr := range addrs {
+		var conn quic.Connection
+		conn, err = c.dialAddr(ctx, udpConn, addr)
+		if err == nil {
+			return conn, nil
+		}
+		c.logger.Debugf("Failed to dial %s at %s: %v", c.addr, addr, err)
+	}
+	return nil, err
+}
+
+// dialRace starts a dial to each address and keeps the first connection that
+// completes. Happy-Eyeballs staggers the dials by happyEyeballsDelay, and at
+// most the dial parallelism of the client, if set, run at the same time.
+func (c *Client) dialRace(ctx context.Context, udpConn *net.UDPConn, addrs []*net.UDPAddr) (quic.Connection, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var slots chan struct{}
+	if c.parallelism > 0 {
+		slots = make(chan struct{}, c.parallelism)
+	}
+	stagger := time.Duration(0)
+	if c.family == familyHappyEyeballs {
+		stagger = happyEyeballsDelay
+	}
+
+	type result struct {
+		addr *net.UDPAddr
+		conn quic.Connection
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	// Candidates take the free slots in order, so the preferred ones are dialed first
+	start := time.Now()
+	go func() {
+		for i, addr := range addrs {
+			select {
+			case <-time.After(time.Until(start.Add(time.Duration(i) * stagger))):
+			case <-ctx.Done():
+			}
+			if slots != nil && ctx.Err() == nil {
+				select {
+				case slots <- struct{}{}:
+				case <-ctx.Done():
+				}
+			}
+			if ctx.Err() != nil {
+				results <- result{addr: addr, err: ctx.Err()}
+				continue
+			}
+			go func(addr *net.UDPAddr) {
+				if slots != nil {
+					defer func() { <-slots }()
+				}
+				conn, err := c.dialAddr(ctx, udpConn, addr)
+				results <- result{addr: addr, conn: conn, err: err}
+			}(addr)
+		}
+	}()
+
+	var winner quic.Connection
+	var firstErr error
+	for range addrs {
+		r := <-results
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			continue
+		}
+		if winner != nil {
+			r.conn.CloseWithError(0, "lost the happy eyeballs race")
+			continue
+		}
+		c.logger.Debugf("Picked %s for peer %s", r.addr, c.addr)
+		winner = r.conn
+		cancel()
+	}
+	if winner == nil {
+		return nil, firstErr
+	}
+	return winner, nil
+}
+
+// dialLastGood falls back to the address the peer was last reached at when
+// dialing the endpoint failed with err, unless that address was just tried
+func (c *Client) dialLastGood(ctx context.Context, udpConn *net.UDPConn, tried []*net.UDPAddr, err error) (quic.Connection, error) {
+	if c.lastGood == nil || ctx.Err() != nil {
+		return nil, err
+	}
+	for _, addr := range tried {
+		if addr.IP.Equal(c.lastGood.IP) && addr.Port == c.lastGood.Port {
+			return nil, err
+		}
+	}
+	c.logger.Infof("Dialing %s failed: %v, falling back to its last good address %s", c.addr, err, c.lastGood)
+	conn, fallbackErr := c.dialAddr(ctx, udpConn, c.lastGood)
+	if fallbackErr != nil {
+		c.logger.Debugf("Failed to dial %s at its last good address %s: %v", c.addr, c.lastGood, fallbackErr)
+		return nil, err
+	}
+	return conn, nil
+}
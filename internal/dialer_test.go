@@ -0,0 +1,272 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.uber.org/zap"
+)
+
+// staticResolver resolves every host to addrs
+type staticResolver struct {
+	addrs []net.IPAddr
+	ttl   time.Duration
+}
+
+func (r staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	return r.addrs, r.ttl, nil
+}
+
+// dialBehavior is how a scriptedTransport dial to an address ends
+type dialBehavior struct {
+	delay time.Duration
+	// hang blocks the dial until it is canceled
+	hang bool
+	err  error
+}
+
+// scriptedTransport records the addresses dialed and ends each dial as
+// scripted for its address, unscripted dials succeed right away
+type scriptedTransport struct {
+	Transport
+	script map[string]dialBehavior
+	mu     sync.Mutex
+	dialed []string
+	// active and maxActive count the dials running at the same time
+	active    int
+	maxActive int
+}
+
+func (t *scriptedTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	t.mu.Lock()
+	t.dialed = append(t.dialed, addr.String())
+	t.active++
+	if t.active > t.maxActive {
+		t.maxActive = t.active
+	}
+	b := t.script[addr.String()]
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		t.active--
+		t.mu.Unlock()
+	}()
+
+	if b.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	select {
+	case <-time.After(b.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	connCtx, cancel := context.WithCancel(context.Background())
+	return &testConn{remote: addr, ctx: connCtx, cancel: cancel}, nil
+}
+
+// dials returns the addresses dialed in order
+func (t *scriptedTransport) dials() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.dialed...)
+}
+
+// newDualStackClient returns a client of a peer resolving to an IPv6 and an IPv4 address
+func newDualStackClient(t *testing.T, family string, transport Transport) *Client {
+	t.Helper()
+	c, err := NewClient("peer.example:51820", "127.0.0.1", 0, nil, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetAddressFamily(family)
+	c.SetTransport(transport)
+	c.setResolver(newResolveCache(staticResolver{addrs: []net.IPAddr{
+		{IP: net.ParseIP("2001:db8::1")},
+		{IP: net.ParseIP("192.0.2.1")},
+	}}, 0))
+	return c
+}
+
+// newDualStackSocket returns a UDP socket able to send to both address families
+func newDualStackSocket(t *testing.T) *net.UDPConn {
+	t.Helper()
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { udpConn.Close() })
+	return udpConn
+}
+
+func TestDialFamilyPreference(t *testing.T) {
+	refused := dialBehavior{err: errors.New("connection refused")}
+	tests := []struct {
+		name   string
+		family string
+		want   []string
+	}{
+		{name: "default", family: "", want: []string{"192.0.2.1:51820", "[2001:db8::1]:51820"}},
+		{name: "v4", family: familyPreferV4, want: []string{"192.0.2.1:51820", "[2001:db8::1]:51820"}},
+		{name: "v6", family: familyPreferV6, want: []string{"[2001:db8::1]:51820", "192.0.2.1:51820"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &scriptedTransport{script: map[string]dialBehavior{
+				"192.0.2.1:51820":     refused,
+				"[2001:db8::1]:51820": refused,
+			}}
+			c := newDualStackClient(t, tt.family, transport)
+			if err := c.DialContext(context.Background(), newDualStackSocket(t)); err == nil {
+				t.Fatal("dial succeeded, want every address refused")
+			}
+			got := transport.dials()
+			if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+				t.Errorf("dialed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDialHappyEyeballs(t *testing.T) {
+	// The IPv6 path black holes, IPv4 connects once its head start is over
+	transport := &scriptedTransport{script: map[string]dialBehavior{
+		"[2001:db8::1]:51820": {hang: true},
+	}}
+	c := newDualStackClient(t, familyHappyEyeballs, transport)
+	start := time.Now()
+	if err := c.DialContext(context.Background(), newDualStackSocket(t)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.connection.RemoteAddr().String(); got != "192.0.2.1:51820" {
+		t.Errorf("connected to %s, want the IPv4 address", got)
+	}
+	if got := transport.dials(); len(got) != 2 || got[0] != "[2001:db8::1]:51820" {
+		t.Errorf("dialed %v, want IPv6 first", got)
+	}
+	if elapsed := time.Since(start); elapsed < happyEyeballsDelay || elapsed > happyEyeballsDelay+2*time.Second {
+		t.Errorf("connected after %s, want about the head start of %s", elapsed, happyEyeballsDelay)
+	}
+}
+
+func TestDialParallelism(t *testing.T) {
+	transport := &scriptedTransport{script: map[string]dialBehavior{
+		"192.0.2.1:51820": {hang: true},
+		"192.0.2.2:51820": {err: errors.New("connection refused")},
+		"192.0.2.3:51820": {delay: 100 * time.Millisecond},
+		"192.0.2.4:51820": {delay: time.Second},
+		"192.0.2.5:51820": {delay: time.Second},
+	}}
+	c, err := NewClient("peer.example:51820", "127.0.0.1", 0, nil, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTransport(transport)
+	c.SetDialParallelism(2, 500*time.Millisecond)
+	var addrs []net.IPAddr
+	for i := 1; i <= 5; i++ {
+		addrs = append(addrs, net.IPAddr{IP: net.IPv4(192, 0, 2, byte(i))})
+	}
+	c.setResolver(newResolveCache(staticResolver{addrs: addrs}, 0))
+
+	if err := c.DialContext(context.Background(), newDualStackSocket(t)); err != nil {
+		t.Fatal(err)
+	}
+	// The third candidate is the first to connect, the later ones are not waited for
+	if got := c.connection.RemoteAddr().String(); got != "192.0.2.3:51820" {
+		t.Errorf("connected to %s, want the first candidate to succeed", got)
+	}
+	transport.mu.Lock()
+	maxActive := transport.maxActive
+	transport.mu.Unlock()
+	if maxActive != 2 {
+		t.Errorf("%d candidates dialed at the same time, want 2", maxActive)
+	}
+	// The later candidates wait for a slot behind the first three
+	got := transport.dials()
+	if len(got) < 3 {
+		t.Fatalf("dialed %v, want at least three candidates", got)
+	}
+	first := append([]string(nil), got[:3]...)
+	sort.Strings(first)
+	if strings.Join(first, ",") != "192.0.2.1:51820,192.0.2.2:51820,192.0.2.3:51820" {
+		t.Errorf("dialed %v, want the candidates in order", got)
+	}
+}
+
+// switchResolver resolves every host to the address last set
+type switchResolver struct {
+	mu   sync.Mutex
+	addr net.IP
+}
+
+func (r *switchResolver) set(ip string) {
+	r.mu.Lock()
+	r.addr = net.ParseIP(ip)
+	r.mu.Unlock()
+}
+
+func (r *switchResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return []net.IPAddr{{IP: r.addr}}, 0, nil
+}
+
+func TestDialFallsBackToLastGoodAddr(t *testing.T) {
+	mesh := newMemNetwork()
+	transport := &recordingTransport{Transport: mesh.transport()}
+	resolver := &switchResolver{}
+	resolver.set("127.0.0.2")
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	_, port, _ := net.SplitHostPort(b.udpConn.LocalAddr().String())
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(transport)
+		qn.SetResolver(resolver)
+	}, newTestPeer(net.JoinHostPort("peer.example", port), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	// The endpoint now resolves to an address nothing listens on
+	resolver.set("127.0.0.9")
+	a.mu.RLock()
+	c := a.clients["10.0.0.2/32"]
+	a.mu.RUnlock()
+	c.connection.CloseWithError(0, "path changed")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		a.mu.RLock()
+		redialed, state := a.clients["10.0.0.2/32"], a.peerStates["10.0.0.2/32"]
+		a.mu.RUnlock()
+		if redialed != nil && redialed != c && state == PeerConnected {
+			if got := redialed.connection.RemoteAddr().String(); got != b.udpConn.LocalAddr().String() {
+				t.Errorf("reconnected to %s, want the last good address %s", got, b.udpConn.LocalAddr())
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("peer did not reconnect at its last good address")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, dials := transport.calls()
+	var triedNew bool
+	for _, d := range dials {
+		if strings.HasSuffix(d, " -> 127.0.0.9:"+port) {
+			triedNew = true
+		}
+	}
+	if !triedNew {
+		t.Errorf("dials %v never tried the newly resolved address", dials)
+	}
+}
@@ -0,0 +1,74 @@
+package quicwire
+
+import (
+	"sync"
+	"time"
+)
+
+// dropRingSize is the number of recent drops kept for inspection
+const dropRingSize = 128
+
+// Reasons packets are dropped
+const (
+	dropNoRoute      = "no-route"
+	dropSendError    = "send-error"
+	dropACL          = "acl"
+	dropTooBig       = "too-big"
+	dropTunWrite     = "tun-write"
+	dropRunt         = "runt"
+	dropEtherType    = "ether-type"
+	dropLocalAddress = "local-address"
+	dropBufferBudget = "buffer-budget"
+	dropHopLimit     = "hop-limit"
+)
+
+// Drop records a dropped packet
+type Drop struct {
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Peer   string    `json:"peer,omitempty"`
+	Size   int       `json:"size"`
+}
+
+// dropRing keeps the most recent drops
+type dropRing struct {
+	mu    sync.Mutex
+	drops [dropRingSize]Drop
+	next  int
+	full  bool
+}
+
+func (r *dropRing) add(d Drop) {
+	r.mu.Lock()
+	r.drops[r.next] = d
+	r.next = (r.next + 1) % dropRingSize
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// list returns the drops oldest first
+func (r *dropRing) list() []Drop {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]Drop(nil), r.drops[:r.next]...)
+	}
+	return append(append([]Drop(nil), r.drops[r.next:]...), r.drops[:r.next]...)
+}
+
+// recordDrop remembers why a packet was dropped. packet is the dropped
+// packet when it is known as a whole, it is written to the capture with
+// CaptureDrops.
+func (qn *QuicWire) recordDrop(reason string, peer string, size int, packet []byte) {
+	qn.drops.add(Drop{Time: time.Now(), Reason: reason, Peer: peer, Size: size})
+	if packet != nil {
+		qn.captureDrop(reason, peer, packet)
+	}
+}
+
+// RecentDrops returns the most recently dropped packets, oldest first
+func (qn *QuicWire) RecentDrops() []Drop {
+	return qn.drops.list()
+}
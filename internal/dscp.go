@@ -0,0 +1,116 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+// maxDSCP is the largest differentiated services code point
+const maxDSCP = 63
+
+// dscpMarks maps remote addresses to the DSCP of the packets sent to them
+type dscpMarks struct {
+	mu    sync.RWMutex
+	marks map[netip.AddrPort]int
+}
+
+func (m *dscpMarks) set(addr net.Addr, dscp int) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+	ap := udpAddr.AddrPort()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.marks[netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())] = dscp
+}
+
+func (m *dscpMarks) get(addr *net.UDPAddr) (int, bool) {
+	ap := addr.AddrPort()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dscp, ok := m.marks[netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())]
+	return dscp, ok
+}
+
+// dscpConn marks the packets sent to peers with a DSCP of their own, the
+// packets to other destinations keep the DSCP set on the socket
+type dscpConn struct {
+	*net.UDPConn
+	marks *dscpMarks
+}
+
+// WriteMsgUDP is used by quic-go to send packets, it adds the traffic class of the destination
+func (c *dscpConn) WriteMsgUDP(b, oob []byte, addr *net.UDPAddr) (int, int, error) {
+	if dscp, ok := c.marks.get(addr); ok {
+		oob = appendDSCPControl(oob, addr, dscp)
+	}
+	return c.UDPConn.WriteMsgUDP(b, oob, addr)
+}
+
+func (c *dscpConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		n, _, err := c.WriteMsgUDP(b, nil, udpAddr)
+		return n, err
+	}
+	return c.UDPConn.WriteTo(b, addr)
+}
+
+// dscpTransport hands the DSCP marking wrapper of the shared socket to the
+// wrapped transport in place of the socket
+type dscpTransport struct {
+	Transport
+	shared *net.UDPConn
+	conn   *dscpConn
+}
+
+func (t *dscpTransport) packetConn(conn net.PacketConn) net.PacketConn {
+	if conn == net.PacketConn(t.shared) {
+		return t.conn
+	}
+	return conn
+}
+
+func (t *dscpTransport) Listen(conn net.PacketConn, tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error) {
+	return t.Transport.Listen(t.packetConn(conn), tlsConf, conf)
+}
+
+func (t *dscpTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	return t.Transport.Dial(ctx, t.packetConn(conn), addr, host, tlsConf, conf)
+}
+
+// setupDSCP marks the packets of the shared socket with the configured DSCP
+// and wraps the transport when peers have a DSCP of their own
+func (qn *QuicWire) setupDSCP() {
+	if dscp := qn.qc.nodeInterface.dscp; dscp > 0 {
+		if err := setSocketDSCP(qn.udpConn, dscp); err != nil {
+			qn.logger.Warnf("Failed to set DSCP %d on the shared socket: %v", dscp, err)
+		}
+	}
+	perPeer := false
+	for _, peer := range qn.qc.peers {
+		perPeer = perPeer || peer.dscp > 0
+	}
+	if !perPeer {
+		return
+	}
+	qn.dscpMarks = &dscpMarks{marks: make(map[netip.AddrPort]int)}
+	qn.transport = &dscpTransport{
+		Transport: qn.transport,
+		shared:    qn.udpConn,
+		conn:      &dscpConn{UDPConn: qn.udpConn, marks: qn.dscpMarks},
+	}
+}
+
+// markPeerDSCP marks the packets sent to the remote address of the peer with its DSCP
+func (qn *QuicWire) markPeerDSCP(remote net.Addr, peer Peer) {
+	if qn.dscpMarks == nil || peer.dscp <= 0 {
+		return
+	}
+	qn.dscpMarks.set(remote, peer.dscp)
+}
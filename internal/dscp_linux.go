@@ -0,0 +1,47 @@
+//go:build linux
+
+package quicwire
+
+import (
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSocketDSCP sets the traffic class of every packet sent from the socket
+func setSocketDSCP(conn *net.UDPConn, dscp int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var v4Err, v6Err error
+	err = raw.Control(func(fd uintptr) {
+		v4Err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, dscp<<2)
+		v6Err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscp<<2)
+	})
+	if err != nil {
+		return err
+	}
+	// Only one of both applies to a single stack socket
+	if v4Err != nil && v6Err != nil {
+		return v4Err
+	}
+	return nil
+}
+
+// appendDSCPControl appends the control message setting the traffic class of a single packet
+func appendDSCPControl(oob []byte, addr *net.UDPAddr, dscp int) []byte {
+	level, typ := unix.IPPROTO_IP, unix.IP_TOS
+	if addr.IP.To4() == nil {
+		level, typ = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+	}
+	start := len(oob)
+	oob = append(oob, make([]byte, unix.CmsgSpace(4))...)
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[start]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(unix.CmsgLen(4))
+	*(*int32)(unsafe.Pointer(&oob[start+unix.CmsgLen(0)])) = int32(dscp << 2)
+	return oob
+}
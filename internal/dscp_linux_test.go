@@ -0,0 +1,95 @@
+//go:build linux
+
+package quicwire
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenTOS returns a socket on the loopback address reporting the TOS byte of received packets
+func listenTOS(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVTOS, 1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return conn
+}
+
+// readDSCP reads a packet from conn and returns the DSCP it was marked with
+func readDSCP(t *testing.T, conn *net.UDPConn) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf, oob := make([]byte, 64), make([]byte, 64)
+	_, oobn, _, _, err := conn.ReadMsgUDP(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.IPPROTO_IP && msg.Header.Type == unix.IP_TOS && len(msg.Data) > 0 {
+			return int(msg.Data[0]) >> 2
+		}
+	}
+	t.Fatal("received packet carries no TOS")
+	return 0
+}
+
+func TestPeerDSCPOverridesGlobal(t *testing.T) {
+	const global, perPeer = 10, 46
+	marked, unmarked := listenTOS(t), listenTOS(t)
+	peer := Peer{endpoint: marked.LocalAddr().String(), allowedIPs: []string{"10.0.0.2"}, dscp: perPeer}
+	qn, _ := newTestQuicWire(t, peer, Peer{endpoint: unmarked.LocalAddr().String(), allowedIPs: []string{"10.0.0.3"}})
+	qn.qc.nodeInterface.dscp = global
+	shared, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shared.Close()
+	qn.udpConn = shared
+	qn.setupDSCP()
+	qn.markPeerDSCP(marked.LocalAddr(), peer)
+
+	// Dials hand the marking wrapper of the shared socket to the transport
+	transport, ok := qn.transport.(*dscpTransport)
+	if !ok {
+		t.Fatalf("transport = %T, want the DSCP marking transport", qn.transport)
+	}
+	conn := transport.packetConn(shared)
+	for _, tt := range []struct {
+		name string
+		to   *net.UDPConn
+		want int
+	}{
+		{name: "peer with a DSCP of its own", to: marked, want: perPeer},
+		{name: "peer with the global DSCP", to: unmarked, want: global},
+	} {
+		if _, err := conn.WriteTo([]byte("probe"), tt.to.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		if got := readDSCP(t, tt.to); got != tt.want {
+			t.Errorf("%s: DSCP = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
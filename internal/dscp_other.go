@@ -0,0 +1,18 @@
+//go:build !linux
+
+package quicwire
+
+import (
+	"fmt"
+	"net"
+)
+
+// setSocketDSCP is only supported on Linux
+func setSocketDSCP(conn *net.UDPConn, dscp int) error {
+	return fmt.Errorf("DSCP marking is not supported on this platform")
+}
+
+// appendDSCPControl leaves the packets unmarked, per packet DSCP is only supported on Linux
+func appendDSCPControl(oob []byte, addr *net.UDPAddr, dscp int) []byte {
+	return oob
+}
@@ -0,0 +1,122 @@
+package quicwire
+
+import (
+	"fmt"
+	"sync"
+)
+
+// dropEgressQueue is the drop reason of packets arriving at the full egress queue of their peer
+const dropEgressQueue = "egress-queue"
+
+// egressPacket is a packet waiting in the egress queue of a peer, buf holds
+// the pooled buffer of data
+type egressPacket struct {
+	c    *Client
+	data []byte
+	buf  *[]byte
+}
+
+// egressQueue holds the packets of one peer in arrival order
+type egressQueue struct {
+	packets []egressPacket
+	bytes   int
+}
+
+// egressQueues give every peer a bounded queue of its own, drained by a
+// goroutine of its own while it holds packets. A peer receiving a burst or
+// whose sends block on its send window only delays and drops its own
+// packets, the read loop keeps serving the other peers. This fairness is
+// opt-in with EgressQueueBytes, without it the read loop sends every packet
+// itself and a peer blocking on its send window holds up all the others.
+type egressQueues struct {
+	mu     sync.Mutex
+	max    int
+	queues map[string]*egressQueue
+}
+
+func newEgressQueues(max int) *egressQueues {
+	return &egressQueues{max: max, queues: make(map[string]*egressQueue)}
+}
+
+// push appends the packet to the queue of the peer. queued is false when the
+// queue is full, start is set when the queue was idle and needs a drainer.
+// An idle queue takes any packet so packets larger than the bound still pass.
+func (q *egressQueues) push(peer string, p egressPacket) (queued bool, start bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pq, ok := q.queues[peer]
+	if !ok {
+		pq = &egressQueue{}
+		q.queues[peer] = pq
+		start = true
+	}
+	if pq.bytes > 0 && pq.bytes+len(p.data) > q.max {
+		return false, false
+	}
+	pq.packets = append(pq.packets, p)
+	pq.bytes += len(p.data)
+	return true, start
+}
+
+// pop takes the oldest packet of the peer, the queue is removed once it is
+// empty and the drainer must exit
+func (q *egressQueues) pop(peer string) (egressPacket, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pq, ok := q.queues[peer]
+	if !ok {
+		return egressPacket{}, false
+	}
+	if len(pq.packets) == 0 {
+		delete(q.queues, peer)
+		return egressPacket{}, false
+	}
+	p := pq.packets[0]
+	pq.packets[0] = egressPacket{}
+	pq.packets = pq.packets[1:]
+	pq.bytes -= len(p.data)
+	return p, true
+}
+
+// queuePacket queues a copy of the packet for the peer and starts draining
+// the queue when it was idle. Errors of the queued send are not returned.
+func (qn *QuicWire) queuePacket(peer string, c *Client, packet []byte) error {
+	n := len(packet)
+	if !qn.buffers.reserve(n) {
+		qn.recordDrop(dropBufferBudget, peer, n, packet)
+		return errBufferBudget
+	}
+	bufp := packetPool.Get().(*[]byte)
+	if cap(*bufp) < n {
+		buf := make([]byte, n)
+		bufp = &buf
+	}
+	data := (*bufp)[:n]
+	copy(data, packet)
+
+	queued, start := qn.egress.push(peer, egressPacket{c: c, data: data, buf: bufp})
+	if !queued {
+		qn.buffers.release(n)
+		packetPool.Put(bufp)
+		qn.counters.countEgressQueueDrop()
+		qn.recordDrop(dropEgressQueue, peer, n, packet)
+		return fmt.Errorf("egress queue of peer %s is full", peer)
+	}
+	if start {
+		go qn.drainEgress(peer)
+	}
+	return nil
+}
+
+// drainEgress sends the queued packets of the peer until its queue is empty
+func (qn *QuicWire) drainEgress(peer string) {
+	for {
+		p, ok := qn.egress.pop(peer)
+		if !ok {
+			return
+		}
+		qn.sendPacket(peer, p.c, p.data)
+		qn.buffers.release(len(p.data))
+		packetPool.Put(p.buf)
+	}
+}
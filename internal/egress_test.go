@@ -0,0 +1,63 @@
+package quicwire
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+// stalledConn is a connection whose sends block until release is closed,
+// like one to a peer that stopped acknowledging
+type stalledConn struct {
+	*testConn
+	release chan struct{}
+}
+
+func (c *stalledConn) SendMessage(b []byte) error {
+	<-c.release
+	return c.testConn.SendMessage(b)
+}
+
+func TestEgressQueuesDoNotStarveLowRatePeer(t *testing.T) {
+	qn, _ := newTestQuicWire(t,
+		Peer{endpoint: "192.0.2.2:55381", allowedIPs: []string{"10.0.0.2/32"}},
+		Peer{endpoint: "192.0.2.3:55381", allowedIPs: []string{"10.0.0.3/32"}})
+	qn.buffers = newBufferBudget(0)
+	qn.egress = newEgressQueues(16 * 1024)
+
+	busyConn, _ := newTestConnPair(t)
+	stalled := &stalledConn{testConn: busyConn, release: make(chan struct{})}
+	defer close(stalled.release)
+	busy := newTestClient(t, busyConn, true)
+	busy.SetConnection(stalled)
+	quietConn, _ := newTestConnPair(t)
+	qn.clients["10.0.0.2/32"] = busy
+	qn.clients["10.0.0.3/32"] = newTestClient(t, quietConn, true)
+
+	src := netip.MustParseAddrPort("10.0.0.1:4000")
+	// A burst to the high-rate peer fills its queue while its sends are stuck
+	for i := 0; i < 1000; i++ {
+		qn.forwardPacket(packettest.UDP(src, netip.MustParseAddrPort("10.0.0.2:5000"), make([]byte, 1000)))
+	}
+	// The packets of the low-rate peer are not held up behind it
+	for i := 0; i < 5; i++ {
+		if err := qn.forwardPacket(packettest.UDP(src, netip.MustParseAddrPort("10.0.0.3:5000"), []byte("quiet"))); err != nil {
+			t.Fatalf("packet %d to the low-rate peer: %v", i, err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(quietConn.messages()) < 5 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of 5 packets reached the low-rate peer behind the burst", len(quietConn.messages()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(busyConn.messages()) != 0 {
+		t.Errorf("%d packets sent to the stalled peer", len(busyConn.messages()))
+	}
+	if drops := qn.Stats().EgressQueueDrops; drops == 0 {
+		t.Error("the burst to the high-rate peer was not bounded by its queue")
+	}
+}
@@ -0,0 +1,49 @@
+package quicwire
+
+// SetEndpointPublisher sets the function told about the public endpoint
+// (ip:port) of the node discovered through STUN, e.g. to publish it to a
+// registry. It is called once the endpoint is determined on Start and again
+// whenever probing finds it changed. It must be set before Start and must
+// not block.
+func (qn *QuicWire) SetEndpointPublisher(publish func(endpoint string)) {
+	qn.endpointPublisher = publish
+}
+
+// publicEndpoint returns the NAT port binding of the node, empty when it is unknown
+func (qn *QuicWire) publicEndpoint() string {
+	qn.bindingMu.Lock()
+	defer qn.bindingMu.Unlock()
+	return qn.portBinding
+}
+
+// setPortBinding records the NAT port binding of the node and publishes it when it changed
+func (qn *QuicWire) setPortBinding(binding string) {
+	qn.bindingMu.Lock()
+	defer qn.bindingMu.Unlock()
+	if binding == qn.portBinding {
+		return
+	}
+	if qn.portBinding != "" {
+		qn.logger.Infof("Port binding changed from %s to %s", qn.portBinding, binding)
+	}
+	qn.portBinding = binding
+	// Publishing under the lock keeps the publisher told in order
+	if binding != "" && qn.endpointPublisher != nil {
+		qn.endpointPublisher(binding)
+	}
+}
+
+// reprobePortBinding probes the NAT port binding again, e.g. after the source
+// address of a connection changed, and publishes it when it changed
+func (qn *QuicWire) reprobePortBinding() {
+	if qn.disableServer || !qn.reprobing.CompareAndSwap(false, true) {
+		return
+	}
+	defer qn.reprobing.Store(false)
+	binding, err := qn.stunServers().portBinding(qn.qc.nodeInterface.listenPort)
+	if err != nil {
+		qn.logger.Warnf("Failed to probe the port binding again: %v", err)
+		return
+	}
+	qn.setPortBinding(binding)
+}
@@ -0,0 +1,50 @@
+package quicwire
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestEndpointPublisher(t *testing.T) {
+	var mapped atomic.Pointer[net.UDPAddr]
+	mapped.Store(&net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242})
+	servers := []string{
+		serveSTUN(t, mapped.Load, 0, false),
+		serveSTUN(t, mapped.Load, 0, false),
+	}
+	published := make(chan string, 4)
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.qc.nodeInterface.stunServers = servers
+		qn.SetEndpointPublisher(func(endpoint string) { published <- endpoint })
+	})
+
+	// The endpoint found like on Start is published
+	binding, err := a.findPortBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.setPortBinding(binding)
+	if got := <-published; got != "203.0.113.7:4242" {
+		t.Errorf("published %s, want the STUN discovered endpoint 203.0.113.7:4242", got)
+	}
+
+	// Probing again without a change publishes nothing
+	a.reprobePortBinding()
+	if len(published) != 0 {
+		t.Errorf("published %s for an unchanged endpoint", <-published)
+	}
+
+	// The NAT binding moved, e.g. after the source address of a connection changed
+	mapped.Store(&net.UDPAddr{IP: net.ParseIP("198.51.100.9"), Port: 6000})
+	a.reprobePortBinding()
+	if len(published) != 1 {
+		t.Fatalf("published %d endpoints after the change, want 1", len(published))
+	}
+	if got := <-published; got != "198.51.100.9:6000" {
+		t.Errorf("published %s, want the changed endpoint 198.51.100.9:6000", got)
+	}
+	if got := a.NodeStatus().PortBinding; got != "198.51.100.9:6000" {
+		t.Errorf("status port binding = %s, want the changed endpoint", got)
+	}
+}
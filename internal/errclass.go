@@ -0,0 +1,88 @@
+package quicwire
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/quic-go/quic-go"
+)
+
+// errorClass tells the retry logic how to react to a failed dial or send
+type errorClass int
+
+const (
+	// errClassBackoff is transient network trouble, retry after the backoff interval
+	errClassBackoff errorClass = iota
+	// errClassRedial means an established connection went away, redial right away
+	errClassRedial
+	// errClassFatal means retrying cannot succeed without a configuration change
+	errClassFatal
+)
+
+func (c errorClass) String() string {
+	switch c {
+	case errClassRedial:
+		return "redial"
+	case errClassFatal:
+		return "fatal"
+	default:
+		return "backoff"
+	}
+}
+
+// classifyError maps dial and connection errors to the way they should be retried
+func classifyError(err error) errorClass {
+	var (
+		idleErr      *quic.IdleTimeoutError
+		resetErr     *quic.StatelessResetError
+		appErr       *quic.ApplicationError
+		transportErr *quic.TransportError
+		versionErr   *quic.VersionNegotiationError
+		addrErr      *net.AddrError
+		certErr      x509.CertificateInvalidError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+	)
+	switch {
+	case err == nil:
+		return errClassBackoff
+	case errors.As(err, &idleErr), errors.As(err, &resetErr):
+		return errClassRedial
+	case errors.As(err, &appErr):
+		// The peer asked us to slow down
+		if appErr.ErrorCode == errCodeRateLimited {
+			return errClassBackoff
+		}
+		if appErr.ErrorCode == errCodeNetworkID || appErr.ErrorCode == errCodeToken {
+			return errClassFatal
+		}
+		return errClassRedial
+	case errors.As(err, &transportErr):
+		// TLS alerts are carried as crypto errors, e.g. a rejected certificate
+		if transportErr.ErrorCode.IsCryptoError() {
+			return errClassFatal
+		}
+		if transportErr.ErrorCode == quic.ConnectionRefused {
+			return errClassBackoff
+		}
+		return errClassRedial
+	case errors.As(err, &versionErr), errors.As(err, &addrErr),
+		errors.As(err, &certErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr):
+		return errClassFatal
+	case errors.Is(err, errDuplicateConnection):
+		// The connection dialed by the peer is used instead
+		return errClassRedial
+	case errors.Is(err, errClockSkew):
+		// Clocks get corrected without a configuration change
+		return errClassBackoff
+	case errors.Is(err, errCertRevoked), errors.Is(err, errNetworkIDMismatch), errors.Is(err, errTokenMismatch):
+		return errClassFatal
+	case errors.Is(err, syscall.EAFNOSUPPORT), errors.Is(err, syscall.EINVAL):
+		return errClassFatal
+	default:
+		// Handshake timeouts, unreachable networks and DNS failures may clear up
+		return errClassBackoff
+	}
+}
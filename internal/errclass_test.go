@@ -0,0 +1,71 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorClass
+	}{
+		{name: "idle timeout", err: &quic.IdleTimeoutError{}, want: errClassRedial},
+		{name: "stateless reset", err: &quic.StatelessResetError{}, want: errClassRedial},
+		{name: "closed by the peer", err: &quic.ApplicationError{ErrorCode: errCodeShutdown, Remote: true}, want: errClassRedial},
+		{name: "rate limited", err: &quic.ApplicationError{ErrorCode: errCodeRateLimited, Remote: true}, want: errClassBackoff},
+		{name: "network ID rejected", err: &quic.ApplicationError{ErrorCode: errCodeNetworkID, Remote: true}, want: errClassFatal},
+		{name: "token rejected", err: &quic.ApplicationError{ErrorCode: errCodeToken, Remote: true}, want: errClassFatal},
+		{name: "TLS alert", err: &quic.TransportError{ErrorCode: quic.TransportErrorCode(0x100 + 42)}, want: errClassFatal},
+		{name: "connection refused", err: &quic.TransportError{ErrorCode: quic.ConnectionRefused}, want: errClassBackoff},
+		{name: "protocol violation", err: &quic.TransportError{ErrorCode: quic.ProtocolViolation}, want: errClassRedial},
+		{name: "version negotiation", err: &quic.VersionNegotiationError{}, want: errClassFatal},
+		{name: "handshake timeout", err: &quic.HandshakeTimeoutError{}, want: errClassBackoff},
+		{name: "unknown authority", err: x509.UnknownAuthorityError{}, want: errClassFatal},
+		{name: "bad address", err: &net.AddrError{Err: "missing port", Addr: "peer"}, want: errClassFatal},
+		{name: "unreachable network", err: &net.OpError{Op: "write", Err: syscall.ENETUNREACH}, want: errClassBackoff},
+		{name: "unsupported family", err: &net.OpError{Op: "write", Err: syscall.EAFNOSUPPORT}, want: errClassFatal},
+		{name: "duplicate connection", err: errDuplicateConnection, want: errClassRedial},
+		{name: "clock skew", err: errClockSkew, want: errClassBackoff},
+		{name: "revoked certificate", err: errCertRevoked, want: errClassFatal},
+		{name: "wrapped", err: fmt.Errorf("dial: %w", &quic.IdleTimeoutError{}), want: errClassRedial},
+		{name: "unknown", err: errors.New("no route to host"), want: errClassBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryOperationStopsOnFatal(t *testing.T) {
+	calls := 0
+	err := RetryOperation(context.Background(), time.Millisecond, 10, func() error {
+		calls++
+		return x509.UnknownAuthorityError{}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("RetryOperation() = %v after %d calls, want the fatal error after 1", err, calls)
+	}
+
+	calls = 0
+	RetryOperation(context.Background(), time.Millisecond, 10, func() error {
+		calls++
+		return &quic.IdleTimeoutError{}
+	})
+	// Every retry redials right away once before backing off
+	if calls != 22 {
+		t.Errorf("redialed %d times, want 22", calls)
+	}
+}
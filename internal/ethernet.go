@@ -0,0 +1,138 @@
+package quicwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Device modes of the tunnel interface
+const (
+	// modeTUN carries IP packets
+	modeTUN = "tun"
+	// modeTAP carries Ethernet frames
+	modeTAP = "tap"
+)
+
+const (
+	ethHeaderLen = 14
+
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+	etherTypeIPv6 = 0x86dd
+)
+
+// defaultEtherTypes are the L2 protocols forwarded in TAP mode unless configured otherwise
+var defaultEtherTypes = []uint16{etherTypeIPv4, etherTypeIPv6, etherTypeARP}
+
+// parseEtherTypes parses a list of EtherTypes given by name (ipv4, ipv6, arp) or as hex numbers
+func parseEtherTypes(value string) ([]uint16, error) {
+	var types []uint16
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+			continue
+		case "ipv4":
+			types = append(types, etherTypeIPv4)
+		case "ipv6":
+			types = append(types, etherTypeIPv6)
+		case "arp":
+			types = append(types, etherTypeARP)
+		default:
+			n, err := strconv.ParseUint(strings.TrimPrefix(name, "0x"), 16, 16)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EtherType %q", name)
+			}
+			types = append(types, uint16(n))
+		}
+	}
+	return types, nil
+}
+
+// tapMode reports whether the tunnel interface carries Ethernet frames
+func (qn *QuicWire) tapMode() bool {
+	return qn.qc.nodeInterface.mode == modeTAP
+}
+
+// maxPacket returns the largest packet read from or written to the tunnel
+// interface, frames in TAP mode carry the Ethernet header on top of the MTU
+func (qn *QuicWire) maxPacket() int {
+	if qn.tapMode() {
+		return qn.tunMTU() + ethHeaderLen
+	}
+	return qn.tunMTU()
+}
+
+// etherTypeAllowed reports whether frames of the given EtherType are forwarded
+func (qn *QuicWire) etherTypeAllowed(etherType uint16) bool {
+	types := qn.qc.nodeInterface.etherTypes
+	if len(types) == 0 {
+		types = defaultEtherTypes
+	}
+	for _, t := range types {
+		if t == etherType {
+			return true
+		}
+	}
+	return false
+}
+
+// frameAllowed reports whether a frame is complete and of a forwarded EtherType
+func (qn *QuicWire) frameAllowed(frame []byte) bool {
+	return len(frame) >= ethHeaderLen && qn.etherTypeAllowed(binary.BigEndian.Uint16(frame[12:ethHeaderLen]))
+}
+
+// forwardFrame forwards an Ethernet frame read from the TAP interface. IPv4
+// frames are routed by their destination address, every other frame is
+// flooded to all connected peers.
+func (qn *QuicWire) forwardFrame(frame []byte) {
+	if !qn.frameAllowed(frame) {
+		qn.logger.Debugf("Dropped frame of %d bytes with a filtered EtherType", len(frame))
+		qn.recordDrop(dropEtherType, "", len(frame), nil)
+		return
+	}
+
+	if binary.BigEndian.Uint16(frame[12:ethHeaderLen]) == etherTypeIPv4 && len(frame) >= ethHeaderLen+ipv4HeaderLen {
+		packet := frame[ethHeaderLen:]
+		qn.mu.RLock()
+		peer, ok := qn.routes.lookup(packetDst(packet), flowHash(packet), func(peer string) bool {
+			_, ok := qn.clients[peer]
+			return ok
+		})
+		c := qn.clients[peer]
+		qn.mu.RUnlock()
+		if ok {
+			qn.sendToPeer(c, frame)
+			return
+		}
+	}
+
+	qn.mu.RLock()
+	seen := make(map[quic.Connection]bool, len(qn.clients))
+	var clients []*Client
+	for _, c := range qn.clients {
+		if c.connection != nil && !seen[c.connection] {
+			seen[c.connection] = true
+			clients = append(clients, c)
+		}
+	}
+	qn.mu.RUnlock()
+	for _, c := range clients {
+		qn.sendToPeer(c, frame)
+	}
+}
+
+// sendToPeer sends a packet to the peer and counts it
+func (qn *QuicWire) sendToPeer(c *Client, packet []byte) {
+	if err := c.SendBytes(packet); err != nil {
+		qn.counters.countSendError()
+		qn.recordDrop(dropSendError, c.addr, len(packet), packet)
+		qn.noisyLog.Errorf("failed to send client message: %v", err)
+		return
+	}
+	qn.counters.countTx(len(packet))
+}
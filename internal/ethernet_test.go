@@ -0,0 +1,90 @@
+package quicwire
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// ethFrame returns a broadcast Ethernet frame of the EtherType carrying payload
+func ethFrame(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, ethHeaderLen, ethHeaderLen+len(payload))
+	copy(frame, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01})
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	return append(frame, payload...)
+}
+
+func TestParseEtherTypes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []uint16
+		err   bool
+	}{
+		{value: "ipv4, IPv6,arp", want: []uint16{etherTypeIPv4, etherTypeIPv6, etherTypeARP}},
+		{value: "0x88b5,88b6", want: []uint16{0x88b5, 0x88b6}},
+		{value: "", want: nil},
+		{value: "ipx", err: true},
+		{value: "0x10000", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseEtherTypes(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("types = %#x, want %#x", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("types = %#x, want %#x", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTapForwardsAllowedEtherTypes(t *testing.T) {
+	const custom = 0x88b5
+	mesh := newMemNetwork()
+	tap := func(etherTypes ...uint16) func(*QuicWire) {
+		return func(qn *QuicWire) {
+			qn.SetTransport(mesh.transport())
+			qn.qc.nodeInterface.mode = modeTAP
+			qn.qc.nodeInterface.etherTypes = etherTypes
+		}
+	}
+	// B only takes ARP from its peers, A also sends the custom EtherType
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", tap(etherTypeARP))
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", tap(etherTypeARP, custom), newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	ipv6 := ethFrame(etherTypeIPv6, make([]byte, 40))
+	customFrame := ethFrame(custom, []byte("custom"))
+	arp := ethFrame(etherTypeARP, make([]byte, 28))
+	for _, frame := range [][]byte{ipv6, customFrame, arp} {
+		if err := a.InjectPacket(frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := b.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames arrive in order, so the custom frame was filtered by B before the ARP frame arrived
+	if len(got) != 1 || !bytes.Equal(got[0], arp) {
+		t.Errorf("B received %d frames, want only the ARP one", len(got))
+	}
+	if tx := a.Stats().TxPackets; tx != 2 {
+		t.Errorf("A sent %d frames, want the custom and the ARP one", tx)
+	}
+	drops := a.RecentDrops()
+	if len(drops) != 1 || drops[0].Reason != dropEtherType || drops[0].Size != len(ipv6) {
+		t.Errorf("drops = %+v, want one %s drop of the IPv6 frame", drops, dropEtherType)
+	}
+}
@@ -0,0 +1,50 @@
+package quicwire
+
+import (
+	"time"
+)
+
+// eventBufferSize is the number of events buffered for a slow consumer before new ones are dropped
+const eventBufferSize = 64
+
+// EventType identifies the kind of an Event
+type EventType string
+
+const (
+	// EventPathValidated is emitted when the handshake of a connection to a peer is confirmed
+	EventPathValidated EventType = "path-validated"
+	// EventPathFailed is emitted when a connection to a peer timed out, during or after the handshake
+	EventPathFailed EventType = "path-failed"
+	// EventPeerFailed is emitted when the node gave up dialing a peer
+	EventPeerFailed EventType = "peer-failed"
+	// EventPeerState is emitted when the lifecycle state of a peer changes
+	EventPeerState EventType = "peer-state"
+)
+
+// Event reports a change in the state of the mesh
+type Event struct {
+	Type   EventType `json:"type"`
+	Peer   string    `json:"peer,omitempty"`
+	Remote string    `json:"remote,omitempty"`
+	Time   time.Time `json:"time"`
+	// State is the new state of the peer of EventPeerState events
+	State PeerState `json:"state,omitempty"`
+}
+
+// Events returns the channel on which mesh events are published. Events are
+// dropped when the channel is not drained.
+func (qn *QuicWire) Events() <-chan Event {
+	return qn.events
+}
+
+// emit publishes an event without blocking the caller
+func (qn *QuicWire) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case qn.events <- e:
+	default:
+		qn.logger.Debugf("Dropped %s event for peer %s, event channel is full", e.Type, e.Peer)
+	}
+}
@@ -0,0 +1,41 @@
+package quicwire
+
+import (
+	"context"
+	"expvar"
+)
+
+// expvarName is the expvar map holding the counters of every node of the process
+const expvarName = "quicwire"
+
+// expvarNodes is published at /debug/vars, keyed by the tunnel address of each node
+var expvarNodes = expvar.NewMap(expvarName)
+
+// expvarPeer is the subset of the peer status published through expvar
+type expvarPeer struct {
+	Connected    bool `json:"connected"`
+	EffectiveMTU int  `json:"effectiveMTU,omitempty"`
+}
+
+// expvarNode is the value published for a node
+type expvarNode struct {
+	Stats Stats                 `json:"stats"`
+	Peers map[string]expvarPeer `json:"peers"`
+}
+
+// publishExpvar publishes the counters and peer gauges of the node through
+// expvar until ctx is done. The values are read when /debug/vars is served.
+func (qn *QuicWire) publishExpvar(ctx context.Context) {
+	key := qn.localAddr.String()
+	expvarNodes.Set(key, expvar.Func(func() any {
+		node := expvarNode{Stats: qn.SnapshotStats(), Peers: make(map[string]expvarPeer)}
+		for _, ps := range qn.Status() {
+			node.Peers[ps.AllowedIPs[0]] = expvarPeer{Connected: ps.Connected, EffectiveMTU: ps.EffectiveMTU}
+		}
+		return node
+	}))
+	go func() {
+		<-ctx.Done()
+		expvarNodes.Delete(key)
+	}()
+}
@@ -0,0 +1,74 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+// readExpvarNodes returns the nodes published at /debug/vars
+func readExpvarNodes(t *testing.T, url string) map[string]expvarNode {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var vars struct {
+		Nodes map[string]expvarNode `json:"quicwire"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	return vars.Nodes
+}
+
+func TestExpvarReflectsTraffic(t *testing.T) {
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) },
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.publishExpvar(ctx)
+	srv := httptest.NewServer(expvar.Handler())
+	defer srv.Close()
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("counted"))
+	for i := 0; i < 2; i++ {
+		if err := a.InjectPacket(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node, ok := readExpvarNodes(t, srv.URL)["10.0.0.1"]
+	if !ok {
+		t.Fatal("node not published")
+	}
+	if node.Stats.TxPackets != 2 || node.Stats.TxBytes != uint64(2*len(packet)) {
+		t.Errorf("published %d packets of %d bytes, want 2 of %d", node.Stats.TxPackets, node.Stats.TxBytes, 2*len(packet))
+	}
+	if peer := node.Peers["10.0.0.2/32"]; !peer.Connected || peer.EffectiveMTU == 0 {
+		t.Errorf("published peer = %+v, want it connected with its MTU", peer)
+	}
+
+	// The node is unpublished once it stops
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := readExpvarNodes(t, srv.URL)["10.0.0.1"]; !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stopped node still published")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
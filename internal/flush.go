@@ -0,0 +1,112 @@
+package quicwire
+
+import (
+	"net/http"
+)
+
+// FlushReport lists what Flush removed
+type FlushReport struct {
+	// ClosedConnections are the peers whose degraded connection was closed, dialed peers are redialed
+	ClosedConnections []string `json:"closedConnections,omitempty"`
+	// StaleConnections is the number of closed connections and clients that were still referenced
+	StaleConnections int `json:"staleConnections"`
+	// LearnedPeers are the learned peers without a live connection whose routes were removed
+	LearnedPeers []string `json:"learnedPeers,omitempty"`
+	// Routes is the number of route entries left, RemovedRoutes the number
+	// of orphaned and duplicate entries removed by the compaction
+	Routes        int `json:"routes"`
+	RemovedRoutes int `json:"removedRoutes"`
+}
+
+// Flush closes the connections of degraded peers, forgets closed connections,
+// removes the routes of learned peers whose connection is gone and compacts
+// the routing table. Healthy peers are left untouched.
+func (qn *QuicWire) Flush() FlushReport {
+	var report FlushReport
+	var learned []Peer
+
+	qn.mu.Lock()
+	for key, c := range qn.clients {
+		if c.connection == nil || c.connection.Context().Err() != nil {
+			delete(qn.clients, key)
+			report.StaleConnections++
+			continue
+		}
+		if qn.peerStates[key] == PeerDegraded {
+			c.connection.CloseWithError(0, "flushed")
+			report.ClosedConnections = append(report.ClosedConnections, key)
+		}
+	}
+	for host, conn := range qn.connections {
+		if conn.Context().Err() != nil {
+			delete(qn.connections, host)
+			report.StaleConnections++
+		}
+	}
+	for host, c := range qn.strangers {
+		if c.connection.Context().Err() != nil {
+			delete(qn.strangers, host)
+		}
+	}
+	// Clients of closed connections were removed above
+	known := make(map[string]bool, len(qn.qc.peers))
+	for _, peer := range qn.qc.peers {
+		known[peer.allowedIPs[0]] = true
+		if _, ok := qn.clients[peer.allowedIPs[0]]; peer.learned && !ok {
+			learned = append(learned, peer)
+		}
+	}
+	qn.mu.Unlock()
+
+	for _, peer := range learned {
+		qn.forgetLearnedPeer(peer)
+		delete(known, peer.allowedIPs[0])
+		report.LearnedPeers = append(report.LearnedPeers, peer.allowedIPs[0])
+	}
+	report.RemovedRoutes, report.Routes = qn.routes.compact(known)
+
+	qn.logger.Infof("Flushed %d degraded and %d stale connections, %d learned peers and %d routes",
+		len(report.ClosedConnections), report.StaleConnections, len(report.LearnedPeers), report.RemovedRoutes)
+	return report
+}
+
+// compact removes the entries of peers that are not known and duplicate
+// entries, and releases the unused capacity. It returns the number of
+// entries removed and left.
+func (rt *routeTable) compact(known map[string]bool) (removed int, left int) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	seen := make(map[routeEntry]bool, len(rt.entries))
+	entries := make([]routeEntry, 0, len(rt.entries))
+	for _, e := range rt.entries {
+		if !known[e.peer] || seen[e] {
+			continue
+		}
+		seen[e] = true
+		entries = append(entries, e)
+	}
+	removed = len(rt.entries) - len(entries)
+	rt.entries = append([]routeEntry(nil), entries...)
+	for peer := range rt.avoid {
+		if !known[peer] {
+			delete(rt.avoid, peer)
+		}
+	}
+	for peer := range rt.congested {
+		if !known[peer] {
+			delete(rt.congested, peer)
+		}
+	}
+	rt.reindex()
+	return removed, len(rt.entries)
+}
+
+// serveFlush runs Flush for POST requests and returns the report
+func (qn *QuicWire) serveFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "flush requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, qn.Flush())
+}
@@ -0,0 +1,72 @@
+package quicwire
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFlushRemovesStaleState(t *testing.T) {
+	healthy := Peer{allowedIPs: []string{"10.1.0.1", "10.1.1.0/24"}}
+	degraded := Peer{allowedIPs: []string{"10.2.0.1"}}
+	closed := Peer{allowedIPs: []string{"10.3.0.1"}}
+	learned := Peer{allowedIPs: []string{"10.4.0.1", "10.4.1.0/24"}, learned: true}
+	qn, _ := newTestQuicWire(t, healthy, degraded, closed, learned)
+
+	client := func(key string) *testConn {
+		conn, _ := newTestConnPair(t)
+		qn.clients[key] = newTestClient(t, conn, true)
+		return conn
+	}
+	healthyConn, degradedConn, closedConn := client("10.1.0.1"), client("10.2.0.1"), client("10.3.0.1")
+	closedConn.cancel()
+	qn.peerStates["10.1.0.1"] = PeerConnected
+	qn.peerStates["10.2.0.1"] = PeerDegraded
+	qn.connections["192.0.2.1"] = healthyConn
+	qn.connections["192.0.2.3"] = closedConn
+	// Routes of a peer no longer configured
+	if err := qn.routes.addPeer(Peer{allowedIPs: []string{"10.5.0.1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	report := qn.Flush()
+	if strings.Join(report.ClosedConnections, ",") != "10.2.0.1" || degradedConn.Context().Err() == nil {
+		t.Errorf("closed connections = %v, want the degraded peer's", report.ClosedConnections)
+	}
+	if healthyConn.Context().Err() != nil {
+		t.Error("flush closed the connection of a healthy peer")
+	}
+	// The closed client and the connection of its host
+	if report.StaleConnections != 2 {
+		t.Errorf("stale connections = %d, want 2", report.StaleConnections)
+	}
+	if _, ok := qn.clients["10.3.0.1"]; ok {
+		t.Error("client of the closed connection is still referenced")
+	}
+	if _, ok := qn.connections["192.0.2.3"]; ok {
+		t.Error("closed connection is still referenced")
+	}
+	if strings.Join(report.LearnedPeers, ",") != "10.4.0.1" {
+		t.Errorf("learned peers = %v, want 10.4.0.1", report.LearnedPeers)
+	}
+	if _, ok := qn.peerByAllowedIP("10.4.0.1"); ok {
+		t.Error("learned peer without a connection is still configured")
+	}
+
+	entries, _, _ := qn.routes.entriesSnapshot()
+	var peers []string
+	for _, e := range entries {
+		peers = append(peers, e.peer)
+	}
+	sort.Strings(peers)
+	if got := strings.Join(peers, ","); got != "10.1.0.1,10.1.0.1,10.2.0.1,10.3.0.1" {
+		t.Errorf("routes left to %s", got)
+	}
+	if report.Routes != len(entries) || report.RemovedRoutes == 0 {
+		t.Errorf("report counts %d routes left and %d removed", report.Routes, report.RemovedRoutes)
+	}
+	if peer, ok := qn.routes.lookup(netip.MustParseAddr("10.4.1.7"), 0, func(string) bool { return true }); ok {
+		t.Errorf("route of the forgotten learned peer still resolves to %s", peer)
+	}
+}
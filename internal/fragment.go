@@ -0,0 +1,239 @@
+package quicwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// fragmentHeaderLen is the index, count and first sequence number ahead of each fragment
+	fragmentHeaderLen = 6
+	// maxFragmentPayload is the part of a packet carried by each fragment of a full datagram
+	maxFragmentPayload = maxFramePayload - fragmentHeaderLen
+	// maxFragments is the number of fragments of a packet tracked by a reassembly
+	maxFragments = 32
+	// minFragmentPayload is the smallest fragment splitting the largest tunnel packet in maxFragments
+	minFragmentPayload = (maxTunMTU + maxFragments - 1) / maxFragments
+
+	// reassemblyTimeout is how long the fragments of an incomplete packet are kept
+	reassemblyTimeout = time.Second
+	// maxPendingReassemblies bounds the packets being reassembled per connection
+	maxPendingReassemblies = 64
+)
+
+// packetPool recycles buffers large enough for the largest tunnel packet
+var packetPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, maxTunMTU)
+		return &buf
+	},
+}
+
+// sendFragments splits a packet larger than a datagram into fragment frames.
+// Each fragment carries its index, the fragment count and the sequence number
+// of the first fragment, which identifies the packet. The first fragment
+// leaves that field zero as its own sequence number is the identifier. All
+// fragments but the last have the same size, which the receiver takes from them.
+func (c *Client) sendFragments(data []byte) error {
+	size := c.framePayload() - fragmentHeaderLen
+	count := (len(data) + size - 1) / size
+	if count > maxFragments {
+		return fmt.Errorf("packet of %d bytes exceeds the maximum tunnel MTU of %d: %w", len(data), maxTunMTU, errPacketTooBig)
+	}
+
+	header := make([]byte, fragmentHeaderLen)
+	header[1] = byte(count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		header[0] = byte(i)
+		seq, err := c.sendFrame(frameFragment, header, data[i*size:end])
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			binary.BigEndian.PutUint32(header[2:], seq)
+		}
+	}
+	return nil
+}
+
+// framePayload returns the largest packet sent in a single datagram to the
+// peer. quic-go does not expose the datagram size of the path, so it is the
+// one found by path MTU discovery, if smaller than the default.
+func (c *Client) framePayload() int {
+	if datagram := int(c.maxDatagram.Load()); datagram > 0 && datagram < maxDatagramSize {
+		return datagram - frameHeaderLen
+	}
+	return maxFramePayload
+}
+
+// reassembly collects the fragments of a single packet
+type reassembly struct {
+	buf      *[]byte
+	count    int
+	received uint32
+	length   int
+	created  time.Time
+	// size is the size of the fragments but the last, known from the first
+	// of them to arrive, tail holds a last fragment arriving before them
+	size int
+	tail []byte
+}
+
+// place copies a fragment to its offset in the packet, it reports false for
+// fragments not fitting the packet buffer
+func (ra *reassembly) place(index int, chunk []byte) bool {
+	offset := index * ra.size
+	if offset+len(chunk) > len(*ra.buf) {
+		return false
+	}
+	copy((*ra.buf)[offset:], chunk)
+	if index == ra.count-1 {
+		if index > 0 && len(chunk) > ra.size {
+			return false
+		}
+		ra.length = offset + len(chunk)
+	}
+	return true
+}
+
+// reassembler rebuilds fragmented packets received on a connection
+type reassembler struct {
+	mu      sync.Mutex
+	pending map[uint32]*reassembly
+	// budget accounts a packet buffer per pending reassembly, overBudget is
+	// told about fragments dropped because the budget was exhausted
+	budget     *bufferBudget
+	overBudget func(size int)
+}
+
+func newReassembler() *reassembler {
+	return &reassembler{pending: make(map[uint32]*reassembly)}
+}
+
+// add stores a fragment and returns the packet once all its fragments arrived.
+// The returned buffer comes from packetPool and must be put back by the caller.
+func (r *reassembler) add(f frame) (*[]byte, int, bool) {
+	if len(f.payload) < fragmentHeaderLen {
+		return nil, 0, false
+	}
+	index := int(f.payload[0])
+	count := int(f.payload[1])
+	id := binary.BigEndian.Uint32(f.payload[2:fragmentHeaderLen])
+	if index == 0 {
+		id = f.seq
+	}
+	chunk := f.payload[fragmentHeaderLen:]
+	if count == 0 || count > maxFragments || index >= count || len(chunk) > maxFragmentPayload || len(chunk) == 0 {
+		return nil, 0, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ra, ok := r.pending[id]
+	if !ok {
+		if len(r.pending) >= maxPendingReassemblies {
+			r.evict()
+		}
+		if !r.budget.reserve(maxTunMTU) {
+			// Give up on the oldest packet of the connection to make room
+			r.evictOldest()
+			if !r.budget.reserve(maxTunMTU) {
+				if r.overBudget != nil {
+					r.overBudget(len(chunk))
+				}
+				return nil, 0, false
+			}
+		}
+		ra = &reassembly{
+			buf:     packetPool.Get().(*[]byte),
+			count:   count,
+			created: time.Now(),
+		}
+		r.pending[id] = ra
+	}
+	if ra.count != count || ra.received&(1<<index) != 0 {
+		return nil, 0, false
+	}
+
+	switch {
+	case index < count-1 && ra.size == 0:
+		ra.size = len(chunk)
+		if !ra.place(index, chunk) || (ra.tail != nil && !ra.place(count-1, ra.tail)) {
+			r.drop(id, ra)
+			return nil, 0, false
+		}
+		ra.tail = nil
+	case index < count-1 && len(chunk) != ra.size:
+		r.drop(id, ra)
+		return nil, 0, false
+	case index == count-1 && ra.size == 0 && index > 0:
+		// The offset of the last fragment is unknown until another one arrives
+		ra.tail = append([]byte(nil), chunk...)
+	default:
+		if !ra.place(index, chunk) {
+			r.drop(id, ra)
+			return nil, 0, false
+		}
+	}
+	ra.received |= 1 << index
+	if ra.received != 1<<count-1 {
+		return nil, 0, false
+	}
+	delete(r.pending, id)
+	r.budget.release(maxTunMTU)
+	return ra.buf, ra.length, true
+}
+
+// evict drops the expired reassemblies, or the oldest one if none expired
+func (r *reassembler) evict() {
+	var oldestID uint32
+	var oldest *reassembly
+	for id, ra := range r.pending {
+		if time.Since(ra.created) > reassemblyTimeout {
+			r.drop(id, ra)
+			continue
+		}
+		if oldest == nil || ra.created.Before(oldest.created) {
+			oldestID, oldest = id, ra
+		}
+	}
+	if len(r.pending) >= maxPendingReassemblies && oldest != nil {
+		r.drop(oldestID, oldest)
+	}
+}
+
+// evictOldest drops the oldest pending reassembly, if any
+func (r *reassembler) evictOldest() {
+	var oldestID uint32
+	var oldest *reassembly
+	for id, ra := range r.pending {
+		if oldest == nil || ra.created.Before(oldest.created) {
+			oldestID, oldest = id, ra
+		}
+	}
+	if oldest != nil {
+		r.drop(oldestID, oldest)
+	}
+}
+
+// reset drops every pending reassembly once the connection is gone
+func (r *reassembler) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, ra := range r.pending {
+		r.drop(id, ra)
+	}
+}
+
+func (r *reassembler) drop(id uint32, ra *reassembly) {
+	delete(r.pending, id)
+	packetPool.Put(ra.buf)
+	r.budget.release(maxTunMTU)
+}
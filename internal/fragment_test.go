@@ -0,0 +1,154 @@
+package quicwire
+
+import (
+	"bytes"
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+// fragmentFrames splits data into fragment frames of size bytes the way sendFragments does
+func fragmentFrames(data []byte, size int, firstSeq uint32) []frame {
+	count := (len(data) + size - 1) / size
+	frames := make([]frame, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		header := []byte{byte(i), byte(count), 0, 0, 0, 0}
+		if i > 0 {
+			header[2], header[3], header[4], header[5] = byte(firstSeq>>24), byte(firstSeq>>16), byte(firstSeq>>8), byte(firstSeq)
+		}
+		frames = append(frames, frame{typ: frameFragment, seq: firstSeq + uint32(i), payload: append(header, data[i*size:end]...)})
+	}
+	return frames
+}
+
+func TestReassembler(t *testing.T) {
+	data := make([]byte, 3000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	tests := []struct {
+		name  string
+		size  int
+		order []int
+	}{
+		{name: "full datagrams in order", size: maxFragmentPayload, order: []int{0, 1, 2}},
+		{name: "full datagrams reversed", size: maxFragmentPayload, order: []int{2, 1, 0}},
+		{name: "small datagrams in order", size: 500, order: []int{0, 1, 2, 3, 4, 5}},
+		{name: "small datagrams last first", size: 500, order: []int{5, 0, 1, 2, 3, 4}},
+		{name: "small datagrams shuffled", size: 500, order: []int{3, 5, 1, 0, 4, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames := fragmentFrames(data, tt.size, 100)
+			if len(frames) != len(tt.order) {
+				t.Fatalf("%d fragments, order lists %d", len(frames), len(tt.order))
+			}
+			r := newReassembler()
+			for n, i := range tt.order {
+				bufp, length, ok := r.add(frames[i])
+				if ok != (n == len(tt.order)-1) {
+					t.Fatalf("fragment %d completed the packet: %v", i, ok)
+				}
+				if ok && !bytes.Equal((*bufp)[:length], data) {
+					t.Errorf("reassembled %d bytes differing from the %d sent", length, len(data))
+				}
+			}
+		})
+	}
+}
+
+func TestReassemblerRejects(t *testing.T) {
+	data := make([]byte, 1500)
+	tests := []struct {
+		name   string
+		frames func() []frame
+	}{
+		{
+			name: "duplicate fragment",
+			frames: func() []frame {
+				frames := fragmentFrames(data, 1000, 1)
+				return []frame{frames[0], frames[0]}
+			},
+		},
+		{
+			name: "fragments of different sizes",
+			frames: func() []frame {
+				a := fragmentFrames(data, 500, 1)
+				b := fragmentFrames(data, 600, 1)
+				return []frame{a[0], b[1], a[2]}
+			},
+		},
+		{
+			name: "last fragment larger than the others",
+			frames: func() []frame {
+				frames := fragmentFrames(make([]byte, 900), 300, 1)
+				frames[2].payload = append(frames[2].payload, make([]byte, 10)...)
+				return frames
+			},
+		},
+		{
+			name: "index beyond the count",
+			frames: func() []frame {
+				return []frame{{typ: frameFragment, seq: 1, payload: []byte{3, 2, 0, 0, 0, 0, 1}}}
+			},
+		},
+		{
+			name: "too many fragments",
+			frames: func() []frame {
+				return []frame{{typ: frameFragment, seq: 1, payload: []byte{0, maxFragments + 1, 0, 0, 0, 0, 1}}}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReassembler()
+			for _, f := range tt.frames() {
+				if _, _, ok := r.add(f); ok {
+					t.Fatal("reassembled a packet from invalid fragments")
+				}
+			}
+		})
+	}
+}
+
+func TestForwardJumboPacket(t *testing.T) {
+	mesh := newMemNetwork()
+	jumbo := func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.mtu = maxTunMTU
+	}
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", jumbo)
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", jumbo)
+	if err := a.AddPeer(newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	payload := make([]byte, maxTunMTU-28)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), payload)
+	if len(packet) != maxTunMTU {
+		t.Fatalf("built a packet of %d bytes, want %d", len(packet), maxTunMTU)
+	}
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := b.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[0], packet) {
+		t.Errorf("received a packet of %d bytes differing from the %d byte packet sent", len(got[0]), len(packet))
+	}
+}
@@ -0,0 +1,102 @@
+package quicwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Frame types carried in the low nibble of the first byte of every datagram
+const (
+	frameData   byte = 0x0
+	frameAck    byte = 0x1
+	frameTooBig byte = 0x2
+	// frameProbe asks the peer to echo the frame back as a frameProbeReply of the same size
+	frameProbe      byte = 0x3
+	frameProbeReply byte = 0x4
+	// frameFragment carries part of a packet too large for a single datagram
+	frameFragment byte = 0x5
+	// frameMirror carries a copy of traffic for a monitoring peer, it is captured and never forwarded
+	frameMirror byte = 0x6
+	// frameGoodbye tells the peer the sender is shutting down or closing the idle
+	// connection, it is acknowledged with a frameProbeReply
+	frameGoodbye byte = 0x7
+	// frameBatch carries several small packets, each prefixed with its 16 bit length
+	frameBatch byte = 0x8
+	// frameCompressed carries a packet compressed with the compressor identified by its first byte
+	frameCompressed byte = 0x9
+	// framePathMTU tells the peer the largest packet the sender found to reach it
+	framePathMTU byte = 0xa
+	// frameRelay carries a packet relayed by a node, prefixed with the hops it has left
+	frameRelay byte = 0xb
+)
+
+const (
+	// frameFlagAckRequest asks the receiver to acknowledge the frame right away
+	frameFlagAckRequest byte = 0x80
+	// frameFlagShortReply asks for a probe reply carrying only the received size
+	frameFlagShortReply byte = 0x40
+	// frameFlagIdle marks a goodbye closing an idle connection rather than a shutdown
+	frameFlagIdle byte = 0x20
+
+	frameTypeMask  byte = 0x0f
+	frameHeaderLen      = 5
+
+	// maxDatagramSize is the largest datagram quic-go sends
+	maxDatagramSize = 1197
+	// maxFramePayload is the largest packet that fits in a single datagram
+	maxFramePayload = maxDatagramSize - frameHeaderLen
+)
+
+// framePool recycles datagram buffers on the send path, quic-go copies the
+// datagram so the buffer can be reused once SendMessage returns
+var framePool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, maxDatagramSize)
+		return &buf
+	},
+}
+
+// frame is a decoded application datagram. Every datagram exchanged between
+// peers starts with a one byte type/flags field followed by a 32 bit
+// application sequence number.
+type frame struct {
+	typ     byte
+	flags   byte
+	seq     uint32
+	payload []byte
+}
+
+// encodeFrame builds a datagram with the given header and payload
+func encodeFrame(typ byte, flags byte, seq uint32, payload []byte) []byte {
+	return appendFrame(make([]byte, 0, frameHeaderLen+len(payload)), typ, flags, seq, payload)
+}
+
+// appendFrame appends a datagram with the given header and payload to buf
+func appendFrame(buf []byte, typ byte, flags byte, seq uint32, payload []byte) []byte {
+	buf = append(buf, typ|flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], seq)
+	return append(buf, payload...)
+}
+
+// decodeFrame parses a received datagram, the payload aliases data
+func decodeFrame(data []byte) (frame, error) {
+	if len(data) < frameHeaderLen {
+		return frame{}, fmt.Errorf("short frame of %d bytes", len(data))
+	}
+	return frame{
+		typ:     data[0] & frameTypeMask,
+		flags:   data[0] &^ frameTypeMask,
+		seq:     binary.BigEndian.Uint32(data[1:frameHeaderLen]),
+		payload: data[frameHeaderLen:],
+	}, nil
+}
+
+// isData reports whether the frame carries packets rather than control of the connection
+func (f frame) isData() bool {
+	switch f.typ {
+	case frameData, frameFragment, frameMirror, frameBatch, frameCompressed, frameRelay:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package quicwire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     byte
+		flags   byte
+		seq     uint32
+		payload []byte
+	}{
+		{name: "data", typ: frameData, seq: 1, payload: []byte("packet")},
+		{name: "empty ack", typ: frameAck, seq: 0xffffffff},
+		{name: "probe with flags", typ: frameProbe, flags: frameFlagAckRequest | frameFlagShortReply, seq: 7, payload: make([]byte, 100)},
+		{name: "idle goodbye", typ: frameGoodbye, flags: frameFlagIdle},
+		{name: "relay", typ: frameRelay, seq: 42, payload: []byte{3, 0x45}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeFrame(tt.typ, tt.flags, tt.seq, tt.payload)
+			if len(data) != frameHeaderLen+len(tt.payload) {
+				t.Fatalf("encoded %d bytes, want %d", len(data), frameHeaderLen+len(tt.payload))
+			}
+			f, err := decodeFrame(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.typ != tt.typ || f.flags != tt.flags || f.seq != tt.seq || !bytes.Equal(f.payload, tt.payload) {
+				t.Errorf("decoded %+v, want type %#x flags %#x seq %d payload %v", f, tt.typ, tt.flags, tt.seq, tt.payload)
+			}
+		})
+	}
+}
+
+func TestDecodeFrameShort(t *testing.T) {
+	for n := 0; n < frameHeaderLen; n++ {
+		if _, err := decodeFrame(make([]byte, n)); err == nil {
+			t.Errorf("decoded a frame of %d bytes", n)
+		}
+	}
+}
+
+func TestFrameIsData(t *testing.T) {
+	tests := []struct {
+		typ  byte
+		data bool
+	}{
+		{typ: frameData, data: true},
+		{typ: frameFragment, data: true},
+		{typ: frameMirror, data: true},
+		{typ: frameBatch, data: true},
+		{typ: frameCompressed, data: true},
+		{typ: frameRelay, data: true},
+		{typ: frameAck},
+		{typ: frameTooBig},
+		{typ: frameProbe},
+		{typ: frameProbeReply},
+		{typ: frameGoodbye},
+		{typ: framePathMTU},
+	}
+	for _, tt := range tests {
+		if got := (frame{typ: tt.typ}).isData(); got != tt.data {
+			t.Errorf("isData of type %#x = %v, want %v", tt.typ, got, tt.data)
+		}
+	}
+}
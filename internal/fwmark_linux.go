@@ -0,0 +1,26 @@
+//go:build linux
+
+package quicwire
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSocketMark sets the firewall mark of every packet sent from the socket,
+// it requires CAP_NET_ADMIN
+func setSocketMark(conn *net.UDPConn, mark uint32) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var markErr error
+	err = raw.Control(func(fd uintptr) {
+		markErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(mark))
+	})
+	if err != nil {
+		return err
+	}
+	return markErr
+}
@@ -0,0 +1,57 @@
+//go:build linux
+
+package quicwire
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketMark returns the firewall mark of the socket
+func socketMark(t *testing.T, conn *net.UDPConn) int {
+	t.Helper()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mark int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		mark, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if sockErr != nil {
+		t.Fatal(sockErr)
+	}
+	return mark
+}
+
+func TestSharedSocketMark(t *testing.T) {
+	const mark = 0x51
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer probe.Close()
+	if err := setSocketMark(probe, mark); errors.Is(err, unix.EPERM) {
+		t.Skip("setting SO_MARK requires CAP_NET_ADMIN")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	if got := socketMark(t, probe); got != mark {
+		t.Fatalf("mark = %#x, want %#x", got, mark)
+	}
+
+	qn := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.qc.nodeInterface.fwmark = mark })
+	if got := socketMark(t, qn.udpConn); got != mark {
+		t.Errorf("shared socket mark = %#x, want %#x", got, mark)
+	}
+	unmarked := startTestNode(t, "127.0.0.2", "10.0.0.2", nil)
+	if got := socketMark(t, unmarked.udpConn); got != 0 {
+		t.Errorf("shared socket mark = %#x without FwMark, want none", got)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package quicwire
+
+import (
+	"fmt"
+	"net"
+)
+
+// setSocketMark is only supported on Linux
+func setSocketMark(conn *net.UDPConn, mark uint32) error {
+	return fmt.Errorf("firewall marks are not supported on this platform")
+}
@@ -0,0 +1,158 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// controlVersion is the version of the control handshake spoken by this node
+const controlVersion = 1
+
+// handshakeTimeout bounds the exchange of hellos on a new connection
+const handshakeTimeout = 5 * time.Second
+
+// hello is exchanged by both ends on the first stream of a connection
+type hello struct {
+	Version int `json:"version"`
+	// MTU is the largest packet the node accepts, the receive direction MTU
+	MTU int `json:"mtu"`
+	// Routes are the prefixes the node serves, a passive server adopts them for unknown peers
+	Routes []string `json:"routes,omitempty"`
+	// Node is the tunnel address of the node, it breaks the tie when two nodes dial each other
+	Node string `json:"node,omitempty"`
+	// Compressors are the packet compressors the node supports, in order of preference
+	Compressors []string `json:"compressors,omitempty"`
+	// NetworkID names the mesh of the node, peers of another mesh are rejected
+	NetworkID string `json:"network_id,omitempty"`
+	// TokenProof proves the node knows the shared connection token without
+	// revealing it, peers presenting a wrong proof are rejected, see tokenProof
+	TokenProof string `json:"token_proof,omitempty"`
+}
+
+// localHello returns the hello this node sends to the peer of c
+func (qn *QuicWire) localHello(c *Client) hello {
+	h := hello{
+		Version:     controlVersion,
+		MTU:         qn.maxPacket(),
+		Routes:      qn.announcedRoutes(),
+		Compressors: qn.qc.nodeInterface.compression,
+		NetworkID:   qn.qc.nodeInterface.networkID,
+	}
+	if qn.localAddr.IsValid() {
+		h.Node = qn.localAddr.String()
+	}
+	if token := qn.qc.nodeInterface.connectionToken; token != "" {
+		proof, err := tokenProof(token, c.connection, c.outbound)
+		if err != nil {
+			qn.logger.Warnf("Failed to prove the connection token to %s: %v", c.addr, err)
+		}
+		h.TokenProof = proof
+	}
+	return h
+}
+
+// handshake opens the control stream, sends the local hello and reads the peer's
+func (c *Client) handshake(ctx context.Context, local hello) (hello, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	stream, err := c.connection.OpenStreamSync(ctx)
+	if err != nil {
+		return hello{}, fmt.Errorf("failed to open the control stream: %w", err)
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(c.controlStream(stream)).Encode(local); err != nil {
+		return hello{}, fmt.Errorf("failed to send hello: %w", err)
+	}
+	var remote hello
+	if err := json.NewDecoder(c.controlStream(stream)).Decode(&remote); err != nil {
+		return hello{}, fmt.Errorf("failed to read hello: %w", err)
+	}
+	return remote, nil
+}
+
+// acceptHandshake waits for the peer to open the control stream, reads its hello and replies
+func (c *Client) acceptHandshake(ctx context.Context, local hello) (hello, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	stream, err := c.connection.AcceptStream(ctx)
+	if err != nil {
+		return hello{}, fmt.Errorf("failed to accept the control stream: %w", err)
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	var remote hello
+	if err := json.NewDecoder(c.controlStream(stream)).Decode(&remote); err != nil {
+		return hello{}, fmt.Errorf("failed to read hello: %w", err)
+	}
+	if err := json.NewEncoder(c.controlStream(stream)).Encode(local); err != nil {
+		return hello{}, fmt.Errorf("failed to send hello: %w", err)
+	}
+	return remote, nil
+}
+
+// applyHello adopts the settings the peer announced. Packets to the peer are
+// clamped to the smaller of both tunnel MTUs.
+func (qn *QuicWire) applyHello(c *Client, remote hello) {
+	if name := negotiateCompressor(qn.qc.nodeInterface.compression, remote.Compressors); name != "" {
+		c.logger.Debugf("Compressing packets to %s with %s", c.addr, name)
+		c.setCompressor(name)
+	} else if len(qn.qc.nodeInterface.compression) > 0 {
+		qn.logger.Infof("Peer %s supports none of the compressors %v, not compressing", c.addr, qn.qc.nodeInterface.compression)
+	}
+	local := qn.maxPacket()
+	if remote.MTU <= 0 {
+		return
+	}
+	if remote.MTU != local {
+		qn.logger.Warnw("Tunnel MTU differs from the peer, clamping to the smaller one",
+			"peer", c.addr,
+			"localMTU", local,
+			"peerMTU", remote.MTU,
+		)
+	}
+	mtu := local
+	if remote.MTU < mtu {
+		mtu = remote.MTU
+	}
+	c.pathMTU.Store(int32(mtu))
+}
+
+// runHandshake exchanges hellos on a new connection, the dialing side opens the control stream.
+// It returns the peer's hello and whether the exchange succeeded.
+func (qn *QuicWire) runHandshake(ctx context.Context, c *Client, dialed bool) (hello, bool) {
+	var remote hello
+	var err error
+	if dialed {
+		remote, err = c.handshake(ctx, qn.localHello(c))
+	} else {
+		remote, err = c.acceptHandshake(ctx, qn.localHello(c))
+	}
+	if err != nil {
+		// Peers running an older release do not speak the handshake
+		c.logger.Debugf("Control handshake with %s failed: %v", c.addr, err)
+		return hello{}, false
+	}
+	c.logger.Debugf("Peer %s runs control version %d with an MTU of %d", c.addr, remote.Version, remote.MTU)
+	qn.applyHello(c, remote)
+	go qn.discoverSendMTU(c)
+	return remote, true
+}
+
+// effectiveMTU returns the largest packet sent to the peer
+func (qn *QuicWire) effectiveMTU(c *Client) int {
+	if mtu := int(c.pathMTU.Load()); mtu > 0 && mtu < qn.maxPacket() {
+		return mtu
+	}
+	return qn.maxPacket()
+}
@@ -0,0 +1,76 @@
+package quicwire
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// effectiveMTUOf returns the effective MTU in the status of the peer owning allowedIP
+func effectiveMTUOf(t *testing.T, qn *QuicWire, allowedIP string) int {
+	t.Helper()
+	for _, ps := range qn.Status() {
+		if ps.AllowedIPs[0] == allowedIP {
+			return ps.EffectiveMTU
+		}
+	}
+	t.Fatalf("no status of peer %s", allowedIP)
+	return 0
+}
+
+func TestMTUMismatchClampsBothWays(t *testing.T) {
+	mesh := newMemNetwork()
+	logsA, logsB := newObservedLogger(), newObservedLogger()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.logger = logsB.logger
+		qn.qc.nodeInterface.mtu = 1300
+		// B only accepts, so there is a single connection to check from both ends
+		qn.disableClient = true
+	}, newTestPeer("127.0.0.1:51820", "10.0.0.1"))
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.logger = logsA.logger
+		qn.qc.nodeInterface.mtu = 1400
+	}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	waitPeerState(t, b.QuicWire, "10.0.0.1/32", PeerConnected)
+
+	if got := effectiveMTUOf(t, a.QuicWire, "10.0.0.2/32"); got != 1300 {
+		t.Errorf("effective MTU from the larger side = %d, want 1300", got)
+	}
+	if got := effectiveMTUOf(t, b.QuicWire, "10.0.0.1/32"); got != 1300 {
+		t.Errorf("effective MTU from the smaller side = %d, want 1300", got)
+	}
+	for name, logs := range map[string]*observedLogger{"larger side": logsA, "smaller side": logsB} {
+		if logs.waitMessage("Tunnel MTU differs from the peer, clamping to the smaller one") == 0 {
+			t.Errorf("%s logged no MTU mismatch warning", name)
+		}
+	}
+}
+
+// observedLogger is a logger recording its warnings for inspection
+type observedLogger struct {
+	logger *zap.SugaredLogger
+	logs   *observer.ObservedLogs
+}
+
+func newObservedLogger() *observedLogger {
+	core, logs := observer.New(zapcore.WarnLevel)
+	return &observedLogger{logger: zap.New(core).Sugar(), logs: logs}
+}
+
+// waitMessage waits up to a second for msg to be logged and returns the number of times it was
+func (l *observedLogger) waitMessage(msg string) int {
+	deadline := time.Now().Add(time.Second)
+	for {
+		n := l.logs.FilterMessage(msg).Len()
+		if n > 0 || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
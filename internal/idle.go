@@ -0,0 +1,147 @@
+package quicwire
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// idleModeRedial redials a peer as soon as its connection idles out, keeping the tunnel warm
+	idleModeRedial = "redial"
+	// idleModeTeardown lets idle connections close and redials on the next packet to the peer
+	idleModeTeardown = "teardown"
+)
+
+const (
+	// idleCloseAbort closes idle connections right away
+	idleCloseAbort = "abort"
+	// idleCloseGraceful says goodbye to the peer before closing idle
+	// connections, so pending batches are flushed and the peer drops the
+	// connection and its routes knowing why
+	idleCloseGraceful = "graceful"
+)
+
+// errCodeIdle is the application error code of connections closed for being idle
+const errCodeIdle quic.ApplicationErrorCode = 0x6
+
+// defaultIdleTimeout is the QUIC max idle timeout used when none is configured
+const defaultIdleTimeout = 30 * time.Second
+
+// keepAlivePeriod returns the QUIC keep-alive period, 0 disables keep-alives
+func keepAlivePeriod(enabled bool) time.Duration {
+	if !enabled {
+		return 0
+	}
+	return 10
+}
+
+// idleTeardown reports whether idle connections are left closed until traffic needs them
+func (qn *QuicWire) idleTeardown() bool {
+	return qn.qc.nodeInterface.idleMode == idleModeTeardown
+}
+
+// closeCause returns the error the connection was closed with. quic-go
+// cancels the connection context without a cause, the error is only returned
+// by the calls failing on the closed connection, such as accepting a stream
+// no peer ever opens.
+func closeCause(conn quic.Connection) error {
+	if cause := context.Cause(conn.Context()); cause != context.Canceled {
+		return cause
+	}
+	_, err := conn.AcceptUniStream(context.Background())
+	return err
+}
+
+// isIdleTimeout reports whether the connection was closed by the QUIC idle
+// timeout or by either end tearing it down for being idle
+func isIdleTimeout(err error) bool {
+	var idleErr *quic.IdleTimeoutError
+	var appErr *quic.ApplicationError
+	return errors.As(err, &idleErr) || errors.As(err, &appErr) && appErr.ErrorCode == errCodeIdle
+}
+
+// watchIdle closes the connection of the peer once no packet was forwarded
+// over it for the idle timeout, in the configured close style. Health probes
+// keep the QUIC connection alive, so its idle timeout alone never fires.
+func (qn *QuicWire) watchIdle(ctx context.Context, c *Client) {
+	timeout := qn.qc.nodeInterface.idleTimeout
+	if timeout <= 0 {
+		timeout = defaultIdleTimeout
+	}
+	c.lastActive.Store(time.Now().UnixNano())
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.connection.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if time.Since(time.Unix(0, c.lastActive.Load())) < timeout {
+			continue
+		}
+		if qn.qc.nodeInterface.idleClose == idleCloseGraceful {
+			if c.coalescer != nil {
+				c.coalescer.flush()
+			}
+			goodbyeCtx, cancel := context.WithTimeout(ctx, qn.stopTimeout())
+			if err := c.goodbye(goodbyeCtx, frameFlagIdle); err != nil {
+				c.logger.Debugf("Peer %s did not acknowledge the idle goodbye: %v", c.addr, err)
+			}
+			cancel()
+		}
+		c.logger.Debugf("Closing the connection to peer %s idle for %s", c.addr, timeout)
+		c.connection.CloseWithError(errCodeIdle, "idle")
+		return
+	}
+}
+
+// waitForTraffic parks an idled out peer until a packet is routed to it. It
+// returns false when the peer was removed meanwhile.
+func (qn *QuicWire) waitForTraffic(ctx context.Context, key string) bool {
+	wake := make(chan struct{})
+	qn.mu.Lock()
+	qn.idlePeers[key] = wake
+	qn.mu.Unlock()
+	defer func() {
+		qn.mu.Lock()
+		if qn.idlePeers[key] == wake {
+			delete(qn.idlePeers, key)
+		}
+		qn.mu.Unlock()
+	}()
+
+	select {
+	case <-wake:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// wakeIdlePeer redials the idled out peer routing dst. It reports whether a
+// peer was woken, the packet that triggered it is dropped.
+func (qn *QuicWire) wakeIdlePeer(dst netip.Addr, hash uint32) bool {
+	qn.mu.Lock()
+	defer qn.mu.Unlock()
+	if len(qn.idlePeers) == 0 {
+		return false
+	}
+	peer, ok := qn.routes.lookup(dst, hash, func(peer string) bool {
+		_, ok := qn.idlePeers[peer]
+		return ok
+	})
+	if !ok {
+		return false
+	}
+	close(qn.idlePeers[peer])
+	delete(qn.idlePeers, peer)
+	qn.logger.Infof("Redialing idle peer [ %s ] for traffic to %s", peer, dst)
+	return true
+}
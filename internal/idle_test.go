@@ -0,0 +1,92 @@
+package quicwire
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestIdleTeardownRedialsOnTraffic(t *testing.T) {
+	mesh := newMemNetwork()
+	transport := &recordingTransport{Transport: mesh.transport()}
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(transport)
+		qn.qc.nodeInterface.idleMode = idleModeTeardown
+		qn.qc.nodeInterface.idleTimeout = 200 * time.Millisecond
+	}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	// Without traffic the connection is closed and left closed
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerDisconnected)
+	time.Sleep(100 * time.Millisecond)
+	if _, dials := transport.calls(); len(dials) != 1 {
+		t.Fatalf("dialed %d times while idle, want no redial", len(dials))
+	}
+
+	// The first packet to the peer is dropped and wakes it up
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("wake up"))
+	if err := a.InjectPacket(packet); !errors.Is(err, errNoRoute) {
+		t.Fatalf("packet to the idle peer: err = %v, want %v", err, errNoRoute)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	if _, dials := transport.calls(); len(dials) != 2 {
+		t.Errorf("dialed %d times, want one redial", len(dials))
+	}
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := b.sink.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdleCloseStyle(t *testing.T) {
+	for _, style := range []string{idleCloseGraceful, idleCloseAbort} {
+		t.Run(style, func(t *testing.T) {
+			mesh := newMemNetwork()
+			teardown := func(qn *QuicWire) {
+				qn.SetTransport(mesh.transport())
+				qn.qc.nodeInterface.idleMode = idleModeTeardown
+			}
+			b := startTestNode(t, "127.0.0.2", "10.0.0.2", teardown)
+			a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+				teardown(qn)
+				qn.qc.nodeInterface.idleTimeout = time.Second
+				qn.qc.nodeInterface.idleClose = style
+			}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+			waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+			// B routes to A over the connection A opened
+			if err := b.AddPeer(newTestPeer(a.udpConn.LocalAddr().String(), "10.0.0.1")); err != nil {
+				t.Fatal(err)
+			}
+			waitPeerState(t, b.QuicWire, "10.0.0.1/32", PeerConnected)
+			b.mu.RLock()
+			c := b.clients["10.0.0.1/32"]
+			b.mu.RUnlock()
+
+			// A closes the connection idle for a second, B drops the route over it
+			waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerDisconnected)
+			waitPeerState(t, b.QuicWire, "10.0.0.1/32", PeerDisconnected)
+			if cause := closeCause(c.connection); !isIdleTimeout(cause) {
+				t.Errorf("B saw the connection closed with %v, want an idle close", cause)
+			}
+			b.mu.RLock()
+			_, routed := b.clients["10.0.0.1/32"]
+			b.mu.RUnlock()
+			if routed {
+				t.Error("B still routes to A over the closed connection")
+			}
+			// Only the graceful style tells B before closing
+			if said := c.draining.Load(); said != (style == idleCloseGraceful) {
+				t.Errorf("B was told goodbye = %v, want %v", said, style == idleCloseGraceful)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package quicwire
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errNoRoute is returned for injected packets no connected peer serves the destination of
+var errNoRoute = errors.New("no connected peer routes")
+
+// InjectPacket feeds an IP packet, or an Ethernet frame in TAP mode, into the
+// forwarding path as if it was read from the tunnel interface. It is routed,
+// mirrored, counted and dropped like any other packet. The packet may be
+// reused once InjectPacket returns.
+func (qn *QuicWire) InjectPacket(packet []byte) error {
+	if len(packet) > qn.maxPacket() {
+		return fmt.Errorf("packet of %d bytes exceeds the tunnel MTU of %d: %w", len(packet), qn.maxPacket(), errPacketTooBig)
+	}
+	return qn.forwardPacket(packet)
+}
+
+// SetPacketHandler passes the packets received from peers, and local packets
+// looped back, to handler instead of writing them to the tunnel interface. It
+// must be called before Start. The handler must not retain the packet.
+func (qn *QuicWire) SetPacketHandler(handler func(packet []byte)) {
+	qn.packetHandler = handler
+}
@@ -0,0 +1,146 @@
+package quicwire
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestInjectPacketLoopsBackLocalPackets(t *testing.T) {
+	qn, sink := newTestQuicWire(t)
+	qn.qc.nodeInterface.localPackets = localPacketsLoopback
+	src := netip.MustParseAddrPort("10.0.0.1:4000")
+	dst := netip.MustParseAddrPort("10.0.0.1:5000")
+	packets := [][]byte{
+		packettest.UDP(src, dst, []byte("first")),
+		packettest.UDP(src, dst, []byte("second")),
+	}
+	if _, err := packettest.NewSource(qn.InjectPacket).Send(packets...); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := sink.Wait(ctx, len(packets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range packets {
+		if string(got[i]) != string(packets[i]) {
+			t.Errorf("looped back packet %d = %v, want %v", i, got[i], packets[i])
+		}
+	}
+}
+
+func TestInjectPacketDrops(t *testing.T) {
+	local := netip.MustParseAddrPort("10.0.0.1:4000")
+	tests := []struct {
+		name   string
+		packet []byte
+		reason string
+		err    error
+	}{
+		{
+			name:   "local address without loopback",
+			packet: packettest.UDP(local, netip.MustParseAddrPort("10.0.0.1:5000"), nil),
+			reason: dropLocalAddress,
+		},
+		{
+			name:   "routed peer not connected",
+			packet: packettest.UDP(local, netip.MustParseAddrPort("10.1.0.5:5000"), nil),
+			reason: dropNoRoute,
+			err:    errNoRoute,
+		},
+		{
+			name:   "unrouted destination",
+			packet: packettest.UDP(local, netip.MustParseAddrPort("192.168.0.1:5000"), nil),
+			reason: dropNoRoute,
+			err:    errNoRoute,
+		},
+		{
+			name:   "runt",
+			packet: []byte{0x45, 0, 0, 4},
+			reason: dropRunt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, sink := newTestQuicWire(t, Peer{allowedIPs: []string{"10.1.0.1", "10.1.0.0/16"}})
+			err := qn.InjectPacket(tt.packet)
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("InjectPacket = %v, want %v", err, tt.err)
+			}
+			if sink.Len() != 0 {
+				t.Errorf("dropped packet reached the tunnel interface")
+			}
+			drops := qn.RecentDrops()
+			if len(drops) != 1 || drops[0].Reason != tt.reason {
+				t.Errorf("drops = %+v, want one %s drop", drops, tt.reason)
+			}
+		})
+	}
+}
+
+func TestLocalPacketsNotSentToPeers(t *testing.T) {
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	// The peer's broad route covers the node's own address
+	peer := NewPeer(b.udpConn.LocalAddr().String(), "10.0.0.2/32", "10.0.0.0/16")
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }, peer)
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	local := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.1:5000"), []byte("local"))
+	routed := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.3.1:5000"), []byte("routed"))
+	for _, packet := range [][]byte{local, routed} {
+		if err := a.InjectPacket(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The routed packet is sent after the local one, once it arrived the local one would have too
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := b.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || string(got[0]) != string(routed) {
+		t.Errorf("peer received %d packets, want only the routed one", len(got))
+	}
+	drops := a.RecentDrops()
+	if len(drops) != 1 || drops[0].Reason != dropLocalAddress {
+		t.Errorf("drops = %+v, want one %s drop", drops, dropLocalAddress)
+	}
+}
+
+func TestInjectPacketSentOverPeerConnection(t *testing.T) {
+	mesh := newMemNetwork()
+	inMemory := func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", inMemory)
+	c := startTestNode(t, "127.0.0.3", "10.0.0.3", inMemory)
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", inMemory,
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"),
+		newTestPeer(c.udpConn.LocalAddr().String(), "10.0.0.3"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	waitPeerState(t, a.QuicWire, "10.0.0.3/32", PeerConnected)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.3:5000"), []byte("injected"))
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := c.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got[0]) != string(packet) {
+		t.Errorf("peer received %v, want %v", got[0], packet)
+	}
+	if b.sink.Len() != 0 {
+		t.Error("packet reached a peer not owning its destination")
+	}
+}
@@ -0,0 +1,132 @@
+package quicwire
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultLogDedupWindow is how long identical log lines are collapsed
+const defaultLogDedupWindow = 10 * time.Second
+
+// dedupEntry tracks a kind of log line suppressed within the current window,
+// last is the latest of the suppressed lines
+type dedupEntry struct {
+	level    string
+	first    time.Time
+	repeated int
+	last     string
+}
+
+// dedupLogger collapses repeated warnings and errors. Lines are of the same
+// kind when they share the template, and the peer for the Peer variants,
+// whatever their arguments such as the dial cycle. The first line of a window
+// is logged, the suppressed ones are counted and summarized with the latest
+// of them once the window ends.
+type dedupLogger struct {
+	logger  *zap.SugaredLogger
+	window  time.Duration
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+func newDedupLogger(logger *zap.SugaredLogger, window time.Duration) *dedupLogger {
+	if window <= 0 {
+		window = defaultLogDedupWindow
+	}
+	return &dedupLogger{
+		logger:  logger,
+		window:  window,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// Warnf logs a warning unless a warning of the template was logged within the window
+func (d *dedupLogger) Warnf(template string, args ...any) {
+	d.log("warn", template, fmt.Sprintf(template, args...))
+}
+
+// Errorf logs an error unless an error of the template was logged within the window
+func (d *dedupLogger) Errorf(template string, args ...any) {
+	d.log("error", template, fmt.Sprintf(template, args...))
+}
+
+// PeerWarnf logs a warning about the peer unless a warning of the template
+// was logged about the same peer within the window
+func (d *dedupLogger) PeerWarnf(peer string, template string, args ...any) {
+	d.log("warn", peer+"\x00"+template, fmt.Sprintf(template, args...))
+}
+
+func (d *dedupLogger) log(level string, key string, msg string) {
+	now := time.Now()
+	d.mu.Lock()
+	e, ok := d.entries[key]
+	if ok && now.Sub(e.first) < d.window {
+		e.repeated++
+		e.last = msg
+		d.mu.Unlock()
+		return
+	}
+	var prev dedupEntry
+	if ok {
+		prev = *e
+	}
+	d.entries[key] = &dedupEntry{level: level, first: now}
+	d.mu.Unlock()
+
+	if prev.repeated > 0 {
+		d.emit(level, d.summary(prev))
+	}
+	d.emit(level, msg)
+}
+
+// summary reports the lines suppressed of an entry
+func (d *dedupLogger) summary(e dedupEntry) string {
+	return fmt.Sprintf("%s (%d similar lines suppressed in the last %s)", e.last, e.repeated, d.window)
+}
+
+// flush summarizes the lines whose window ended and forgets them
+func (d *dedupLogger) flush() {
+	now := time.Now()
+	d.mu.Lock()
+	var summaries []dedupEntry
+	for key, e := range d.entries {
+		if now.Sub(e.first) < d.window {
+			continue
+		}
+		if e.repeated > 0 {
+			summaries = append(summaries, *e)
+		}
+		delete(d.entries, key)
+	}
+	d.mu.Unlock()
+
+	for _, e := range summaries {
+		d.emit(e.level, d.summary(e))
+	}
+}
+
+func (d *dedupLogger) emit(level string, msg string) {
+	if level == "error" {
+		d.logger.Error(msg)
+	} else {
+		d.logger.Warn(msg)
+	}
+}
+
+// run flushes the summaries every window until ctx is done
+func (d *dedupLogger) run(ctx context.Context) {
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.flush()
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package quicwire
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestDedupLoggerSummarizesRepeats(t *testing.T) {
+	const window = 50 * time.Millisecond
+	logs := newObservedLogger()
+	d := newDedupLogger(logs.logger, window)
+
+	for cycle := 1; cycle <= 5; cycle++ {
+		d.PeerWarnf("10.0.0.2/32", "Peer %s is not reachable, dial cycle %d", "192.0.2.2:51820", cycle)
+	}
+	d.PeerWarnf("10.0.0.3/32", "Peer %s is not reachable, dial cycle %d", "192.0.2.3:51820", 1)
+	d.Errorf("failed to send client message: %v", "closed")
+	d.Errorf("failed to send client message: %v", "closed")
+
+	// Only the first line of each kind is logged within the window
+	want := []string{
+		"Peer 192.0.2.2:51820 is not reachable, dial cycle 1",
+		"Peer 192.0.2.3:51820 is not reachable, dial cycle 1",
+		"failed to send client message: closed",
+	}
+	entries := logs.logs.TakeAll()
+	if len(entries) != len(want) {
+		t.Fatalf("logged %d lines, want %d: %v", len(entries), len(want), entries)
+	}
+	for i, e := range entries {
+		if e.Message != want[i] {
+			t.Errorf("line %d = %q, want %q", i, e.Message, want[i])
+		}
+	}
+
+	// Once the window ended the suppressed lines are summarized with the latest of them
+	time.Sleep(window)
+	d.flush()
+	summaries := map[string]zapcore.Level{}
+	for _, e := range logs.logs.TakeAll() {
+		summaries[e.Message] = e.Level
+	}
+	wantSummaries := map[string]zapcore.Level{
+		"Peer 192.0.2.2:51820 is not reachable, dial cycle 5 (4 similar lines suppressed in the last 50ms)": zapcore.WarnLevel,
+		"failed to send client message: closed (1 similar lines suppressed in the last 50ms)":               zapcore.ErrorLevel,
+	}
+	if len(summaries) != len(wantSummaries) {
+		t.Errorf("summaries = %v, want %v", summaries, wantSummaries)
+	}
+	for msg, level := range wantSummaries {
+		if got, ok := summaries[msg]; !ok || got != level {
+			t.Errorf("summary %q logged at %v, want %v", msg, got, level)
+		}
+	}
+
+	// A new window logs the next line again
+	d.PeerWarnf("10.0.0.2/32", "Peer %s is not reachable, dial cycle %d", "192.0.2.2:51820", 6)
+	if n := logs.logs.FilterMessage("Peer 192.0.2.2:51820 is not reachable, dial cycle 6").Len(); n != 1 {
+		t.Errorf("first line of a new window logged %d times, want 1", n)
+	}
+}
@@ -0,0 +1,194 @@
+package quicwire
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// logRingSize is the number of recent log lines replayed to a new streamer
+	logRingSize = 256
+	// maxLogStreamers bounds the number of clients streaming logs at a time
+	maxLogStreamers = 4
+	// logStreamBuffer is the number of lines buffered for a slow streamer before new ones are dropped
+	logStreamBuffer = 64
+)
+
+// LogLine is a log entry served by the control API
+type LogLine struct {
+	Time    time.Time      `json:"time"`
+	Level   string         `json:"level"`
+	Logger  string         `json:"logger,omitempty"`
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// logStream keeps the most recent log lines and hands new ones to the
+// clients streaming them
+type logStream struct {
+	mu        sync.Mutex
+	lines     [logRingSize]LogLine
+	next      int
+	full      bool
+	streamers map[chan LogLine]struct{}
+}
+
+func newLogStream() *logStream {
+	return &logStream{streamers: make(map[chan LogLine]struct{})}
+}
+
+// add remembers the line and passes it to every streamer without blocking the logger
+func (s *logStream) add(l LogLine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines[s.next] = l
+	s.next = (s.next + 1) % logRingSize
+	if s.next == 0 {
+		s.full = true
+	}
+	for ch := range s.streamers {
+		select {
+		case ch <- l:
+		default:
+		}
+	}
+}
+
+// subscribe returns the buffered lines oldest first and a channel receiving
+// the new ones, it fails when maxLogStreamers are already streaming
+func (s *logStream) subscribe() ([]LogLine, chan LogLine, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.streamers) >= maxLogStreamers {
+		return nil, nil, fmt.Errorf("%d clients are already streaming logs", maxLogStreamers)
+	}
+	var lines []LogLine
+	if !s.full {
+		lines = append(lines, s.lines[:s.next]...)
+	} else {
+		lines = append(append(lines, s.lines[s.next:]...), s.lines[:s.next]...)
+	}
+	ch := make(chan LogLine, logStreamBuffer)
+	s.streamers[ch] = struct{}{}
+	return lines, ch, nil
+}
+
+func (s *logStream) unsubscribe(ch chan LogLine) {
+	s.mu.Lock()
+	delete(s.streamers, ch)
+	s.mu.Unlock()
+}
+
+// teeLogger returns a logger writing to logger and to the stream
+func (s *logStream) teeLogger(logger *zap.SugaredLogger) *zap.SugaredLogger {
+	return logger.Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		// The stream takes the levels of the core so disabled debug lines stay cheap
+		return zapcore.NewTee(core, &streamCore{LevelEnabler: core, stream: s})
+	})).Sugar()
+}
+
+// streamCore is the zap core feeding the log stream
+type streamCore struct {
+	zapcore.LevelEnabler
+	stream *logStream
+	fields []zapcore.Field
+}
+
+func (c *streamCore) With(fields []zapcore.Field) zapcore.Core {
+	return &streamCore{
+		LevelEnabler: c.LevelEnabler,
+		stream:       c.stream,
+		fields:       append(append([]zapcore.Field(nil), c.fields...), fields...),
+	}
+}
+
+func (c *streamCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+func (c *streamCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	l := LogLine{Time: e.Time, Level: e.Level.String(), Logger: e.LoggerName, Message: e.Message}
+	if len(c.fields)+len(fields) > 0 {
+		enc := zapcore.NewMapObjectEncoder()
+		for _, f := range c.fields {
+			f.AddTo(enc)
+		}
+		for _, f := range fields {
+			f.AddTo(enc)
+		}
+		l.Fields = enc.Fields
+	}
+	c.stream.add(l)
+	return nil
+}
+
+func (c *streamCore) Sync() error {
+	return nil
+}
+
+// serveLogs streams the buffered and live log lines at or above the level
+// query parameter (default debug) as server sent events
+func (qn *QuicWire) serveLogs(w http.ResponseWriter, r *http.Request) {
+	level := zapcore.DebugLevel
+	if l := r.URL.Query().Get("level"); l != "" {
+		if err := level.UnmarshalText([]byte(l)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid level %q", l), http.StatusBadRequest)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	lines, ch, err := qn.logs.subscribe()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer qn.logs.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(l LogLine) error {
+		var lineLevel zapcore.Level
+		if lineLevel.UnmarshalText([]byte(l.Level)) == nil && lineLevel < level {
+			return nil
+		}
+		data, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		return err
+	}
+	for _, l := range lines {
+		if err := send(l); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-qn.ctx.Done():
+			return
+		case l := <-ch:
+			if err := send(l); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
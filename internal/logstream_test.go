@@ -0,0 +1,83 @@
+package quicwire
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// readLogEvent reads the next server sent event from the log stream
+func readLogEvent(t *testing.T, r *bufio.Reader) LogLine {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("log stream ended: %v", err)
+		}
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+			var l LogLine
+			if err := json.Unmarshal([]byte(data), &l); err != nil {
+				t.Fatal(err)
+			}
+			return l
+		}
+	}
+}
+
+func TestLogStreamDeliversEvents(t *testing.T) {
+	core, _ := observer.New(zapcore.DebugLevel)
+	qn, err := NewQuicWire(zap.New(core).Sugar(), "", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(qn.controlHandler())
+	defer srv.Close()
+
+	// Lines logged before the client connects are replayed
+	qn.logger.Infow("Peer connection established", "peer", "10.0.0.2/32")
+	qn.logger.Debug("filtered by the level")
+	// The timeout also bounds reading the stream
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(srv.URL + "/logs?level=info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type = %q, want text/event-stream", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	replayed := readLogEvent(t, r)
+	if replayed.Message != "Peer connection established" || replayed.Level != "info" || replayed.Fields["peer"] != "10.0.0.2/32" {
+		t.Errorf("replayed line = %+v, want the connection established one with its peer", replayed)
+	}
+
+	// Live lines follow as they are logged, debug ones are left out
+	qn.logger.Debug("filtered by the level")
+	qn.logger.Warn("Peer 10.0.0.2/32 is degraded")
+	if live := readLogEvent(t, r); live.Message != "Peer 10.0.0.2/32 is degraded" || live.Level != "warn" {
+		t.Errorf("live line = %+v, want the degraded warning", live)
+	}
+}
+
+func TestLogStreamRejectsInvalidLevel(t *testing.T) {
+	qn, _ := newTestQuicWire(t)
+	srv := httptest.NewServer(qn.controlHandler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/logs?level=loud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,110 @@
+package quicwire
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ipForwardPath tells whether the kernel forwards IPv4 packets between interfaces
+const ipForwardPath = "/proc/sys/net/ipv4/ip_forward"
+
+// runIptables runs an iptables command and returns its combined output, replaced by tests
+var runIptables = func(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// masqueradeRule returns the iptables command and arguments masquerading
+// traffic from source leaving through outIf. action is -A, -C or -D.
+func masqueradeRule(action, source, outIf string) (string, []string, error) {
+	cidr, err := routePrefix(source)
+	if err != nil {
+		return "", nil, err
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid masquerade source %s: %w", source, err)
+	}
+	cmd := "iptables"
+	if prefix.Addr().Is6() {
+		cmd = "ip6tables"
+	}
+	return cmd, []string{"-t", "nat", action, "POSTROUTING", "-s", prefix.String(), "-o", outIf, "-j", "MASQUERADE"}, nil
+}
+
+// masqueradeSources returns the sources masqueraded for the node: the tunnel
+// subnet when Masquerade is set and the allowed IPs of masqueraded peers
+func (qn *QuicWire) masqueradeSources() []string {
+	var sources []string
+	if qn.qc.nodeInterface.masquerade {
+		if _, subnet, err := net.ParseCIDR(qn.qc.nodeInterface.localEndpoint); err == nil {
+			sources = append(sources, subnet.String())
+		} else if ip := net.ParseIP(qn.qc.nodeInterface.localEndpoint); ip != nil {
+			// Plain tunnel addresses get a /24, see configureTunIface
+			sources = append(sources, (&net.IPNet{IP: ip.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String())
+		}
+	}
+	qn.mu.RLock()
+	defer qn.mu.RUnlock()
+	for _, peer := range qn.qc.peers {
+		if peer.masquerade {
+			sources = append(sources, peer.allowedIPs...)
+		}
+	}
+	return sources
+}
+
+// setupMasquerade installs the source NAT rules so return traffic of
+// forwarded packets comes back through this node
+func (qn *QuicWire) setupMasquerade() error {
+	outIf := qn.qc.nodeInterface.masqueradeInterface
+	if outIf == "" {
+		return nil
+	}
+	if forward, err := os.ReadFile(ipForwardPath); err == nil && strings.TrimSpace(string(forward)) != "1" {
+		qn.logger.Warnf("IP forwarding is disabled, masqueraded traffic is not forwarded to %s until %s is set", outIf, ipForwardPath)
+	}
+	for _, source := range qn.masqueradeSources() {
+		if err := qn.addMasquerade(source, outIf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMasquerade installs the rule for source unless it is present already
+func (qn *QuicWire) addMasquerade(source, outIf string) error {
+	name, args, err := masqueradeRule("-C", source, outIf)
+	if err != nil {
+		return err
+	}
+	if _, err := runIptables(name, args...); err == nil {
+		return nil
+	}
+	name, args, _ = masqueradeRule("-A", source, outIf)
+	if out, err := runIptables(name, args...); err != nil {
+		return fmt.Errorf("failed to masquerade %s on %s: %v: %s", source, outIf, err, strings.TrimSpace(string(out)))
+	}
+	qn.logger.Debugf("Masquerading traffic from %s leaving through %s", source, outIf)
+	return nil
+}
+
+// removeMasquerade removes the rules of the sources installed by setupMasquerade
+func (qn *QuicWire) removeMasquerade(sources []string) {
+	outIf := qn.qc.nodeInterface.masqueradeInterface
+	if outIf == "" {
+		return
+	}
+	for _, source := range sources {
+		name, args, err := masqueradeRule("-D", source, outIf)
+		if err != nil {
+			continue
+		}
+		if _, err := runIptables(name, args...); err != nil {
+			qn.logger.Warnf("Failed to remove the masquerade rule of %s: %v", source, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package quicwire
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeIptables keeps the rules added by iptables commands in memory
+type fakeIptables struct {
+	mu    sync.Mutex
+	rules map[string]bool
+	// failAppend fails every -A command
+	failAppend bool
+}
+
+// install replaces runIptables with the fake until the test ends
+func (f *fakeIptables) install(t *testing.T) {
+	orig := runIptables
+	runIptables = f.run
+	t.Cleanup(func() { runIptables = orig })
+}
+
+func (f *fakeIptables) run(name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// The action is the third argument, after -t nat
+	action := args[2]
+	rule := name + " " + strings.Join(append(append([]string(nil), args[:2]...), args[3:]...), " ")
+	switch action {
+	case "-C":
+		if !f.rules[rule] {
+			return []byte("Bad rule"), errors.New("exit status 1")
+		}
+	case "-A":
+		if f.failAppend {
+			return []byte("Permission denied"), errors.New("exit status 4")
+		}
+		f.rules[rule] = true
+	case "-D":
+		if !f.rules[rule] {
+			return []byte("Bad rule"), errors.New("exit status 1")
+		}
+		delete(f.rules, rule)
+	}
+	return nil, nil
+}
+
+// list returns the rules in place, sorted
+func (f *fakeIptables) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var rules []string
+	for rule := range f.rules {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	return rules
+}
+
+func TestMasqueradeRulesRoundTrip(t *testing.T) {
+	ipt := &fakeIptables{rules: make(map[string]bool)}
+	ipt.install(t)
+	qn, _ := newTestQuicWire(t,
+		Peer{allowedIPs: []string{"10.0.0.2"}},
+		Peer{allowedIPs: []string{"10.1.0.0/16", "fd01::/64"}, masquerade: true})
+	qn.qc.nodeInterface.localEndpoint = "10.0.0.1/24"
+	qn.qc.nodeInterface.masquerade = true
+	qn.qc.nodeInterface.masqueradeInterface = "eth0"
+
+	// Setting up twice, as on a restart, adds each rule once
+	for i := 0; i < 2; i++ {
+		if err := qn.setupMasquerade(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"ip6tables -t nat POSTROUTING -s fd01::/64 -o eth0 -j MASQUERADE",
+		"iptables -t nat POSTROUTING -s 10.0.0.0/24 -o eth0 -j MASQUERADE",
+		"iptables -t nat POSTROUTING -s 10.1.0.0/16 -o eth0 -j MASQUERADE",
+	}
+	if got := ipt.list(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rules = %q, want %q", got, want)
+	}
+
+	qn.removeMasquerade(qn.masqueradeSources())
+	if got := ipt.list(); len(got) != 0 {
+		t.Errorf("rules left after removal: %q", got)
+	}
+}
+
+func TestMasqueradeSetupFails(t *testing.T) {
+	ipt := &fakeIptables{rules: make(map[string]bool), failAppend: true}
+	ipt.install(t)
+	qn, _ := newTestQuicWire(t)
+	qn.qc.nodeInterface.localEndpoint = "10.0.0.1"
+	qn.qc.nodeInterface.masquerade = true
+	qn.qc.nodeInterface.masqueradeInterface = "eth0"
+	err := qn.setupMasquerade()
+	if err == nil || !strings.Contains(err.Error(), "10.0.0.0/24") || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("error = %v, want the failed source and the iptables output", err)
+	}
+}
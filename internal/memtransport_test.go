@@ -0,0 +1,328 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// memDatagramQueue is the number of datagrams queued for a reader before new ones are dropped
+const memDatagramQueue = 1024
+
+// memNetwork connects the transports of a test mesh in memory. Listeners are
+// found by the local address of the packet conn they were created on, so the
+// nodes keep their UDP sockets for everything but QUIC.
+type memNetwork struct {
+	mu        sync.Mutex
+	listeners map[string]*memListener
+}
+
+func newMemNetwork() *memNetwork {
+	return &memNetwork{listeners: make(map[string]*memListener)}
+}
+
+// transport returns a Transport listening and dialing on the network
+func (n *memNetwork) transport() Transport {
+	return memTransport{net: n}
+}
+
+// memTransport is a Transport whose connections carry datagrams and streams
+// in memory. Datagrams larger than a QUIC datagram over a 1280 byte path are
+// rejected like quic-go does.
+type memTransport struct {
+	net *memNetwork
+}
+
+func (t memTransport) Listen(conn net.PacketConn, tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error) {
+	l := &memListener{
+		net:   t.net,
+		addr:  conn.LocalAddr(),
+		conns: make(chan quic.Connection, 16),
+		done:  make(chan struct{}),
+	}
+	t.net.mu.Lock()
+	defer t.net.mu.Unlock()
+	if _, ok := t.net.listeners[l.addr.String()]; ok {
+		return nil, fmt.Errorf("address %s is in use", l.addr)
+	}
+	t.net.listeners[l.addr.String()] = l
+	return l, nil
+}
+
+func (t memTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	t.net.mu.Lock()
+	l := t.net.listeners[addr.String()]
+	t.net.mu.Unlock()
+	if l == nil {
+		return nil, &quic.HandshakeTimeoutError{}
+	}
+	dialed, accepted := newMemConnPair(conn.LocalAddr(), addr)
+	select {
+	case l.conns <- accepted:
+		return dialed, nil
+	case <-l.done:
+		return nil, &quic.HandshakeTimeoutError{}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// memListener accepts the connections dialed to its address
+type memListener struct {
+	net       *memNetwork
+	addr      net.Addr
+	conns     chan quic.Connection
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *memListener) Addr() net.Addr { return l.addr }
+
+func (l *memListener) Accept(ctx context.Context) (quic.Connection, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *memListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.net.mu.Lock()
+		delete(l.net.listeners, l.addr.String())
+		l.net.mu.Unlock()
+	})
+	return nil
+}
+
+// memConn is one end of an in-memory connection
+type memConn struct {
+	local  net.Addr
+	remote net.Addr
+	peer   *memConn
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closeErr  error
+
+	datagrams chan []byte
+	streams   chan *memStream
+
+	mu     sync.Mutex
+	nextID quic.StreamID
+	opened []*memStream
+}
+
+// newMemConnPair returns the dialed and the accepted end of a connection
+func newMemConnPair(local, remote net.Addr) (*memConn, *memConn) {
+	end := func(local, remote net.Addr) *memConn {
+		ctx, cancel := context.WithCancel(context.Background())
+		return &memConn{
+			local:     local,
+			remote:    remote,
+			ctx:       ctx,
+			cancel:    cancel,
+			datagrams: make(chan []byte, memDatagramQueue),
+			streams:   make(chan *memStream, 16),
+		}
+	}
+	dialed, accepted := end(local, remote), end(remote, local)
+	dialed.peer, accepted.peer = accepted, dialed
+	accepted.nextID = 1
+	return dialed, accepted
+}
+
+func (c *memConn) LocalAddr() net.Addr      { return c.local }
+func (c *memConn) RemoteAddr() net.Addr     { return c.remote }
+func (c *memConn) Context() context.Context { return c.ctx }
+func (c *memConn) ConnectionState() quic.ConnectionState {
+	return quic.ConnectionState{SupportsDatagrams: true}
+}
+func (c *memConn) OpenUniStream() (quic.SendStream, error) {
+	return nil, errors.New("unidirectional streams are not supported")
+}
+func (c *memConn) AcceptUniStream(ctx context.Context) (quic.ReceiveStream, error) {
+	// Like quic-go, fail with the close error once the connection is closed
+	select {
+	case <-c.ctx.Done():
+		return nil, c.err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+func (c *memConn) OpenUniStreamSync(context.Context) (quic.SendStream, error) {
+	return nil, errors.New("unidirectional streams are not supported")
+}
+
+// close ends the connection with err, the streams opened on it fail as well
+func (c *memConn) close(err error) {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		c.closeErr = err
+		streams := c.opened
+		c.mu.Unlock()
+		for _, s := range streams {
+			s.abort(err)
+		}
+		c.cancel()
+	})
+}
+
+// err returns the error the connection was closed with
+func (c *memConn) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closeErr
+}
+
+func (c *memConn) CloseWithError(code quic.ApplicationErrorCode, msg string) error {
+	c.close(&quic.ApplicationError{ErrorCode: code, ErrorMessage: msg})
+	c.peer.close(&quic.ApplicationError{ErrorCode: code, ErrorMessage: msg, Remote: true})
+	return nil
+}
+
+func (c *memConn) SendMessage(b []byte) error {
+	if c.ctx.Err() != nil {
+		return c.err()
+	}
+	if len(b) > maxDatagramSize {
+		return fmt.Errorf("datagram of %d bytes exceeds the maximum of %d", len(b), maxDatagramSize)
+	}
+	select {
+	case c.peer.datagrams <- append([]byte(nil), b...):
+	default:
+		// Datagrams are unreliable, a slow reader loses them
+	}
+	return nil
+}
+
+func (c *memConn) ReceiveMessage() ([]byte, error) {
+	select {
+	case b := <-c.datagrams:
+		return b, nil
+	case <-c.ctx.Done():
+		return nil, c.err()
+	}
+}
+
+func (c *memConn) OpenStream() (quic.Stream, error) {
+	return c.OpenStreamSync(context.Background())
+}
+
+func (c *memConn) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	c.mu.Lock()
+	id := c.nextID
+	c.nextID += 4
+	c.mu.Unlock()
+	local, remote := newMemStreamPair(id, c.ctx, c.peer.ctx)
+	c.track(local)
+	c.peer.track(remote)
+	select {
+	case c.peer.streams <- remote:
+		return local, nil
+	case <-c.ctx.Done():
+		return nil, c.err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *memConn) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	select {
+	case s := <-c.streams:
+		return s, nil
+	case <-c.ctx.Done():
+		return nil, c.err()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// track remembers a stream of the connection so closing the connection ends it
+func (c *memConn) track(s *memStream) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.opened = append(c.opened, s)
+}
+
+// memStream is one end of an in-memory bidirectional stream
+type memStream struct {
+	id  quic.StreamID
+	ctx context.Context
+	r   *io.PipeReader
+	w   *io.PipeWriter
+
+	mu         sync.Mutex
+	readTimer  *time.Timer
+	writeTimer *time.Timer
+}
+
+// newMemStreamPair returns the two ends of a stream, each living as long as its connection
+func newMemStreamPair(id quic.StreamID, localCtx, remoteCtx context.Context) (*memStream, *memStream) {
+	r1, w1 := io.Pipe()
+	r2, w2 := io.Pipe()
+	return &memStream{id: id, ctx: localCtx, r: r2, w: w1}, &memStream{id: id, ctx: remoteCtx, r: r1, w: w2}
+}
+
+func (s *memStream) StreamID() quic.StreamID     { return s.id }
+func (s *memStream) Context() context.Context    { return s.ctx }
+func (s *memStream) Read(p []byte) (int, error)  { return s.r.Read(p) }
+func (s *memStream) Write(p []byte) (int, error) { return s.w.Write(p) }
+func (s *memStream) Close() error                { return s.w.Close() }
+
+func (s *memStream) CancelRead(code quic.StreamErrorCode) {
+	s.r.CloseWithError(&quic.StreamError{StreamID: s.id, ErrorCode: code})
+}
+
+func (s *memStream) CancelWrite(code quic.StreamErrorCode) {
+	s.w.CloseWithError(&quic.StreamError{StreamID: s.id, ErrorCode: code})
+}
+
+// abort fails both directions of the stream with err
+func (s *memStream) abort(err error) {
+	s.r.CloseWithError(err)
+	s.w.CloseWithError(err)
+}
+
+func (s *memStream) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readTimer = deadlineTimer(s.readTimer, t, func() { s.r.CloseWithError(os.ErrDeadlineExceeded) })
+	return nil
+}
+
+func (s *memStream) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeTimer = deadlineTimer(s.writeTimer, t, func() { s.w.CloseWithError(os.ErrDeadlineExceeded) })
+	return nil
+}
+
+func (s *memStream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+// deadlineTimer replaces timer by one calling expired at t, a zero t clears the deadline
+func deadlineTimer(timer *time.Timer, t time.Time, expired func()) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), expired)
+}
@@ -0,0 +1,292 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+	"github.com/pion/stun"
+	"github.com/quic-go/quic-go"
+	"go.uber.org/zap"
+)
+
+// newTestQuicWire returns a node with the local address 10.0.0.1 handing the
+// packets it would write to the tunnel interface to a sink
+func newTestQuicWire(t *testing.T, peers ...Peer) (*QuicWire, *packettest.Sink) {
+	t.Helper()
+	qn, err := NewQuicWire(zap.NewNop().Sugar(), "", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qn.localAddr = netip.MustParseAddr("10.0.0.1")
+	qn.qc.peers = peers
+	if qn.routes, err = newRouteTable(peers); err != nil {
+		t.Fatal(err)
+	}
+	sink := packettest.NewSink()
+	qn.SetPacketHandler(sink.Handle)
+	return qn, sink
+}
+
+// testConn is a QUIC connection carrying the TLS state of a real handshake,
+// it records the datagrams sent over it
+type testConn struct {
+	quic.Connection
+	state  quic.ConnectionState
+	remote net.Addr
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	sent [][]byte
+}
+
+func (c *testConn) ConnectionState() quic.ConnectionState { return c.state }
+func (c *testConn) Context() context.Context              { return c.ctx }
+func (c *testConn) RemoteAddr() net.Addr                  { return c.remote }
+
+func (c *testConn) CloseWithError(quic.ApplicationErrorCode, string) error {
+	c.cancel()
+	return nil
+}
+
+func (c *testConn) SendMessage(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, append([]byte(nil), b...))
+	return nil
+}
+
+// messages returns the datagrams sent over the connection
+func (c *testConn) messages() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]byte(nil), c.sent...)
+}
+
+// newTestConnPair returns the two ends of a connection sharing the session
+// of a TLS 1.3 handshake, the connection dialed and the connection accepted
+func newTestConnPair(t *testing.T) (*testConn, *testConn) {
+	t.Helper()
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+	server := tls.Server(serverSide, getTLSConfig())
+	client := tls.Client(clientSide, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{defaultALPN}})
+	done := make(chan error, 1)
+	go func() { done <- server.Handshake() }()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	end := func(tlsConn *tls.Conn, remote string) *testConn {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		c := &testConn{remote: net.UDPAddrFromAddrPort(netip.MustParseAddrPort(remote)), ctx: ctx, cancel: cancel}
+		c.state.TLS.ConnectionState = tlsConn.ConnectionState()
+		return c
+	}
+	return end(client, "192.0.2.2:55381"), end(server, "192.0.2.1:40000")
+}
+
+// newTestClient returns a client of the connection, outbound for the dialed end
+func newTestClient(t *testing.T, conn *testConn, outbound bool) *Client {
+	t.Helper()
+	c, err := NewClient(conn.RemoteAddr().String(), "127.0.0.1", 0, nil, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetConnection(conn)
+	c.outbound = outbound
+	return c
+}
+
+// testNode is a node of a test mesh on a loopback address, it hands the
+// packets it would write to its tunnel interface to sink
+type testNode struct {
+	*QuicWire
+	sink *packettest.Sink
+}
+
+// newTestPeer returns the peer serving the tunnel address of a node listening on endpoint
+func newTestPeer(endpoint string, tunnelAddr string) Peer {
+	return Peer{endpoint: endpoint, allowedIPs: []string{tunnelAddr + "/32"}}
+}
+
+// startTestNode starts a node without tunnel interface listening on an
+// ephemeral port of the loopback address ip, with tunnelAddr as its address.
+// configure, if set, adjusts the node before it starts.
+func startTestNode(t testing.TB, ip string, tunnelAddr string, configure func(*QuicWire), peers ...Peer) *testNode {
+	t.Helper()
+	qn, err := NewQuicWire(zap.NewNop().Sugar(), "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qn.qc.nodeInterface.localNodeIP = ip
+	qn.qc.nodeInterface.localEndpoint = tunnelAddr + "/24"
+	qn.qc.nodeInterface.stunServers = []string{"127.0.0.1:1"}
+	qn.qc.peers = peers
+	qn.localAddr = netip.MustParseAddr(tunnelAddr)
+	sink := packettest.NewSink()
+	qn.SetPacketHandler(sink.Handle)
+	if configure != nil {
+		configure(qn)
+	}
+	if qn.routes, err = newRouteTable(qn.qc.peers); err != nil {
+		t.Fatal(err)
+	}
+	if err := qn.setupCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	qn.ctx, qn.cancel = context.WithCancel(context.Background())
+	if err := qn.bindSharedSocket(); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	qn.setupTunnel(&wg, false, false)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		qn.StopContext(ctx)
+	})
+	return &testNode{QuicWire: qn, sink: sink}
+}
+
+// waitPeerState waits until the peer of the node owning allowedIP is in state
+func waitPeerState(t testing.TB, qn *QuicWire, allowedIP string, state PeerState) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		qn.mu.RLock()
+		got := qn.peerStates[allowedIP]
+		qn.mu.RUnlock()
+		if got == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("peer %s did not reach state %v", allowedIP, state)
+}
+
+// waitEvent returns the first event of the node matching match
+func waitEvent(t *testing.T, qn *QuicWire, match func(Event) bool) Event {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case e := <-qn.Events():
+			if match(e) {
+				return e
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+}
+
+// startTestSTUN starts a STUN server answering binding requests with mapped
+// as the reflexive address of the client, it returns the server address
+func startTestSTUN(t *testing.T, mapped *net.UDPAddr) string {
+	t.Helper()
+	return startScriptedSTUN(t, mapped, 0, false)
+}
+
+// startScriptedSTUN starts a STUN responder answering after delay, with an
+// error response instead of the binding when fail is set. A nil mapped
+// address reflects the source address of the request.
+func startScriptedSTUN(t *testing.T, mapped *net.UDPAddr, delay time.Duration, fail bool) string {
+	t.Helper()
+	return serveSTUN(t, func() *net.UDPAddr { return mapped }, delay, fail)
+}
+
+// serveSTUN starts a STUN responder answering with the address mapped
+// returns at the time of the request, see startScriptedSTUN
+func serveSTUN(t *testing.T, mapped func() *net.UDPAddr, delay time.Duration, fail bool) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if req.Decode() != nil {
+				continue
+			}
+			binding := mapped()
+			if binding == nil {
+				binding = addr.(*net.UDPAddr)
+			}
+			setters := []stun.Setter{stun.NewTransactionIDSetter(req.TransactionID), stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: binding.IP, Port: binding.Port}}
+			if fail {
+				setters = []stun.Setter{stun.NewTransactionIDSetter(req.TransactionID), stun.BindingError, stun.CodeServerError}
+			}
+			res, err := stun.Build(setters...)
+			if err != nil {
+				continue
+			}
+			time.Sleep(delay)
+			conn.WriteTo(res.Raw, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// startEchoPeer starts a QUIC listener on the loopback address ip echoing
+// the probes of its peers like a node does, it returns the listen address.
+// It speaks no hello and never acknowledges a goodbye.
+func startEchoPeer(t *testing.T, ip string) string {
+	t.Helper()
+	listener, err := quic.ListenAddr(ip+":0", getTLSConfig(), &quic.Config{EnableDatagrams: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			// Control streams are refused, so the hello fails at once
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					stream.CancelRead(0)
+					stream.Close()
+				}
+			}()
+			go func() {
+				for {
+					data, err := conn.ReceiveMessage()
+					if err != nil {
+						return
+					}
+					f, err := decodeFrame(data)
+					if err != nil || f.typ != frameProbe {
+						continue
+					}
+					conn.SendMessage(encodeFrame(frameProbeReply, 0, f.seq, f.payload))
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
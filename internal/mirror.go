@@ -0,0 +1,145 @@
+package quicwire
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// mirror copies selected traffic to a monitoring peer
+type mirror struct {
+	// peer is the first allowed IP of the monitoring peer
+	peer     string
+	prefixes []netip.Prefix
+}
+
+// newMirror parses the mirror settings, it returns nil when mirroring is off
+func newMirror(peer string, cidrs []string) (*mirror, error) {
+	if peer == "" {
+		return nil, nil
+	}
+	m := &mirror{peer: peer}
+	for _, cidr := range cidrs {
+		route, err := routePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirror CIDR %s: %w", cidr, err)
+		}
+		m.prefixes = append(m.prefixes, prefix)
+	}
+	return m, nil
+}
+
+// matches reports whether the source or destination of the packet is mirrored
+func (m *mirror) matches(packet []byte) bool {
+	if len(m.prefixes) == 0 {
+		return true
+	}
+	src, dst := packetSrc(packet), packetDst(packet)
+	for _, prefix := range m.prefixes {
+		if prefix.Contains(src) || prefix.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+// setupMirror enables mirroring to a peer and capturing mirrored traffic as configured
+func (qn *QuicWire) setupMirror() error {
+	ni := qn.qc.nodeInterface
+	m, err := newMirror(strings.TrimSpace(ni.mirrorPeer), ni.mirrorCIDRs)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		if _, ok := qn.peerByAllowedIP(m.peer); !ok {
+			return fmt.Errorf("mirror peer %s is not a configured peer", m.peer)
+		}
+		qn.logger.Infof("Mirroring traffic to peer %s", m.peer)
+	}
+	qn.mirror = m
+
+	if ni.mirrorCapture != "" {
+		capture, err := newPcapWriter(ni.mirrorCapture)
+		if err != nil {
+			return fmt.Errorf("failed to create mirror capture: %w", err)
+		}
+		qn.mirrorCapture = capture
+		qn.logger.Infof("Writing traffic mirrored by peers to %s", ni.mirrorCapture)
+		if ni.captureDrops {
+			qn.logger.Infof("Writing packets dropped by the node to %s", ni.mirrorCapture)
+		}
+	}
+	return nil
+}
+
+// mirrorPacket sends a copy of a forwarded packet to the monitoring peer.
+// exclude is the peer the packet travels to or from, it is not mirrored back.
+func (qn *QuicWire) mirrorPacket(packet []byte, exclude string) {
+	m := qn.mirror
+	if m == nil || m.peer == exclude || !m.matches(packet) {
+		return
+	}
+	qn.mu.RLock()
+	c, ok := qn.clients[m.peer]
+	qn.mu.RUnlock()
+	if !ok {
+		return
+	}
+	if err := c.SendMirror(packet); err != nil {
+		qn.logger.Debugf("Failed to mirror packet to %s: %v", m.peer, err)
+	}
+}
+
+// captureMirrored stores a packet mirrored by a peer, it is never forwarded
+func (qn *QuicWire) captureMirrored(c packetContext) {
+	if qn.mirrorCapture == nil {
+		return
+	}
+	if err := qn.mirrorCapture.writePacket(c.Data); err != nil && qn.tunWriteLog.allow() {
+		qn.logger.Errorf("Failed to capture packet mirrored by %s: %v", c.RemoteAddr(), err)
+	}
+}
+
+// captureDrop writes a packet dropped by the node to the capture with the
+// reason in its comment, with CaptureDrops
+func (qn *QuicWire) captureDrop(reason string, peer string, packet []byte) {
+	if qn.mirrorCapture == nil || !qn.qc.nodeInterface.captureDrops {
+		return
+	}
+	comment := "dropped: " + reason
+	if peer != "" {
+		comment += ", peer " + peer
+	}
+	if err := qn.mirrorCapture.writeAnnotated(packet, comment); err != nil && qn.tunWriteLog.allow() {
+		qn.logger.Errorf("Failed to capture packet dropped for %s: %v", reason, err)
+	}
+}
+
+// deliver handles a packet received from a peer
+func (qn *QuicWire) deliver(c packetContext) {
+	if c.mirrored {
+		qn.captureMirrored(c)
+		return
+	}
+	qn.counters.countRx(len(c.Data))
+	if qn.tapMode() {
+		// Keep peers from flooding the local segment with filtered protocols
+		if qn.frameAllowed(c.Data) {
+			qn.writeTun(c)
+		}
+		return
+	}
+	if qn.mirror != nil && len(c.Data) >= ipv4HeaderLen {
+		if peer, ok := qn.routes.lookup(packetSrc(c.Data), flowHash(c.Data), func(string) bool { return true }); ok {
+			qn.mirrorPacket(c.Data, peer)
+		}
+	}
+	if qn.qc.nodeInterface.relay && qn.relay(c) {
+		return
+	}
+	qn.writeTun(c)
+}
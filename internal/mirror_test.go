@@ -0,0 +1,194 @@
+package quicwire
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestMirrorCopiesForwardedPackets(t *testing.T) {
+	mesh := newMemNetwork()
+	inMemory := func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", inMemory)
+	capture := filepath.Join(t.TempDir(), "mirror.pcapng")
+	monitor := startTestNode(t, "127.0.0.3", "10.0.0.3", func(qn *QuicWire) {
+		inMemory(qn)
+		qn.qc.nodeInterface.mirrorCapture = capture
+		if err := qn.setupMirror(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		inMemory(qn)
+		qn.qc.nodeInterface.mirrorPeer = "10.0.0.3/32"
+		qn.qc.nodeInterface.mirrorCIDRs = []string{"10.0.0.2/32"}
+		if err := qn.setupMirror(); err != nil {
+			t.Fatal(err)
+		}
+	},
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"),
+		newTestPeer(monitor.udpConn.LocalAddr().String(), "10.0.0.3"),
+	)
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	waitPeerState(t, a.QuicWire, "10.0.0.3/32", PeerConnected)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("mirrored"))
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := b.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[0], packet) {
+		t.Errorf("peer received %x, want %x", got[0], packet)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(capture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, packet) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("mirrored packet not written to the monitor's capture")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := monitor.sink.Len(); n != 0 {
+		t.Errorf("monitor forwarded %d mirrored packets, want 0", n)
+	}
+	if rx := monitor.Stats().RxPackets; rx != 0 {
+		t.Errorf("monitor counted %d received packets, want 0", rx)
+	}
+}
+
+// capturedPacket is a packet record of a pcapng capture
+type capturedPacket struct {
+	data    []byte
+	comment string
+}
+
+// readCapture returns the packet records of the pcapng capture at path
+func readCapture(t *testing.T, path string) []capturedPacket {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []capturedPacket
+	for len(data) >= 12 {
+		typ := binary.LittleEndian.Uint32(data[0:4])
+		length := int(binary.LittleEndian.Uint32(data[4:8]))
+		if length < 12 || length > len(data) {
+			t.Fatalf("block of %d bytes in %d remaining", length, len(data))
+		}
+		body := data[8 : length-4]
+		data = data[length:]
+		if typ != pcapngEnhancedPacket {
+			continue
+		}
+		size := int(binary.LittleEndian.Uint32(body[12:16]))
+		p := capturedPacket{data: body[20 : 20+size]}
+		options := body[20+(size+3)/4*4:]
+		for len(options) >= 4 {
+			code := binary.LittleEndian.Uint16(options[0:2])
+			n := int(binary.LittleEndian.Uint16(options[2:4]))
+			if code == pcapngOptEndOfOptions {
+				break
+			}
+			if code == pcapngOptComment {
+				p.comment = string(options[4 : 4+n])
+			}
+			options = options[4+(n+3)/4*4:]
+		}
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+func TestCaptureAnnotatesDrops(t *testing.T) {
+	mesh := newMemNetwork()
+	inMemory := func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", inMemory)
+	capture := filepath.Join(t.TempDir(), "mirror.pcapng")
+	rules, err := parseACL("deny udp any 9; allow any")
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitor := startTestNode(t, "127.0.0.3", "10.0.0.3", func(qn *QuicWire) {
+		inMemory(qn)
+		// The monitor only accepts, so the ACL of the configured peer applies to it
+		qn.disableClient = true
+		qn.qc.nodeInterface.mirrorCapture = capture
+		qn.qc.nodeInterface.captureDrops = true
+		if err := qn.setupMirror(); err != nil {
+			t.Fatal(err)
+		}
+	}, Peer{endpoint: "127.0.0.1:51820", allowedIPs: []string{"10.0.0.1/32"}, acl: rules})
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		inMemory(qn)
+		qn.qc.nodeInterface.mirrorPeer = "10.0.0.3/32"
+		qn.qc.nodeInterface.mirrorCIDRs = []string{"10.0.0.2/32"}
+		if err := qn.setupMirror(); err != nil {
+			t.Fatal(err)
+		}
+	},
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"),
+		newTestPeer(monitor.udpConn.LocalAddr().String(), "10.0.0.3"),
+	)
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	waitPeerState(t, a.QuicWire, "10.0.0.3/32", PeerConnected)
+	waitPeerState(t, monitor.QuicWire, "10.0.0.1/32", PeerConnected)
+
+	src := netip.MustParseAddrPort("10.0.0.1:4000")
+	forwarded := packettest.UDP(src, netip.MustParseAddrPort("10.0.0.2:5000"), []byte("forwarded"))
+	denied := packettest.UDP(src, netip.MustParseAddrPort("10.0.0.3:9"), []byte("denied"))
+	unrouted := packettest.UDP(netip.MustParseAddrPort("10.0.0.3:4000"), netip.MustParseAddrPort("192.168.0.1:5000"), []byte("unrouted"))
+	for _, packet := range [][]byte{forwarded, denied} {
+		if err := a.InjectPacket(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := monitor.InjectPacket(unrouted); !errors.Is(err, errNoRoute) {
+		t.Fatalf("error = %v, want %v", err, errNoRoute)
+	}
+
+	want := map[string]string{
+		string(forwarded): "",
+		string(denied):    "dropped: acl, peer 10.0.0.1/32",
+		string(unrouted):  "dropped: no-route",
+	}
+	var got []capturedPacket
+	deadline := time.Now().Add(5 * time.Second)
+	for len(got) < len(want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("captured %d packets, want %d", len(got), len(want))
+		}
+		time.Sleep(10 * time.Millisecond)
+		got = readCapture(t, capture)
+	}
+	for _, p := range got {
+		comment, ok := want[string(p.data)]
+		if !ok {
+			t.Errorf("captured unexpected packet %x", p.data)
+			continue
+		}
+		if p.comment != comment {
+			t.Errorf("packet %q captured with comment %q, want %q", p.data[28:], p.comment, comment)
+		}
+	}
+}
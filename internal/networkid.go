@@ -0,0 +1,51 @@
+package quicwire
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+)
+
+// errCodeNetworkID closes connections to nodes of another mesh
+const errCodeNetworkID quic.ApplicationErrorCode = 0x4
+
+// errNetworkIDMismatch is returned for a peer announcing a different network ID than the node
+var errNetworkIDMismatch = errors.New("network ID mismatch")
+
+// verifyNetworkID rejects a peer whose hello carries another network ID than
+// the node's, ok tells whether the hello exchange succeeded. The connection
+// is closed and forgotten before any packet is routed over it.
+func (qn *QuicWire) verifyNetworkID(c *Client, remote hello, ok bool) error {
+	local := qn.qc.nodeInterface.networkID
+	if remote.NetworkID == local {
+		return nil
+	}
+	var err error
+	if !ok {
+		err = fmt.Errorf("%w: peer %s did not complete the hello, node is in network %q", errNetworkIDMismatch, c.addr, local)
+	} else {
+		err = fmt.Errorf("%w: peer %s is in network %q, node is in network %q", errNetworkIDMismatch, c.addr, remote.NetworkID, local)
+	}
+	qn.logger.Errorf("Rejected peer: %v", err)
+	qn.rejectConnection(c, errCodeNetworkID, "network ID mismatch")
+	return err
+}
+
+// rejectConnection forgets the connection of a rejected peer and closes it
+// with the given application error code
+func (qn *QuicWire) rejectConnection(c *Client, code quic.ApplicationErrorCode, reason string) {
+	qn.mu.Lock()
+	for key, client := range qn.clients {
+		if client == c {
+			delete(qn.clients, key)
+		}
+	}
+	for host, conn := range qn.connections {
+		if conn == c.connection {
+			delete(qn.connections, host)
+		}
+	}
+	qn.mu.Unlock()
+	c.connection.CloseWithError(code, reason)
+}
@@ -0,0 +1,48 @@
+package quicwire
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyNetworkID(t *testing.T) {
+	tests := []struct {
+		name   string
+		local  string
+		remote string
+		ok     bool
+		err    error
+	}{
+		{name: "both unset", ok: true},
+		{name: "same network", local: "lab", remote: "lab", ok: true},
+		{name: "other network", local: "lab", remote: "prod", ok: true, err: errNetworkIDMismatch},
+		{name: "peer without network", local: "lab", ok: true, err: errNetworkIDMismatch},
+		{name: "node without network", remote: "lab", ok: true, err: errNetworkIDMismatch},
+		{name: "hello failed", local: "lab", err: errNetworkIDMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, _ := newTestQuicWire(t)
+			qn.qc.nodeInterface.networkID = tt.local
+			dialed, _ := newTestConnPair(t)
+			c := newTestClient(t, dialed, true)
+			qn.clients["10.0.0.2"] = c
+			qn.connections["192.0.2.2"] = dialed
+
+			err := qn.verifyNetworkID(c, hello{NetworkID: tt.remote}, tt.ok)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("verifyNetworkID = %v, want %v", err, tt.err)
+			}
+			rejected := tt.err != nil
+			if closed := dialed.Context().Err() != nil; closed != rejected {
+				t.Errorf("connection closed = %v, want %v", closed, rejected)
+			}
+			if _, ok := qn.clients["10.0.0.2"]; ok == rejected {
+				t.Errorf("client kept = %v after rejecting = %v", ok, rejected)
+			}
+			if _, ok := qn.connections["192.0.2.2"]; ok == rejected {
+				t.Errorf("connection kept = %v after rejecting = %v", ok, rejected)
+			}
+		})
+	}
+}
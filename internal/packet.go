@@ -0,0 +1,78 @@
+package quicwire
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/netip"
+)
+
+// ipv4HeaderLen is the length of an IPv4 header without options
+const ipv4HeaderLen = 20
+
+// ipv4HeaderLength returns the length of the header of an IPv4 packet
+// including its options, read from the IHL field. ok is false when the
+// header is shorter than the minimum or longer than the packet.
+func ipv4HeaderLength(packet []byte) (int, bool) {
+	if len(packet) < ipv4HeaderLen {
+		return 0, false
+	}
+	n := int(packet[0]&0x0f) * 4
+	if n < ipv4HeaderLen || n > len(packet) {
+		return 0, false
+	}
+	return n, true
+}
+
+// Handling of packets read from the tunnel that are addressed to the node itself
+const (
+	// localPacketsDrop drops them
+	localPacketsDrop = "drop"
+	// localPacketsLoopback writes them back to the tunnel interface for local delivery
+	localPacketsLoopback = "loopback"
+)
+
+// packetSrc returns the source address of an IPv4 packet
+func packetSrc(packet []byte) netip.Addr {
+	return netip.AddrFrom4([4]byte(packet[12:16]))
+}
+
+// packetDst returns the destination address of an IPv4 packet
+func packetDst(packet []byte) netip.Addr {
+	return netip.AddrFrom4([4]byte(packet[16:20]))
+}
+
+// flowHash hashes the addresses of a packet so all packets of a flow take the same path
+func flowHash(packet []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(packet[12:20])
+	return h.Sum32()
+}
+
+// tunnelAddr returns the tunnel address of the node from its local endpoint
+func tunnelAddr(localEndpoint string) (netip.Addr, error) {
+	if prefix, err := netip.ParsePrefix(localEndpoint); err == nil {
+		return prefix.Addr(), nil
+	}
+	addr, err := netip.ParseAddr(localEndpoint)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid IP address format: %s", localEndpoint)
+	}
+	return addr, nil
+}
+
+// handleLocalPacket handles a packet read from the tunnel interface that is
+// addressed to the node itself, it is never forwarded to a peer
+func (qn *QuicWire) handleLocalPacket(packet []byte) {
+	if qn.qc.nodeInterface.localPackets != localPacketsLoopback {
+		qn.logger.Debugf("Dropped packet of %d bytes addressed to the local tunnel address", len(packet))
+		qn.recordDrop(dropLocalAddress, "", len(packet), packet)
+		return
+	}
+	if qn.packetHandler != nil {
+		qn.packetHandler(packet)
+		return
+	}
+	if err := qn.writeTunIface(packet); err != nil && qn.tunWriteLog.allow() {
+		qn.logger.Errorf("Failed to loop back packet to the tunnel interface: %v", err)
+	}
+}
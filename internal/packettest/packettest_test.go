@@ -0,0 +1,141 @@
+package packettest
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestSourceSendsInOrder(t *testing.T) {
+	sink := NewSink()
+	source := NewSource(func(packet []byte) error {
+		sink.Handle(packet)
+		return nil
+	})
+	n, err := source.Send([]byte{1}, []byte{2}, []byte{3})
+	if err != nil || n != 3 {
+		t.Fatalf("Send = %d, %v", n, err)
+	}
+	packets := sink.Packets()
+	for i, packet := range packets {
+		if packet[0] != byte(i+1) {
+			t.Errorf("packet %d = %v, want [%d]", i, packet, i+1)
+		}
+	}
+	if source.Sent() != 3 || sink.Len() != 3 {
+		t.Errorf("Sent = %d, Len = %d, want 3", source.Sent(), sink.Len())
+	}
+}
+
+func TestSourceStopsAtError(t *testing.T) {
+	errFull := errors.New("full")
+	source := NewSource(func(packet []byte) error {
+		if packet[0] == 2 {
+			return errFull
+		}
+		return nil
+	})
+	n, err := source.Send([]byte{1}, []byte{2}, []byte{3})
+	if !errors.Is(err, errFull) || n != 1 {
+		t.Errorf("Send = %d, %v, want 1, %v", n, err, errFull)
+	}
+	if source.Sent() != 1 {
+		t.Errorf("Sent = %d, want 1", source.Sent())
+	}
+}
+
+func TestSinkCopiesPackets(t *testing.T) {
+	sink := NewSink()
+	packet := []byte{1, 2}
+	sink.Handle(packet)
+	packet[0] = 9
+	if got := sink.Packets()[0][0]; got != 1 {
+		t.Errorf("captured packet changed with the handled buffer: %d", got)
+	}
+	sink.Reset()
+	if sink.Len() != 0 {
+		t.Errorf("Len after Reset = %d", sink.Len())
+	}
+}
+
+func TestSinkWait(t *testing.T) {
+	sink := NewSink()
+	go func() {
+		for i := 0; i < 3; i++ {
+			sink.Handle([]byte{byte(i)})
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	packets, err := sink.Wait(ctx, 2)
+	if err != nil || len(packets) != 2 {
+		t.Fatalf("Wait = %d packets, %v", len(packets), err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := sink.Wait(ctx, 4); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait for more packets than handled = %v, want the context error", err)
+	}
+}
+
+func TestIPv4(t *testing.T) {
+	src, dst := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+	tests := []struct {
+		name      string
+		options   []byte
+		payload   []byte
+		headerLen int
+	}{
+		{name: "no options", payload: []byte("abc"), headerLen: 20},
+		{name: "padded options", options: []byte{1, 1, 1}, payload: []byte("abc"), headerLen: 24},
+		{name: "aligned options", options: []byte{1, 1, 1, 1, 1, 1, 1, 1}, headerLen: 28},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet := IPv4(src, dst, ProtoTCP, tt.options, tt.payload)
+			if got := int(packet[0]&0x0f) * 4; got != tt.headerLen || packet[0]>>4 != 4 {
+				t.Errorf("version and header length byte = %#x, want a header of %d bytes", packet[0], tt.headerLen)
+			}
+			if got := int(packet[2])<<8 | int(packet[3]); got != len(packet) || got != tt.headerLen+len(tt.payload) {
+				t.Errorf("total length = %d, packet of %d bytes", got, len(packet))
+			}
+			if packet[9] != ProtoTCP {
+				t.Errorf("protocol = %d", packet[9])
+			}
+			gotSrc, _ := netip.AddrFromSlice(packet[12:16])
+			gotDst, _ := netip.AddrFromSlice(packet[16:20])
+			if gotSrc != src || gotDst != dst {
+				t.Errorf("addresses = %v", packet[12:20])
+			}
+			// A valid header sums to zero including its checksum
+			if sum := checksum(packet[:tt.headerLen]); sum != 0 {
+				t.Errorf("header checksum does not verify: %#x", sum)
+			}
+		})
+	}
+}
+
+func TestUDP(t *testing.T) {
+	src := netip.MustParseAddrPort("10.0.0.1:1000")
+	dst := netip.MustParseAddrPort("10.0.0.2:2000")
+	packet := UDP(src, dst, []byte("hello"))
+	if packet[9] != ProtoUDP {
+		t.Fatalf("protocol = %d", packet[9])
+	}
+	datagram := packet[ipv4HeaderLen:]
+	if got := int(datagram[0])<<8 | int(datagram[1]); got != 1000 {
+		t.Errorf("source port = %d", got)
+	}
+	if got := int(datagram[2])<<8 | int(datagram[3]); got != 2000 {
+		t.Errorf("destination port = %d", got)
+	}
+	if got := int(datagram[4])<<8 | int(datagram[5]); got != 8+len("hello") {
+		t.Errorf("UDP length = %d", got)
+	}
+	if string(datagram[8:]) != "hello" {
+		t.Errorf("payload = %q", datagram[8:])
+	}
+}
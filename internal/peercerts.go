@@ -0,0 +1,80 @@
+package quicwire
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// errUntrustedCert is returned for a peer certificate not signed by the configured CA
+var errUntrustedCert = errors.New("peer certificate is not signed by the configured CA")
+
+// setupCertificates loads the certificate of the node and the CA peer
+// certificates are verified against. Without a configured certificate the
+// node presents a self-signed one, which peers verifying a CA refuse.
+func (qn *QuicWire) setupCertificates() error {
+	ni := qn.qc.nodeInterface
+	if ni.caCertificate != "" && ni.certificate == "" {
+		return fmt.Errorf("CACertificate requires a Certificate signed by the CA and its PrivateKey")
+	}
+	if ni.certificate != "" {
+		cert, err := tls.LoadX509KeyPair(ni.certificate, ni.privateKey)
+		if err != nil {
+			return fmt.Errorf("failed to load the node certificate: %w", err)
+		}
+		qn.certificate = &cert
+	} else {
+		qn.certificate = &getTLSConfig().Certificates[0]
+	}
+	if ni.caCertificate == "" {
+		return nil
+	}
+	data, err := os.ReadFile(ni.caCertificate)
+	if err != nil {
+		return fmt.Errorf("failed to read the CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no PEM certificate found in %s", ni.caCertificate)
+	}
+	qn.caPool = pool
+	return nil
+}
+
+// verifiesPeers reports whether peer certificates are checked on dial and accept
+func (qn *QuicWire) verifiesPeers() bool {
+	return qn.caPool != nil || qn.revocation != nil || qn.qc.nodeInterface.checkCertValidity
+}
+
+// verifyChain verifies the peer certificate up to one of the roots, the
+// certificates following the leaf are the intermediates. Peers are addressed
+// by IP and identified by their allowed IPs, so the name is not checked.
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, fmt.Errorf("%w: peer presented no certificate", errUntrustedCert)
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		// Node certificates serve both ends of the connections
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUntrustedCert, err)
+	}
+	return chains, nil
+}
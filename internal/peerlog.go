@@ -0,0 +1,129 @@
+package quicwire
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// peerLevels holds the log level overrides of the peers by their first allowed IP
+type peerLevels struct {
+	mu     sync.RWMutex
+	levels map[string]zapcore.Level
+}
+
+func newPeerLevels() *peerLevels {
+	return &peerLevels{levels: make(map[string]zapcore.Level)}
+}
+
+// get returns the log level override of the peer
+func (pl *peerLevels) get(key string) (zapcore.Level, bool) {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+	level, ok := pl.levels[key]
+	return level, ok
+}
+
+// set overrides the log level of the peer, an empty level removes the override
+func (pl *peerLevels) set(key string, level string) error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if level == "" {
+		delete(pl.levels, key)
+		return nil
+	}
+	l, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	pl.levels[key] = l
+	return nil
+}
+
+// snapshot returns the overrides by peer
+func (pl *peerLevels) snapshot() map[string]string {
+	pl.mu.RLock()
+	defer pl.mu.RUnlock()
+	out := make(map[string]string, len(pl.levels))
+	for key, level := range pl.levels {
+		out[key] = level.String()
+	}
+	return out
+}
+
+// peerCore logs the entries of a peer at the level overriding the level of
+// the node, e.g. the debug lines of a single peer on a node logging at info.
+// Write goes to the wrapped core directly, which does not check the level again.
+type peerCore struct {
+	zapcore.Core
+	levels *peerLevels
+	peer   string
+}
+
+func (c *peerCore) Enabled(level zapcore.Level) bool {
+	if override, ok := c.levels.get(c.peer); ok {
+		return level >= override
+	}
+	return c.Core.Enabled(level)
+}
+
+func (c *peerCore) With(fields []zapcore.Field) zapcore.Core {
+	return &peerCore{Core: c.Core.With(fields), levels: c.levels, peer: c.peer}
+}
+
+func (c *peerCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+	return ce
+}
+
+// peerLogger returns the logger of the connection and forwarding events of
+// the peer, it logs at the level set for the peer
+func (qn *QuicWire) peerLogger(key string) *zap.SugaredLogger {
+	return qn.logger.Desugar().WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &peerCore{Core: core, levels: qn.peerLevels, peer: key}
+	})).Sugar().With("peer", key)
+}
+
+// SetPeerLogLevel overrides the log level of the peer owning the allowed IP
+// until the peer is removed or its LogLevel changes on reload, an empty level
+// logs the peer at the level of the node again
+func (qn *QuicWire) SetPeerLogLevel(allowedIP string, level string) error {
+	peer, ok := qn.peerByAllowedIP(allowedIP)
+	if !ok {
+		return fmt.Errorf("no peer with allowed IP %s", allowedIP)
+	}
+	if err := qn.peerLevels.set(peer.allowedIPs[0], level); err != nil {
+		return err
+	}
+	qn.logger.Infof("Set the log level of peer %s [ %s ] to %q", peer.endpoint, peer.allowedIPs[0], level)
+	return nil
+}
+
+// servePeerLogLevels returns the log level overrides of the peers, POST
+// requests set the override of the peer query parameter to the level query
+// parameter, an empty level removes it
+func (qn *QuicWire) servePeerLogLevels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		peer := r.URL.Query().Get("peer")
+		if peer == "" {
+			http.Error(w, "peer query parameter is required", http.StatusBadRequest)
+			return
+		}
+		if err := qn.SetPeerLogLevel(peer, r.URL.Query().Get("level")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "log levels require GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, qn.peerLevels.snapshot())
+}
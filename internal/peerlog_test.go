@@ -0,0 +1,82 @@
+package quicwire
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// peerDebugLines returns the number of debug lines logged for the peer
+func peerDebugLines(logs *observer.ObservedLogs, key string) int {
+	return logs.Filter(func(e observer.LoggedEntry) bool {
+		return e.Level == zapcore.DebugLevel && e.ContextMap()["peer"] == key
+	}).Len()
+}
+
+func TestPeerLogLevel(t *testing.T) {
+	mesh := newMemNetwork()
+	inMemory := func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", inMemory)
+	c := startTestNode(t, "127.0.0.3", "10.0.0.3", inMemory)
+	core, logs := observer.New(zapcore.InfoLevel)
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		inMemory(qn)
+		qn.logger = zap.New(core).Sugar()
+	})
+
+	// B logs at debug, C at the info level of the node
+	debugPeer := newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2")
+	debugPeer.logLevel = "debug"
+	if err := a.AddPeer(debugPeer); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AddPeer(newTestPeer(c.udpConn.LocalAddr().String(), "10.0.0.3")); err != nil {
+		t.Fatal(err)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	waitPeerState(t, a.QuicWire, "10.0.0.3/32", PeerConnected)
+
+	if peerDebugLines(logs, "10.0.0.2/32") == 0 {
+		t.Error("no debug lines logged for the peer at debug")
+	}
+	if n := peerDebugLines(logs, "10.0.0.3/32"); n != 0 {
+		t.Errorf("%d debug lines logged for the peer at info", n)
+	}
+	if logs.FilterField(zap.String("peer", "10.0.0.3/32")).Len() == 0 {
+		t.Error("no info lines logged for the peer at info")
+	}
+	if logs.Filter(func(e observer.LoggedEntry) bool {
+		_, ok := e.ContextMap()["peer"]
+		return e.Level == zapcore.DebugLevel && !ok
+	}).Len() != 0 {
+		t.Error("debug lines of the node logged")
+	}
+
+	// Raising C to debug through the control API logs its next dial
+	srv := httptest.NewServer(a.controlHandler())
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/loglevels?peer=10.0.0.3%2F32&level=debug", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("setting the level returned %s", resp.Status)
+	}
+	a.mu.RLock()
+	conn := a.clients["10.0.0.3/32"].connection
+	a.mu.RUnlock()
+	conn.CloseWithError(0, "redial")
+	deadline := time.Now().Add(5 * time.Second)
+	for peerDebugLines(logs, "10.0.0.3/32") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no debug lines logged for the peer raised to debug")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
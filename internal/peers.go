@@ -0,0 +1,504 @@
+package quicwire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NewPeer creates a peer reachable at endpoint (host:port) serving the given allowed IPs
+func NewPeer(endpoint string, allowedIPs ...string) Peer {
+	return Peer{
+		endpoint:   endpoint,
+		allowedIPs: allowedIPs,
+	}
+}
+
+// PeerResult is the outcome of adding one peer with AddPeers
+type PeerResult struct {
+	AllowedIP string `json:"allowedIP"`
+	Endpoint  string `json:"endpoint"`
+	Err       error  `json:"-"`
+}
+
+// AddPeer adds a peer to the running mesh, routes its allowed IPs and dials it
+func (qn *QuicWire) AddPeer(peer Peer) error {
+	return qn.addPeer(peer, 0)
+}
+
+// AddPeers adds many peers at once. The dials start after a random stagger
+// and at most the configured dial concurrency handshakes run at a time. It
+// returns the result of every peer and an error joining the failures.
+func (qn *QuicWire) AddPeers(peers []Peer) ([]PeerResult, error) {
+	results := make([]PeerResult, 0, len(peers))
+	var errs []error
+	for _, peer := range peers {
+		res := PeerResult{Endpoint: peer.endpoint}
+		if len(peer.allowedIPs) > 0 {
+			res.AllowedIP = strings.TrimSpace(peer.allowedIPs[0])
+		}
+		res.Err = qn.addPeer(peer, dialStagger)
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %w", peer.endpoint, res.Err))
+		}
+		results = append(results, res)
+	}
+	return results, errors.Join(errs...)
+}
+
+// addPeer adds the peer, its first dial is delayed by a random duration up to stagger
+func (qn *QuicWire) addPeer(peer Peer, stagger time.Duration) error {
+	if peer.endpoint == "" || len(peer.allowedIPs) == 0 {
+		return fmt.Errorf("peer needs an endpoint and at least one allowed IP")
+	}
+	key := strings.TrimSpace(peer.allowedIPs[0])
+	if _, ok := qn.peerByAllowedIP(key); ok {
+		return fmt.Errorf("peer with allowed IP %s already exists", key)
+	}
+	if err := qn.routes.addPeer(peer); err != nil {
+		return err
+	}
+	if qn.localIf != nil {
+		if err := qn.installPeerRoutes(qn.localIf.Name(), peer); err != nil {
+			qn.routes.removePeer(peer.allowedIPs[0])
+			return err
+		}
+	}
+
+	if err := qn.peerLevels.set(key, peer.logLevel); err != nil {
+		qn.routes.removePeer(peer.allowedIPs[0])
+		return err
+	}
+
+	qn.mu.Lock()
+	qn.qc.peers = append(qn.qc.peers, peer)
+	qn.mu.Unlock()
+
+	if !qn.disableClient && qn.udpConn != nil {
+		qn.startPeer(peer, 0, stagger)
+	}
+	qn.logger.Infof("Added peer %s [ %s ]", peer.endpoint, peer.allowedIPs[0])
+	return nil
+}
+
+// RemovePeer removes the peer owning the allowed IP, stopping its dial
+// attempts, closing its connection and removing its routes
+func (qn *QuicWire) RemovePeer(allowedIP string) error {
+	peer, ok := qn.peerByAllowedIP(allowedIP)
+	if !ok {
+		return fmt.Errorf("no peer with allowed IP %s", allowedIP)
+	}
+	key := peer.allowedIPs[0]
+
+	qn.mu.Lock()
+	if cancel, ok := qn.peerCancels[key]; ok {
+		cancel()
+		delete(qn.peerCancels, key)
+	}
+	c := qn.clients[key]
+	delete(qn.clients, key)
+	delete(qn.pathEvents, key)
+	delete(qn.failedPeers, key)
+	delete(qn.peerStates, key)
+	if host, _, err := net.SplitHostPort(peer.endpoint); err == nil {
+		delete(qn.connections, host)
+	}
+	peers := make([]Peer, 0, len(qn.qc.peers))
+	for _, p := range qn.qc.peers {
+		if p.allowedIPs[0] != key {
+			peers = append(peers, p)
+		}
+	}
+	qn.qc.peers = peers
+	qn.mu.Unlock()
+
+	qn.routes.removePeer(key)
+	qn.peerLevels.set(key, "")
+	if qn.localIf != nil {
+		qn.removePeerRoutes(qn.localIf.Name(), peer)
+	}
+	if peer.masquerade {
+		qn.removeMasquerade(peer.allowedIPs)
+	}
+	// Closing the connection ends the goroutine receiving from it
+	if c != nil && c.connection != nil {
+		c.connection.CloseWithError(0, "peer removed")
+	}
+	qn.logger.Infof("Removed peer %s [ %s ]", peer.endpoint, key)
+	return nil
+}
+
+// startPeers starts dialing the peers in order of priority, highest first.
+// Each lower priority starts a stagger interval after the one above it, so
+// critical peers get their handshakes in before the dial slots fill up.
+func (qn *QuicWire) startPeers(peers []Peer, stagger time.Duration) {
+	peers = append([]Peer(nil), peers...)
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peers[i].priority > peers[j].priority
+	})
+	var delay time.Duration
+	for i, peer := range peers {
+		if i > 0 && peer.priority != peers[i-1].priority {
+			delay += stagger
+		}
+		qn.startPeer(peer, delay, stagger)
+	}
+}
+
+// startPeer dials the peer in the background after delay and a random delay
+// up to stagger. The dial attempts stop when the peer is removed.
+func (qn *QuicWire) startPeer(peer Peer, delay, stagger time.Duration) {
+	qn.logger.Debugf("Starting client for peer %s", peer.endpoint)
+	ctx, cancel := context.WithCancel(qn.ctx)
+	qn.mu.Lock()
+	qn.peerCancels[peer.allowedIPs[0]] = cancel
+	qn.mu.Unlock()
+
+	go func() {
+		if stagger > 0 {
+			delay += time.Duration(rand.Int63n(int64(stagger)))
+		}
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+		qn.runPeer(ctx, peer)
+	}()
+}
+
+// SetDialConcurrency bounds the number of peer handshakes running at a time
+func (qn *QuicWire) SetDialConcurrency(n int) {
+	if n <= 0 {
+		n = defaultDialConcurrency
+	}
+	slots := make(chan struct{}, n)
+	qn.mu.Lock()
+	qn.dialSlots = slots
+	qn.mu.Unlock()
+}
+
+// acquireDialSlot waits for room to start a handshake, the returned function
+// releases it. The slot is released to the channel it was taken from, which
+// SetDialConcurrency may have replaced meanwhile.
+func (qn *QuicWire) acquireDialSlot(ctx context.Context) (func(), error) {
+	qn.mu.RLock()
+	slots := qn.dialSlots
+	qn.mu.RUnlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runPeer dials the peer and redials it whenever the connection is lost.
+// Every dial goes out of the shared UDP socket, so the local source port and
+// the NAT binding learned through STUN stay the same across reconnects.
+func (qn *QuicWire) runPeer(ctx context.Context, peer Peer) {
+	key := peer.allowedIPs[0]
+	logger := qn.peerLogger(key)
+	qn.mu.RLock()
+	_, ok := qn.clients[key]
+	qn.mu.RUnlock()
+	if ok {
+		logger.Infof("Client already exists for peer %s [ %s ]", peer.endpoint, key)
+		return
+	}
+
+	//split endpoint to get ip and port
+	host, _, err := net.SplitHostPort(peer.endpoint)
+	if err != nil {
+		logger.Errorf("Failed to split host and port of peer %s: %v", peer.endpoint, err)
+		return
+	}
+	if self, err := qn.isSelfEndpoint(ctx, peer.endpoint); err != nil {
+		logger.Debugf("Failed to check whether peer %s is this node: %v", peer.endpoint, err)
+	} else if self {
+		logger.Warnf("Not dialing peer %s [ %s ], its endpoint is this node's own listen address, check the peer configuration",
+			peer.endpoint, key)
+		return
+	}
+
+	var localAddr net.Addr
+	// lastGood is the address the peer was last reached at, the fallback when its endpoint fails
+	var lastGood *net.UDPAddr
+	cycles := 0
+	for {
+		// Pick up the changes Reload applied in place
+		if current, ok := qn.peerByAllowedIP(key); ok {
+			peer = current
+		}
+		qn.setPeerState(key, PeerDialing)
+		c, err := qn.connectPeer(ctx, peer, host, lastGood)
+		if err != nil {
+			cycles++
+			if cycles >= qn.maxReconnects() {
+				qn.markPeerFailed(peer, fmt.Errorf("gave up after %d dial cycles: %w", cycles, err))
+				return
+			}
+			qn.noisyLog.PeerWarnf(key, "Peer %s is not reachable, dial cycle %d: %v", peer.endpoint, cycles, err)
+			continue
+		}
+		if c == nil {
+			return
+		}
+		cycles = 0
+		if localAddr != nil && localAddr.String() != c.connection.LocalAddr().String() {
+			logger.Warnf("Source address of peer %s changed from %s to %s, the NAT binding may have changed",
+				peer.endpoint, localAddr, c.connection.LocalAddr())
+			go qn.reprobePortBinding()
+		}
+		localAddr = c.connection.LocalAddr()
+		if remote, ok := c.connection.RemoteAddr().(*net.UDPAddr); ok {
+			lastGood = remote
+		}
+		go qn.checkHealth(ctx, key, c)
+		if qn.idleTeardown() {
+			go qn.watchIdle(ctx, c)
+		}
+		if qn.congestionEnabled() {
+			go qn.watchCongestion(ctx, key, c)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.connection.Context().Done():
+		}
+
+		qn.mu.Lock()
+		if qn.clients[key] == c {
+			delete(qn.clients, key)
+		}
+		if qn.connections[host] == c.connection {
+			delete(qn.connections, host)
+		}
+		qn.mu.Unlock()
+		if ctx.Err() != nil {
+			return
+		}
+		cause := closeCause(c.connection)
+		if isIdleTimeout(cause) {
+			if qn.idleTeardown() {
+				logger.Infof("Connection to peer %s closed after being idle, redialing on the next packet", peer.endpoint)
+				qn.setPeerState(key, PeerDisconnected)
+				if !qn.waitForTraffic(ctx, key) {
+					return
+				}
+				continue
+			}
+			logger.Infof("Connection to peer %s idled out, redialing to keep the tunnel warm", peer.endpoint)
+			continue
+		}
+		qn.noisyLog.PeerWarnf(key, "Connection to peer %s lost: %v, redialing from %s", peer.endpoint, cause, localAddr)
+	}
+}
+
+// connectPeer dials the peer, or reuses the connection the peer opened to us,
+// and stores the client. Dials fall back to lastGood, if set, when the endpoint
+// fails. It returns an error when every dial retry failed and a nil client when
+// the peer was removed meanwhile.
+func (qn *QuicWire) connectPeer(ctx context.Context, peer Peer, host string, lastGood *net.UDPAddr) (*Client, error) {
+	logger := qn.peerLogger(peer.allowedIPs[0])
+	c, err := NewClient(peer.endpoint, qn.qc.nodeInterface.localNodeIP, qn.qc.nodeInterface.listenPort, qn.localIf, logger)
+	if err != nil {
+		return nil, err
+	}
+	c.SetSendWindow(peer.maxInFlight)
+	c.SetACL(peer.acl)
+	c.SetDropHandler(func(reason string, size int, packet []byte) { qn.recordDrop(reason, peer.allowedIPs[0], size, packet) })
+	c.SetControlHandler(qn.counters.countControl)
+	c.SetCertificate(qn.certificate)
+	if qn.verifiesPeers() {
+		c.SetVerifyPeerCertificate(qn.verifyPeerCertificate)
+	}
+	c.SetSessionCache(qn.sessionCache)
+	c.SetAddressFamily(peer.addressFamily)
+	c.SetLastGoodAddr(lastGood)
+	c.SetDialParallelism(peer.dialParallelism, peer.candidateTimeout)
+	c.SetTracer(qn.tracer)
+	c.SetTransport(qn.transport)
+	c.setResolver(qn.resolver)
+	c.SetIdleTimeout(qn.qc.nodeInterface.idleTimeout, !qn.idleTeardown())
+	c.SetCPUAffinity(qn.qc.nodeInterface.forwardingCPUs)
+	c.SetCoalescing(qn.qc.nodeInterface.flushInterval, qn.qc.nodeInterface.maxBatchBytes)
+	c.SetBufferBudget(qn.buffers)
+
+	dialCtx, dialSpan := qn.startSpan(ctx, "quicwire.peer.dial", peerAttrs(peer)...)
+	c.spanContext = dialSpan.SpanContext()
+
+	start := time.Now()
+	attempts := 0
+	err = RetryOperation(ctx, peerRetryInterval(peer), retries, func() (err error) {
+		attempts++
+		attemptCtx, attemptSpan := qn.startSpan(dialCtx, "quicwire.peer.dial.attempt", attribute.Int("quicwire.attempt", attempts))
+		defer func() { endSpan(attemptSpan, err) }()
+
+		qn.mu.RLock()
+		conn, ok := qn.connections[host]
+		prev := qn.clients[peer.allowedIPs[0]]
+		if prev == nil {
+			// The node dialed before it was added as a peer
+			prev = qn.strangers[host]
+		}
+		qn.mu.RUnlock()
+		if ok && prev != nil && prev.connection == conn {
+			// Reuse the client the server registered for the connection, it
+			// receives the acks and probes sent over it
+			logger.Infof("Connection already exists for peer endpoint %s", peer.endpoint)
+			c = prev
+			return nil
+		}
+		logger.Debugf("No existing connection to the peer endpoint %s.", peer.endpoint)
+
+		release, err := qn.acquireDialSlot(ctx)
+		if err != nil {
+			return err
+		}
+		_, handshakeSpan := qn.startSpan(attemptCtx, "quicwire.peer.handshake")
+		err = c.DialContext(ctx, qn.udpConn)
+		endSpan(handshakeSpan, err)
+		release()
+		if err != nil {
+			logger.Debugf("Failed to dial: %v", err)
+			qn.noisyLog.PeerWarnf(peer.allowedIPs[0], "Retrying to dial %s", peer.endpoint)
+			return err
+		}
+		logger.Infof("Dialed new connection to peer endpoint %s.", peer.endpoint)
+		qn.setPeerState(peer.allowedIPs[0], PeerHandshaking)
+		c.outbound = true
+		qn.markPeerDSCP(c.connection.RemoteAddr(), peer)
+		remote, ok := qn.runHandshake(ctx, c, true)
+		qn.setPeerState(peer.allowedIPs[0], PeerAuthenticating)
+		if err := qn.verifyNetworkID(c, remote, ok); err != nil {
+			return err
+		}
+		if err := qn.verifyToken(c, remote, ok); err != nil {
+			return err
+		}
+		// Packets of the peer are only handled once it passed the hello checks
+		c.AttachHandler(func(c packetContext) error {
+			msg := c.Data
+			logger.Debugf("Client [ %s ] sent a message [ %v ] over server initiated connection", c.RemoteAddr().String(), msg)
+			qn.deliver(c)
+			return nil
+		})
+		if ok {
+			if !qn.resolveSimultaneousOpen(c, remote, peer.allowedIPs[0]) {
+				return errDuplicateConnection
+			}
+			go qn.reconcileLoop(ctx, c)
+		}
+		return nil
+	})
+	dialSpan.SetAttributes(attribute.Int("quicwire.retries", attempts-1))
+	endSpan(dialSpan, err)
+	if err != nil && ctx.Err() == nil {
+		return nil, err
+	}
+	qn.mu.Lock()
+	defer qn.mu.Unlock()
+	// RemovePeer cancels under the lock, so a removed peer is never stored
+	if ctx.Err() != nil {
+		if c.connection != nil {
+			c.connection.CloseWithError(0, "peer removed")
+		}
+		return nil, nil
+	}
+	// The connection may have lost a simultaneous open resolved by the
+	// server side since it was checked, keep the client that survived
+	if cur, ok := qn.clients[peer.allowedIPs[0]]; ok && cur != c && qn.keptLocked(cur) && !qn.keptLocked(c) {
+		c = cur
+	}
+	c.setDialStats(time.Since(start), attempts-1)
+	dialStats := c.DialStats()
+	logger.Infow("Peer connection established",
+		"peer", peer.allowedIPs[0],
+		"endpoint", peer.endpoint,
+		"localAddr", c.connection.LocalAddr().String(),
+		"dialDuration", dialStats.Duration,
+		"dialRetries", dialStats.Retries,
+	)
+	qn.clients[peer.allowedIPs[0]] = c
+	qn.setPeerStateLocked(peer.allowedIPs[0], PeerConnected)
+	return c, nil
+}
+
+// peerRetryInterval returns the wait between dial retries, peers with a
+// priority above the default are retried more aggressively
+func peerRetryInterval(peer Peer) time.Duration {
+	if peer.priority > 0 {
+		return priorityRetryInterval
+	}
+	return retryInterval
+}
+
+// maxReconnects returns the number of failed dial cycles after which a peer is marked failed
+func (qn *QuicWire) maxReconnects() int {
+	if qn.qc.nodeInterface.maxReconnects > 0 {
+		return qn.qc.nodeInterface.maxReconnects
+	}
+	return defaultMaxReconnects
+}
+
+// markPeerFailed stops dialing the peer until ReconnectPeer is called
+func (qn *QuicWire) markPeerFailed(peer Peer, err error) {
+	key := peer.allowedIPs[0]
+	qn.mu.Lock()
+	if _, ok := qn.peerCancels[key]; !ok {
+		// The peer was removed meanwhile
+		qn.mu.Unlock()
+		return
+	}
+	qn.failedPeers[key] = err
+	qn.setPeerStateLocked(key, PeerFailed)
+	qn.mu.Unlock()
+	qn.logger.Errorf("Peer %s [ %s ] marked as failed, no more dials until it is reconnected: %v", peer.endpoint, key, err)
+	qn.emit(Event{Type: EventPeerFailed, Peer: key, Remote: peer.endpoint})
+}
+
+// ReconnectPeer dials the peer owning the allowed IP again, clearing a failed
+// state. An established connection to the peer is closed first.
+func (qn *QuicWire) ReconnectPeer(allowedIP string) error {
+	peer, ok := qn.peerByAllowedIP(allowedIP)
+	if !ok {
+		return fmt.Errorf("no peer with allowed IP %s", allowedIP)
+	}
+	if peer.learned {
+		return fmt.Errorf("peer %s was learned from its announcements and is not dialed", allowedIP)
+	}
+	if qn.disableClient || qn.udpConn == nil {
+		return fmt.Errorf("client function is not running")
+	}
+	key := peer.allowedIPs[0]
+
+	qn.mu.Lock()
+	if cancel, ok := qn.peerCancels[key]; ok {
+		cancel()
+	}
+	c := qn.clients[key]
+	delete(qn.clients, key)
+	delete(qn.failedPeers, key)
+	if host, _, err := net.SplitHostPort(peer.endpoint); err == nil && c != nil && qn.connections[host] == c.connection {
+		delete(qn.connections, host)
+	}
+	qn.mu.Unlock()
+
+	if c != nil && c.connection != nil {
+		c.connection.CloseWithError(0, "reconnecting")
+	}
+	qn.startPeer(peer, 0, 0)
+	qn.logger.Infof("Reconnecting peer %s [ %s ]", peer.endpoint, key)
+	return nil
+}
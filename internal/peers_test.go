@@ -0,0 +1,217 @@
+package quicwire
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRemovePeerStopsGoroutines(t *testing.T) {
+	mesh := newMemNetwork()
+	inMemory := func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", inMemory)
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", inMemory)
+	baseline := runtime.NumGoroutine()
+
+	// One peer gets connected, the others are never answered and keep being redialed
+	if err := a.AddPeer(newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	const unreachable = 50
+	for i := 0; i < unreachable; i++ {
+		peer := newTestPeer(fmt.Sprintf("127.0.1.%d:51820", i+1), fmt.Sprintf("10.0.1.%d", i+1))
+		if err := a.AddPeer(peer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	if n := runtime.NumGoroutine(); n <= baseline+unreachable {
+		t.Fatalf("%d goroutines with %d peers running, want more than %d", n, unreachable+1, baseline+unreachable)
+	}
+
+	if err := a.RemovePeer("10.0.0.2/32"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < unreachable; i++ {
+		if err := a.RemovePeer(fmt.Sprintf("10.0.1.%d/32", i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines after removing every peer, want %d\n%s",
+				runtime.NumGoroutine(), baseline, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedialKeepsSourcePort(t *testing.T) {
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", nil)
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", nil, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	client := func() *Client {
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		return a.clients["10.0.0.2/32"]
+	}
+	first := client()
+	want := a.udpConn.LocalAddr().String()
+	if got := first.connection.LocalAddr().String(); got != want {
+		t.Fatalf("dialed from %s, want the shared socket %s", got, want)
+	}
+
+	first.connection.CloseWithError(0, "forced reconnect")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if c := client(); c != nil && c != first {
+			if got := c.connection.LocalAddr().String(); got != want {
+				t.Errorf("redialed from %s, want %s", got, want)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("peer not redialed after its connection was closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAddPeersBoundsConcurrency(t *testing.T) {
+	const peers, concurrency = 20, 3
+	transport := &scriptedTransport{Transport: newMemNetwork().transport(), script: make(map[string]dialBehavior)}
+	var imported []Peer
+	for i := 0; i < peers; i++ {
+		endpoint := fmt.Sprintf("127.0.1.%d:51820", i+1)
+		transport.script[endpoint] = dialBehavior{delay: 50 * time.Millisecond, err: errors.New("connection refused")}
+		imported = append(imported, newTestPeer(endpoint, fmt.Sprintf("10.0.1.%d", i+1)))
+	}
+	// A duplicate and an invalid peer fail without stopping the others
+	imported = append(imported, newTestPeer("127.0.2.1:51820", "10.0.1.1"), NewPeer("127.0.2.2:51820"))
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(transport)
+		qn.SetDialConcurrency(concurrency)
+	})
+
+	results, err := a.AddPeers(imported)
+	if err == nil {
+		t.Error("AddPeers of a duplicate and an invalid peer returned no error")
+	}
+	if len(results) != len(imported) {
+		t.Fatalf("got %d results, want %d", len(results), len(imported))
+	}
+	for i, res := range results {
+		if failed := res.Err != nil; failed != (i >= peers) {
+			t.Errorf("result %d of %s: err = %v", i, res.Endpoint, res.Err)
+		}
+		if res.Endpoint != imported[i].endpoint {
+			t.Errorf("result %d endpoint = %s, want %s", i, res.Endpoint, imported[i].endpoint)
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(transport.dials()) < peers {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d peers dialed, want %d", len(transport.dials()), peers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	transport.mu.Lock()
+	maxActive := transport.maxActive
+	transport.mu.Unlock()
+	if maxActive > concurrency {
+		t.Errorf("%d dials ran at the same time, want at most %d", maxActive, concurrency)
+	}
+}
+
+func TestPeerFailedAfterMaxReconnects(t *testing.T) {
+	const endpoint, cycles = "127.0.1.1:51820", 3
+	// A fatal error ends a dial cycle without retries
+	transport := &scriptedTransport{Transport: newMemNetwork().transport(), script: map[string]dialBehavior{
+		endpoint: {err: &net.AddrError{Err: "unreachable", Addr: endpoint}},
+	}}
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(transport)
+		qn.qc.nodeInterface.maxReconnects = cycles
+	})
+	if err := a.AddPeer(newTestPeer(endpoint, "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerFailed)
+	if got := len(transport.dials()); got != cycles {
+		t.Errorf("dialed %d times, want one dial per cycle, %d", got, cycles)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := len(transport.dials()); got != cycles {
+		t.Errorf("dialed %d times after the peer failed, want no more dials", got)
+	}
+
+	// Reconnecting clears the failed state and starts dialing again
+	if err := a.ReconnectPeer("10.0.0.2/32"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(transport.dials()) < 2*cycles {
+		if time.Now().After(deadline) {
+			t.Fatalf("dialed %d times after reconnecting, want %d", len(transport.dials()), 2*cycles)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerFailed)
+}
+
+func TestDialedPeerStateTransitions(t *testing.T) {
+	mesh := newMemNetwork()
+	inMemory := func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+		inMemory(qn)
+		qn.disableClient = true
+	})
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", inMemory)
+	if err := a.AddPeer(newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []PeerState
+	for len(got) == 0 || got[len(got)-1] != PeerConnected {
+		e := waitEvent(t, a.QuicWire, func(e Event) bool { return e.Type == EventPeerState && e.Peer == "10.0.0.2/32" })
+		got = append(got, e.State)
+	}
+	want := []PeerState{PeerDialing, PeerHandshaking, PeerAuthenticating, PeerConnected}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+}
+
+func TestStartPeersDialsByPriority(t *testing.T) {
+	endpoints := []string{"127.0.1.1:51820", "127.0.1.2:51820", "127.0.1.3:51820"}
+	script := make(map[string]dialBehavior, len(endpoints))
+	for _, endpoint := range endpoints {
+		script[endpoint] = dialBehavior{hang: true}
+	}
+	transport := &scriptedTransport{Transport: newMemNetwork().transport(), script: script}
+	peers := []Peer{
+		{endpoint: endpoints[0], allowedIPs: []string{"10.0.1.1/32"}},
+		{endpoint: endpoints[1], allowedIPs: []string{"10.0.1.2/32"}, priority: 2},
+		{endpoint: endpoints[2], allowedIPs: []string{"10.0.1.3/32"}, priority: 1},
+	}
+	startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(transport) }, peers...)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(transport.dials()) < len(peers) {
+		if time.Now().After(deadline) {
+			t.Fatalf("dialed %v, want every peer dialed", transport.dials())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []string{endpoints[1], endpoints[2], endpoints[0]}
+	if got := transport.dials(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("dialed %v, want the highest priority first %v", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package quicwire
+
+import (
+	"context"
+	"time"
+)
+
+// PeerState is the lifecycle state of a peer reported by the status API
+type PeerState string
+
+const (
+	// PeerDisconnected is a peer without a connection that is not being dialed
+	PeerDisconnected PeerState = "disconnected"
+	// PeerDialing is a peer whose QUIC connection is being dialed
+	PeerDialing PeerState = "dialing"
+	// PeerHandshaking is a peer exchanging hellos on a new connection
+	PeerHandshaking PeerState = "handshaking"
+	// PeerAuthenticating is a peer whose network ID and connection token are being checked
+	PeerAuthenticating PeerState = "authenticating"
+	// PeerConnected is a peer traffic is forwarded to
+	PeerConnected PeerState = "connected"
+	// PeerDegraded is a connected peer leaving health probes unanswered, the
+	// path may carry traffic in one direction only or lose most of it
+	PeerDegraded PeerState = "degraded"
+	// PeerDraining is a connected peer going away because either end is shutting down
+	PeerDraining PeerState = "draining"
+	// PeerFailed is a peer the node gave up dialing
+	PeerFailed PeerState = "failed"
+)
+
+const (
+	// healthCheckInterval is how often connected peers are probed
+	healthCheckInterval = 10 * time.Second
+	// healthCheckTimeout bounds the wait for the reply to a health probe
+	healthCheckTimeout = 3 * time.Second
+	// degradedAfter is the number of health probes in a row left unanswered
+	// before a peer is reported degraded
+	degradedAfter = 2
+)
+
+// setPeerState records the lifecycle state of the peer and reports changes as events
+func (qn *QuicWire) setPeerState(key string, state PeerState) {
+	qn.mu.Lock()
+	qn.setPeerStateLocked(key, state)
+	qn.mu.Unlock()
+}
+
+// setPeerStateLocked is setPeerState for callers holding mu
+func (qn *QuicWire) setPeerStateLocked(key string, state PeerState) {
+	if qn.peerStates[key] == state {
+		return
+	}
+	qn.peerStates[key] = state
+	qn.logger.Debugf("Peer %s is %s", key, state)
+	qn.emit(Event{Type: EventPeerState, Peer: key, State: state})
+}
+
+// peerLiveLocked reports whether the peer is routed over a live connection.
+// It must be called with mu held.
+func (qn *QuicWire) peerLiveLocked(key string) bool {
+	c, ok := qn.clients[key]
+	return ok && c.connection != nil && c.connection.Context().Err() == nil
+}
+
+// peerStateLocked returns the state reported for the peer, connected tells
+// whether the client of the peer has a live connection. It must be called
+// with mu held.
+func (qn *QuicWire) peerStateLocked(key string, c *Client, connected bool) PeerState {
+	if _, ok := qn.failedPeers[key]; ok {
+		return PeerFailed
+	}
+	state, ok := qn.peerStates[key]
+	if !ok {
+		return PeerDisconnected
+	}
+	switch state {
+	case PeerConnected, PeerDegraded, PeerDraining:
+		if !connected {
+			return PeerDisconnected
+		}
+		if state != PeerDraining && c.draining.Load() {
+			return PeerDraining
+		}
+	}
+	return state
+}
+
+// checkHealth probes the connected peer every healthCheckInterval until the
+// connection closes, reporting it degraded while the probes go unanswered
+func (qn *QuicWire) checkHealth(ctx context.Context, key string, c *Client) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.connection.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		_, err := c.probe(probeCtx, frameHeaderLen)
+		cancel()
+
+		qn.mu.Lock()
+		state := qn.peerStates[key]
+		if err != nil {
+			failures++
+			if failures >= degradedAfter && state == PeerConnected {
+				qn.logger.Warnf("Peer %s left %d health probes unanswered, reporting it degraded", key, failures)
+				qn.setPeerStateLocked(key, PeerDegraded)
+			}
+		} else {
+			failures = 0
+			if state == PeerDegraded {
+				qn.logger.Infof("Peer %s answers health probes again", key)
+				qn.setPeerStateLocked(key, PeerConnected)
+			}
+		}
+		qn.mu.Unlock()
+	}
+}
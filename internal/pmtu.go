@@ -0,0 +1,100 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+const (
+	// pmtuMinDatagram is the smallest datagram probed, paths not carrying it keep the default MTU
+	pmtuMinDatagram = 256
+	// pmtuPrecision ends the search once the bounds are this close
+	pmtuPrecision = 16
+	// pmtuProbeTimeout bounds the wait for the reply to each discovery probe
+	pmtuProbeTimeout = time.Second
+	// pmtuProbeAttempts is how often a size is probed before it is considered too large
+	pmtuProbeAttempts = 2
+)
+
+// searchMTU returns the largest size between lo and hi for which fits
+// reports true, within pmtuPrecision. It returns 0 when not even lo fits.
+func searchMTU(lo, hi int, fits func(size int) bool) int {
+	if fits(hi) {
+		return hi
+	}
+	if !fits(lo) {
+		return 0
+	}
+	for hi-lo > pmtuPrecision {
+		mid := (lo + hi) / 2
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// discoverSendMTU probes the largest datagram reaching the peer. The probes
+// ask for a short reply, so the reverse direction does not limit the result.
+// Fragments to the peer are clamped to the discovered size, paths too small
+// for that clamp the packets instead and the peer is told about it, as it is
+// the receive direction MTU of the peer.
+func (qn *QuicWire) discoverSendMTU(c *Client) {
+	ctx := c.connection.Context()
+	datagram := searchMTU(pmtuMinDatagram, maxDatagramSize, func(size int) bool {
+		for i := 0; i < pmtuProbeAttempts; i++ {
+			probeCtx, cancel := context.WithTimeout(ctx, pmtuProbeTimeout)
+			_, err := c.probeFlags(probeCtx, size, frameFlagShortReply)
+			cancel()
+			if err == nil {
+				return true
+			}
+			if ctx.Err() != nil {
+				return false
+			}
+		}
+		return false
+	})
+	if datagram == 0 {
+		if ctx.Err() == nil {
+			c.logger.Debugf("Peer %s did not answer any path MTU probe, keeping the MTU", c.addr)
+		}
+		return
+	}
+	if datagram < maxDatagramSize && datagram-frameHeaderLen-fragmentHeaderLen >= minFragmentPayload {
+		// Larger packets are fragmented into datagrams the path carries
+		c.maxDatagram.Store(int32(datagram))
+		qn.logger.Infof("Path to peer %s carries datagrams of up to %d bytes, fragmenting larger packets", c.addr, datagram)
+	} else if datagram < maxDatagramSize {
+		// The largest packets would need more fragments than a reassembly tracks
+		mtu := datagram - frameHeaderLen
+		if cur := int(c.pathMTU.Load()); cur == 0 || mtu < cur {
+			c.pathMTU.Store(int32(mtu))
+		}
+		qn.logger.Infof("Path to peer %s carries datagrams of up to %d bytes, sending packets of up to %d bytes",
+			c.addr, datagram, qn.effectiveMTU(c))
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(qn.effectiveMTU(c)))
+	if err := c.sendControl(encodeFrame(framePathMTU, 0, 0, payload)); err != nil {
+		c.logger.Debugf("Failed to report the path MTU to %s: %v", c.addr, err)
+	}
+}
+
+// setRecvMTU records the largest packet the peer found to reach this node
+func (c *Client) setRecvMTU(mtu int) {
+	if mtu > 0 {
+		c.recvMTU.Store(int32(mtu))
+	}
+}
+
+// receiveMTU returns the largest packet the peer sends to the node, it never
+// exceeds what the tunnel interface accepts
+func (qn *QuicWire) receiveMTU(c *Client) int {
+	if mtu := int(c.recvMTU.Load()); mtu > 0 && mtu < qn.maxPacket() {
+		return mtu
+	}
+	return qn.maxPacket()
+}
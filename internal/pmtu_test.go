@@ -0,0 +1,139 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// narrowPathTransport is a Transport whose connections reject the datagrams
+// larger than maxDatagram they send, like quic-go does for its path MTU
+type narrowPathTransport struct {
+	Transport
+	maxDatagram int
+}
+
+func (t narrowPathTransport) Listen(conn net.PacketConn, tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error) {
+	l, err := t.Transport.Listen(conn, tlsConf, conf)
+	if err != nil {
+		return nil, err
+	}
+	return narrowPathListener{Listener: l, maxDatagram: t.maxDatagram}, nil
+}
+
+func (t narrowPathTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	c, err := t.Transport.Dial(ctx, conn, addr, host, tlsConf, conf)
+	if err != nil {
+		return nil, err
+	}
+	return narrowPathConn{Connection: c, maxDatagram: t.maxDatagram}, nil
+}
+
+type narrowPathListener struct {
+	quic.Listener
+	maxDatagram int
+}
+
+func (l narrowPathListener) Accept(ctx context.Context) (quic.Connection, error) {
+	c, err := l.Listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return narrowPathConn{Connection: c, maxDatagram: l.maxDatagram}, nil
+}
+
+type narrowPathConn struct {
+	quic.Connection
+	maxDatagram int
+}
+
+func (c narrowPathConn) SendMessage(b []byte) error {
+	if len(b) > c.maxDatagram {
+		return fmt.Errorf("datagram of %d bytes exceeds the path MTU of %d", len(b), c.maxDatagram)
+	}
+	return c.Connection.SendMessage(b)
+}
+
+// peerStatusOf returns the status of the peer owning allowedIP
+func peerStatusOf(t *testing.T, qn *QuicWire, allowedIP string) PeerStatus {
+	t.Helper()
+	for _, ps := range qn.Status() {
+		if ps.AllowedIPs[0] == allowedIP {
+			return ps
+		}
+	}
+	t.Fatalf("no status of peer %s", allowedIP)
+	return PeerStatus{}
+}
+
+func TestAsymmetricPathMTU(t *testing.T) {
+	tests := []struct {
+		name string
+		// up and down are the largest datagrams from A to B and from B to A
+		up, down int
+		// fragmented is whether the narrow directions fragment packets
+		// instead of clamping them
+		fragmented bool
+	}{
+		{name: "fragmenting", up: 800, down: 1100, fragmented: true},
+		{name: "clamping", up: 280, down: maxDatagramSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mesh := newMemNetwork()
+			b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+				qn.SetTransport(narrowPathTransport{Transport: mesh.transport(), maxDatagram: tt.down})
+				qn.disableClient = true
+			}, newTestPeer("127.0.0.1:51820", "10.0.0.1"))
+			a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+				qn.SetTransport(narrowPathTransport{Transport: mesh.transport(), maxDatagram: tt.up})
+			}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+			waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+			waitPeerState(t, b.QuicWire, "10.0.0.1/32", PeerConnected)
+
+			// converged reports whether discovery found the direction to the peer within its precision
+			converged := func(qn *QuicWire, key string, limit int) bool {
+				qn.mu.RLock()
+				c := qn.clients[key]
+				qn.mu.RUnlock()
+				// The other end reports its direction once it is done
+				if c == nil || c.recvMTU.Load() == 0 {
+					return false
+				}
+				if limit >= maxDatagramSize {
+					return true
+				}
+				got := int(c.maxDatagram.Load())
+				if !tt.fragmented {
+					got = int(c.pathMTU.Load()) + frameHeaderLen
+				}
+				return got <= limit && got > limit-pmtuPrecision
+			}
+			deadline := time.Now().Add(10 * time.Second)
+			for !converged(a.QuicWire, "10.0.0.2/32", tt.up) || !converged(b.QuicWire, "10.0.0.1/32", tt.down) {
+				if time.Now().After(deadline) {
+					t.Fatal("path MTU discovery did not converge in both directions")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// Each end receives what the other found to reach it
+			sa, sb := peerStatusOf(t, a.QuicWire, "10.0.0.2/32"), peerStatusOf(t, b.QuicWire, "10.0.0.1/32")
+			if sa.SendMTU != sb.RecvMTU || sb.SendMTU != sa.RecvMTU {
+				t.Errorf("A sends %d and receives %d, B sends %d and receives %d, want the directions to match",
+					sa.SendMTU, sa.RecvMTU, sb.SendMTU, sb.RecvMTU)
+			}
+			if !tt.fragmented {
+				if sa.SendMTU > tt.up-frameHeaderLen || sb.SendMTU != a.maxPacket() {
+					t.Errorf("send MTUs = %d and %d, want A clamped below %d and B unclamped",
+						sa.SendMTU, sb.SendMTU, tt.up)
+				}
+			}
+		})
+	}
+}
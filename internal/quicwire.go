@@ -2,28 +2,54 @@ package quicwire
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
+	"net/netip"
 	"os/exec"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/libp2p/go-reuseport"
 	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
 	"github.com/songgao/water"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 const (
 	retryInterval = 5 * time.Second
 	retries       = 10
-	tunDevMTU     = 1190
+	// priorityRetryInterval is the retry interval of peers with a priority above the default
+	priorityRetryInterval = 2 * time.Second
+	// defaultMaxReconnects is the number of failed dial cycles after which a peer is marked failed
+	defaultMaxReconnects = 3
+	// tunDevMTU is the default tunnel MTU, it fits in a single datagram with the frame header
+	tunDevMTU = 1190
+	// maxTunMTU is the largest configurable tunnel MTU, larger packets are fragmented over datagrams
+	maxTunMTU = 9000
+	// defaultDialConcurrency is the default number of peer handshakes running at a time
+	defaultDialConcurrency = 16
+	// dialStagger is the largest random delay before the first dial of a peer started in bulk
+	dialStagger = 500 * time.Millisecond
 )
 
 type packetContext struct {
 	localIf *water.Interface
 	quic.Connection
 	Data []byte
+	// mirrored is set for copies of traffic sent to this node as the monitoring peer
+	mirrored bool
+	// relayed is set for packets arriving in relay frames, hops is the hop
+	// count they have left
+	relayed bool
+	hops    int
 }
 
 // QuicWire struct holds state need to enable connectivity to peers
@@ -31,20 +57,105 @@ type QuicWire struct {
 	qc         *QuicConf
 	logger     *zap.SugaredLogger
 	configFile string
+	// configLayers are config files layered over configFile, configConflicts
+	// tells how disagreeing sources are handled
+	configLayers    []string
+	configConflicts string
+	// configLimits bound the size of the config sources and the time spent reading them
+	configLimits confLimits
 
 	// QuicNet state data
 	localIf *water.Interface
+	// tun is the tunnel interface packets are read from and written to, it is
+	// replaced when the interface is recovered after a failure
+	tun atomic.Pointer[water.Interface]
 
-	//NAT port binding determined through stun request
-	portBinding string
+	//NAT port binding determined through stun request, guarded by bindingMu.
+	//endpointPublisher is told about it, reprobing is set while it is probed again.
+	bindingMu         sync.Mutex
+	portBinding       string
+	endpointPublisher func(endpoint string)
+	reprobing         atomic.Bool
 
 	//Flag to indicate if node is behind Symmetric NAT
 	symmetricNAT bool
 
-	connections   map[string]quic.Connection
-	clients       map[string]*Client
+	// stun selects among the configured STUN servers by their health
+	stun     *stunSelector
+	stunOnce sync.Once
+
+	// ctx is the lifetime of the node passed to Start, cancel ends it on Stop
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+	udpConn  *net.UDPConn
+	// failErr is the failure that stopped the node, see fail
+	failMu  sync.Mutex
+	failErr error
+	// localAddr is the tunnel address of the node
+	localAddr netip.Addr
+	// started is when Start was called
+	started time.Time
+
+	// mu guards the peers of qc, connections, clients, strangers, peerConns, peerCancels, failedPeers, idlePeers, pathEvents and peerStates
+	mu          sync.RWMutex
+	peerCancels map[string]context.CancelFunc
+	failedPeers map[string]error
+	// idlePeers holds the peers whose connection idled out, closing the channel redials them
+	idlePeers   map[string]chan struct{}
+	connections map[string]quic.Connection
+	clients     map[string]*Client
+	// strangers holds the clients of connections accepted from nodes that were not peers, by host
+	strangers map[string]*Client
+	// peerConns holds the connection kept to every node by its tunnel address, see resolveSimultaneousOpen
+	peerConns     map[netip.Addr]*Client
+	pathEvents    map[string]Event
+	peerStates    map[string]PeerState
+	events        chan Event
+	routes        *routeTable
+	dialSlots     chan struct{}
+	mirror        *mirror
+	mirrorCapture *pcapWriter
+	revocation    *revocationChecker
+	tracer        logging.Tracer
+	transport     Transport
+	resolver      *resolveCache
+	// dscpMarks holds the DSCP of the peers marked differently than the node, nil when none is
+	dscpMarks     *dscpMarks
+	otelTracer    trace.Tracer
 	disableClient bool
 	disableServer bool
+
+	counters counters
+	drops    dropRing
+	// buffers accounts the memory of the batches, reassemblies and egress queues of all peers
+	buffers *bufferBudget
+	// egress queues the packets read from the tunnel interface per peer, nil sends them from the read loop
+	egress *egressQueues
+	// sessionCache keeps the session tickets of the peers across reconnects
+	sessionCache tls.ClientSessionCache
+	// certificate is presented to peers on dial and accept, caPool verifies
+	// the certificates of the peers when a CA is configured
+	certificate *tls.Certificate
+	caPool      *x509.CertPool
+
+	// logs keeps the recent log lines served by the control API
+	logs *logStream
+
+	// peerLevels overrides the log level of the node for single peers, see peerLogger
+	peerLevels *peerLevels
+
+	// noisyLog collapses repetitive warnings and errors of the dial and forwarding loops
+	noisyLog *dedupLogger
+
+	// packetHandler receives the packets otherwise written to the tunnel interface, see SetPacketHandler
+	packetHandler func(packet []byte)
+
+	// tunWriteLog rate limits the logging of failed writes to the tunnel interface
+	tunWriteLog *rateLimiter
+
+	// command runs the ip commands configuring the tunnel interface and its routes
+	command func(name string, arg ...string) error
 }
 
 // NewQuicWire creates a new QuicWire
@@ -53,57 +164,186 @@ func NewQuicWire(logger *zap.SugaredLogger,
 	disableClient bool,
 	disableServer bool) (*QuicWire, error) {
 
+	logs := newLogStream()
+	logger = logs.teeLogger(logger)
 	qn := &QuicWire{
+		ctx:           context.Background(),
 		qc:            &QuicConf{},
 		logger:        logger,
 		configFile:    configFile,
+		configLimits:  defaultConfLimits,
 		connections:   make(map[string]quic.Connection),
 		clients:       make(map[string]*Client),
+		strangers:     make(map[string]*Client),
+		peerConns:     make(map[netip.Addr]*Client),
+		pathEvents:    make(map[string]Event),
+		peerStates:    make(map[string]PeerState),
+		peerCancels:   make(map[string]context.CancelFunc),
+		failedPeers:   make(map[string]error),
+		idlePeers:     make(map[string]chan struct{}),
+		events:        make(chan Event, eventBufferSize),
 		disableClient: disableClient,
 		disableServer: disableServer,
+		tunWriteLog:   newRateLimiter(tunWriteLogInterval),
+		transport:     DefaultTransport(),
+		resolver:      newResolveCache(DefaultResolver(), 0),
+		noisyLog:      newDedupLogger(logger, defaultLogDedupWindow),
+		logs:          logs,
+		buffers:       newBufferBudget(0),
+		sessionCache:  tls.NewLRUClientSessionCache(0),
+		peerLevels:    newPeerLevels(),
+		command:       runCommand,
 	}
+	qn.tracer = newPathTracer(qn)
+	qn.SetDialConcurrency(defaultDialConcurrency)
+	qn.SetTracerProvider(trace.NewNoopTracerProvider())
 	return qn, nil
 }
 
 // Start Initializes the QuicWire network
 func (qn *QuicWire) Start(ctx context.Context, wg *sync.WaitGroup) error {
 	qn.logger.Info("QuicWire Starting")
+	ctx, span := qn.startSpan(ctx, "quicwire.start")
+	defer span.End()
+	qn.ctx, qn.cancel = context.WithCancel(ctx)
+	qn.started = time.Now()
+
 	qn.logger.Infof("Read the quic config file : %s", qn.configFile)
-	err := readQuicConf(qn.qc, qn.configFile)
+	_, configSpan := qn.startSpan(ctx, "quicwire.config.load", attribute.String("quicwire.config_file", qn.configFile),
+		attribute.Int("quicwire.config_layers", len(qn.configLayers)))
+	err := qn.readConfig(qn.qc)
+	endSpan(configSpan, err)
 	if err != nil {
 		return err
 	}
 	qn.logger.Debugf("QuicWire config: %v", qn.qc)
+	for _, peer := range qn.qc.peers {
+		if err := qn.peerLevels.set(peer.allowedIPs[0], peer.logLevel); err != nil {
+			return err
+		}
+	}
+	if qn.qc.nodeInterface.logDedupWindow > 0 {
+		qn.noisyLog = newDedupLogger(qn.logger, qn.qc.nodeInterface.logDedupWindow)
+	}
+	go qn.noisyLog.run(qn.ctx)
+	if qn.qc.nodeInterface.dialConcurrency > 0 {
+		qn.SetDialConcurrency(qn.qc.nodeInterface.dialConcurrency)
+	}
+	qn.buffers = newBufferBudget(qn.qc.nodeInterface.maxBufferBytes)
+	if qn.qc.nodeInterface.egressQueueBytes > 0 {
+		qn.egress = newEgressQueues(qn.qc.nodeInterface.egressQueueBytes)
+	}
+	qn.resolver.interval = qn.qc.nodeInterface.resolveInterval
+	if qn.qc.nodeInterface.noUDPBatching {
+		qn.logger.Info("Batched UDP receive is disabled, reading one datagram per syscall")
+		qn.transport = unbatchedTransport{qn.transport}
+	}
+	qn.localAddr, err = tunnelAddr(qn.qc.nodeInterface.localEndpoint)
+	if err != nil {
+		return err
+	}
+	qn.routes, err = newRouteTable(qn.qc.peers)
+	if err != nil {
+		return err
+	}
+	if err := qn.setupMirror(); err != nil {
+		return err
+	}
+	qn.setupRevocation()
+	if err := qn.setupCertificates(); err != nil {
+		return err
+	}
+	if err := qn.startControlAPI(qn.ctx); err != nil {
+		return fmt.Errorf("failed to start the control API: %w", err)
+	}
+	qn.startStatsFile(qn.ctx)
+	qn.publishExpvar(qn.ctx)
+	if err := qn.startStatsd(qn.ctx); err != nil {
+		return fmt.Errorf("failed to start the StatsD exporter: %w", err)
+	}
 	qn.logger.Info("Create tunnel interface on local host")
-	if err := qn.createTunIface(); err != nil {
+	if err := qn.startTunIface(qn.ctx); err != nil {
+		return err
+	}
+	if err := qn.setupMasquerade(); err != nil {
 		return err
 	}
 
+	// Bind the shared socket before probing STUN so an OS assigned port is known
+	if err := qn.bindSharedSocket(); err != nil {
+		return err
+	}
+	qn.setupDSCP()
+
 	//find port binding
 	if !qn.disableServer {
-		qn.findPortBinding()
+		_, stunSpan := qn.startSpan(ctx, "quicwire.stun.probe")
+		binding, err := qn.findPortBinding()
+		stunSpan.SetAttributes(attribute.String("quicwire.binding", binding))
+		endSpan(stunSpan, err)
+		if err != nil {
+			qn.logger.Warnf("Failed to find the port binding, peers behind NAT may not reach this node: %v", err)
+		}
+		qn.setPortBinding(binding)
 	}
 
 	// Start the server
 	qn.setupTunnel(wg, qn.disableClient, qn.disableServer)
 
 	qn.enableTrafficForwarding()
+	qn.startSDNotify(qn.ctx)
 	return nil
 }
 
-// Stop stops the QuicWire network
+// SetTransport sets the transport used for every listener and dial, it must be called before Start
+func (qn *QuicWire) SetTransport(transport Transport) {
+	qn.transport = transport
+}
+
+// SetResolver sets the resolver of peer endpoints, it must be called before Start
+func (qn *QuicWire) SetResolver(resolver Resolver) {
+	qn.resolver.resolver = resolver
+}
+
+// Stop stops the QuicWire network, waiting at most the configured StopTimeout for peers
 func (qn *QuicWire) Stop() {
-	qn.logger.Info("QuicWire Stop")
+	ctx, cancel := context.WithTimeout(context.Background(), qn.stopTimeout())
+	defer cancel()
+	qn.StopContext(ctx)
 }
 
+// createTunIface brings up the tunnel interface in order: create the device,
+// assign its address, set the MTU, set the link up and finally install the
+// peer routes, which the kernel only accepts once the link is up. A failed
+// step removes the interface again so no half configured device is left.
 func (qn *QuicWire) createTunIface() error {
 	// Create a TUN interface
-	iface, err := water.New(water.Config{DeviceType: water.TUN})
+	var deviceType water.DeviceType = water.TUN
+	if qn.tapMode() {
+		deviceType = water.TAP
+	}
+	iface, err := water.New(water.Config{DeviceType: deviceType})
 	if err != nil {
 		return fmt.Errorf("failed to create Tun interface: %w", err)
 	}
 	qn.logger.Debugf("TUN interface created: %s", iface.Name())
 
+	if err := qn.configureTunIface(iface.Name()); err != nil {
+		// Closing the non persistent device deletes it along with its addresses and routes
+		if closeErr := iface.Close(); closeErr != nil {
+			qn.logger.Errorf("Failed to remove TUN interface %s: %v", iface.Name(), closeErr)
+		}
+		return err
+	}
+
+	qn.logger.Debugf("TUN interface %s is up and running", iface.Name())
+	qn.localIf = iface
+	qn.tun.Store(iface)
+
+	return nil
+}
+
+func (qn *QuicWire) configureTunIface(name string) error {
 	// Assign an IP address to the TUN interface
 	var tunnelIPStr string
 	ip, ipNet, err := net.ParseCIDR(qn.qc.nodeInterface.localEndpoint)
@@ -119,34 +359,84 @@ func (qn *QuicWire) createTunIface() error {
 		ones, _ := ipNet.Mask.Size()
 		tunnelIPStr = fmt.Sprintf("%s/%d", ip.String(), ones)
 	}
-	cmd := exec.Command("ip", "addr", "add", tunnelIPStr, "dev", iface.Name())
-	if err := cmd.Run(); err != nil {
+	if err := qn.command("ip", "addr", "add", tunnelIPStr, "dev", name); err != nil {
 		return fmt.Errorf("failed to assign IP address to TUN interface: %w", err)
 	}
 	qn.logger.Debugf("IP address assigned to TUN interface")
 
 	// Set the MTU
-	tunDevMTUString := strconv.Itoa(tunDevMTU)
-	cmd = exec.Command("ip", "link", "set", "dev", iface.Name(), "mtu", tunDevMTUString)
-	if err := cmd.Run(); err != nil {
+	tunDevMTUString := strconv.Itoa(qn.tunMTU())
+	if err := qn.command("ip", "link", "set", "dev", name, "mtu", tunDevMTUString); err != nil {
 		return fmt.Errorf("failed to set the MTU: %v", err)
 	}
 
 	// Up the TUN interface
-	cmd = exec.Command("ip", "link", "set", "dev", iface.Name(), "up")
-	if err := cmd.Run(); err != nil {
+	if err := qn.command("ip", "link", "set", "dev", name, "up"); err != nil {
 		return fmt.Errorf("failed to change the state to UP for the TUN interface: %v", err)
 	}
 
-	qn.logger.Debugf("TUN interface %s is up and running", iface.Name())
-	qn.localIf = iface
+	// Route the allowed IPs of every peer through the TUN interface
+	for _, peer := range qn.qc.peers {
+		if err := qn.installPeerRoutes(name, peer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
+// installPeerRoutes routes the allowed IPs of the peer through the TUN interface
+func (qn *QuicWire) installPeerRoutes(name string, peer Peer) error {
+	for _, allowedIP := range peer.allowedIPs {
+		route, err := routePrefix(allowedIP)
+		if err != nil {
+			return err
+		}
+		if err := qn.command("ip", "route", "replace", route, "dev", name); err != nil {
+			return fmt.Errorf("failed to install route %s on the TUN interface: %v", route, err)
+		}
+		qn.logger.Debugf("Installed route %s on TUN interface %s", route, name)
+	}
 	return nil
 }
 
+// removePeerRoutes removes the routes installed for the peer
+func (qn *QuicWire) removePeerRoutes(name string, peer Peer) {
+	for _, allowedIP := range peer.allowedIPs {
+		route, err := routePrefix(allowedIP)
+		if err != nil {
+			continue
+		}
+		if err := qn.command("ip", "route", "del", route, "dev", name); err != nil {
+			qn.logger.Warnf("Failed to remove route %s from TUN interface %s: %v", route, name, err)
+		}
+	}
+}
+
+// runCommand runs the command and waits for it to finish
+func runCommand(name string, arg ...string) error {
+	return exec.Command(name, arg...).Run()
+}
+
+// routePrefix turns an allowed IP into a CIDR, plain addresses become host routes
+func routePrefix(allowedIP string) (string, error) {
+	allowedIP = strings.TrimSpace(allowedIP)
+	if _, ipNet, err := net.ParseCIDR(allowedIP); err == nil {
+		return ipNet.String(), nil
+	}
+	ip := net.ParseIP(allowedIP)
+	if ip == nil {
+		return "", fmt.Errorf("invalid allowed IP format: %s", allowedIP)
+	}
+	if ip.To4() != nil {
+		return ip.String() + "/32", nil
+	}
+	return ip.String() + "/128", nil
+}
+
 func (qn *QuicWire) findPortBinding() (string, error) {
 
-	isSymmetric, err := IsSymmetricNAT(qn.qc.nodeInterface.listenPort)
+	isSymmetric, err := qn.stunServers().isSymmetricNAT(qn.qc.nodeInterface.listenPort)
 	if err != nil {
 		qn.logger.Error(err)
 	}
@@ -155,42 +445,83 @@ func (qn *QuicWire) findPortBinding() (string, error) {
 		return "", fmt.Errorf("node is behind Symmetric NAT")
 	}
 
-	res, err := GetPortBinding(qn.qc.nodeInterface.listenPort)
+	res, err := qn.stunServers().portBinding(qn.qc.nodeInterface.listenPort)
 	if err != nil {
-		qn.logger.Fatalf("stun request failed: %v", err)
+		return "", fmt.Errorf("stun request failed: %w", err)
 	}
 	qn.logger.Infof("Port binding returned by STUN request: %s", res)
 	return res, nil
 }
 
-func (qn *QuicWire) setupTunnel(wg *sync.WaitGroup, disableClient bool, disableServer bool) {
-	// Create a shared UDP socket
+// bindSharedSocket creates the UDP socket shared by the server and every
+// peer dial. A listen port of 0 is replaced by the port the OS assigned, so
+// STUN probes, reconnects and the status all use the real port. The socket
+// allows port reuse so STUN probes can be sent from the same port.
+func (qn *QuicWire) bindSharedSocket() error {
 	localipPortStr := fmt.Sprintf("%s:%d", qn.qc.nodeInterface.localNodeIP, qn.qc.nodeInterface.listenPort)
-	udpAddr, err := net.ResolveUDPAddr("udp4", localipPortStr)
+	pconn, err := reuseport.ListenPacket("udp", localipPortStr)
 	if err != nil {
-		qn.logger.Fatalf("Failed to resolve UDP address: %v", err)
+		return fmt.Errorf("failed to create shared UDP socket: %w", err)
 	}
-	udpConn, err := net.ListenUDP("udp4", udpAddr)
-	if err != nil {
-		qn.logger.Fatalf("Failed to create shared UDP socket: %v", err)
+	udpConn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		pconn.Close()
+		return fmt.Errorf("shared socket on %s is not a UDP socket", localipPortStr)
 	}
+	if mark := qn.qc.nodeInterface.fwmark; mark != 0 {
+		// Without the mark the tunnel packets would be routed back into the tunnel in full tunnel setups
+		if err := setSocketMark(udpConn, mark); err != nil {
+			udpConn.Close()
+			return fmt.Errorf("failed to set fwmark %#x on the shared socket: %w", mark, err)
+		}
+	}
+	if qn.qc.nodeInterface.listenPort == 0 {
+		// Pin the ephemeral port so reconnects keep using the same source port
+		qn.qc.nodeInterface.listenPort = udpConn.LocalAddr().(*net.UDPAddr).Port
+		qn.logger.Infof("Pinned ephemeral source port %d", qn.qc.nodeInterface.listenPort)
+	}
+	qn.udpConn = udpConn
+	return nil
+}
+
+func (qn *QuicWire) setupTunnel(wg *sync.WaitGroup, disableClient bool, disableServer bool) {
+	udpConn := qn.udpConn
+	localipPortStr := udpConn.LocalAddr().String()
 
 	if !disableServer {
 		wg.Add(1)
 		go func() {
 			// server mode
-			ctx, cancel := context.WithCancel(context.Background())
+			ctx, cancel := context.WithCancel(qn.ctx)
 			defer cancel()
 
 			qn.logger.Infof("Starting server on %s", localipPortStr)
 			s := NewServer(localipPortStr, qn.localIf, qn.logger)
+			s.SetTracer(qn.tracer)
+			s.SetTransport(qn.transport)
+			s.SetIdleTimeout(qn.qc.nodeInterface.idleTimeout, !qn.idleTeardown())
+			s.SetDropHandler(func(peer string, reason string, size int, packet []byte) { qn.recordDrop(reason, peer, size, packet) })
+			s.SetControlHandler(qn.counters.countControl)
+			s.SetCPUAffinity(qn.qc.nodeInterface.forwardingCPUs)
+			s.SetCoalescing(qn.qc.nodeInterface.flushInterval, qn.qc.nodeInterface.maxBatchBytes)
+			s.SetBufferBudget(qn.buffers)
+			s.SetConnRateLimit(qn.qc.nodeInterface.connRateLimit, qn.qc.nodeInterface.connRateBurst)
+			s.SetStatelessRetry(qn.qc.nodeInterface.statelessRetry)
+			s.SetSessionTicketKeys(qn.qc.nodeInterface.sessionTicketKeys, qn.qc.nodeInterface.sessionTicketRotation)
+			s.SetCertificate(qn.certificate)
+			if qn.verifiesPeers() {
+				s.SetVerifyPeerCertificate(qn.verifyPeerCertificate)
+			}
 			s.SetHandler(func(c packetContext) error {
 				msg := c.Data
 				qn.logger.Debugf("Client [ %s ] sent a message [ %v ] over client initiated connection", c.RemoteAddr().String(), msg)
-				c.localIf.Write(c.Data)
+				qn.deliver(c)
 				return nil
 			})
-			qn.logger.Fatal(s.StartServer(ctx, udpConn, qn, wg))
+			err := s.StartServer(ctx, udpConn, qn, wg)
+			if qn.ctx.Err() == nil {
+				qn.fail(fmt.Errorf("server stopped: %w", err))
+			}
 		}()
 		wg.Wait()
 	}
@@ -198,86 +529,107 @@ func (qn *QuicWire) setupTunnel(wg *sync.WaitGroup, disableClient bool, disableS
 	if !disableClient {
 
 		//range over all peers and create client connections
-		for _, peer := range qn.qc.peers {
-			qn.logger.Debugf("Starting client for peer %s", peer.endpoint)
-			go func(peer Peer) {
-
-				_, ok := qn.clients[peer.allowedIPs[0]]
-				if ok {
-					qn.logger.Infof("Client already exists for peer %s [ %s ]", peer.endpoint, peer.allowedIPs[0])
-					return
-				}
-
-				ctx, cancel := context.WithCancel(context.Background())
-				defer cancel()
-
-				c := NewClient(peer.endpoint, qn.qc.nodeInterface.localNodeIP, qn.qc.nodeInterface.listenPort, qn.localIf, qn.logger)
-
-				//split endpoint to get ip and port
-				host, _, err := net.SplitHostPort(peer.endpoint)
-				if err != nil {
-					qn.logger.Fatalf("Failed to split host and port: %v", err)
-				}
-
-				err = RetryOperation(ctx, retryInterval, retries, func() error {
-					if conn, ok := qn.connections[host]; ok {
-						qn.logger.Infof("Connection already exists for peer endpoint %s", peer.endpoint)
-						c.SetConnection(conn)
-						return nil
-					}
-					qn.logger.Debugf("No existing connection to the peer endpoint %s.", peer.endpoint)
-
-					err := c.Dial(udpConn)
-					if err != nil {
-						qn.logger.Debugf("Failed to dial: %v", err)
-						qn.logger.Warnf("Retrying to dial %s", peer.endpoint)
-						return err
-					}
-					qn.logger.Infof("Dialed new connection to peer endpoint %s.", peer.endpoint)
-					c.AttachHandler(func(c packetContext) error {
-						msg := c.Data
-						qn.logger.Debugf("Client [ %s ] sent a message [ %v ] over server initiated connection", c.RemoteAddr().String(), msg)
-						c.localIf.Write(c.Data)
-						return nil
-					})
-					return nil
-				})
-				if err != nil {
-					qn.logger.Fatalf("Peer is not reachable or : %v", err)
-				}
-				qn.clients[peer.allowedIPs[0]] = c
-			}(peer)
-		}
+		qn.mu.RLock()
+		peers := append([]Peer(nil), qn.qc.peers...)
+		qn.mu.RUnlock()
+		qn.startPeers(peers, dialStagger)
 	}
 }
 
 func (qn *QuicWire) enableTrafficForwarding() error {
 	go func() error {
+		pinForwarding(qn.qc.nodeInterface.forwardingCPUs, qn.logger)
 		// Start reading packets from the TUN interface
-		packet := make([]byte, 1500)
+		packet := make([]byte, qn.maxPacket())
 		for {
-			n, err := qn.localIf.Read(packet)
+			n, err := qn.tun.Load().Read(packet)
+			if err != nil && qn.ctx.Err() != nil {
+				// The interface was closed by Stop
+				return nil
+			}
 			if err != nil {
-				qn.logger.Fatalf("Failed to read packet from TUN interface: %v", err)
-				panic(err)
+				if qn.handleTunFailure(err) {
+					continue
+				}
+				return err
 			}
+			qn.forwardPacket(packet[:n])
+		}
+	}()
+	return nil
+}
 
-			dstIP := net.IP(packet[16:20])
+// forwardPacket routes a packet read from the tunnel interface, or injected,
+// to the peer serving its destination. It returns errNoRoute when no
+// connected peer serves the destination and the error of a failed send.
+func (qn *QuicWire) forwardPacket(packet []byte) error {
+	n := len(packet)
+	if qn.tapMode() {
+		qn.forwardFrame(packet)
+		return nil
+	}
+	// Skip empty and runt reads rather than parsing stale bytes of the previous packet
+	if n < ipv4HeaderLen {
+		if n > 0 {
+			qn.logger.Debugf("Skipped runt packet of %d bytes from local tun interface", n)
+			qn.recordDrop(dropRunt, "", n, packet)
+		}
+		return fmt.Errorf("runt packet of %d bytes", n)
+	}
 
-			// Do something with the packet
-			qn.logger.Debugf("Received packet from local tun interface: %v for destination %s", packet[:n], dstIP.String())
+	dstIP := packetDst(packet)
 
-			//check if dstIp is in the list of peers
-			if c, ok := qn.clients[dstIP.String()]; ok {
-				err = c.SendBytes(packet[:n])
-				if err != nil {
-					qn.logger.Errorf("failed to send client message: %v", err)
-				}
-				//check if dstIp is in the Con
-			} else {
-				qn.logger.Debugf("No client connection found for destination IP %s", dstIP.String())
-			}
+	// Do something with the packet
+	qn.logger.Debugf("Received packet from local tun interface: %v for destination %s", packet, dstIP.String())
+
+	// Packets to the node itself must not loop out through a broad peer route
+	if dstIP == qn.localAddr {
+		qn.handleLocalPacket(packet)
+		return nil
+	}
+
+	//find the peer routing dstIp
+	qn.mu.RLock()
+	var c *Client
+	peer, ok, single := qn.routes.lookupSingle(dstIP)
+	if !single {
+		peer, ok = qn.routes.lookup(dstIP, flowHash(packet), func(peer string) bool {
+			_, ok := qn.clients[peer]
+			return ok
+		})
+	}
+	if ok {
+		c, ok = qn.clients[peer]
+	}
+	qn.mu.RUnlock()
+	if !ok {
+		if !qn.wakeIdlePeer(dstIP, flowHash(packet)) {
+			qn.logger.Debugf("No client connection found for destination IP %s", dstIP.String())
 		}
-	}()
+		qn.recordDrop(dropNoRoute, "", n, packet)
+		return fmt.Errorf("%w %s", errNoRoute, dstIP)
+	}
+	if qn.egress != nil {
+		return qn.queuePacket(peer, c, packet)
+	}
+	return qn.sendPacket(peer, c, packet)
+}
+
+// sendPacket sends a packet read from the tunnel interface to the peer
+func (qn *QuicWire) sendPacket(peer string, c *Client, packet []byte) error {
+	n := len(packet)
+	qn.mirrorPacket(packet, peer)
+	if err := c.SendBytes(packet); err != nil {
+		qn.counters.countSendError()
+		qn.recordDrop(dropSendError, peer, n, packet)
+		qn.noisyLog.Errorf("failed to send client message: %v", err)
+		return err
+	}
+	qn.counters.countTx(n)
+	if c.firstForwarded.CompareAndSwap(false, true) {
+		_, span := qn.startSpan(trace.ContextWithSpanContext(qn.ctx, c.spanContext), "quicwire.peer.first_packet",
+			attribute.String("quicwire.peer", peer), attribute.Int("quicwire.bytes", n))
+		span.End()
+	}
 	return nil
 }
@@ -0,0 +1,95 @@
+package quicwire
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestConfigureTunIfaceOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		failing string
+		want    []string
+	}{
+		{
+			name: "routes after link up",
+			want: []string{
+				"ip addr add 10.0.0.1/24 dev tun0",
+				"ip link set dev tun0 mtu 1190",
+				"ip link set dev tun0 up",
+				"ip route replace 10.1.0.0/16 dev tun0",
+				"ip route replace 10.2.0.7/32 dev tun0",
+			},
+		},
+		{
+			name:    "link up failing",
+			failing: "ip link set dev tun0 up",
+			want: []string{
+				"ip addr add 10.0.0.1/24 dev tun0",
+				"ip link set dev tun0 mtu 1190",
+				"ip link set dev tun0 up",
+			},
+		},
+		{
+			name:    "address failing",
+			failing: "ip addr add 10.0.0.1/24 dev tun0",
+			want:    []string{"ip addr add 10.0.0.1/24 dev tun0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, err := NewQuicWire(zap.NewNop().Sugar(), "", true, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qn.qc.nodeInterface.localEndpoint = "10.0.0.1"
+			qn.qc.peers = []Peer{
+				{endpoint: "192.0.2.1:51820", allowedIPs: []string{"10.1.0.0/16"}},
+				{endpoint: "192.0.2.2:51820", allowedIPs: []string{"10.2.0.7"}},
+			}
+			var ran []string
+			qn.command = func(name string, arg ...string) error {
+				cmd := strings.Join(append([]string{name}, arg...), " ")
+				ran = append(ran, cmd)
+				if cmd == tt.failing {
+					return errors.New("exit status 2")
+				}
+				return nil
+			}
+			err = qn.configureTunIface("tun0")
+			if (err != nil) != (tt.failing != "") {
+				t.Errorf("configureTunIface() error = %v, want an error %t", err, tt.failing != "")
+			}
+			if strings.Join(ran, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("ran\n%s\nwant\n%s", strings.Join(ran, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestEphemeralListenPortResolved(t *testing.T) {
+	// The responders reflect the source address, so the binding shows the port probed from
+	reflecting := []string{startScriptedSTUN(t, nil, 0, false), startScriptedSTUN(t, nil, 0, false)}
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.qc.nodeInterface.listenPort = 0
+		qn.qc.nodeInterface.stunServers = reflecting
+	})
+
+	port := a.udpConn.LocalAddr().(*net.UDPAddr).Port
+	status := a.NodeStatus()
+	if status.ListenPort == 0 || status.ListenPort != port {
+		t.Errorf("status listen port = %d, want the bound port %d", status.ListenPort, port)
+	}
+	binding, err := a.findPortBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := net.JoinHostPort("127.0.0.1", strconv.Itoa(port)); binding != want {
+		t.Errorf("port binding = %s, want %s probed from the bound port", binding, want)
+	}
+}
@@ -0,0 +1,191 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reconcile is exchanged periodically on a new stream so both ends of a
+// connection can check that their views of each other's routes agree
+type reconcile struct {
+	// Routes are the prefixes the sender serves
+	Routes []string `json:"routes"`
+	// PeerRoutes are the prefixes the sender routes to the receiver
+	PeerRoutes []string `json:"peerRoutes"`
+}
+
+// normalizeRoutes returns the routes as sorted CIDRs, invalid ones are kept verbatim
+func normalizeRoutes(routes []string) []string {
+	out := make([]string, 0, len(routes))
+	for _, route := range routes {
+		if cidr, err := routePrefix(route); err == nil {
+			route = cidr
+		}
+		out = append(out, strings.TrimSpace(route))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// sameRoutes reports whether both lists hold the same prefixes
+func sameRoutes(a, b []string) bool {
+	a, b = normalizeRoutes(a), normalizeRoutes(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// peerForClient returns the peer the client carries the traffic of
+func (qn *QuicWire) peerForClient(c *Client) (Peer, bool) {
+	qn.mu.RLock()
+	defer qn.mu.RUnlock()
+	for _, peer := range qn.qc.peers {
+		if qn.clients[peer.allowedIPs[0]] == c {
+			return peer, true
+		}
+	}
+	return Peer{}, false
+}
+
+// localReconcile returns the routes this node serves and routes to the peer of c
+func (qn *QuicWire) localReconcile(c *Client) reconcile {
+	msg := reconcile{Routes: qn.announcedRoutes()}
+	if peer, ok := qn.peerForClient(c); ok {
+		msg.PeerRoutes = peer.allowedIPs
+	}
+	return msg
+}
+
+// reconcileLoop periodically compares route views with the peer over the
+// dialed connection until it closes
+func (qn *QuicWire) reconcileLoop(ctx context.Context, c *Client) {
+	interval := qn.qc.nodeInterface.routeReconcileInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.connection.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		remote, err := c.exchangeReconcile(ctx, qn.localReconcile(c))
+		if err != nil {
+			qn.logger.Debugf("Route reconciliation with %s failed: %v", c.addr, err)
+			continue
+		}
+		qn.reconcileRoutes(c, remote)
+	}
+}
+
+// exchangeReconcile sends the local route view on a new stream and reads the peer's
+func (c *Client) exchangeReconcile(ctx context.Context, local reconcile) (reconcile, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	stream, err := c.connection.OpenStreamSync(ctx)
+	if err != nil {
+		return reconcile{}, fmt.Errorf("failed to open the reconciliation stream: %w", err)
+	}
+	defer stream.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(c.controlStream(stream)).Encode(local); err != nil {
+		return reconcile{}, fmt.Errorf("failed to send routes: %w", err)
+	}
+	var remote reconcile
+	if err := json.NewDecoder(c.controlStream(stream)).Decode(&remote); err != nil {
+		return reconcile{}, fmt.Errorf("failed to read routes: %w", err)
+	}
+	return remote, nil
+}
+
+// serveReconcile answers the reconciliation requests of the peer that dialed
+// the connection until it closes
+func (qn *QuicWire) serveReconcile(c *Client) {
+	ctx := c.connection.Context()
+	for {
+		stream, err := c.connection.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		stream.SetDeadline(time.Now().Add(handshakeTimeout))
+		var remote reconcile
+		if err := json.NewDecoder(c.controlStream(stream)).Decode(&remote); err != nil {
+			qn.logger.Debugf("Failed to read routes from %s: %v", c.addr, err)
+			stream.Close()
+			continue
+		}
+		if err := json.NewEncoder(c.controlStream(stream)).Encode(qn.localReconcile(c)); err != nil {
+			qn.logger.Debugf("Failed to send routes to %s: %v", c.addr, err)
+		}
+		stream.Close()
+		qn.reconcileRoutes(c, remote)
+	}
+}
+
+// reconcileRoutes compares the peer's route view with ours. Drift of learned
+// routes is corrected by adopting the routes the peer serves now, drift of
+// configured peers is only logged since the configuration is authoritative.
+func (qn *QuicWire) reconcileRoutes(c *Client, remote reconcile) {
+	if local := qn.announcedRoutes(); !sameRoutes(remote.PeerRoutes, local) {
+		qn.logger.Infof("Peer %s routes %s to this node which serves %s, the peer corrects its view",
+			c.addr, strings.Join(remote.PeerRoutes, ","), strings.Join(local, ","))
+	}
+
+	peer, ok := qn.peerForClient(c)
+	if !ok || sameRoutes(peer.allowedIPs, remote.Routes) {
+		return
+	}
+	if !peer.learned {
+		qn.logger.Warnf("Peer %s serves %s but is configured with allowed IPs %s, check the configuration",
+			c.addr, strings.Join(remote.Routes, ","), strings.Join(peer.allowedIPs, ","))
+		return
+	}
+	if err := qn.checkRouteCount(remote.Routes); err != nil {
+		// Keep serving the routes adopted before rather than dropping them all
+		qn.logger.Warnf("Rejected routes announced by %s, keeping %s: %v", c.addr, strings.Join(peer.allowedIPs, ","), err)
+		return
+	}
+	qn.logger.Warnf("Routes learned from %s drifted from %s to %s, adopting the announced ones",
+		c.addr, strings.Join(peer.allowedIPs, ","), strings.Join(remote.Routes, ","))
+	qn.forgetLearnedPeer(peer)
+	qn.adoptAnnouncedRoutes(c, hello{Routes: remote.Routes})
+}
+
+// forgetLearnedPeer removes the routes of a learned peer without closing its connection
+func (qn *QuicWire) forgetLearnedPeer(peer Peer) {
+	key := peer.allowedIPs[0]
+	qn.mu.Lock()
+	delete(qn.clients, key)
+	// A new slice, callers may hold the previous one outside the lock
+	peers := make([]Peer, 0, len(qn.qc.peers))
+	for _, p := range qn.qc.peers {
+		if p.allowedIPs[0] != key {
+			peers = append(peers, p)
+		}
+	}
+	qn.qc.peers = peers
+	qn.mu.Unlock()
+
+	qn.routes.removePeer(key)
+	if qn.localIf != nil {
+		qn.removePeerRoutes(qn.localIf.Name(), peer)
+	}
+}
@@ -0,0 +1,83 @@
+package quicwire
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestSameRoutes(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want bool
+	}{
+		{name: "equal", a: []string{"10.5.0.0/24"}, b: []string{"10.5.0.0/24"}, want: true},
+		{name: "order and host notation", a: []string{"10.0.0.2", "10.5.0.0/24"}, b: []string{"10.5.0.0/24", "10.0.0.2/32"}, want: true},
+		{name: "different prefix", a: []string{"10.5.0.0/24"}, b: []string{"10.6.0.0/24"}},
+		{name: "extra route", a: []string{"10.5.0.0/24"}, b: []string{"10.5.0.0/24", "10.6.0.0/24"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameRoutes(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameRoutes(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// waitLearnedRoute waits until the node routes prefix to a learned peer
+func waitLearnedRoute(t *testing.T, qn *QuicWire, prefix string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if peer, ok := qn.peerByAllowedIP(prefix); ok && peer.learned {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("route %s was not learned", prefix)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconcileConvergesDesyncedRoutes(t *testing.T) {
+	mesh := newMemNetwork()
+	hub := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.acceptAnnouncedRoutes = true
+	})
+	spoke := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.announceRoutes = []string{"10.5.0.0/24"}
+		qn.qc.nodeInterface.routeReconcileInterval = 50 * time.Millisecond
+	}, newTestPeer(hub.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, spoke.QuicWire, "10.0.0.2/32", PeerConnected)
+	waitLearnedRoute(t, hub.QuicWire, "10.5.0.0/24")
+
+	// The hub loses track of the announced route and holds a stale one instead
+	peer, _ := hub.peerByAllowedIP("10.5.0.0/24")
+	hub.mu.RLock()
+	c := hub.clients[peer.allowedIPs[0]]
+	hub.mu.RUnlock()
+	hub.forgetLearnedPeer(peer)
+	hub.adoptAnnouncedRoutes(c, hello{Routes: []string{"10.9.0.0/24"}})
+
+	// The next reconciliation restores the announced route
+	waitLearnedRoute(t, hub.QuicWire, "10.5.0.0/24")
+	if _, ok := hub.peerByAllowedIP("10.9.0.0/24"); ok {
+		t.Error("stale route 10.9.0.0/24 is still routed")
+	}
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.2:5000"), netip.MustParseAddrPort("10.5.0.7:4000"), []byte("converged"))
+	if err := hub.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := spoke.sink.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+}
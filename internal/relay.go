@@ -0,0 +1,85 @@
+package quicwire
+
+import (
+	"fmt"
+)
+
+const (
+	// defaultMaxHops is the hop count of packets sent by a node
+	defaultMaxHops = 8
+	// maxHopLimit is the largest hop count the relay frame carries
+	maxHopLimit = 255
+)
+
+// maxHops returns the hop count of the packets this node sends
+func (qn *QuicWire) maxHops() int {
+	if n := qn.qc.nodeInterface.maxHops; n > 0 {
+		return n
+	}
+	return defaultMaxHops
+}
+
+// relay forwards a packet received from a peer to the peer serving its
+// destination without passing it through the tunnel interface. Every relay
+// decrements the hop count of the packet and packets running out of hops are
+// dropped, so packets caught in a routing loop die out whatever the topology.
+// It returns false for packets to hand to the tunnel interface instead: those
+// to this node, to destinations no connected peer serves and those too large
+// for a relay frame, which the kernel bounds by their TTL.
+func (qn *QuicWire) relay(c packetContext) bool {
+	if len(c.Data) < ipv4HeaderLen || len(c.Data) > maxFramePayload-1 {
+		return false
+	}
+	dstIP := packetDst(c.Data)
+	if dstIP == qn.localAddr {
+		return false
+	}
+	qn.mu.RLock()
+	var target *Client
+	peer, ok := qn.routes.lookup(dstIP, flowHash(c.Data), func(peer string) bool {
+		_, ok := qn.clients[peer]
+		return ok
+	})
+	if ok {
+		target, ok = qn.clients[peer]
+	}
+	qn.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	// Packets arriving in plain data frames come from their origin and start
+	// out with the full hop count, relay frames keep the count they carry so
+	// no relay can restart it. A count of 0 is exhausted.
+	hops := qn.maxHops()
+	if c.relayed {
+		hops = c.hops
+	}
+	if hops <= 1 {
+		qn.counters.countHopLimit()
+		qn.recordDrop(dropHopLimit, peer, len(c.Data), c.Data)
+		qn.noisyLog.Warnf("Dropped packet from %s to %s that ran out of hops relayed by %s, check the routes for a loop",
+			packetSrc(c.Data), dstIP, c.RemoteAddr())
+		return true
+	}
+	if err := target.sendRelay(byte(hops-1), c.Data); err != nil {
+		qn.counters.countSendError()
+		qn.recordDrop(dropSendError, peer, len(c.Data), c.Data)
+		qn.noisyLog.Errorf("Failed to relay a packet to %s: %v", dstIP, err)
+		return true
+	}
+	qn.counters.countTx(len(c.Data))
+	return true
+}
+
+// sendRelay sends a relayed packet with the hops it has left
+func (c *Client) sendRelay(hops byte, data []byte) error {
+	if c.connection == nil {
+		return fmt.Errorf("Client has no active connection to peer %s", c.addr)
+	}
+	if mtu := int(c.pathMTU.Load()); mtu > 0 && len(data) > mtu {
+		return fmt.Errorf("packet of %d bytes exceeds the MTU of peer %s (%d): %w", len(data), c.addr, mtu, errPacketTooBig)
+	}
+	_, err := c.sendFrame(frameRelay, []byte{hops}, data)
+	return err
+}
@@ -0,0 +1,114 @@
+package quicwire
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestRelayHops(t *testing.T) {
+	src := netip.MustParseAddrPort("10.2.0.1:4000")
+	routed := packettest.UDP(src, netip.MustParseAddrPort("10.1.0.5:5000"), []byte("relayed"))
+	tests := []struct {
+		name    string
+		packet  []byte
+		maxHops int
+		relayed bool
+		hops    int
+		// handled tells whether relay took the packet, sent whether it went
+		// out with sentHops left or was dropped
+		handled  bool
+		sent     bool
+		sentHops byte
+	}{
+		{name: "origin", packet: routed, handled: true, sent: true, sentHops: defaultMaxHops - 1},
+		{name: "origin with configured hops", packet: routed, maxHops: 3, handled: true, sent: true, sentHops: 2},
+		{name: "origin with a single hop", packet: routed, maxHops: 1, handled: true},
+		{name: "relayed keeps its count", packet: routed, maxHops: 3, relayed: true, hops: 5, handled: true, sent: true, sentHops: 4},
+		{name: "relayed with a last hop", packet: routed, relayed: true, hops: 1, handled: true},
+		{name: "relayed without hops", packet: routed, relayed: true, hops: 0, handled: true},
+		{name: "to the node", packet: packettest.UDP(src, netip.MustParseAddrPort("10.0.0.1:5000"), nil)},
+		{name: "unrouted", packet: packettest.UDP(src, netip.MustParseAddrPort("192.168.0.1:5000"), nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, _ := newTestQuicWire(t, Peer{allowedIPs: []string{"10.1.0.1", "10.1.0.0/16"}})
+			qn.qc.nodeInterface.maxHops = tt.maxHops
+			out, _ := newTestConnPair(t)
+			qn.clients["10.1.0.1"] = newTestClient(t, out, true)
+			in, _ := newTestConnPair(t)
+
+			handled := qn.relay(packetContext{Connection: in, Data: tt.packet, relayed: tt.relayed, hops: tt.hops})
+			if handled != tt.handled {
+				t.Fatalf("relay = %v, want %v", handled, tt.handled)
+			}
+			messages := out.messages()
+			if !tt.sent {
+				if len(messages) != 0 {
+					t.Errorf("sent %d datagrams, want the packet dropped", len(messages))
+				}
+				if drops := qn.RecentDrops(); tt.handled && (len(drops) != 1 || drops[0].Reason != dropHopLimit) {
+					t.Errorf("drops = %+v, want one %s drop", drops, dropHopLimit)
+				}
+				return
+			}
+			if len(messages) != 1 {
+				t.Fatalf("sent %d datagrams, want 1", len(messages))
+			}
+			f, err := decodeFrame(messages[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.typ != frameRelay || f.payload[0] != tt.sentHops || string(f.payload[1:]) != string(tt.packet) {
+				t.Errorf("sent frame of type %#x with %d hops left, want a relay frame with %d", f.typ, f.payload[0], tt.sentHops)
+			}
+		})
+	}
+}
+
+func TestRelayLoopDropsAtMaxHops(t *testing.T) {
+	mesh := newMemNetwork()
+	relaying := func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.relay = true
+		qn.qc.nodeInterface.maxHops = 4
+	}
+	// Each node routes 10.9.0.0/16 to the next one, so packets to it go round A, B, C
+	c := startTestNode(t, "127.0.0.3", "10.0.0.3", relaying)
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", relaying, NewPeer(c.udpConn.LocalAddr().String(), "10.0.0.3/32", "10.9.0.0/16"))
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", relaying, NewPeer(b.udpConn.LocalAddr().String(), "10.0.0.2/32", "10.9.0.0/16"))
+	if err := c.AddPeer(NewPeer(a.udpConn.LocalAddr().String(), "10.0.0.1/32", "10.9.0.0/16")); err != nil {
+		t.Fatal(err)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	waitPeerState(t, b.QuicWire, "10.0.0.3/32", PeerConnected)
+	waitPeerState(t, c.QuicWire, "10.0.0.1/32", PeerConnected)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.9.0.5:5000"), []byte("looping"))
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	// A sends with 4 hops, B, C and A relay it with 3, 2 and 1 left and B drops it
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for b.Stats().HopLimitDrops == 0 {
+		if ctx.Err() != nil {
+			t.Fatal("looping packet was not dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if drops := b.RecentDrops(); len(drops) != 1 || drops[0].Reason != dropHopLimit {
+		t.Errorf("drops = %+v, want one %s drop", drops, dropHopLimit)
+	}
+	for name, n := range map[string]*testNode{"A": a, "B": b, "C": c} {
+		if got := n.Stats().HopLimitDrops; n != b && got != 0 {
+			t.Errorf("%s dropped %d packets at the hop limit, want none", name, got)
+		}
+		if n.sink.Len() != 0 {
+			t.Errorf("looping packet reached the tunnel interface of %s", name)
+		}
+	}
+}
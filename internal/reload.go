@@ -0,0 +1,209 @@
+package quicwire
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+)
+
+// ReloadReport lists how Reload applied the peers of the config, by their first allowed IP
+type ReloadReport struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	// Updated peers changed in place and kept their connection, Redialed
+	// peers changed a setting of their connection and were dialed again
+	Updated  []string `json:"updated,omitempty"`
+	Redialed []string `json:"redialed,omitempty"`
+}
+
+// Reload reads the config sources again and applies the changes to the
+// peers: new peers are added, missing peers removed and changed peers
+// updated. Changes to the endpoint or the dial settings of a peer replace its
+// connection, other changes such as its allowed IPs, priority or ACL are
+// applied to the live connection. Peers are matched by their first allowed
+// IP, changes to the Interface section take effect on restart.
+func (qn *QuicWire) Reload() (ReloadReport, error) {
+	var report ReloadReport
+	if qn.routes == nil {
+		return report, fmt.Errorf("the node is not started")
+	}
+	next := &QuicConf{}
+	if err := qn.readConfig(next); err != nil {
+		return report, err
+	}
+
+	qn.mu.RLock()
+	var current []Peer
+	for _, peer := range qn.qc.peers {
+		if !peer.learned {
+			current = append(current, peer)
+		}
+	}
+	qn.mu.RUnlock()
+
+	configured := make(map[string]Peer, len(next.peers))
+	for _, peer := range next.peers {
+		configured[peer.allowedIPs[0]] = peer
+	}
+	var errs []error
+	// Remove first so allowed IPs moving to another peer are free
+	for _, peer := range current {
+		key := peer.allowedIPs[0]
+		if _, ok := configured[key]; ok {
+			continue
+		}
+		if err := qn.RemovePeer(key); err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %w", key, err))
+			continue
+		}
+		report.Removed = append(report.Removed, key)
+	}
+	previous := make(map[string]Peer, len(current))
+	for _, peer := range current {
+		previous[peer.allowedIPs[0]] = peer
+	}
+	for _, peer := range next.peers {
+		key := peer.allowedIPs[0]
+		prev, ok := previous[key]
+		var err error
+		switch {
+		case !ok:
+			if err = qn.AddPeer(peer); err == nil {
+				report.Added = append(report.Added, key)
+			}
+		case reflect.DeepEqual(prev, peer):
+		case peerConnChanged(prev, peer):
+			if err = qn.RemovePeer(key); err == nil {
+				if err = qn.AddPeer(peer); err == nil {
+					report.Redialed = append(report.Redialed, key)
+				}
+			}
+		default:
+			if err = qn.updatePeer(prev, peer); err == nil {
+				report.Updated = append(report.Updated, key)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("peer %s: %w", key, err))
+		}
+	}
+
+	qn.logger.Infof("Reloaded the config: %d peers added, %d removed, %d updated and %d redialed",
+		len(report.Added), len(report.Removed), len(report.Updated), len(report.Redialed))
+	return report, errors.Join(errs...)
+}
+
+// peerConnChanged reports whether the settings the connection to the peer
+// was dialed with differ, the connection must be replaced to apply them
+func peerConnChanged(prev, next Peer) bool {
+	return prev.endpoint != next.endpoint ||
+		prev.addressFamily != next.addressFamily ||
+		prev.persistentKeepalive != next.persistentKeepalive ||
+		prev.maxInFlight != next.maxInFlight ||
+		prev.dialParallelism != next.dialParallelism ||
+		prev.candidateTimeout != next.candidateTimeout
+}
+
+// updatePeer applies the changes of a peer that keep its connection: its
+// routes, ACL, DSCP, masquerading and log level
+func (qn *QuicWire) updatePeer(prev, next Peer) error {
+	key := next.allowedIPs[0]
+	if err := qn.routes.replacePeer(next); err != nil {
+		return err
+	}
+	if qn.localIf != nil {
+		qn.removePeerRoutes(qn.localIf.Name(), Peer{allowedIPs: missing(prev.allowedIPs, next.allowedIPs)})
+		if err := qn.installPeerRoutes(qn.localIf.Name(), next); err != nil {
+			return err
+		}
+	}
+	if prev.masquerade {
+		removed := prev.allowedIPs
+		if next.masquerade {
+			removed = missing(prev.allowedIPs, next.allowedIPs)
+		}
+		qn.removeMasquerade(removed)
+	}
+	if outIf := qn.qc.nodeInterface.masqueradeInterface; next.masquerade && outIf != "" {
+		for _, source := range next.allowedIPs {
+			if err := qn.addMasquerade(source, outIf); err != nil {
+				return err
+			}
+		}
+	}
+
+	if prev.logLevel != next.logLevel {
+		if err := qn.peerLevels.set(key, next.logLevel); err != nil {
+			return err
+		}
+	}
+
+	qn.mu.Lock()
+	for i, peer := range qn.qc.peers {
+		if peer.allowedIPs[0] == key {
+			qn.qc.peers[i] = next
+		}
+	}
+	c := qn.clients[key]
+	qn.mu.Unlock()
+	if c != nil {
+		c.SetACL(next.acl)
+		if c.connection != nil {
+			qn.markPeerDSCP(c.connection.RemoteAddr(), next)
+		}
+	}
+	qn.logger.Infof("Updated peer %s [ %s ] in place", next.endpoint, key)
+	return nil
+}
+
+// missing returns the entries of a that are not in b
+func missing(a, b []string) []string {
+	var out []string
+	for _, s := range a {
+		found := false
+		for _, t := range b {
+			found = found || s == t
+		}
+		if !found {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// replacePeer replaces the routes to the peer with the routes of its allowed
+// IPs at once, so packets to the unchanged prefixes keep being routed
+func (rt *routeTable) replacePeer(peer Peer) error {
+	next, err := newRouteTable([]Peer{peer})
+	if err != nil {
+		return err
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	entries := rt.entries[:0]
+	for _, e := range rt.entries {
+		if e.peer != peer.allowedIPs[0] {
+			entries = append(entries, e)
+		}
+	}
+	rt.entries = append(entries, next.entries...)
+	rt.sort()
+	rt.reindex()
+	return nil
+}
+
+// serveReload runs Reload for POST requests and returns the report
+func (qn *QuicWire) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "reload requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := qn.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, report)
+}
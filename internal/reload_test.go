@@ -0,0 +1,66 @@
+package quicwire
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestReloadAllowedIPsKeepsConnection(t *testing.T) {
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	conf := filepath.Join(t.TempDir(), "quicwire.conf")
+	writeConf := func(allowedIPs string) {
+		t.Helper()
+		err := os.WriteFile(conf, []byte("[Interface]\nLocalEndpoint = 10.0.0.1/24\n\n[Peer]\nEndpoint = "+
+			b.udpConn.LocalAddr().String()+"\nAllowedIPs = "+allowedIPs+"\n"), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConf("10.0.0.2/32")
+	var qc QuicConf
+	if err := readQuicConfLayers(&qc, []string{conf}, nil, "", defaultConfLimits, nil); err != nil {
+		t.Fatal(err)
+	}
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.configFile = conf
+	}, qc.peers...)
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	a.mu.RLock()
+	c := a.clients["10.0.0.2/32"]
+	a.mu.RUnlock()
+
+	writeConf("10.0.0.2/32,10.7.0.0/16")
+	report, err := a.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(report.Updated, ",") != "10.0.0.2/32" || len(report.Redialed) != 0 {
+		t.Fatalf("report = %+v, want the peer updated in place", report)
+	}
+	a.mu.RLock()
+	after := a.clients["10.0.0.2/32"]
+	a.mu.RUnlock()
+	if after != c || c.connection.Context().Err() != nil {
+		t.Fatal("reload replaced the connection of the peer")
+	}
+
+	// The added prefix is routed over the same connection
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.7.0.5:5000"), []byte("new route"))
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := b.sink.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+}
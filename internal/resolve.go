@@ -0,0 +1,79 @@
+package quicwire
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver resolves the hosts of peer endpoints. Resolvers knowing the TTL
+// of the records return it, a TTL of 0 falls back to the configured
+// ResolveInterval.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error)
+}
+
+// systemResolver is the Resolver backed by the Go resolver, it does not expose TTLs
+type systemResolver struct{}
+
+// DefaultResolver returns the Resolver backed by the system resolver
+func DefaultResolver() Resolver {
+	return systemResolver{}
+}
+
+func (systemResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	return addrs, 0, err
+}
+
+// resolveEntry is a cached resolution of a host
+type resolveEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+// resolveCache is a read-through cache of resolved endpoint hosts. Entries
+// are kept until the TTL of their records expires, or for interval when the
+// resolver does not tell the TTL. Without either every lookup resolves.
+type resolveCache struct {
+	resolver Resolver
+	interval time.Duration
+	mu       sync.Mutex
+	entries  map[string]resolveEntry
+}
+
+func newResolveCache(resolver Resolver, interval time.Duration) *resolveCache {
+	return &resolveCache{
+		resolver: resolver,
+		interval: interval,
+		entries:  make(map[string]resolveEntry),
+	}
+}
+
+// lookup returns the addresses of host, resolving it when no unexpired entry is cached
+func (rc *resolveCache) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := time.Now()
+	rc.mu.Lock()
+	e, ok := rc.entries[host]
+	rc.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	addrs, ttl, err := rc.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = rc.interval
+	}
+	rc.mu.Lock()
+	if ttl > 0 {
+		rc.entries[host] = resolveEntry{addrs: addrs, expires: now.Add(ttl)}
+	} else {
+		delete(rc.entries, host)
+	}
+	rc.mu.Unlock()
+	return addrs, nil
+}
@@ -0,0 +1,57 @@
+package quicwire
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingResolver is a staticResolver counting the lookups it answers
+type countingResolver struct {
+	staticResolver
+	lookups atomic.Int32
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, time.Duration, error) {
+	r.lookups.Add(1)
+	return r.staticResolver.LookupIPAddr(ctx, host)
+}
+
+func TestResolveCacheExpiry(t *testing.T) {
+	addrs := []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}}
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		interval time.Duration
+		// cached is whether the entry outlives the wait
+		cached bool
+	}{
+		{name: "short TTL within a long interval", ttl: 50 * time.Millisecond, interval: time.Hour},
+		{name: "long TTL", ttl: time.Hour, interval: 50 * time.Millisecond, cached: true},
+		{name: "no TTL falls back to the interval", interval: time.Hour, cached: true},
+		{name: "no TTL and no interval"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &countingResolver{staticResolver: staticResolver{addrs: addrs, ttl: tt.ttl}}
+			rc := newResolveCache(resolver, tt.interval)
+			ctx := context.Background()
+			if _, err := rc.lookup(ctx, "peer.example"); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			if _, err := rc.lookup(ctx, "peer.example"); err != nil {
+				t.Fatal(err)
+			}
+			want := int32(2)
+			if tt.cached {
+				want = 1
+			}
+			if got := resolver.lookups.Load(); got != want {
+				t.Errorf("resolved %d times, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package quicwire
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// defaultCRLRefresh is how often the CRL file is reloaded
+	defaultCRLRefresh = time.Hour
+	// ocspTimeout bounds a request to the OCSP responder
+	ocspTimeout = 5 * time.Second
+)
+
+// Revocation enforcement modes
+const (
+	// revocationSoftFail accepts certificates whose revocation status can not be determined
+	revocationSoftFail = "soft-fail"
+	// revocationHardFail refuses certificates whose revocation status can not be determined
+	revocationHardFail = "hard-fail"
+)
+
+// errCertRevoked is returned when a peer presents a revoked certificate
+var errCertRevoked = errors.New("peer certificate is revoked")
+
+// revocationChecker checks peer certificates against a CRL file, reloaded
+// every refresh, and an OCSP responder
+type revocationChecker struct {
+	crlPath  string
+	refresh  time.Duration
+	ocspURL  string
+	hardFail bool
+	client   *http.Client
+
+	mu       sync.Mutex
+	revoked  map[string]bool
+	loadedAt time.Time
+	loadErr  error
+}
+
+func newRevocationChecker(crlPath string, refresh time.Duration, ocspURL string, mode string) *revocationChecker {
+	if refresh <= 0 {
+		refresh = defaultCRLRefresh
+	}
+	return &revocationChecker{
+		crlPath:  crlPath,
+		refresh:  refresh,
+		ocspURL:  ocspURL,
+		hardFail: mode == revocationHardFail,
+		client:   &http.Client{Timeout: ocspTimeout},
+	}
+}
+
+// loadCRL reads the revoked serial numbers from the CRL file, PEM or DER encoded
+func (rc *revocationChecker) loadCRL() (map[string]bool, error) {
+	data, err := os.ReadFile(rc.crlPath)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL %s: %w", rc.crlPath, err)
+	}
+	revoked := make(map[string]bool, len(crl.RevokedCertificates))
+	for _, entry := range crl.RevokedCertificates {
+		revoked[entry.SerialNumber.String()] = true
+	}
+	return revoked, nil
+}
+
+// checkCRL looks the certificate up in the CRL, reloading it when it is stale
+func (rc *revocationChecker) checkCRL(cert *x509.Certificate) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if time.Since(rc.loadedAt) > rc.refresh {
+		revoked, err := rc.loadCRL()
+		rc.loadedAt = time.Now()
+		rc.loadErr = err
+		if err == nil {
+			rc.revoked = revoked
+		}
+	}
+	if rc.revoked == nil && rc.loadErr != nil {
+		return rc.loadErr
+	}
+	if rc.revoked[cert.SerialNumber.String()] {
+		return fmt.Errorf("serial %s is listed in CRL %s: %w", cert.SerialNumber, rc.crlPath, errCertRevoked)
+	}
+	return nil
+}
+
+// checkOCSP asks the OCSP responder for the status of the certificate
+func (rc *revocationChecker) checkOCSP(cert, issuer *x509.Certificate) error {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := rc.client.Post(rc.ocspURL, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return fmt.Errorf("OCSP request to %s failed: %w", rc.ocspURL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	status, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return fmt.Errorf("invalid OCSP response from %s: %w", rc.ocspURL, err)
+	}
+	switch status.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return fmt.Errorf("serial %s revoked at %s according to %s: %w", cert.SerialNumber, status.RevokedAt, rc.ocspURL, errCertRevoked)
+	default:
+		return fmt.Errorf("OCSP responder %s does not know serial %s", rc.ocspURL, cert.SerialNumber)
+	}
+}
+
+// verifyPeerCertificate is a tls.Config.VerifyPeerCertificate callback.
+// Revoked certificates are always refused, certificates whose status can not
+// be determined only in hard-fail mode. The OCSP request names the issuer of
+// the verified chain, or the certificate following the leaf when the chain
+// was not verified, a lone certificate has no issuer to ask about.
+func (rc *revocationChecker) verifyPeerCertificate(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	var issuer *x509.Certificate
+	switch {
+	case len(chains) > 0 && len(chains[0]) > 1:
+		issuer = chains[0][1]
+	case len(rawCerts) > 1:
+		if issuer, err = x509.ParseCertificate(rawCerts[1]); err != nil {
+			return err
+		}
+	}
+
+	var checks []error
+	if rc.crlPath != "" {
+		checks = append(checks, rc.checkCRL(cert))
+	}
+	if rc.ocspURL != "" {
+		if issuer == nil {
+			checks = append(checks, fmt.Errorf("issuer of serial %s is unknown, OCSP can not be asked", cert.SerialNumber))
+		} else {
+			checks = append(checks, rc.checkOCSP(cert, issuer))
+		}
+	}
+	for _, err := range checks {
+		if errors.Is(err, errCertRevoked) {
+			return err
+		}
+	}
+	for _, err := range checks {
+		if err != nil && rc.hardFail {
+			return fmt.Errorf("revocation status of the peer certificate is unknown: %w", err)
+		}
+	}
+	return nil
+}
+
+// setupRevocation enables revocation checks of peer certificates as configured
+func (qn *QuicWire) setupRevocation() {
+	ni := qn.qc.nodeInterface
+	if ni.revocationCRL == "" && ni.revocationOCSP == "" {
+		return
+	}
+	qn.revocation = newRevocationChecker(ni.revocationCRL, ni.revocationCRLRefresh, ni.revocationOCSP, ni.revocationMode)
+}
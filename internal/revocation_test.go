@@ -0,0 +1,123 @@
+package quicwire
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA issues leaf certificates and revocation lists
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "quicwire test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a DER encoded leaf certificate with the serial number
+func (ca *testCA) issue(t *testing.T, serial int64) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "quicwire test peer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// writeCRL writes a PEM encoded CRL revoking the serial numbers and returns its path
+func (ca *testCA) writeCRL(t *testing.T, serials ...int64) string {
+	t.Helper()
+	var revoked []pkix.RevokedCertificate
+	for _, serial := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          time.Now(),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: revoked,
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "peers.crl")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRevocationCRL(t *testing.T) {
+	ca := newTestCA(t)
+	crl := ca.writeCRL(t, 3)
+	missing := filepath.Join(t.TempDir(), "missing.crl")
+	tests := []struct {
+		name    string
+		crl     string
+		mode    string
+		serial  int64
+		revoked bool
+		err     bool
+	}{
+		{name: "valid", crl: crl, serial: 2},
+		{name: "revoked", crl: crl, serial: 3, revoked: true, err: true},
+		{name: "revoked in hard-fail mode", crl: crl, mode: revocationHardFail, serial: 3, revoked: true, err: true},
+		{name: "unreadable CRL soft-fail", crl: missing, mode: revocationSoftFail, serial: 2},
+		{name: "unreadable CRL hard-fail", crl: missing, mode: revocationHardFail, serial: 2, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := newRevocationChecker(tt.crl, 0, "", tt.mode)
+			err := rc.verifyPeerCertificate([][]byte{ca.issue(t, tt.serial), ca.cert.Raw}, nil)
+			if (err != nil) != tt.err {
+				t.Fatalf("error = %v, want error %v", err, tt.err)
+			}
+			if errors.Is(err, errCertRevoked) != tt.revoked {
+				t.Errorf("error = %v, want revoked %v", err, tt.revoked)
+			}
+		})
+	}
+}
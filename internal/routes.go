@@ -0,0 +1,329 @@
+package quicwire
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// routeEntry says a prefix is reachable through a peer, identified by its first allowed IP
+type routeEntry struct {
+	prefix   netip.Prefix
+	peer     string
+	priority int
+}
+
+// routeTable resolves destinations to peers by longest prefix match. Among
+// the peers serving the longest matching prefix the ones with the highest
+// priority are used, equal priority peers share the flows (ECMP). Peers that
+// were migrated away from or are congested are only used when no other peer
+// is usable.
+type routeTable struct {
+	mu      sync.RWMutex
+	entries []routeEntry
+	// byPrefix indexes the entries in lookup order by prefix, lengths holds
+	// the prefix lengths routed, longest first. A lookup probes one prefix
+	// per length instead of scanning every entry.
+	byPrefix map[netip.Prefix][]routeEntry
+	lengths  []int
+	avoid    map[string]bool
+	// congested are the peers de-preferred for the loss or RTT of their connection, see watchCongestion
+	congested map[string]bool
+	// single is the peer every route goes to, empty when several peers are routed
+	single string
+}
+
+func newRouteTable(peers []Peer) (*routeTable, error) {
+	rt := &routeTable{avoid: make(map[string]bool), congested: make(map[string]bool)}
+	for _, peer := range peers {
+		if err := rt.addPeer(peer); err != nil {
+			return nil, err
+		}
+	}
+	return rt, nil
+}
+
+// addPeer adds a route to the peer for each of its allowed IPs
+func (rt *routeTable) addPeer(peer Peer) error {
+	var entries []routeEntry
+	for _, allowedIP := range peer.allowedIPs {
+		cidr, err := routePrefix(allowedIP)
+		if err != nil {
+			return err
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid allowed IP %s: %w", allowedIP, err)
+		}
+		entries = append(entries, routeEntry{
+			prefix:   prefix,
+			peer:     peer.allowedIPs[0],
+			priority: peer.priority,
+		})
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.entries = append(rt.entries, entries...)
+	rt.sort()
+	rt.reindex()
+	return nil
+}
+
+// removePeer removes every route to the peer
+func (rt *routeTable) removePeer(peer string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	entries := rt.entries[:0]
+	for _, e := range rt.entries {
+		if e.peer != peer {
+			entries = append(entries, e)
+		}
+	}
+	rt.entries = entries
+	delete(rt.avoid, peer)
+	delete(rt.congested, peer)
+	rt.reindex()
+}
+
+// reindex rebuilds the prefix index from the sorted entries and records
+// whether every route goes to the same peer, it must be called after every
+// change of the entries
+func (rt *routeTable) reindex() {
+	rt.byPrefix = make(map[netip.Prefix][]routeEntry, len(rt.entries))
+	rt.lengths = rt.lengths[:0]
+	for _, e := range rt.entries {
+		if n := len(rt.lengths); n == 0 || rt.lengths[n-1] != e.prefix.Bits() {
+			rt.lengths = append(rt.lengths, e.prefix.Bits())
+		}
+		rt.byPrefix[e.prefix] = append(rt.byPrefix[e.prefix], e)
+	}
+	rt.updateSingle()
+}
+
+// updateSingle records whether every route goes to the same peer
+func (rt *routeTable) updateSingle() {
+	rt.single = ""
+	for _, e := range rt.entries {
+		if rt.single != "" && e.peer != rt.single {
+			rt.single = ""
+			return
+		}
+		rt.single = e.peer
+	}
+}
+
+// matching returns the entries of the prefix of the given length containing dst
+func (rt *routeTable) matching(dst netip.Addr, bits int) []routeEntry {
+	if bits > dst.BitLen() {
+		return nil
+	}
+	prefix, err := dst.Prefix(bits)
+	if err != nil {
+		return nil
+	}
+	return rt.byPrefix[prefix]
+}
+
+// lookupSingle is the fast path of lookup for the common case of a single
+// peer, it skips the flow hashing and the per prefix peer selection. single
+// is false when several peers are routed and lookup must be used instead.
+func (rt *routeTable) lookupSingle(dst netip.Addr) (peer string, ok bool, single bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	if rt.single == "" {
+		return "", false, false
+	}
+	for _, bits := range rt.lengths {
+		if len(rt.matching(dst, bits)) > 0 {
+			return rt.single, true, true
+		}
+	}
+	return "", false, true
+}
+
+// overlapping returns a routed prefix overlapping the prefix, whether equal,
+// more specific or broader
+func (rt *routeTable) overlapping(prefix netip.Prefix) (netip.Prefix, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	for _, e := range rt.entries {
+		if e.prefix.Overlaps(prefix) {
+			return e.prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// sort orders the entries by prefix length, longest first, then by priority
+func (rt *routeTable) sort() {
+	sort.SliceStable(rt.entries, func(i, j int) bool {
+		a, b := rt.entries[i], rt.entries[j]
+		if a.prefix.Bits() != b.prefix.Bits() {
+			return a.prefix.Bits() > b.prefix.Bits()
+		}
+		return a.priority > b.priority
+	})
+}
+
+// lookup returns the peer to forward a flow to. usable reports whether a peer
+// can currently take traffic, prefixes without a usable peer fall through to
+// shorter ones.
+func (rt *routeTable) lookup(dst netip.Addr, flowHash uint32, usable func(peer string) bool) (string, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	var preferred, avoided []string
+	for _, bits := range rt.lengths {
+		// Collect the entries of the prefix of the next length containing dst
+		preferred, avoided = preferred[:0], avoided[:0]
+		bestPreferred, bestAvoided := 0, 0
+		for _, e := range rt.matching(dst, bits) {
+			if !usable(e.peer) {
+				continue
+			}
+			if rt.avoid[e.peer] || rt.congested[e.peer] {
+				if len(avoided) == 0 || e.priority == bestAvoided {
+					bestAvoided = e.priority
+					avoided = append(avoided, e.peer)
+				}
+				continue
+			}
+			if len(preferred) == 0 || e.priority == bestPreferred {
+				bestPreferred = e.priority
+				preferred = append(preferred, e.peer)
+			}
+		}
+		if len(preferred) > 0 {
+			return preferred[flowHash%uint32(len(preferred))], true
+		}
+		if len(avoided) > 0 {
+			return avoided[flowHash%uint32(len(avoided))], true
+		}
+	}
+	return "", false
+}
+
+// setCongested marks a peer as congested, or not congested any more
+func (rt *routeTable) setCongested(peer string, congested bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if congested {
+		rt.congested[peer] = true
+	} else {
+		delete(rt.congested, peer)
+	}
+}
+
+// setAvoid marks a peer as not preferred, or preferred again
+func (rt *routeTable) setAvoid(peer string, avoid bool) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if avoid {
+		rt.avoid[peer] = true
+	} else {
+		delete(rt.avoid, peer)
+	}
+}
+
+// MigrateAwayFrom moves the flows routed through the peer with the given
+// allowed IP to the next best peer serving the same destinations. The peer
+// keeps forwarding destinations nobody else serves and stays not preferred
+// until ClearMigration is called.
+func (qn *QuicWire) MigrateAwayFrom(allowedIP string) error {
+	peer, ok := qn.peerByAllowedIP(allowedIP)
+	if !ok {
+		return fmt.Errorf("no peer with allowed IP %s", allowedIP)
+	}
+	qn.routes.setAvoid(peer.allowedIPs[0], true)
+	qn.logger.Infof("Migrating flows away from peer %s [ %s ]", peer.endpoint, peer.allowedIPs[0])
+	return nil
+}
+
+// ClearMigration makes a peer previously passed to MigrateAwayFrom preferred again
+func (qn *QuicWire) ClearMigration(allowedIP string) error {
+	peer, ok := qn.peerByAllowedIP(allowedIP)
+	if !ok {
+		return fmt.Errorf("no peer with allowed IP %s", allowedIP)
+	}
+	qn.routes.setAvoid(peer.allowedIPs[0], false)
+	qn.logger.Infof("Peer %s [ %s ] is preferred again", peer.endpoint, peer.allowedIPs[0])
+	return nil
+}
+
+// peerByAllowedIP finds the configured peer owning an allowed IP
+func (qn *QuicWire) peerByAllowedIP(allowedIP string) (Peer, bool) {
+	qn.mu.RLock()
+	defer qn.mu.RUnlock()
+	for _, peer := range qn.qc.peers {
+		for _, ip := range peer.allowedIPs {
+			if strings.TrimSpace(ip) == allowedIP {
+				return peer, true
+			}
+		}
+	}
+	return Peer{}, false
+}
+
+// RouteStatus is an entry of the routing table used to forward packets
+type RouteStatus struct {
+	Prefix   string `json:"prefix"`
+	Peer     string `json:"peer"`
+	Endpoint string `json:"endpoint"`
+	Priority int    `json:"priority"`
+	// Avoided is set for peers migrated away from, they only take traffic no other peer can
+	Avoided bool `json:"avoided,omitempty"`
+	// Congested is set for peers de-preferred for the loss or RTT of their connection, like Avoided
+	Congested bool `json:"congested,omitempty"`
+	// Learned is set for routes adopted from peer announcements
+	Learned bool `json:"learned,omitempty"`
+}
+
+// entriesSnapshot returns a copy of the entries in lookup order, the avoided and the congested peers
+func (rt *routeTable) entriesSnapshot() ([]routeEntry, map[string]bool, map[string]bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	avoid := make(map[string]bool, len(rt.avoid))
+	for peer := range rt.avoid {
+		avoid[peer] = true
+	}
+	congested := make(map[string]bool, len(rt.congested))
+	for peer := range rt.congested {
+		congested[peer] = true
+	}
+	return append([]routeEntry(nil), rt.entries...), avoid, congested
+}
+
+// Routes returns the routing table in the order lookups resolve it: longest
+// prefix first, then highest priority. Peers with the same prefix and
+// priority share the flows.
+func (qn *QuicWire) Routes() []RouteStatus {
+	if qn.routes == nil {
+		return nil
+	}
+	entries, avoid, congested := qn.routes.entriesSnapshot()
+
+	qn.mu.RLock()
+	peers := make(map[string]Peer, len(qn.qc.peers))
+	for _, peer := range qn.qc.peers {
+		peers[peer.allowedIPs[0]] = peer
+	}
+	qn.mu.RUnlock()
+
+	routes := make([]RouteStatus, 0, len(entries))
+	for _, e := range entries {
+		peer := peers[e.peer]
+		routes = append(routes, RouteStatus{
+			Prefix:    e.prefix.String(),
+			Peer:      e.peer,
+			Endpoint:  peer.endpoint,
+			Priority:  e.priority,
+			Avoided:   avoid[e.peer],
+			Congested: congested[e.peer],
+			Learned:   peer.learned,
+		})
+	}
+	return routes
+}
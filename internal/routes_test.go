@@ -0,0 +1,253 @@
+package quicwire
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+)
+
+func allUsable(string) bool { return true }
+
+func TestRouteTableLookup(t *testing.T) {
+	rt, err := newRouteTable([]Peer{
+		{allowedIPs: []string{"10.0.0.1", "10.0.0.0/8"}},
+		{allowedIPs: []string{"10.0.0.2", "10.1.0.0/16"}},
+		{allowedIPs: []string{"10.0.0.3", "10.1.2.0/24"}, priority: 1},
+		{allowedIPs: []string{"10.0.0.4", "10.1.2.0/24"}},
+		{allowedIPs: []string{"fd00::1", "fd00::/64"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		dst  string
+		peer string
+		ok   bool
+	}{
+		{dst: "10.0.0.1", peer: "10.0.0.1", ok: true},
+		{dst: "10.0.0.2", peer: "10.0.0.2", ok: true},
+		{dst: "10.9.9.9", peer: "10.0.0.1", ok: true},
+		{dst: "10.1.9.9", peer: "10.0.0.2", ok: true},
+		// The higher priority wins among the peers of the longest prefix
+		{dst: "10.1.2.3", peer: "10.0.0.3", ok: true},
+		{dst: "fd00::5", peer: "fd00::1", ok: true},
+		{dst: "192.168.1.1", ok: false},
+		{dst: "fd01::1", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dst, func(t *testing.T) {
+			peer, ok := rt.lookup(netip.MustParseAddr(tt.dst), 0, allUsable)
+			if ok != tt.ok || peer != tt.peer {
+				t.Errorf("lookup(%s) = %q, %v, want %q, %v", tt.dst, peer, ok, tt.peer, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRouteTableLookupFallsThrough(t *testing.T) {
+	rt, err := newRouteTable([]Peer{
+		{allowedIPs: []string{"10.0.0.1", "10.0.0.0/8"}},
+		{allowedIPs: []string{"10.0.0.2", "10.1.0.0/16"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := netip.MustParseAddr("10.1.1.1")
+	usable := func(peer string) bool { return peer != "10.0.0.2" }
+	if peer, ok := rt.lookup(dst, 0, usable); !ok || peer != "10.0.0.1" {
+		t.Errorf("lookup with the /16 peer down = %q, %v, want the /8 peer", peer, ok)
+	}
+
+	rt.setAvoid("10.0.0.2", true)
+	if peer, _ := rt.lookup(dst, 0, allUsable); peer != "10.0.0.2" {
+		t.Errorf("lookup with the only /16 peer avoided = %q, want it used anyway", peer)
+	}
+
+	rt.removePeer("10.0.0.2")
+	if peer, _ := rt.lookup(dst, 0, allUsable); peer != "10.0.0.1" {
+		t.Errorf("lookup after removing the /16 peer = %q, want the /8 peer", peer)
+	}
+}
+
+func TestRouteTableLookupSingle(t *testing.T) {
+	rt, err := newRouteTable([]Peer{{allowedIPs: []string{"10.0.0.1", "10.2.0.0/16"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer, ok, single := rt.lookupSingle(netip.MustParseAddr("10.2.3.4")); !single || !ok || peer != "10.0.0.1" {
+		t.Errorf("lookupSingle = %q, %v, %v", peer, ok, single)
+	}
+	if _, ok, single := rt.lookupSingle(netip.MustParseAddr("10.3.0.1")); !single || ok {
+		t.Errorf("lookupSingle of an unrouted address = %v, %v", ok, single)
+	}
+	if err := rt.addPeer(Peer{allowedIPs: []string{"10.0.0.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, single := rt.lookupSingle(netip.MustParseAddr("10.2.3.4")); single {
+		t.Error("lookupSingle reports a single peer with two peers routed")
+	}
+}
+
+func TestRouteTableOverlapping(t *testing.T) {
+	rt, err := newRouteTable([]Peer{{allowedIPs: []string{"10.0.0.1", "10.1.0.0/16"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prefix  string
+		overlap bool
+	}{
+		{prefix: "10.1.0.0/16", overlap: true},
+		{prefix: "10.1.2.0/24", overlap: true},
+		{prefix: "10.0.0.0/8", overlap: true},
+		{prefix: "10.0.0.1/32", overlap: true},
+		{prefix: "10.2.0.0/16", overlap: false},
+		{prefix: "fd00::/64", overlap: false},
+	}
+	for _, tt := range tests {
+		if _, ok := rt.overlapping(netip.MustParsePrefix(tt.prefix)); ok != tt.overlap {
+			t.Errorf("overlapping(%s) = %v, want %v", tt.prefix, ok, tt.overlap)
+		}
+	}
+}
+
+// benchRouteTable routes a /24 of 10.0.0.0/8 to each of n peers
+func benchRouteTable(b *testing.B, n int) *routeTable {
+	peers := make([]Peer, 0, n)
+	for i := 0; i < n; i++ {
+		peers = append(peers, Peer{allowedIPs: []string{
+			fmt.Sprintf("10.%d.%d.1", i/256, i%256),
+			fmt.Sprintf("10.%d.%d.0/24", i/256, i%256),
+		}})
+	}
+	rt, err := newRouteTable(peers)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return rt
+}
+
+// lookupLinear is the scan over every entry lookup used before the prefix index
+func (rt *routeTable) lookupLinear(dst netip.Addr) (string, bool) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	for _, e := range rt.entries {
+		if e.prefix.Contains(dst) {
+			return e.peer, true
+		}
+	}
+	return "", false
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, n := range []int{1, 16, 256, 4096} {
+		rt := benchRouteTable(b, n)
+		// The route of the last peer is found last by a scan
+		dst := netip.MustParseAddr(fmt.Sprintf("10.%d.%d.9", (n-1)/256, (n-1)%256))
+		b.Run(fmt.Sprintf("index/peers=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, ok := rt.lookup(dst, uint32(i), allUsable); !ok {
+					b.Fatal("no route")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("linear/peers=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, ok := rt.lookupLinear(dst); !ok {
+					b.Fatal("no route")
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateAwayFrom(t *testing.T) {
+	qn, _ := newTestQuicWire(t,
+		Peer{endpoint: "192.0.2.1:55381", allowedIPs: []string{"10.0.0.2", "10.9.0.0/16"}},
+		Peer{endpoint: "192.0.2.2:55381", allowedIPs: []string{"10.0.0.3", "10.9.0.0/16"}},
+	)
+	dst := netip.MustParseAddr("10.9.1.1")
+	// routeFlows returns the peer of each of a number of flows to dst
+	routeFlows := func() []string {
+		peers := make([]string, 16)
+		for i := range peers {
+			peers[i], _ = qn.routes.lookup(dst, uint32(i), allUsable)
+		}
+		return peers
+	}
+	before := routeFlows()
+	moved := 0
+	for _, peer := range before {
+		if peer == "10.0.0.2" {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(before) {
+		t.Fatalf("flows before the migration = %v, want them spread over both peers", before)
+	}
+
+	if err := qn.MigrateAwayFrom("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	for i, peer := range routeFlows() {
+		if peer != "10.0.0.3" {
+			t.Errorf("flow %d routed through %s while migrating away, want 10.0.0.3", i, peer)
+		}
+	}
+	// Destinations only the peer serves stay with it
+	if peer, ok := qn.routes.lookup(netip.MustParseAddr("10.0.0.2"), 0, allUsable); !ok || peer != "10.0.0.2" {
+		t.Errorf("own address of the migrated peer routed through %q, want 10.0.0.2", peer)
+	}
+
+	if err := qn.ClearMigration("10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	after := routeFlows()
+	for i := range before {
+		if after[i] != before[i] {
+			t.Errorf("flow %d routed through %s after clearing, want %s as before", i, after[i], before[i])
+		}
+	}
+	if err := qn.MigrateAwayFrom("10.0.0.9"); err == nil {
+		t.Error("migrating away from an unknown peer succeeded")
+	}
+}
+
+func TestRoutesReportLookupOrder(t *testing.T) {
+	qn, _ := newTestQuicWire(t,
+		Peer{endpoint: "192.0.2.1:51820", allowedIPs: []string{"10.0.0.1/32", "10.0.0.0/8"}},
+		Peer{endpoint: "192.0.2.2:51820", allowedIPs: []string{"10.0.0.2/32", "10.1.0.0/16"}},
+		Peer{endpoint: "192.0.2.3:51820", allowedIPs: []string{"10.0.0.3/32", "10.1.2.0/24"}},
+		Peer{endpoint: "192.0.2.4:51820", allowedIPs: []string{"10.0.0.4/32", "10.1.2.0/24"}, priority: 1},
+	)
+	// Longest prefix first, then the highest priority
+	want := []RouteStatus{
+		{Prefix: "10.0.0.4/32", Peer: "10.0.0.4/32", Endpoint: "192.0.2.4:51820", Priority: 1},
+		{Prefix: "10.0.0.1/32", Peer: "10.0.0.1/32", Endpoint: "192.0.2.1:51820"},
+		{Prefix: "10.0.0.2/32", Peer: "10.0.0.2/32", Endpoint: "192.0.2.2:51820"},
+		{Prefix: "10.0.0.3/32", Peer: "10.0.0.3/32", Endpoint: "192.0.2.3:51820"},
+		{Prefix: "10.1.2.0/24", Peer: "10.0.0.4/32", Endpoint: "192.0.2.4:51820", Priority: 1},
+		{Prefix: "10.1.2.0/24", Peer: "10.0.0.3/32", Endpoint: "192.0.2.3:51820"},
+		{Prefix: "10.1.0.0/16", Peer: "10.0.0.2/32", Endpoint: "192.0.2.2:51820"},
+		{Prefix: "10.0.0.0/8", Peer: "10.0.0.1/32", Endpoint: "192.0.2.1:51820"},
+	}
+	got := qn.Routes()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("routes =\n%v\nwant\n%v", got, want)
+	}
+
+	// The first reported route containing a destination is the one lookups pick
+	for _, dst := range []string{"10.0.0.3", "10.1.2.3", "10.1.9.9", "10.9.9.9"} {
+		addr := netip.MustParseAddr(dst)
+		var first string
+		for _, r := range got {
+			if netip.MustParsePrefix(r.Prefix).Contains(addr) {
+				first = r.Peer
+				break
+			}
+		}
+		if peer, ok := qn.routes.lookup(addr, 0, allUsable); !ok || peer != first {
+			t.Errorf("lookup of %s = %s, want %s, the first reported route", dst, peer, first)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package quicwire
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// readyPollInterval is how often WaitReady checks the required peers
+const readyPollInterval = 250 * time.Millisecond
+
+// WaitReady blocks until the node forwards traffic: Start created the tunnel
+// interface and every peer marked Required is connected. It returns the
+// error of ctx, or of the node's lifetime, when either ends first.
+func (qn *QuicWire) WaitReady(ctx context.Context) error {
+	return qn.waitFor(ctx, qn.ready)
+}
+
+// waitFor polls cond until it holds or ctx, or the node's lifetime, ends
+func (qn *QuicWire) waitFor(ctx context.Context, cond func() bool) error {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-qn.ctx.Done():
+			return qn.ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// ready reports whether the tunnel interface is up and the required peers are connected
+func (qn *QuicWire) ready() bool {
+	if qn.tun.Load() == nil {
+		return false
+	}
+	qn.mu.RLock()
+	defer qn.mu.RUnlock()
+	for _, peer := range qn.qc.peers {
+		if !peer.required {
+			continue
+		}
+		c, ok := qn.clients[peer.allowedIPs[0]]
+		if !ok || c.connection == nil || c.connection.Context().Err() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sdNotify sends a state to the systemd notification socket, it is a no-op
+// when the node does not run under systemd
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns how often systemd expects a watchdog ping, 0 when the watchdog is off
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// The watchdog may be meant for another process of the unit
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// startSDNotify tells systemd the node is ready once WaitReady returns and
+// pings its watchdog at half the configured interval until ctx is done
+func (qn *QuicWire) startSDNotify(ctx context.Context) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	go func() {
+		if err := qn.WaitReady(ctx); err != nil {
+			return
+		}
+		if err := sdNotify("READY=1"); err != nil {
+			qn.logger.Warnf("Failed to notify systemd of readiness: %v", err)
+			return
+		}
+		qn.logger.Info("Notified systemd of readiness")
+
+		interval := sdWatchdogInterval()
+		if interval == 0 {
+			return
+		}
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := sdNotify("WATCHDOG=1"); err != nil {
+					qn.noisyLog.Warnf("Failed to ping the systemd watchdog: %v", err)
+				}
+			}
+		}
+	}()
+}
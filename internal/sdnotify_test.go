@@ -0,0 +1,83 @@
+package quicwire
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/songgao/water"
+)
+
+// listenNotifySocket points NOTIFY_SOCKET at a datagram socket and returns it
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotify returns the next state sent to the socket, "" when none arrives within wait
+func readNotify(t *testing.T, conn *net.UnixConn, wait time.Duration) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(wait))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return ""
+		}
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSDNotifyReadyAndStopping(t *testing.T) {
+	notify := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	peer := newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2")
+	peer.required = true
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }, peer)
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	// The required peer is connected but the tunnel interface is missing
+	a.startSDNotify(a.ctx)
+	if got := readNotify(t, notify, 2*readyPollInterval); got != "" {
+		t.Fatalf("sent %q before the tunnel interface was up", got)
+	}
+	a.tun.Store(&water.Interface{ReadWriteCloser: &stubTun{mtu: 1500}})
+	if got := readNotify(t, notify, 5*time.Second); got != "READY=1" {
+		t.Fatalf("sent %q, want READY=1", got)
+	}
+	if got := readNotify(t, notify, 5*time.Second); got != "WATCHDOG=1" {
+		t.Fatalf("sent %q, want a watchdog ping", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	a.StopContext(ctx)
+	for {
+		got := readNotify(t, notify, 5*time.Second)
+		if got == "STOPPING=1" {
+			break
+		}
+		if got != "WATCHDOG=1" {
+			t.Fatalf("sent %q, want STOPPING=1", got)
+		}
+	}
+}
+
+func TestSDNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("notifying without systemd failed: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package quicwire
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+// isSelfEndpoint reports whether the peer endpoint resolves to this node: a
+// local address on the listen port, or the NAT binding learned through STUN
+func (qn *QuicWire) isSelfEndpoint(ctx context.Context, endpoint string) (bool, error) {
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return false, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return false, err
+	}
+	ipAddrs, err := qn.resolver.lookup(ctx, host)
+	if err != nil {
+		return false, err
+	}
+
+	binding := qn.publicEndpoint()
+	for _, ipAddr := range ipAddrs {
+		if binding != "" && net.JoinHostPort(ipAddr.IP.String(), portStr) == binding {
+			return true, nil
+		}
+	}
+	if port != qn.qc.nodeInterface.listenPort {
+		return false, nil
+	}
+
+	localAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false, err
+	}
+	for _, ipAddr := range ipAddrs {
+		if ipAddr.IP.IsLoopback() || ipAddr.IP.IsUnspecified() {
+			return true, nil
+		}
+		for _, addr := range localAddrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ipAddr.IP) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,35 @@
+package quicwire
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfEndpointNotDialed(t *testing.T) {
+	transport := &scriptedTransport{Transport: newMemNetwork().transport()}
+	logs := newObservedLogger()
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(transport)
+		qn.logger = logs.logger
+	})
+	self := a.udpConn.LocalAddr().String()
+	if err := a.AddPeer(newTestPeer(self, "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for logs.logs.FilterMessageSnippet("own listen address").Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no warning logged for a peer whose endpoint is the node itself")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	warning := logs.logs.FilterMessageSnippet("own listen address").All()[0].Message
+	if !strings.Contains(warning, self) || !strings.Contains(warning, "10.0.0.2/32") {
+		t.Errorf("warning %q does not name the peer %s [ 10.0.0.2/32 ]", warning, self)
+	}
+	if dials := transport.dials(); len(dials) != 0 {
+		t.Errorf("dialed %v, want no dials", dials)
+	}
+}
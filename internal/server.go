@@ -2,11 +2,14 @@ package quicwire
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
 	"github.com/songgao/water"
 	"go.uber.org/zap"
 )
@@ -16,7 +19,35 @@ type Server struct {
 	addr            string
 	tunnelInterface *water.Interface
 	handler         Handler
-	logger          *zap.SugaredLogger
+	// alpnHandlers process the packets of connections that negotiated the given protocol
+	alpnHandlers map[string]Handler
+	alpnOrder    []string
+	tracer       logging.Tracer
+	transport    Transport
+	dropped      func(peer string, reason string, size int, packet []byte)
+	control      func(sent bool, size int)
+	limiter      *sourceLimiter
+	cpus         []int
+	// idleTimeout and noKeepAlive configure idling of accepted connections, see Client.SetIdleTimeout
+	idleTimeout time.Duration
+	noKeepAlive bool
+	// flushInterval and maxBatchBytes configure batching on accepted connections
+	flushInterval time.Duration
+	maxBatchBytes int
+	// statelessRetry validates the source address of new connections with a retry
+	statelessRetry bool
+	// ticketKeys are the session ticket keys shared by a cluster of servers,
+	// the first encrypts. Without them a random key is rotated every ticketRotation.
+	ticketKeys     [][32]byte
+	ticketRotation time.Duration
+	// budget accounts the buffers of accepted connections
+	budget *bufferBudget
+	logger *zap.SugaredLogger
+
+	// certificate is presented to peers, a self-signed one is generated when
+	// nil. verifyPeer requires and checks the certificates of the peers.
+	certificate *tls.Certificate
+	verifyPeer  func([][]byte, [][]*x509.Certificate) error
 }
 
 // NewServer creates a new server that listen on given port for incoming QUIC connections
@@ -24,55 +55,287 @@ func NewServer(addr string, tunIface *water.Interface, logger *zap.SugaredLogger
 	return &Server{
 		addr:            addr,
 		tunnelInterface: tunIface,
+		transport:       DefaultTransport(),
 		logger:          logger,
 	}
 }
 
+// SetDropHandler sets the function told about packets dropped on accepted connections
+func (s *Server) SetDropHandler(dropped func(peer string, reason string, size int, packet []byte)) {
+	s.dropped = dropped
+}
+
+// SetControlHandler sets the function told about the control traffic of
+// accepted connections, see Client.SetControlHandler
+func (s *Server) SetControlHandler(control func(sent bool, size int)) {
+	s.control = control
+}
+
+// SetTransport sets the transport accepting connections
+func (s *Server) SetTransport(transport Transport) {
+	s.transport = transport
+}
+
 // SetHandler sets the handler to process incoming packets
 func (s *Server) SetHandler(handler Handler) {
 	s.handler = handler
 }
 
-// StartServer starts the server and listens for incoming connections
+// HandleALPN sets the handler for connections negotiating the given
+// application protocol. Protocols are offered in registration order ahead of
+// the default one, connections negotiating the default protocol use the
+// handler set by SetHandler.
+func (s *Server) HandleALPN(proto string, handler Handler) {
+	if s.alpnHandlers == nil {
+		s.alpnHandlers = make(map[string]Handler)
+	}
+	if _, ok := s.alpnHandlers[proto]; !ok && proto != defaultALPN {
+		s.alpnOrder = append(s.alpnOrder, proto)
+	}
+	s.alpnHandlers[proto] = handler
+}
+
+// handlerFor returns the handler for the negotiated application protocol
+func (s *Server) handlerFor(proto string) Handler {
+	if handler, ok := s.alpnHandlers[proto]; ok {
+		return handler
+	}
+	return s.handler
+}
+
+// tlsConfig returns the server TLS config offering every registered protocol
+func (s *Server) tlsConfig() *tls.Config {
+	conf := getTLSConfig()
+	conf.NextProtos = append(append([]string(nil), s.alpnOrder...), defaultALPN)
+	if s.certificate != nil {
+		conf.Certificates = []tls.Certificate{*s.certificate}
+	}
+	if s.verifyPeer != nil {
+		// The chain is verified by verifyPeer against the CA of the node, if any
+		conf.ClientAuth = tls.RequireAnyClientCert
+		conf.VerifyPeerCertificate = s.verifyPeer
+	}
+	return conf
+}
+
+// SetCertificate sets the certificate presented to peers
+func (s *Server) SetCertificate(cert *tls.Certificate) {
+	s.certificate = cert
+}
+
+// SetVerifyPeerCertificate requires peers to present a certificate and
+// checks it, see Client.SetVerifyPeerCertificate
+func (s *Server) SetVerifyPeerCertificate(verify func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error) {
+	s.verifyPeer = verify
+}
+
+// SetTracer sets the QUIC tracer attached to accepted connections
+func (s *Server) SetTracer(tracer logging.Tracer) {
+	s.tracer = tracer
+}
+
+// SetIdleTimeout sets the QUIC max idle timeout of accepted connections, see Client.SetIdleTimeout
+func (s *Server) SetIdleTimeout(timeout time.Duration, keepAlive bool) {
+	s.idleTimeout = timeout
+	s.noKeepAlive = !keepAlive
+}
+
+// SetCPUAffinity pins the goroutines receiving from accepted connections to the given CPU cores
+func (s *Server) SetCPUAffinity(cpus []int) {
+	s.cpus = cpus
+}
+
+// SetCoalescing batches packets sent over accepted connections, see Client.SetCoalescing
+func (s *Server) SetCoalescing(flushInterval time.Duration, maxBatchBytes int) {
+	s.flushInterval = flushInterval
+	s.maxBatchBytes = maxBatchBytes
+}
+
+// SetBufferBudget accounts the buffers of accepted connections, see Client.SetBufferBudget
+func (s *Server) SetBufferBudget(budget *bufferBudget) {
+	s.budget = budget
+}
+
+// SetStatelessRetry makes new connections validate their source address with
+// a QUIC retry before the server keeps any state, it costs a round trip
+func (s *Server) SetStatelessRetry(enabled bool) {
+	s.statelessRetry = enabled
+}
+
+// SetSessionTicketKeys sets the keys of the session tickets peers resume
+// connections with. Servers sharing keys resume the sessions of each other
+// and across restarts, the first key encrypts and every key decrypts. Without
+// keys a random key is rotated every rotation, 0 uses the default.
+func (s *Server) SetSessionTicketKeys(keys [][32]byte, rotation time.Duration) {
+	s.ticketKeys = keys
+	s.ticketRotation = rotation
+}
+
+// SetConnRateLimit limits the connection attempts accepted per second from a single source IP, 0 disables the limit
+func (s *Server) SetConnRateLimit(rate int, burst int) {
+	if rate <= 0 {
+		s.limiter = nil
+		return
+	}
+	s.limiter = newSourceLimiter(rate, burst)
+}
+
 // StartServer starts the server and listens for incoming connections
 func (s *Server) StartServer(ctx context.Context, udpConn *net.UDPConn, qm *QuicWire, wg *sync.WaitGroup) error {
-	listener, err := quic.Listen(udpConn, getTLSConfig(), &quic.Config{
-		KeepAlivePeriod: 10,
+	config := &quic.Config{
+		KeepAlivePeriod: keepAlivePeriod(!s.noKeepAlive),
+		MaxIdleTimeout:  s.idleTimeout,
 		EnableDatagrams: true,
-	})
+		Tracer:          s.tracer,
+	}
+	if s.statelessRetry {
+		config.RequireAddressValidation = requireAddressValidation
+	}
+	tlsConf := s.tlsConfig()
+	if len(s.ticketKeys) > 0 {
+		tlsConf.SetSessionTicketKeys(s.ticketKeys)
+	} else {
+		rotation := s.ticketRotation
+		if rotation <= 0 {
+			rotation = defaultTicketKeyRotation
+		}
+		if err := rotateTicketKeys(ctx, tlsConf, rotation); err != nil {
+			wg.Done()
+			return err
+		}
+	}
+	listener, err := s.transport.Listen(udpConn, tlsConf, config)
 	if err != nil {
+		wg.Done()
 		return err
 	}
 
 	wg.Done()
 
 	for {
-		conn, _ := listener.Accept(ctx)
-		s.logger.Infof("Accepted connection from %v and local address is %v", conn.RemoteAddr(), conn.LocalAddr())
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			return err
+		}
 		//split host and port
 		host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
 		if err != nil {
 			return err
 		}
 
-		qm.connections[host] = conn
-
-		// Set the client entry for the allowed ip of the host
-		for _, peer := range qm.qc.peers {
-			if peer.endpoint == host {
-				qm.clients[peer.allowedIPs[0]] = NewClient(host, qm.qc.nodeInterface.localNodeIP, qm.qc.nodeInterface.listenPort, qm.localIf, s.logger)
-				qm.clients[host].SetConnection(conn)
-			}
+		if s.limiter != nil && !s.limiter.allow(host) {
+			qm.counters.countRateLimited()
+			s.logger.Debugf("Rejected connection from %v, connection rate limit exceeded", conn.RemoteAddr())
+			conn.CloseWithError(errCodeRateLimited, "connection rate limit exceeded")
+			continue
 		}
+		s.logger.Infof("Accepted connection from %v and local address is %v", conn.RemoteAddr(), conn.LocalAddr())
 
+		c, err := NewClient(conn.RemoteAddr().String(), qm.qc.nodeInterface.localNodeIP, qm.qc.nodeInterface.listenPort, s.tunnelInterface, s.logger)
 		if err != nil {
+			conn.CloseWithError(0, "")
 			return err
 		}
+		c.SetConnection(conn)
+		c.SetCPUAffinity(s.cpus)
+		c.SetCoalescing(s.flushInterval, s.maxBatchBytes)
+		c.SetBufferBudget(s.budget)
+		c.SetControlHandler(s.control)
+
+		// Match the connection to the configured peer of the host, it is only
+		// registered for routing once the peer passed the hello checks
+		qm.mu.Lock()
+		known := false
+		var peerKey string
+		for _, peer := range qm.qc.peers {
+			peerHost, _, err := net.SplitHostPort(peer.endpoint)
+			if err != nil || peerHost != host || peer.learned {
+				continue
+			}
+			c.addr = peer.endpoint
+			c.logger = qm.peerLogger(peer.allowedIPs[0])
+			c.SetSendWindow(peer.maxInFlight)
+			c.SetACL(peer.acl)
+			qm.markPeerDSCP(conn.RemoteAddr(), peer)
+			if s.dropped != nil {
+				key := peer.allowedIPs[0]
+				c.SetDropHandler(func(reason string, size int, packet []byte) { s.dropped(key, reason, size, packet) })
+			}
+			peerKey = peer.allowedIPs[0]
+			known = true
+			qm.setAcceptedStateLocked(peerKey, PeerHandshaking)
+		}
+		qm.mu.Unlock()
+
+		proto := conn.ConnectionState().TLS.NegotiatedProtocol
+		s.logger.Debugf("Connection from %v negotiated protocol %q", conn.RemoteAddr(), proto)
 		go func() {
-			err := handleMsg(s.tunnelInterface, conn, s.handler)
-			if err != nil {
-				fmt.Printf("handler err: %v", err)
+			remote, ok := qm.runHandshake(ctx, c, false)
+			if known {
+				qm.setAcceptedState(peerKey, PeerAuthenticating)
+			}
+			if qm.verifyNetworkID(c, remote, ok) != nil || qm.verifyToken(c, remote, ok) != nil {
+				if known {
+					qm.setAcceptedState(peerKey, PeerDisconnected)
+				}
+				return
+			}
+			if !qm.registerAccepted(c, host, peerKey) {
+				conn.CloseWithError(0, "peer removed")
+				return
 			}
+			c.AttachHandler(s.handlerFor(proto))
+			if ok && !qm.resolveSimultaneousOpen(c, remote, peerKey) {
+				return
+			}
+			if known {
+				qm.setPeerState(peerKey, PeerConnected)
+			}
+			if !ok {
+				return
+			}
+			if !known {
+				qm.adoptAnnouncedRoutes(c, remote)
+			}
+			qm.serveReconcile(c)
 		}()
 	}
 }
+
+// setAcceptedState reports the state of an accepted connection of the peer,
+// unless the peer is connected over another connection which a duplicate
+// connection from it does not change
+func (qn *QuicWire) setAcceptedState(key string, state PeerState) {
+	qn.mu.Lock()
+	defer qn.mu.Unlock()
+	qn.setAcceptedStateLocked(key, state)
+}
+
+// setAcceptedStateLocked is setAcceptedState for callers holding mu
+func (qn *QuicWire) setAcceptedStateLocked(key string, state PeerState) {
+	if !qn.peerLiveLocked(key) {
+		qn.setPeerStateLocked(key, state)
+	}
+}
+
+// registerAccepted routes the peer over an accepted connection that passed
+// the hello checks, key is the peer matched to the connection or empty for
+// unknown nodes. It reports false when the matched peer was removed meanwhile.
+func (qn *QuicWire) registerAccepted(c *Client, host string, key string) bool {
+	qn.mu.Lock()
+	defer qn.mu.Unlock()
+	if key != "" {
+		found := false
+		for _, peer := range qn.qc.peers {
+			found = found || peer.allowedIPs[0] == key
+		}
+		if !found {
+			return false
+		}
+		qn.clients[key] = c
+	} else {
+		qn.strangers[host] = c
+	}
+	qn.connections[host] = c.connection
+	return true
+}
@@ -0,0 +1,118 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.uber.org/zap"
+)
+
+func TestServerRoutesByALPN(t *testing.T) {
+	qn, _ := newTestQuicWire(t)
+	qn.qc.nodeInterface.localNodeIP = "127.0.0.1"
+	received := make(chan [2]string, 2)
+	handler := func(name string) Handler {
+		return func(c packetContext) error {
+			received <- [2]string{name, c.ConnectionState().TLS.NegotiatedProtocol}
+			return nil
+		}
+	}
+	s := NewServer("127.0.0.1:0", nil, zap.NewNop().Sugar())
+	s.SetHandler(handler("default"))
+	s.HandleALPN("quicwire-old", handler("old"))
+	s.HandleALPN("quicwire-new", handler("new"))
+
+	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go s.StartServer(ctx, udpConn, qn, &wg)
+	wg.Wait()
+
+	tests := []struct {
+		proto   string
+		handler string
+	}{
+		{proto: "quicwire-old", handler: "old"},
+		{proto: "quicwire-new", handler: "new"},
+		{proto: defaultALPN, handler: "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.proto, func(t *testing.T) {
+			dialCtx, dialCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer dialCancel()
+			conn, err := quic.DialAddrContext(dialCtx, udpConn.LocalAddr().String(),
+				&tls.Config{InsecureSkipVerify: true, NextProtos: []string{tt.proto}},
+				&quic.Config{EnableDatagrams: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.CloseWithError(0, "")
+			c, err := NewClient(conn.RemoteAddr().String(), "127.0.0.1", 0, nil, zap.NewNop().Sugar())
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.SetConnection(conn)
+			c.outbound = true
+			peer, _ := newTestQuicWire(t)
+			if _, ok := peer.runHandshake(dialCtx, c, true); !ok {
+				t.Fatal("hello exchange with the server failed")
+			}
+			if err := c.SendBytes([]byte("packet")); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case got := <-received:
+				if got[0] != tt.handler || got[1] != tt.proto {
+					t.Errorf("packet of a %s connection handled by the %s handler, negotiated %s", tt.proto, got[0], got[1])
+				}
+			case <-dialCtx.Done():
+				t.Fatal("packet never reached a handler")
+			}
+		})
+	}
+}
+
+func TestAcceptedStateKeepsConnectedPeer(t *testing.T) {
+	const key = "10.0.0.2/32"
+	tests := []struct {
+		name string
+		// client is the connection the peer is routed over: "", "live" or "closed"
+		client string
+		want   PeerState
+	}{
+		{name: "no connection", want: PeerHandshaking},
+		{name: "closed connection", client: "closed", want: PeerHandshaking},
+		{name: "live connection", client: "live", want: PeerConnected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, _ := newTestQuicWire(t, Peer{endpoint: "192.0.2.2:55381", allowedIPs: []string{key}})
+			if tt.client != "" {
+				conn, _ := newTestConnPair(t)
+				qn.clients[key] = newTestClient(t, conn, true)
+				if tt.client == "closed" {
+					conn.cancel()
+				}
+			}
+			qn.setPeerState(key, PeerConnected)
+
+			// A duplicate connection accepted from a connected peer leaves its state alone
+			qn.setAcceptedState(key, PeerHandshaking)
+			if got := qn.peerStates[key]; got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package quicwire
+
+import (
+	"errors"
+	"net/netip"
+
+	"github.com/quic-go/quic-go"
+)
+
+// errCodeDuplicate closes the losing connection when two nodes dialed each other at the same time
+const errCodeDuplicate quic.ApplicationErrorCode = 0x3
+
+// errDuplicateConnection is returned for a dialed connection closed in favor of the one the peer dialed
+var errDuplicateConnection = errors.New("duplicate connection, using the one dialed by the peer")
+
+// dialerOf returns the tunnel address of the node that dialed the connection of c
+func (qn *QuicWire) dialerOf(c *Client, remote netip.Addr) netip.Addr {
+	if c.outbound {
+		return qn.localAddr
+	}
+	return remote
+}
+
+// resolveSimultaneousOpen keeps a single connection per pair of nodes when
+// both dialed each other. Of two live connections to the same node the one
+// dialed by the node with the lower tunnel address survives, both ends
+// decide alike without further coordination. It reports whether the
+// connection of c was kept.
+//
+// The hello is not authenticated, so only connections matched to the
+// configured peer key, by the endpoint dialed or the source address
+// accepted from, take part and only when the node they claim to be is an
+// allowed IP of that peer. Other connections are kept without replacing
+// the connection of any peer.
+func (qn *QuicWire) resolveSimultaneousOpen(c *Client, remote hello, key string) bool {
+	id, err := netip.ParseAddr(remote.Node)
+	if err != nil {
+		// Peers running an older release do not tell their identity
+		return true
+	}
+	if key == "" {
+		return true
+	}
+	peer, ok := qn.peerByAllowedIP(key)
+	if !ok || !peerOwnsAddr(peer, id) {
+		qn.logger.Warnf("Peer %s [ %s ] claims to be node %s outside of its allowed IPs, not resolving duplicate connections",
+			c.addr, key, id)
+		return true
+	}
+
+	qn.mu.Lock()
+	prev, ok := qn.peerConns[id]
+	if !ok || prev == c || prev.connection.Context().Err() != nil {
+		qn.peerConns[id] = c
+		qn.mu.Unlock()
+		return true
+	}
+	keep, lose := c, prev
+	if qn.dialerOf(prev, id).Less(qn.dialerOf(c, id)) {
+		keep, lose = prev, c
+	}
+	qn.peerConns[id] = keep
+	// Move the peer over to the surviving connection
+	if qn.clients[key] == lose {
+		qn.clients[key] = keep
+	}
+	for host, conn := range qn.connections {
+		if conn == lose.connection {
+			qn.connections[host] = keep.connection
+		}
+	}
+	qn.mu.Unlock()
+
+	qn.logger.Infof("Both nodes dialed %s [ %s ], keeping the connection dialed by %s", c.addr, id, qn.dialerOf(keep, id))
+	lose.connection.CloseWithError(errCodeDuplicate, "duplicate connection")
+	return keep == c
+}
+
+// keptLocked reports whether c holds the live connection kept to its node by
+// resolveSimultaneousOpen. It must be called with mu held.
+func (qn *QuicWire) keptLocked(c *Client) bool {
+	for _, kept := range qn.peerConns {
+		if kept == c {
+			return c.connection.Context().Err() == nil
+		}
+	}
+	return false
+}
+
+// peerOwnsAddr reports whether the address is within an allowed IP of the peer
+func peerOwnsAddr(peer Peer, addr netip.Addr) bool {
+	for _, allowedIP := range peer.allowedIPs {
+		cidr, err := routePrefix(allowedIP)
+		if err != nil {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(cidr); err == nil && prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
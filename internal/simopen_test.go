@@ -0,0 +1,244 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+	"github.com/quic-go/quic-go"
+)
+
+func TestResolveSimultaneousOpen(t *testing.T) {
+	const key = "10.0.0.2"
+	tests := []struct {
+		name  string
+		local string
+		node  string
+		key   string
+		// prev is the connection known for the node: "" for none, "outbound",
+		// "inbound" or "closed"
+		prev      string
+		outbound  bool
+		kept      bool
+		closedOld bool
+		// client is the connection the peer key maps to afterwards: "prev" or "new"
+		client string
+	}{
+		{name: "older release", local: "10.0.0.1", key: key, prev: "outbound", kept: true, client: "prev"},
+		{name: "unmatched connection", local: "10.0.0.1", node: "10.0.0.2", prev: "outbound", kept: true, client: "prev"},
+		{name: "node outside of the peer", local: "10.0.0.1", node: "10.9.0.1", key: key, prev: "outbound", kept: true, client: "prev"},
+		{name: "first connection", local: "10.0.0.1", node: "10.0.0.2", key: key, kept: true},
+		{name: "previous connection closed", local: "10.0.0.1", node: "10.0.0.2", key: key, prev: "closed", kept: true, client: "prev"},
+		{name: "lower node dialed before", local: "10.0.0.1", node: "10.0.0.2", key: key, prev: "outbound", client: "prev"},
+		{name: "lower node dials now", local: "10.0.0.1", node: "10.0.0.2", key: key, prev: "inbound", outbound: true, kept: true, closedOld: true, client: "new"},
+		{name: "higher node dialed before", local: "10.0.0.3", node: "10.0.0.2", key: key, prev: "outbound", kept: true, closedOld: true, client: "new"},
+		{name: "peer address within a prefix", local: "10.3.0.1", node: "10.1.0.7", key: key, prev: "outbound", kept: true, closedOld: true, client: "new"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, _ := newTestQuicWire(t, Peer{endpoint: "192.0.2.2:55381", allowedIPs: []string{key, "10.1.0.0/16"}})
+			qn.localAddr = netip.MustParseAddr(tt.local)
+			var prev *Client
+			if tt.prev != "" {
+				conn, _ := newTestConnPair(t)
+				prev = newTestClient(t, conn, tt.prev == "outbound")
+				if tt.prev == "closed" {
+					conn.cancel()
+				}
+				qn.clients[key] = prev
+				if id, err := netip.ParseAddr(tt.node); err == nil {
+					qn.peerConns[id] = prev
+				}
+			}
+			conn, _ := newTestConnPair(t)
+			c := newTestClient(t, conn, tt.outbound)
+
+			kept := qn.resolveSimultaneousOpen(c, hello{Node: tt.node}, tt.key)
+			if kept != tt.kept {
+				t.Errorf("kept = %v, want %v", kept, tt.kept)
+			}
+			if closed := conn.Context().Err() != nil; closed == tt.kept {
+				t.Errorf("new connection closed = %v, kept = %v", closed, tt.kept)
+			}
+			if prev != nil && tt.prev != "closed" {
+				if closed := prev.connection.Context().Err() != nil; closed != tt.closedOld {
+					t.Errorf("previous connection closed = %v, want %v", closed, tt.closedOld)
+				}
+			}
+			want := map[string]*Client{"prev": prev, "new": c}[tt.client]
+			if got := qn.clients[key]; got != want {
+				t.Errorf("peer maps to %p, want the %s connection %p", got, tt.client, want)
+			}
+		})
+	}
+}
+
+// delayedTransport is a Transport holding back every dial for delay
+type delayedTransport struct {
+	Transport
+	delay time.Duration
+}
+
+func (t delayedTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	select {
+	case <-time.After(t.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return t.Transport.Dial(ctx, conn, addr, host, tlsConf, conf)
+}
+
+func TestPeerDialingFirstKeepsConnection(t *testing.T) {
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	// The dial of A is held back so the lower node B dials first, A's own
+	// connection loses and A settles on the one B opened
+	a := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) {
+		qn.SetTransport(delayedTransport{Transport: mesh.transport(), delay: 300 * time.Millisecond})
+	})
+	if err := a.AddPeer(newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	// B dials once A's dial is under way, A would not dial at all with a connection in place
+	waitPeerState(t, a.QuicWire, "10.0.0.1/32", PeerDialing)
+	if err := b.AddPeer(newTestPeer(a.udpConn.LocalAddr().String(), "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.1/32", PeerConnected)
+
+	// Wait for the dial loop of A to pick up the connection, it records its dial stats
+	var c *Client
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.RLock()
+		c = a.clients["10.0.0.1/32"]
+		dialed := c != nil && c.DialStats().Duration > 0
+		a.mu.RUnlock()
+		if dialed && !c.outbound && c.connection.Context().Err() == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("A did not settle on the connection B dialed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// The client A routes over is the one receiving the acks and probes of the connection
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := c.probe(ctx, frameHeaderLen); err != nil {
+		t.Errorf("probe over the reused connection: %v", err)
+	}
+	a.mu.RLock()
+	state := a.peerStates["10.0.0.1/32"]
+	a.mu.RUnlock()
+	if state != PeerConnected {
+		t.Errorf("state of B = %v, want %v", state, PeerConnected)
+	}
+}
+
+func TestPeerAddedAfterAcceptReusesConnection(t *testing.T) {
+	mesh := newMemNetwork()
+	transport := &recordingTransport{Transport: mesh.transport()}
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(transport) })
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) },
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	// B accepted the connection of A before A was one of its peers
+	host, _, _ := net.SplitHostPort(a.udpConn.LocalAddr().String())
+	var accepted *Client
+	deadline := time.Now().Add(5 * time.Second)
+	for accepted == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the connection of A was not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+		b.mu.RLock()
+		accepted = b.strangers[host]
+		b.mu.RUnlock()
+	}
+	if err := b.AddPeer(newTestPeer(a.udpConn.LocalAddr().String(), "10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	waitPeerState(t, b.QuicWire, "10.0.0.1/32", PeerConnected)
+
+	b.mu.RLock()
+	c := b.clients["10.0.0.1/32"]
+	b.mu.RUnlock()
+	if c != accepted {
+		t.Error("B does not route over the client of the accepted connection")
+	}
+	if _, dials := transport.calls(); len(dials) != 0 {
+		t.Errorf("B dialed %d times, want the accepted connection reused", len(dials))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := c.probe(ctx, frameHeaderLen); err != nil {
+		t.Errorf("probe over the reused connection: %v", err)
+	}
+}
+
+func TestSimultaneousOpenKeepsOneConnection(t *testing.T) {
+	mesh := newMemNetwork()
+	inMemory := func(qn *QuicWire) { qn.SetTransport(mesh.transport()) }
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", inMemory)
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", inMemory)
+	// Both nodes dial each other at the same time
+	errs := make(chan error, 2)
+	go func() { errs <- a.AddPeer(newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2")) }()
+	go func() { errs <- b.AddPeer(newTestPeer(a.udpConn.LocalAddr().String(), "10.0.0.1")) }()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	waitPeerState(t, b.QuicWire, "10.0.0.1/32", PeerConnected)
+
+	// Traffic flows both ways over the connection both nodes settled on
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, dir := range []struct {
+		from, to *testNode
+		src, dst string
+	}{
+		{from: a, to: b, src: "10.0.0.1:4000", dst: "10.0.0.2:5000"},
+		{from: b, to: a, src: "10.0.0.2:5000", dst: "10.0.0.1:4000"},
+	} {
+		packet := packettest.UDP(netip.MustParseAddrPort(dir.src), netip.MustParseAddrPort(dir.dst), []byte("both ways"))
+		var err error
+		// The losing connection may still be torn down, retry until the survivor carries the packet
+		for dir.to.sink.Len() == 0 && ctx.Err() == nil {
+			err = dir.from.InjectPacket(packet)
+			time.Sleep(20 * time.Millisecond)
+		}
+		if dir.to.sink.Len() == 0 {
+			t.Fatalf("%s did not reach %s: %v", dir.src, dir.dst, err)
+		}
+	}
+	// Traffic may have crossed the losing connection before it was closed,
+	// both nodes settle on the other one
+	connOf := func(n *testNode, key string) quic.Connection {
+		n.mu.RLock()
+		defer n.mu.RUnlock()
+		if c := n.clients[key]; c != nil && c.connection.Context().Err() == nil {
+			return c.connection
+		}
+		return nil
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		connA, connB := connOf(a, "10.0.0.2/32"), connOf(b, "10.0.0.1/32")
+		if connA != nil && connB != nil && connA.(*memConn).peer == connB {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the nodes kept different connections to each other")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
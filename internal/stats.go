@@ -0,0 +1,141 @@
+package quicwire
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Stats holds the node wide counters
+type Stats struct {
+	RateLimitedConnections uint64 `json:"rateLimitedConnections"`
+	TxPackets              uint64 `json:"txPackets"`
+	TxBytes                uint64 `json:"txBytes"`
+	RxPackets              uint64 `json:"rxPackets"`
+	RxBytes                uint64 `json:"rxBytes"`
+	SendErrors             uint64 `json:"sendErrors"`
+	// RejectedRoutes counts the routes of announcements exceeding MaxPeerRoutes
+	RejectedRoutes uint64 `json:"rejectedRoutes"`
+	// HopLimitDrops counts the relayed packets dropped after running out of hops
+	HopLimitDrops uint64 `json:"hopLimitDrops"`
+	// EgressQueueDrops counts the packets dropped at the full egress queue of their peer
+	EgressQueueDrops uint64 `json:"egressQueueDrops"`
+	// Control counters are the overhead kept apart from the forwarded packets
+	// above: probes, acks and other in-band frames, the control streams,
+	// QUIC keep-alives and STUN requests
+	ControlTxPackets uint64 `json:"controlTxPackets"`
+	ControlTxBytes   uint64 `json:"controlTxBytes"`
+	ControlRxPackets uint64 `json:"controlRxPackets"`
+	ControlRxBytes   uint64 `json:"controlRxBytes"`
+	// BufferBytes is the memory currently held by the batches, reassemblies and egress queues of all peers
+	BufferBytes int64 `json:"bufferBytes"`
+}
+
+// counters are the live values behind Stats. Updates on the forwarding path
+// are plain atomic adds, mu only serializes snapshots and resets. A snapshot
+// may see the packet of an update without its bytes, resets lose no update
+// as every counter is swapped atomically.
+type counters struct {
+	mu                     sync.Mutex
+	rateLimitedConnections atomic.Uint64
+	txPackets              atomic.Uint64
+	txBytes                atomic.Uint64
+	rxPackets              atomic.Uint64
+	rxBytes                atomic.Uint64
+	sendErrors             atomic.Uint64
+	rejectedRoutes         atomic.Uint64
+	hopLimitDrops          atomic.Uint64
+	egressQueueDrops       atomic.Uint64
+	controlTxPackets       atomic.Uint64
+	controlTxBytes         atomic.Uint64
+	controlRxPackets       atomic.Uint64
+	controlRxBytes         atomic.Uint64
+}
+
+func (c *counters) countRateLimited() {
+	c.rateLimitedConnections.Add(1)
+}
+
+// countTx counts a packet forwarded to a peer
+func (c *counters) countTx(bytes int) {
+	c.txPackets.Add(1)
+	c.txBytes.Add(uint64(bytes))
+}
+
+// countRx counts a packet received from a peer
+func (c *counters) countRx(bytes int) {
+	c.rxPackets.Add(1)
+	c.rxBytes.Add(uint64(bytes))
+}
+
+// countControl counts control traffic sent to or received from a peer or STUN server
+func (c *counters) countControl(sent bool, bytes int) {
+	if sent {
+		c.controlTxPackets.Add(1)
+		c.controlTxBytes.Add(uint64(bytes))
+	} else {
+		c.controlRxPackets.Add(1)
+		c.controlRxBytes.Add(uint64(bytes))
+	}
+}
+
+func (c *counters) countRejectedRoutes(routes int) {
+	c.rejectedRoutes.Add(uint64(routes))
+}
+
+func (c *counters) countHopLimit() {
+	c.hopLimitDrops.Add(1)
+}
+
+func (c *counters) countEgressQueueDrop() {
+	c.egressQueueDrops.Add(1)
+}
+
+func (c *counters) countSendError() {
+	c.sendErrors.Add(1)
+}
+
+// snapshot reads every counter and zeroes them when reset is set
+func (c *counters) snapshot(reset bool) Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	load := func(v *atomic.Uint64) uint64 {
+		if reset {
+			return v.Swap(0)
+		}
+		return v.Load()
+	}
+	return Stats{
+		RateLimitedConnections: load(&c.rateLimitedConnections),
+		TxPackets:              load(&c.txPackets),
+		TxBytes:                load(&c.txBytes),
+		RxPackets:              load(&c.rxPackets),
+		RxBytes:                load(&c.rxBytes),
+		SendErrors:             load(&c.sendErrors),
+		RejectedRoutes:         load(&c.rejectedRoutes),
+		HopLimitDrops:          load(&c.hopLimitDrops),
+		EgressQueueDrops:       load(&c.egressQueueDrops),
+		ControlTxPackets:       load(&c.controlTxPackets),
+		ControlTxBytes:         load(&c.controlTxBytes),
+		ControlRxPackets:       load(&c.controlRxPackets),
+		ControlRxBytes:         load(&c.controlRxBytes),
+	}
+}
+
+// Stats returns the node wide counters
+func (qn *QuicWire) Stats() Stats {
+	return qn.SnapshotStats()
+}
+
+// SnapshotStats reads all counters
+func (qn *QuicWire) SnapshotStats() Stats {
+	stats := qn.counters.snapshot(false)
+	stats.BufferBytes = qn.buffers.inUse()
+	return stats
+}
+
+// ResetStats zeroes all counters and returns their values before the reset
+func (qn *QuicWire) ResetStats() Stats {
+	stats := qn.counters.snapshot(true)
+	stats.BufferBytes = qn.buffers.inUse()
+	return stats
+}
@@ -0,0 +1,133 @@
+package quicwire
+
+import (
+	"context"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+// forwardConcurrently injects packets for the peer at 10.0.0.2 from several
+// goroutines and calls read while they run, it returns the packet size
+func forwardConcurrently(t *testing.T, a *testNode, packets int, read func()) int {
+	t.Helper()
+	const forwarders = 4
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), make([]byte, 100))
+	var wg sync.WaitGroup
+	for i := 0; i < forwarders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < packets/forwarders; j++ {
+				if err := a.InjectPacket(packet); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			return len(packet)
+		default:
+			read()
+		}
+	}
+}
+
+func TestSnapshotStatsConcurrentWithForwarding(t *testing.T) {
+	const packets = 2000
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) },
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	t.Run("snapshots are monotonic", func(t *testing.T) {
+		start := a.SnapshotStats()
+		prev := start
+		size := forwardConcurrently(t, a, packets, func() {
+			s := a.SnapshotStats()
+			if s.TxPackets < prev.TxPackets || s.TxBytes < prev.TxBytes {
+				t.Fatalf("snapshot went backwards from %d packets, %d bytes to %d packets, %d bytes",
+					prev.TxPackets, prev.TxBytes, s.TxPackets, s.TxBytes)
+			}
+			prev = s
+		})
+		end := a.SnapshotStats()
+		if got := end.TxPackets - start.TxPackets; got != packets {
+			t.Errorf("counted %d forwarded packets, want %d", got, packets)
+		}
+		if got := end.TxBytes - start.TxBytes; got != uint64(packets*size) {
+			t.Errorf("counted %d forwarded bytes, want %d", got, packets*size)
+		}
+	})
+
+	t.Run("resets lose no counts", func(t *testing.T) {
+		a.ResetStats()
+		var sum Stats
+		size := forwardConcurrently(t, a, packets, func() {
+			s := a.ResetStats()
+			sum.TxPackets += s.TxPackets
+			sum.TxBytes += s.TxBytes
+		})
+		s := a.ResetStats()
+		sum.TxPackets += s.TxPackets
+		sum.TxBytes += s.TxBytes
+		if sum.TxPackets != packets || sum.TxBytes != uint64(packets*size) {
+			t.Errorf("resets returned %d packets, %d bytes in total, want %d, %d",
+				sum.TxPackets, sum.TxBytes, packets, packets*size)
+		}
+	})
+}
+
+func TestControlTrafficCountedApart(t *testing.T) {
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) },
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	beforeA, beforeB := a.Stats(), b.Stats()
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), make([]byte, 100))
+	for i := 0; i < 3; i++ {
+		if err := a.InjectPacket(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := b.sink.Wait(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.PingPeer(ctx, "10.0.0.2/32"); err != nil {
+		t.Fatal(err)
+	}
+	afterA, afterB := a.Stats(), b.Stats()
+
+	// The data counters hold the forwarded packets only, the probe and its reply are control traffic
+	if n, size := afterA.TxPackets-beforeA.TxPackets, afterA.TxBytes-beforeA.TxBytes; n != 3 || size != uint64(3*len(packet)) {
+		t.Errorf("sent %d data packets of %d bytes, want 3 of %d", n, size, 3*len(packet))
+	}
+	if n, size := afterB.RxPackets-beforeB.RxPackets, afterB.RxBytes-beforeB.RxBytes; n != 3 || size != uint64(3*len(packet)) {
+		t.Errorf("received %d data packets of %d bytes, want 3 of %d", n, size, 3*len(packet))
+	}
+	if n, size := afterA.ControlTxPackets-beforeA.ControlTxPackets, afterA.ControlTxBytes-beforeA.ControlTxBytes; n == 0 || size < connectOnceProbeSize {
+		t.Errorf("sent %d control packets of %d bytes, want at least the probe of %d", n, size, connectOnceProbeSize)
+	}
+	if n, size := afterB.ControlRxPackets-beforeB.ControlRxPackets, afterB.ControlRxBytes-beforeB.ControlRxBytes; n == 0 || size < connectOnceProbeSize {
+		t.Errorf("received %d control packets of %d bytes, want at least the probe of %d", n, size, connectOnceProbeSize)
+	}
+	if afterA.ControlRxPackets == beforeA.ControlRxPackets {
+		t.Error("probe reply not counted as control traffic")
+	}
+}
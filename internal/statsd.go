@@ -0,0 +1,152 @@
+package quicwire
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultStatsdInterval is how often metrics are pushed to StatsD
+	defaultStatsdInterval = 10 * time.Second
+	// statsdMaxPacket keeps metric packets below common path MTUs
+	statsdMaxPacket = 1400
+	statsdPrefix    = "quicwire."
+)
+
+// StatsD line formats
+const (
+	statsdFormatStatsd    = "statsd"
+	statsdFormatDogStatsd = "dogstatsd"
+)
+
+// statsdExporter pushes the node counters and peer gauges to a StatsD server
+type statsdExporter struct {
+	conn   net.Conn
+	format string
+	prev   Stats
+	lines  []string
+}
+
+// metric adds a metric line, peer labels become tags in DogStatsD and part of the name otherwise
+func (e *statsdExporter) metric(name string, value int64, kind string, peer string) {
+	var line string
+	switch {
+	case peer == "":
+		line = fmt.Sprintf("%s%s:%d|%s", statsdPrefix, name, value, kind)
+	case e.format == statsdFormatDogStatsd:
+		line = fmt.Sprintf("%s%s:%d|%s|#peer:%s", statsdPrefix, name, value, kind, peer)
+	default:
+		line = fmt.Sprintf("%speer.%s.%s:%d|%s", statsdPrefix, strings.NewReplacer(".", "_", ":", "_", "/", "_").Replace(peer), name, value, kind)
+	}
+	e.lines = append(e.lines, line)
+}
+
+// counter adds the increase of a counter since the previous push
+func (e *statsdExporter) counter(name string, cur, prev uint64) {
+	delta := cur - prev
+	if cur < prev {
+		// The counters were reset in between
+		delta = cur
+	}
+	if delta > 0 {
+		e.metric(name, int64(delta), "c", "")
+	}
+}
+
+// flush sends the collected lines, packing as many as fit into each packet
+func (e *statsdExporter) flush() error {
+	var packet strings.Builder
+	send := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := e.conn.Write([]byte(packet.String()))
+		packet.Reset()
+		return err
+	}
+	for _, line := range e.lines {
+		if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+			if err := send(); err != nil {
+				return err
+			}
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+	e.lines = e.lines[:0]
+	return send()
+}
+
+// pushStatsd collects the current metrics and sends them
+func (qn *QuicWire) pushStatsd(e *statsdExporter) error {
+	stats := qn.SnapshotStats()
+	e.counter("tx_packets", stats.TxPackets, e.prev.TxPackets)
+	e.counter("tx_bytes", stats.TxBytes, e.prev.TxBytes)
+	e.counter("rx_packets", stats.RxPackets, e.prev.RxPackets)
+	e.counter("rx_bytes", stats.RxBytes, e.prev.RxBytes)
+	e.counter("control_tx_packets", stats.ControlTxPackets, e.prev.ControlTxPackets)
+	e.counter("control_tx_bytes", stats.ControlTxBytes, e.prev.ControlTxBytes)
+	e.counter("control_rx_packets", stats.ControlRxPackets, e.prev.ControlRxPackets)
+	e.counter("control_rx_bytes", stats.ControlRxBytes, e.prev.ControlRxBytes)
+	e.counter("send_errors", stats.SendErrors, e.prev.SendErrors)
+	e.counter("rejected_routes", stats.RejectedRoutes, e.prev.RejectedRoutes)
+	e.counter("hop_limit_drops", stats.HopLimitDrops, e.prev.HopLimitDrops)
+	e.counter("egress_queue_drops", stats.EgressQueueDrops, e.prev.EgressQueueDrops)
+	e.counter("rate_limited_connections", stats.RateLimitedConnections, e.prev.RateLimitedConnections)
+	e.metric("buffer_bytes", stats.BufferBytes, "g", "")
+	e.prev = stats
+
+	for _, ps := range qn.Status() {
+		peer := ps.AllowedIPs[0]
+		connected := int64(0)
+		if ps.Connected {
+			connected = 1
+		}
+		e.metric("connected", connected, "g", peer)
+		if ps.Connected {
+			e.metric("effective_mtu", int64(ps.EffectiveMTU), "g", peer)
+			e.metric("dial_duration_ms", ps.Dial.Duration.Milliseconds(), "g", peer)
+		}
+	}
+	return e.flush()
+}
+
+// startStatsd pushes metrics to the configured StatsD server until ctx is done
+func (qn *QuicWire) startStatsd(ctx context.Context) error {
+	ni := qn.qc.nodeInterface
+	if ni.statsdAddr == "" {
+		return nil
+	}
+	conn, err := net.Dial("udp", ni.statsdAddr)
+	if err != nil {
+		return err
+	}
+	interval := ni.statsdInterval
+	if interval <= 0 {
+		interval = defaultStatsdInterval
+	}
+	e := &statsdExporter{conn: conn, format: ni.statsdFormat}
+
+	go func() {
+		defer conn.Close()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := qn.pushStatsd(e); err != nil {
+					qn.logger.Debugf("Failed to push metrics to StatsD %s: %v", ni.statsdAddr, err)
+				}
+			}
+		}
+	}()
+	qn.logger.Infof("Pushing metrics to StatsD %s every %s", ni.statsdAddr, interval)
+	return nil
+}
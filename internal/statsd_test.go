@@ -0,0 +1,93 @@
+package quicwire
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+// readStatsdLines reads the metric lines of the first packet received on conn
+func readStatsdLines(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, statsdMaxPacket)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no metrics pushed: %v", err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestStatsdPushesMetrics(t *testing.T) {
+	listener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.statsdAddr = listener.LocalAddr().String()
+		qn.qc.nodeInterface.statsdFormat = statsdFormatDogStatsd
+		qn.qc.nodeInterface.statsdInterval = 50 * time.Millisecond
+	}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), make([]byte, 72))
+	for i := 0; i < 3; i++ {
+		if err := a.InjectPacket(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := b.sink.Wait(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	// Pushing starts after the traffic, so the first push carries all of it
+	if err := a.startStatsd(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	first := strings.Join(readStatsdLines(t, listener), "\n")
+	for _, want := range []string{
+		"quicwire.tx_packets:3|c",
+		fmt.Sprintf("quicwire.tx_bytes:%d|c", 3*len(packet)),
+		"quicwire.connected:1|g|#peer:10.0.0.2/32",
+		fmt.Sprintf("quicwire.effective_mtu:%d|g|#peer:10.0.0.2/32", a.maxPacket()),
+	} {
+		if !strings.Contains(first, want) {
+			t.Errorf("first push lacks %q:\n%s", want, first)
+		}
+	}
+	// Counters are pushed as the increase since the previous push
+	if second := strings.Join(readStatsdLines(t, listener), "\n"); strings.Contains(second, "tx_packets") {
+		t.Errorf("second push without traffic counts packets again:\n%s", second)
+	}
+}
+
+func TestStatsdMetricFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{format: statsdFormatStatsd, want: "quicwire.peer.10_0_0_2_32.connected:1|g"},
+		{format: statsdFormatDogStatsd, want: "quicwire.connected:1|g|#peer:10.0.0.2/32"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			e := &statsdExporter{format: tt.format}
+			e.metric("connected", 1, "g", "10.0.0.2/32")
+			if len(e.lines) != 1 || e.lines[0] != tt.want {
+				t.Errorf("lines = %q, want %q", e.lines, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultStatsFileInterval is how often the stats snapshot is written
+const defaultStatsFileInterval = 30 * time.Second
+
+// statsSnapshot is the content of the stats file
+type statsSnapshot struct {
+	Time  time.Time    `json:"time"`
+	Stats Stats        `json:"stats"`
+	Peers []PeerStatus `json:"peers"`
+	Drops []Drop       `json:"drops"`
+}
+
+// writeStatsFile atomically replaces the stats file with a new snapshot
+func (qn *QuicWire) writeStatsFile(path string) error {
+	data, err := json.MarshalIndent(statsSnapshot{
+		Time:  time.Now(),
+		Stats: qn.SnapshotStats(),
+		Peers: qn.Status(),
+		Drops: qn.RecentDrops(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// startStatsFile periodically snapshots the stats to the configured file,
+// a last snapshot is written when ctx is done
+func (qn *QuicWire) startStatsFile(ctx context.Context) {
+	path := qn.qc.nodeInterface.statsFile
+	if path == "" {
+		return
+	}
+	interval := qn.qc.nodeInterface.statsFileInterval
+	if interval <= 0 {
+		interval = defaultStatsFileInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				if err := qn.writeStatsFile(path); err != nil {
+					qn.logger.Warnf("Failed to write the stats file %s: %v", path, err)
+				}
+				return
+			case <-ticker.C:
+				if err := qn.writeStatsFile(path); err != nil {
+					qn.logger.Warnf("Failed to write the stats file %s: %v", path, err)
+				}
+			}
+		}
+	}()
+	qn.logger.Infof("Writing stats snapshots to %s every %s", path, interval)
+}
@@ -0,0 +1,72 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+// readStatsFile waits until the stats file at path counts txPackets sent packets
+func readStatsFile(t *testing.T, path string, txPackets uint64) statsSnapshot {
+	t.Helper()
+	var snapshot statsSnapshot
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, err := os.ReadFile(path); err == nil {
+			if err := json.Unmarshal(data, &snapshot); err != nil {
+				t.Fatalf("stats file is not a complete snapshot: %v", err)
+			}
+			if snapshot.Stats.TxPackets == txPackets {
+				return snapshot
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("stats file counts %d sent packets, want %d", snapshot.Stats.TxPackets, txPackets)
+	return snapshot
+}
+
+func TestStatsFileSnapshotsCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.qc.nodeInterface.statsFile = path
+		qn.qc.nodeInterface.statsFileInterval = 20 * time.Millisecond
+	}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a.startStatsFile(ctx)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), make([]byte, 100))
+	inject := func() {
+		t.Helper()
+		if err := a.InjectPacket(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inject()
+	inject()
+	snapshot := readStatsFile(t, path, 2)
+	if snapshot.Stats.TxBytes != uint64(2*len(packet)) {
+		t.Errorf("txBytes = %d, want %d", snapshot.Stats.TxBytes, 2*len(packet))
+	}
+	if len(snapshot.Peers) != 1 || !snapshot.Peers[0].Connected || snapshot.Peers[0].AllowedIPs[0] != "10.0.0.2/32" {
+		t.Errorf("peers = %+v, want the connected peer 10.0.0.2/32", snapshot.Peers)
+	}
+
+	// Later snapshots replace the file, the last one is written on shutdown
+	inject()
+	readStatsFile(t, path, 3)
+	inject()
+	cancel()
+	readStatsFile(t, path, 4)
+}
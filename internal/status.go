@@ -0,0 +1,91 @@
+package quicwire
+
+import (
+	"time"
+)
+
+// PeerStatus reports the state of the connection to a peer
+type PeerStatus struct {
+	AllowedIPs []string  `json:"allowedIPs"`
+	Endpoint   string    `json:"endpoint"`
+	Connected  bool      `json:"connected"`
+	Dial       DialStats `json:"dial"`
+	// State is the lifecycle state of the peer
+	State PeerState `json:"state"`
+	// EffectiveMTU is the largest packet sent to the peer, the smaller of both tunnel MTUs
+	EffectiveMTU int `json:"effectiveMTU,omitempty"`
+	// SendMTU and RecvMTU are the largest packets sent to and received from
+	// the peer, they differ on paths with a different MTU in each direction
+	SendMTU int `json:"sendMTU,omitempty"`
+	RecvMTU int `json:"recvMTU,omitempty"`
+	// Failed is set when the node gave up dialing the peer, FailureReason tells why
+	Failed        bool   `json:"failed,omitempty"`
+	FailureReason string `json:"failureReason,omitempty"`
+	// Compression reports the savings of the compressor negotiated with the peer
+	Compression *CompressionStats `json:"compression,omitempty"`
+	// LastPathEvent is the most recent path-validated or path-failed event of the peer
+	LastPathEvent *Event `json:"lastPathEvent,omitempty"`
+	// SmoothedRTT and LossRate are the RTT and the share of lost packets of the connection
+	SmoothedRTT time.Duration `json:"smoothedRTT,omitempty"`
+	LossRate    float64       `json:"lossRate,omitempty"`
+}
+
+// Status returns the state of every configured peer
+func (qn *QuicWire) Status() []PeerStatus {
+	qn.mu.RLock()
+	defer qn.mu.RUnlock()
+
+	status := make([]PeerStatus, 0, len(qn.qc.peers))
+	for _, peer := range qn.qc.peers {
+		ps := PeerStatus{
+			AllowedIPs: peer.allowedIPs,
+			Endpoint:   peer.endpoint,
+		}
+		if c, ok := qn.clients[peer.allowedIPs[0]]; ok {
+			ps.Connected = c.connection != nil && c.connection.Context().Err() == nil
+			ps.Dial = c.DialStats()
+			ps.EffectiveMTU = qn.effectiveMTU(c)
+			ps.SendMTU = ps.EffectiveMTU
+			ps.RecvMTU = qn.receiveMTU(c)
+			if z := c.compressor.Load(); z != nil {
+				stats := z.stats()
+				ps.Compression = &stats
+			}
+			if stats := qn.pathStats(c); stats != nil {
+				ps.SmoothedRTT = stats.rtt()
+				ps.LossRate = stats.lossRate()
+			}
+		}
+		ps.State = qn.peerStateLocked(peer.allowedIPs[0], qn.clients[peer.allowedIPs[0]], ps.Connected)
+		if err, ok := qn.failedPeers[peer.allowedIPs[0]]; ok {
+			ps.Failed = true
+			ps.FailureReason = err.Error()
+		}
+		if e, ok := qn.pathEvents[peer.allowedIPs[0]]; ok {
+			ps.LastPathEvent = &e
+		}
+		status = append(status, ps)
+	}
+	return status
+}
+
+// NodeStatus reports the local state of the node
+type NodeStatus struct {
+	// ListenPort is the port of the shared socket, the OS assigned one when configured as 0
+	ListenPort int `json:"listenPort"`
+	// PortBinding is the external address of the shared socket learned through STUN
+	PortBinding string `json:"portBinding,omitempty"`
+	TunnelAddr  string `json:"tunnelAddr"`
+}
+
+// NodeStatus returns the local state of the node
+func (qn *QuicWire) NodeStatus() NodeStatus {
+	ns := NodeStatus{
+		ListenPort:  qn.qc.nodeInterface.listenPort,
+		PortBinding: qn.publicEndpoint(),
+	}
+	if qn.localAddr.IsValid() {
+		ns.TunnelAddr = qn.localAddr.String()
+	}
+	return ns
+}
@@ -0,0 +1,118 @@
+package quicwire
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// defaultStopTimeout bounds the graceful part of Stop
+const defaultStopTimeout = 5 * time.Second
+
+// errCodeShutdown is the application error code of connections closed by Stop
+const errCodeShutdown quic.ApplicationErrorCode = 0x2
+
+// stopTimeout returns how long Stop waits for peers before closing everything
+func (qn *QuicWire) stopTimeout() time.Duration {
+	if qn.qc.nodeInterface.stopTimeout > 0 {
+		return qn.qc.nodeInterface.stopTimeout
+	}
+	return defaultStopTimeout
+}
+
+// goodbye tells the peer the node is going away, or with frameFlagIdle that
+// the idle connection is closing, and waits for it to acknowledge
+func (c *Client) goodbye(ctx context.Context, flags byte) error {
+	reply := make(chan struct{}, 1)
+	c.probeMu.Lock()
+	c.probeSeq++
+	seq := c.probeSeq
+	c.probes[seq] = reply
+	c.probeMu.Unlock()
+	defer func() {
+		c.probeMu.Lock()
+		delete(c.probes, seq)
+		c.probeMu.Unlock()
+	}()
+
+	if err := c.sendControl(encodeFrame(frameGoodbye, flags, seq, nil)); err != nil {
+		return err
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// StopContext stops the node. Peers are sent a goodbye and the node waits for
+// them to acknowledge it until ctx is done, then every connection, the shared
+// socket and the tunnel interface are closed regardless. Only the first call
+// stops the node.
+func (qn *QuicWire) StopContext(ctx context.Context) {
+	qn.stopOnce.Do(func() { qn.stop(ctx) })
+}
+
+func (qn *QuicWire) stop(ctx context.Context) {
+	qn.logger.Info("QuicWire Stop")
+	if err := sdNotify("STOPPING=1"); err != nil {
+		qn.logger.Debugf("Failed to notify systemd of the stop: %v", err)
+	}
+	// Stop dialing and redialing peers
+	if qn.cancel != nil {
+		qn.cancel()
+	}
+
+	qn.mu.Lock()
+	conns := make(map[quic.Connection]*Client)
+	for key, c := range qn.clients {
+		if c.connection != nil {
+			conns[c.connection] = c
+			qn.setPeerStateLocked(key, PeerDraining)
+		}
+	}
+	qn.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			if c.coalescer != nil {
+				c.coalescer.flush()
+			}
+			if err := c.goodbye(ctx, 0); err != nil {
+				qn.logger.Debugf("Peer %s did not acknowledge the goodbye: %v", c.addr, err)
+			}
+		}(c)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		qn.logger.Warnf("Graceful stop timed out, closing the remaining connections")
+	}
+
+	for conn := range conns {
+		conn.CloseWithError(errCodeShutdown, "shutting down")
+	}
+	if qn.udpConn != nil {
+		qn.udpConn.Close()
+	}
+	qn.removeMasquerade(qn.masqueradeSources())
+	if tun := qn.tun.Load(); tun != nil {
+		tun.Close()
+	}
+	if qn.mirrorCapture != nil {
+		if err := qn.mirrorCapture.Close(); err != nil {
+			qn.logger.Errorf("Failed to close the mirror capture: %v", err)
+		}
+	}
+}
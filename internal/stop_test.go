@@ -0,0 +1,30 @@
+package quicwire
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStopTimesOutUnacknowledgedGoodbye(t *testing.T) {
+	const timeout = 300 * time.Millisecond
+	// The echo peer answers probes but never acknowledges a goodbye
+	peer := startEchoPeer(t, "127.0.0.2")
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.qc.nodeInterface.stopTimeout = timeout
+	}, newTestPeer(peer, "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	a.mu.RLock()
+	conn := a.clients["10.0.0.2/32"].connection
+	a.mu.RUnlock()
+
+	start := time.Now()
+	a.Stop()
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > timeout+time.Second {
+		t.Errorf("Stop returned after %v, want about the timeout of %v", elapsed, timeout)
+	}
+	select {
+	case <-conn.Context().Done():
+	default:
+		t.Error("connection to the peer still open after Stop")
+	}
+}
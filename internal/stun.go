@@ -48,6 +48,11 @@ func GetPortBinding(sourcePort int) (string, error) {
 
 // StunRequest initiate a connection to a STUN server sourced from the wg src port
 func StunRequest(stunServer string, srcPort int) (string, error) {
+	return stunRequest(stunServer, srcPort, nil)
+}
+
+// stunRequest is StunRequest telling counted, if set, about the datagrams exchanged with the server
+func stunRequest(stunServer string, srcPort int, counted func(sent bool, size int)) (string, error) {
 
 	log.Debugf("dialing stun server %s", stunServer)
 
@@ -58,6 +63,9 @@ func StunRequest(stunServer string, srcPort int) (string, error) {
 	}
 
 	defer conn.Close()
+	if counted != nil {
+		conn = countedConn{Conn: conn, counted: counted}
+	}
 	stunResults, err := stunDialer(&conn)
 	if err != nil {
 		return "", fmt.Errorf("stun dialing timed out %w", err)
@@ -76,12 +84,9 @@ func stunDialer(conn *net.Conn) (string, error) {
 			log.Println(res.Error)
 			return
 		}
+		// The client is closed once Do returns, closing it in the callback deadlocks
 		if getErr := xorAddr.GetFrom(res.Message); getErr != nil {
 			log.Println(getErr)
-			if err := c.Close(); err != nil {
-				log.Println(err)
-				return
-			}
 			return
 		}
 		log.Debugf("Stun address and port is: %s:%d", xorAddr.IP, xorAddr.Port)
@@ -103,3 +108,25 @@ func stunDialer(conn *net.Conn) (string, error) {
 
 	return stunAddress, nil
 }
+
+// countedConn tells counted about every datagram read from and written to the connection
+type countedConn struct {
+	net.Conn
+	counted func(sent bool, size int)
+}
+
+func (c countedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.counted(false, n)
+	}
+	return n, err
+}
+
+func (c countedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.counted(true, n)
+	}
+	return n, err
+}
@@ -0,0 +1,158 @@
+package quicwire
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// stunLatencyWeight is the weight of the newest sample in the smoothed latency of a STUN server
+	stunLatencyWeight = 0.3
+	// stunFailurePenalty is how long a failure counts against a STUN server
+	stunFailurePenalty = 5 * time.Minute
+)
+
+// stunServerHealth tracks how a STUN server answered recent probes
+type stunServerHealth struct {
+	addr        string
+	latency     time.Duration
+	failures    int
+	lastFailure time.Time
+}
+
+// weight is the relative chance the server is tried first. Fast servers are
+// preferred, failures divide the weight until they age out.
+func (h *stunServerHealth) weight(now time.Time) float64 {
+	latency := h.latency
+	if latency <= 0 {
+		// Untried servers get the benefit of the doubt
+		latency = 50 * time.Millisecond
+	}
+	w := 1 / latency.Seconds()
+	if h.failures > 0 && now.Sub(h.lastFailure) < stunFailurePenalty {
+		w /= math.Pow(2, float64(h.failures))
+	}
+	return w
+}
+
+// stunSelector orders the configured STUN servers by weighted random selection on their health
+type stunSelector struct {
+	mu      sync.Mutex
+	servers []*stunServerHealth
+	rand    *rand.Rand
+	// counted is told about the datagrams exchanged with the servers
+	counted func(sent bool, size int)
+}
+
+func newStunSelector(servers []string) *stunSelector {
+	s := &stunSelector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, addr := range servers {
+		s.servers = append(s.servers, &stunServerHealth{addr: addr})
+	}
+	return s
+}
+
+// order returns the servers in the order they should be tried
+func (s *stunSelector) order() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// Weighted random sampling without replacement: sort by u^(1/w)
+	type keyed struct {
+		addr string
+		key  float64
+	}
+	keys := make([]keyed, 0, len(s.servers))
+	for _, h := range s.servers {
+		keys = append(keys, keyed{addr: h.addr, key: math.Pow(s.rand.Float64(), 1/h.weight(now))})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key > keys[j].key })
+
+	order := make([]string, len(keys))
+	for i, k := range keys {
+		order[i] = k.addr
+	}
+	return order
+}
+
+// record updates the health of a server after a probe
+func (s *stunSelector) record(addr string, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, h := range s.servers {
+		if h.addr != addr {
+			continue
+		}
+		if err != nil {
+			h.failures++
+			h.lastFailure = time.Now()
+			return
+		}
+		h.failures = 0
+		if h.latency == 0 {
+			h.latency = latency
+		} else {
+			h.latency = time.Duration(stunLatencyWeight*float64(latency) + (1-stunLatencyWeight)*float64(h.latency))
+		}
+		return
+	}
+}
+
+// request sends a binding request to the server and records the outcome
+func (s *stunSelector) request(addr string, srcPort int) (string, error) {
+	start := time.Now()
+	res, err := stunRequest(addr, srcPort, s.counted)
+	s.record(addr, time.Since(start), err)
+	return res, err
+}
+
+// portBinding returns the NAT port binding from the first server that answers
+func (s *stunSelector) portBinding(srcPort int) (string, error) {
+	var lastErr error
+	for _, addr := range s.order() {
+		res, err := s.request(addr, srcPort)
+		if err == nil {
+			return res, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		return "", fmt.Errorf("no STUN servers configured")
+	}
+	return "", fmt.Errorf("stun request failed: %v", lastErr)
+}
+
+// isSymmetricNAT compares the bindings reported by the first two servers
+// that answer. If they differ the node is likely behind a symmetric NAT.
+func (s *stunSelector) isSymmetricNAT(srcPort int) (bool, error) {
+	var bindings []string
+	for _, addr := range s.order() {
+		res, err := s.request(addr, srcPort)
+		if err != nil {
+			continue
+		}
+		bindings = append(bindings, res)
+		if len(bindings) == 2 {
+			return bindings[0] != bindings[1], nil
+		}
+	}
+	return false, fmt.Errorf("fewer than two STUN servers answered")
+}
+
+// stunServers returns the selector over the configured STUN servers
+func (qn *QuicWire) stunServers() *stunSelector {
+	qn.stunOnce.Do(func() {
+		servers := qn.qc.nodeInterface.stunServers
+		if len(servers) == 0 {
+			servers = []string{stunServer1, stunServer2}
+		}
+		qn.stun = newStunSelector(servers)
+		qn.stun.counted = qn.counters.countControl
+	})
+	return qn.stun
+}
@@ -0,0 +1,39 @@
+package quicwire
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStunSelectorPrefersHealthyServers(t *testing.T) {
+	mapped := &net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000}
+	failing := startScriptedSTUN(t, mapped, 0, true)
+	slow := startScriptedSTUN(t, mapped, 200*time.Millisecond, false)
+	fast := startTestSTUN(t, mapped)
+	s := newStunSelector([]string{failing, slow, fast})
+
+	// Probe every server so each has a health record, failures add up
+	for _, addr := range []string{failing, failing, failing, slow, fast} {
+		s.request(addr, 0)
+	}
+	for i := 0; i < 5; i++ {
+		binding, err := s.portBinding(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if binding != mapped.String() {
+			t.Errorf("binding = %s, want %s", binding, mapped)
+		}
+	}
+
+	first := make(map[string]int)
+	const rounds = 1000
+	for i := 0; i < rounds; i++ {
+		first[s.order()[0]]++
+	}
+	if first[fast] < rounds/2 || first[fast] < 2*first[slow] || first[fast] < 2*first[failing] {
+		t.Errorf("fast server tried first in %d of %d orders, slow %d, failing %d, want the fast one preferred",
+			first[fast], rounds, first[slow], first[failing])
+	}
+}
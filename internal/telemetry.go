@@ -0,0 +1,41 @@
+package quicwire
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the OpenTelemetry tracer of the package
+const instrumentationName = "github.com/packetdrop/quicwire"
+
+// SetTracerProvider sets the OpenTelemetry tracer provider used to trace
+// config loading, STUN probes and peer connection setup. It must be called
+// before Start, tracing is a no-op by default.
+func (qn *QuicWire) SetTracerProvider(tp trace.TracerProvider) {
+	qn.otelTracer = tp.Tracer(instrumentationName)
+}
+
+// startSpan starts a span as a child of the span in ctx
+func (qn *QuicWire) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return qn.otelTracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, recording err as its status
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// peerAttrs are the span attributes identifying a peer
+func peerAttrs(peer Peer) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("quicwire.peer", peer.allowedIPs[0]),
+		attribute.String("quicwire.endpoint", peer.endpoint),
+	}
+}
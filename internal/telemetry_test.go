@@ -0,0 +1,133 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanRecorder is an in-memory tracer provider recording the spans ended on it
+type spanRecorder struct {
+	mu     sync.Mutex
+	nextID uint64
+	ended  []*recordedSpan
+}
+
+// recordedSpan is a span of a spanRecorder, the operations it doesn't record are no-ops
+type recordedSpan struct {
+	trace.Span
+	recorder *spanRecorder
+	name     string
+	sc       trace.SpanContext
+	parent   trace.SpanContext
+	status   codes.Code
+}
+
+func (r *spanRecorder) Tracer(string, ...trace.TracerOption) trace.Tracer { return r }
+
+func (r *spanRecorder) Start(ctx context.Context, name string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	parent := trace.SpanContextFromContext(ctx)
+	r.mu.Lock()
+	r.nextID++
+	var spanID trace.SpanID
+	binary.BigEndian.PutUint64(spanID[:], r.nextID)
+	r.mu.Unlock()
+	traceID := parent.TraceID()
+	if !parent.IsValid() {
+		// A root span starts a trace of its own
+		copy(traceID[:], spanID[:])
+	}
+	span := &recordedSpan{
+		Span:     trace.SpanFromContext(context.Background()),
+		recorder: r,
+		name:     name,
+		sc:       trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}),
+		parent:   parent,
+	}
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (s *recordedSpan) SpanContext() trace.SpanContext { return s.sc }
+func (s *recordedSpan) IsRecording() bool              { return true }
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.recorder.mu.Lock()
+	defer s.recorder.mu.Unlock()
+	s.recorder.ended = append(s.recorder.ended, s)
+}
+
+// span returns the first ended span called name
+func (r *spanRecorder) span(name string) *recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.ended {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestPeerConnectionSpans(t *testing.T) {
+	mesh := newMemNetwork()
+	recorder := &spanRecorder{}
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.SetTracerProvider(recorder)
+	})
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	if err := a.AddPeer(newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("hello"))
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := b.sink.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	dial := recorder.span("quicwire.peer.dial")
+	if dial == nil {
+		t.Fatal("no quicwire.peer.dial span recorded")
+	}
+	if dial.status == codes.Error {
+		t.Error("dial span of a successful connection has an error status")
+	}
+	tests := []struct {
+		name   string
+		parent string
+	}{
+		{name: "quicwire.peer.dial.attempt", parent: "quicwire.peer.dial"},
+		{name: "quicwire.peer.handshake", parent: "quicwire.peer.dial.attempt"},
+		{name: "quicwire.peer.first_packet", parent: "quicwire.peer.dial"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := recorder.span(tt.name)
+			if span == nil {
+				t.Fatalf("no %s span recorded", tt.name)
+			}
+			parent := recorder.span(tt.parent)
+			if parent == nil || span.parent.SpanID() != parent.sc.SpanID() {
+				t.Errorf("parent of %s is not the %s span", tt.name, tt.parent)
+			}
+			if span.sc.TraceID() != dial.sc.TraceID() {
+				t.Errorf("trace of %s = %s, want the dial trace %s", tt.name, span.sc.TraceID(), dial.sc.TraceID())
+			}
+		})
+	}
+}
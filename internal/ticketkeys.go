@@ -0,0 +1,76 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultTicketKeyRotation is how often the server replaces the key encrypting session tickets
+	defaultTicketKeyRotation = 6 * time.Hour
+	// ticketKeyCount is the number of keys the server keeps, tickets
+	// encrypted with the older ones still resume until they are rotated out
+	ticketKeyCount = 3
+)
+
+// parseTicketKeys parses comma separated session ticket keys of 32 hex encoded bytes each
+func parseTicketKeys(value string) ([][32]byte, error) {
+	var keys [][32]byte
+	for _, s := range strings.Split(value, ",") {
+		b, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil || len(b) != 32 {
+			return nil, fmt.Errorf("invalid session ticket key, expected 32 hex encoded bytes")
+		}
+		keys = append(keys, [32]byte(b))
+	}
+	return keys, nil
+}
+
+// ticketKeyRing holds the session ticket keys of a server TLS config, newest first
+type ticketKeyRing struct {
+	conf *tls.Config
+	keys [][32]byte
+}
+
+// rotate sets a new random key encrypting the session tickets, keeping the
+// ticketKeyCount most recent keys to decrypt the tickets issued before
+func (r *ticketKeyRing) rotate() error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return fmt.Errorf("failed to generate a session ticket key: %w", err)
+	}
+	r.keys = append([][32]byte{key}, r.keys...)
+	if len(r.keys) > ticketKeyCount {
+		r.keys = r.keys[:ticketKeyCount]
+	}
+	r.conf.SetSessionTicketKeys(r.keys)
+	return nil
+}
+
+// rotateTicketKeys sets a new random session ticket key on the server TLS
+// config every interval until ctx is done, see ticketKeyRing
+func rotateTicketKeys(ctx context.Context, conf *tls.Config, interval time.Duration) error {
+	ring := &ticketKeyRing{conf: conf}
+	if err := ring.rotate(); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// crypto/rand does not fail on supported platforms, the current keys stay in use if it does
+			ring.rotate()
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,101 @@
+package quicwire
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// resumes runs a TLS 1.3 handshake over a pipe and reports whether the
+// client resumed a session. The client reads a byte the server writes after
+// the handshake, which delivers the session ticket to its cache.
+func resumes(t *testing.T, server *tls.Config, client *tls.Config) bool {
+	t.Helper()
+	clientSide, serverSide := net.Pipe()
+	defer clientSide.Close()
+	defer serverSide.Close()
+	done := make(chan error, 1)
+	go func() {
+		conn := tls.Server(serverSide, server)
+		if err := conn.Handshake(); err != nil {
+			done <- err
+			return
+		}
+		_, err := conn.Write([]byte{1})
+		done <- err
+	}()
+	conn := tls.Client(clientSide, client)
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return conn.ConnectionState().DidResume
+}
+
+func TestTicketKeyRotation(t *testing.T) {
+	server := getTLSConfig()
+	ring := &ticketKeyRing{conf: server}
+	if err := ring.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	client := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "quicwire",
+		NextProtos:         []string{defaultALPN},
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	if resumes(t, server, client) {
+		t.Fatal("the first connection resumed a session")
+	}
+
+	// The ticket was issued with the previous key, which still decrypts it
+	if err := ring.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if !resumes(t, server, client) {
+		t.Error("the session did not resume within the rotation window")
+	}
+
+	// Rotating the key of the last ticket out falls back to a full handshake
+	for i := 0; i < ticketKeyCount; i++ {
+		if err := ring.rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ring.keys) != ticketKeyCount {
+		t.Errorf("kept %d keys, want %d", len(ring.keys), ticketKeyCount)
+	}
+	if resumes(t, server, client) {
+		t.Error("resumed a session after its key was rotated out")
+	}
+}
+
+func TestSharedTicketKeys(t *testing.T) {
+	keys, err := parseTicketKeys("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Servers of a cluster sharing the keys resume the sessions of each other
+	first, second := getTLSConfig(), getTLSConfig()
+	first.SetSessionTicketKeys(keys)
+	second.SetSessionTicketKeys(keys)
+	client := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "quicwire",
+		NextProtos:         []string{defaultALPN},
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	resumes(t, first, client)
+	if !resumes(t, second, client) {
+		t.Error("the session issued by another server sharing the keys did not resume")
+	}
+
+	if _, err := parseTicketKeys("0001"); err == nil {
+		t.Error("a short session ticket key was accepted")
+	}
+}
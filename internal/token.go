@@ -0,0 +1,78 @@
+package quicwire
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// errCodeToken closes connections presenting a wrong connection token
+const errCodeToken quic.ApplicationErrorCode = 0x5
+
+// errTokenMismatch is returned for a peer presenting a wrong connection token
+var errTokenMismatch = errors.New("connection token mismatch")
+
+// tokenExporterLabel is the TLS exporter label of the keying material token proofs are bound to
+const tokenExporterLabel = "EXPORTER-quicwire-connection-token"
+
+// tokenProof proves knowledge of the connection token without sending it: an
+// HMAC keyed with the token over keying material exported from the TLS
+// session of the connection. A server impersonating a peer learns nothing it
+// could present elsewhere, proofs are bound to their connection and to the
+// end sending them, so a proof can not be reflected back either.
+func tokenProof(token string, conn quic.Connection, dialer bool) (string, error) {
+	state := conn.ConnectionState().TLS
+	if !state.HandshakeComplete {
+		return "", fmt.Errorf("TLS handshake is not complete")
+	}
+	role := []byte("acceptor")
+	if dialer {
+		role = []byte("dialer")
+	}
+	ekm, err := state.ExportKeyingMaterial(tokenExporterLabel, role, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(ekm)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyToken rejects a peer whose hello does not prove it knows the
+// connection token of the node, ok tells whether the hello exchange
+// succeeded. Nodes without a token accept every peer.
+func (qn *QuicWire) verifyToken(c *Client, remote hello, ok bool) error {
+	local := qn.qc.nodeInterface.connectionToken
+	if local == "" {
+		return nil
+	}
+	// The peer sent the proof of the other end of the connection
+	expected, err := tokenProof(local, c.connection, !c.outbound)
+	if err == nil && ok && hmac.Equal([]byte(remote.TokenProof), []byte(expected)) {
+		return nil
+	}
+	switch {
+	case err != nil:
+		err = fmt.Errorf("%w: failed to check the proof of peer %s: %v", errTokenMismatch, c.addr, err)
+	case !ok:
+		err = fmt.Errorf("%w: peer %s did not complete the hello", errTokenMismatch, c.addr)
+	case remote.TokenProof == "":
+		err = fmt.Errorf("%w: peer %s presented no token proof", errTokenMismatch, c.addr)
+	default:
+		err = fmt.Errorf("%w: peer %s presented a wrong token proof", errTokenMismatch, c.addr)
+	}
+	qn.logger.Errorf("Rejected peer: %v", err)
+	qn.rejectConnection(c, errCodeToken, "connection token mismatch")
+	return err
+}
+
+// requireAddressValidation makes every new connection prove it owns its
+// source address with a stateless retry before the server keeps any state
+func requireAddressValidation(net.Addr) bool {
+	return true
+}
@@ -0,0 +1,148 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+	"go.uber.org/zap"
+)
+
+func TestTokenProofBinding(t *testing.T) {
+	dialed, accepted := newTestConnPair(t)
+	otherDialed, _ := newTestConnPair(t)
+
+	proof := func(token string, conn *testConn, dialer bool) string {
+		p, err := tokenProof(token, conn, dialer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	if proof("secret", dialed, true) != proof("secret", accepted, true) {
+		t.Error("the ends of a connection compute different proofs")
+	}
+	if proof("secret", dialed, true) == proof("secret", dialed, false) {
+		t.Error("the proofs of the dialer and the acceptor are equal")
+	}
+	if proof("secret", dialed, true) == proof("other", dialed, true) {
+		t.Error("the proofs of different tokens are equal")
+	}
+	if proof("secret", dialed, true) == proof("secret", otherDialed, true) {
+		t.Error("the proofs of different connections are equal")
+	}
+}
+
+func TestVerifyToken(t *testing.T) {
+	// proofOf returns the proof of token sent by one end of the connection
+	proofOf := func(token string, dialer bool) func(t *testing.T, dialed, accepted *testConn) string {
+		return func(t *testing.T, dialed, accepted *testConn) string {
+			conn := accepted
+			if dialer {
+				conn = dialed
+			}
+			p, err := tokenProof(token, conn, dialer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return p
+		}
+	}
+	noProof := func(*testing.T, *testConn, *testConn) string { return "" }
+	tests := []struct {
+		name  string
+		local string
+		// proof returns the proof the accepting peer sends to the dialer
+		proof func(t *testing.T, dialed, accepted *testConn) string
+		ok    bool
+		err   error
+	}{
+		{name: "no token", proof: noProof, ok: true},
+		{name: "proof of the peer", local: "secret", proof: proofOf("secret", false), ok: true},
+		{name: "proof reflected back", local: "secret", proof: proofOf("secret", true), ok: true, err: errTokenMismatch},
+		{name: "proof of another token", local: "secret", proof: proofOf("other", false), ok: true, err: errTokenMismatch},
+		{name: "no proof", local: "secret", proof: noProof, ok: true, err: errTokenMismatch},
+		{name: "hello failed", local: "secret", proof: proofOf("secret", false), err: errTokenMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, _ := newTestQuicWire(t)
+			qn.qc.nodeInterface.connectionToken = tt.local
+			dialed, accepted := newTestConnPair(t)
+			c := newTestClient(t, dialed, true)
+			err := qn.verifyToken(c, hello{TokenProof: tt.proof(t, dialed, accepted)}, tt.ok)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("verifyToken = %v, want %v", err, tt.err)
+			}
+			if closed := dialed.Context().Err() != nil; closed != (tt.err != nil) {
+				t.Errorf("connection closed = %v, want it closed only when rejected", closed)
+			}
+		})
+	}
+}
+
+// retryTracer counts the retries received by the connections it traces
+type retryTracer struct {
+	logging.NullTracer
+	retries atomic.Int32
+}
+
+func (t *retryTracer) TracerForConnection(context.Context, logging.Perspective, logging.ConnectionID) logging.ConnectionTracer {
+	return retryConnTracer{parent: t}
+}
+
+type retryConnTracer struct {
+	logging.NullConnectionTracer
+	parent *retryTracer
+}
+
+func (t retryConnTracer) ReceivedRetry(*logging.Header) {
+	t.parent.retries.Add(1)
+}
+
+func TestStatelessRetryChallengesNewConnections(t *testing.T) {
+	for _, retry := range []bool{false, true} {
+		t.Run(fmt.Sprintf("retry %v", retry), func(t *testing.T) {
+			qn, _ := newTestQuicWire(t)
+			s := NewServer("127.0.0.1:0", nil, zap.NewNop().Sugar())
+			s.SetHandler(func(packetContext) error { return nil })
+			s.SetStatelessRetry(retry)
+			udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer udpConn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go s.StartServer(ctx, udpConn, qn, &wg)
+			wg.Wait()
+
+			tracer := &retryTracer{}
+			conn, err := quic.DialAddrContext(ctx, udpConn.LocalAddr().String(),
+				&tls.Config{InsecureSkipVerify: true, NextProtos: []string{defaultALPN}},
+				&quic.Config{EnableDatagrams: true, Tracer: tracer})
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn.CloseWithError(0, "")
+			// The client proves its address with the retry token and proceeds
+			want := int32(0)
+			if retry {
+				want = 1
+			}
+			if got := tracer.retries.Load(); got != want {
+				t.Errorf("received %d retries, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package quicwire
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// pathTracer reports the validation and the loss of the path of every
+// connection as events, it also counts keep-alive packets as control traffic
+// and keeps the RTT and loss of every connection
+type pathTracer struct {
+	logging.NullTracer
+	qn *QuicWire
+	// stats holds the *pathStats of the open connections by tracing ID
+	stats sync.Map
+}
+
+func newPathTracer(qn *QuicWire) *pathTracer {
+	return &pathTracer{qn: qn}
+}
+
+// TracerForConnection implements logging.Tracer
+func (t *pathTracer) TracerForConnection(ctx context.Context, p logging.Perspective, odcid logging.ConnectionID) logging.ConnectionTracer {
+	ct := &pathConnTracer{qn: t.qn, parent: t, stats: &pathStats{}}
+	if id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64); ok {
+		ct.id = id
+		t.stats.Store(id, ct.stats)
+	}
+	return ct
+}
+
+// connStats returns the path stats of the connection, nil when it is not traced
+func (t *pathTracer) connStats(conn quic.Connection) *pathStats {
+	id, ok := conn.Context().Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+	stats, ok := t.stats.Load(id)
+	if !ok {
+		return nil
+	}
+	return stats.(*pathStats)
+}
+
+// pathStats are the RTT and the packet loss of a connection
+type pathStats struct {
+	smoothedRTT atomic.Int64
+	sent        atomic.Uint64
+	lost        atomic.Uint64
+}
+
+// rtt returns the smoothed RTT of the connection
+func (s *pathStats) rtt() time.Duration {
+	return time.Duration(s.smoothedRTT.Load())
+}
+
+// lossRate returns the share of the packets sent over the connection that were lost
+func (s *pathStats) lossRate() float64 {
+	sent := s.sent.Load()
+	if sent == 0 {
+		return 0
+	}
+	return float64(s.lost.Load()) / float64(sent)
+}
+
+// pathConnTracer follows the path of a single connection. quic-go neither
+// migrates connections nor probes paths with PATH_CHALLENGE frames, so the
+// path counts as validated once the handshake is confirmed and as failed when
+// the connection times out.
+type pathConnTracer struct {
+	logging.NullConnectionTracer
+	qn     *QuicWire
+	parent *pathTracer
+	// id is the tracing ID of the connection, stats are kept under it
+	id    uint64
+	stats *pathStats
+
+	mu     sync.Mutex
+	remote net.Addr
+}
+
+func (t *pathConnTracer) StartedConnection(local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
+	t.mu.Lock()
+	t.remote = remote
+	t.mu.Unlock()
+}
+
+func (t *pathConnTracer) SentShortHeaderPacket(hdr *logging.ShortHeader, size logging.ByteCount, ack *logging.AckFrame, frames []logging.Frame) {
+	t.stats.sent.Add(1)
+	if keepAliveOnly(frames) && (ack != nil || len(frames) > 0) {
+		t.qn.counters.countControl(true, int(size))
+	}
+}
+
+func (t *pathConnTracer) ReceivedShortHeaderPacket(hdr *logging.ShortHeader, size logging.ByteCount, frames []logging.Frame) {
+	if len(frames) > 0 && keepAliveOnly(frames) {
+		t.qn.counters.countControl(false, int(size))
+	}
+}
+
+// DroppedEncryptionLevel is called with the handshake level once the
+// handshake is confirmed, the peer proved it receives on the path
+func (t *pathConnTracer) DroppedEncryptionLevel(level logging.EncryptionLevel) {
+	if level == logging.EncryptionHandshake {
+		t.report(EventPathValidated)
+	}
+}
+
+// keepAliveOnly reports whether a packet carries nothing but PING and ACK
+// frames, packets of keep-alives and acknowledgements are overhead
+func keepAliveOnly(frames []logging.Frame) bool {
+	for _, f := range frames {
+		switch f.(type) {
+		case *logging.PingFrame, *logging.AckFrame:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (t *pathConnTracer) UpdatedMetrics(rttStats *logging.RTTStats, cwnd, bytesInFlight logging.ByteCount, packetsInFlight int) {
+	t.stats.smoothedRTT.Store(int64(rttStats.SmoothedRTT()))
+}
+
+func (t *pathConnTracer) LostPacket(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+	t.stats.lost.Add(1)
+}
+
+// Close is called last once the connection is gone, whether it was established or not
+func (t *pathConnTracer) Close() {
+	if t.id != 0 {
+		t.parent.stats.Delete(t.id)
+	}
+}
+
+func (t *pathConnTracer) ClosedConnection(err error) {
+	var idleErr *quic.IdleTimeoutError
+	var handshakeErr *quic.HandshakeTimeoutError
+	if errors.As(err, &idleErr) || errors.As(err, &handshakeErr) {
+		t.report(EventPathFailed)
+	}
+}
+
+func (t *pathConnTracer) report(typ EventType) {
+	t.mu.Lock()
+	remote := t.remote
+	t.mu.Unlock()
+	if remote == nil {
+		return
+	}
+	t.qn.recordPathEvent(Event{Type: typ, Remote: remote.String()})
+}
+
+// recordPathEvent attributes a path event to its peer, keeps it for the status and publishes it
+func (qn *QuicWire) recordPathEvent(e Event) {
+	host, _, err := net.SplitHostPort(e.Remote)
+	if err != nil {
+		return
+	}
+	qn.mu.Lock()
+	for _, peer := range qn.qc.peers {
+		peerHost, _, err := net.SplitHostPort(peer.endpoint)
+		if err == nil && peerHost == host {
+			e.Peer = peer.allowedIPs[0]
+			break
+		}
+	}
+	qn.logger.Infof("Path event %s for peer %s via %s", e.Type, e.Peer, e.Remote)
+	if e.Peer != "" {
+		qn.pathEvents[e.Peer] = e
+	}
+	qn.mu.Unlock()
+
+	qn.emit(e)
+}
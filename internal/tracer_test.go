@@ -0,0 +1,35 @@
+package quicwire
+
+import (
+	"testing"
+)
+
+func TestPathValidatedEvents(t *testing.T) {
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", nil)
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", nil)
+	endpoint := b.udpConn.LocalAddr().String()
+	if err := a.AddPeer(newTestPeer(endpoint, "10.0.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	validated := func(e Event) bool { return e.Type == EventPathValidated }
+	e := waitEvent(t, a.QuicWire, validated)
+	if e.Peer != "10.0.0.2/32" || e.Remote != endpoint {
+		t.Errorf("event = %+v, want the path to %s of peer 10.0.0.2/32", e, endpoint)
+	}
+
+	// Losing the connection moves the peer to the path of the next dial
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+	a.mu.RLock()
+	first := a.clients["10.0.0.2/32"].connection
+	a.mu.RUnlock()
+	first.CloseWithError(0, "path change")
+	e = waitEvent(t, a.QuicWire, validated)
+	if e.Peer != "10.0.0.2/32" {
+		t.Errorf("event after the path change = %+v, want one for peer 10.0.0.2/32", e)
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if got := a.pathEvents["10.0.0.2/32"]; got.Type != EventPathValidated {
+		t.Errorf("path event kept for the status = %+v, want %s", got, EventPathValidated)
+	}
+}
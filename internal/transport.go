@@ -0,0 +1,36 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Transport creates the QUIC listeners and connections of the mesh. The
+// default uses quic-go over the shared UDP socket, tests can inject an
+// in-memory transport and users can wrap dialing with proxies or
+// instrumentation.
+type Transport interface {
+	// Listen accepts QUIC connections on the packet conn
+	Listen(conn net.PacketConn, tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error)
+	// Dial establishes a QUIC connection to addr from the packet conn
+	Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error)
+}
+
+// quicTransport is the Transport backed by quic-go
+type quicTransport struct{}
+
+// DefaultTransport returns the Transport backed by quic-go
+func DefaultTransport() Transport {
+	return quicTransport{}
+}
+
+func (quicTransport) Listen(conn net.PacketConn, tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error) {
+	return quic.Listen(conn, tlsConf, conf)
+}
+
+func (quicTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	return quic.DialContext(ctx, conn, addr, host, tlsConf, conf)
+}
@@ -0,0 +1,86 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+	"github.com/quic-go/quic-go"
+)
+
+// recordingTransport records the listen and dial calls passed on to Transport
+type recordingTransport struct {
+	Transport
+	mu      sync.Mutex
+	listens []string
+	dials   []string
+}
+
+func (t *recordingTransport) Listen(conn net.PacketConn, tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error) {
+	t.mu.Lock()
+	t.listens = append(t.listens, conn.LocalAddr().String())
+	t.mu.Unlock()
+	return t.Transport.Listen(conn, tlsConf, conf)
+}
+
+func (t *recordingTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	t.mu.Lock()
+	t.dials = append(t.dials, conn.LocalAddr().String()+" -> "+addr.String())
+	t.mu.Unlock()
+	return t.Transport.Dial(ctx, conn, addr, host, tlsConf, conf)
+}
+
+// calls returns the listen and dial calls recorded so far
+func (t *recordingTransport) calls() ([]string, []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.listens...), append([]string(nil), t.dials...)
+}
+
+func TestTransportCarriesPeerTraffic(t *testing.T) {
+	mesh := newMemNetwork()
+	transportA := &recordingTransport{Transport: mesh.transport()}
+	transportB := &recordingTransport{Transport: mesh.transport()}
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(transportB) })
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) { qn.SetTransport(transportA) },
+		newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("in memory"))
+	if err := a.InjectPacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := b.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got[0]) != string(packet) {
+		t.Errorf("B received %x, want %x", got[0], packet)
+	}
+
+	// Both nodes listen on their shared socket, only A dials, from its shared socket
+	for _, node := range []struct {
+		name      string
+		transport *recordingTransport
+		qn        *QuicWire
+		dials     []string
+	}{
+		{name: "A", transport: transportA, qn: a.QuicWire, dials: []string{a.udpConn.LocalAddr().String() + " -> " + b.udpConn.LocalAddr().String()}},
+		{name: "B", transport: transportB, qn: b.QuicWire},
+	} {
+		listens, dials := node.transport.calls()
+		if len(listens) != 1 || listens[0] != node.qn.udpConn.LocalAddr().String() {
+			t.Errorf("%s listened on %v, want %s", node.name, listens, node.qn.udpConn.LocalAddr())
+		}
+		if len(dials) != len(node.dials) || (len(dials) > 0 && dials[0] != node.dials[0]) {
+			t.Errorf("%s dialed %v, want %v", node.name, dials, node.dials)
+		}
+	}
+}
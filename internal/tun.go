@@ -0,0 +1,109 @@
+package quicwire
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// tunWriteLogInterval is the minimum time between two logs of failed tunnel writes
+const tunWriteLogInterval = 10 * time.Second
+
+// errPacketTooBig is returned when a packet does not fit the MTU of the receiving tunnel interface
+var errPacketTooBig = errors.New("packet too big")
+
+// tunMTU returns the MTU of the tunnel interface
+func (qn *QuicWire) tunMTU() int {
+	if qn.qc.nodeInterface.mtu > 0 {
+		return qn.qc.nodeInterface.mtu
+	}
+	return tunDevMTU
+}
+
+// writeTun writes a packet received from a peer to the local tunnel interface.
+// Packets larger than the interface MTU are dropped and the peer is told the
+// MTU so it stops sending them, other write errors are logged.
+func (qn *QuicWire) writeTun(c packetContext) {
+	if qn.packetHandler != nil {
+		qn.packetHandler(c.Data)
+		return
+	}
+	var err error
+	mtu := qn.maxPacket()
+	if len(c.Data) > mtu {
+		err = syscall.EMSGSIZE
+	} else {
+		err = qn.writeTunIface(c.Data)
+	}
+	if err == nil {
+		return
+	}
+
+	if errors.Is(err, syscall.EMSGSIZE) {
+		qn.recordDrop(dropTooBig, c.RemoteAddr().String(), len(c.Data), c.Data)
+		if qn.tunWriteLog.allow() {
+			qn.logger.Warnf("Dropped packet of %d bytes from %s exceeding the tunnel MTU of %d", len(c.Data), c.RemoteAddr(), mtu)
+		}
+		payload := make([]byte, 2)
+		binary.BigEndian.PutUint16(payload, uint16(mtu))
+		frame := encodeFrame(frameTooBig, 0, 0, payload)
+		if err := c.SendMessage(frame); err != nil {
+			qn.logger.Debugf("Failed to signal the MTU to %s: %v", c.RemoteAddr(), err)
+		} else {
+			qn.counters.countControl(true, len(frame))
+		}
+		return
+	}
+
+	qn.recordDrop(dropTunWrite, c.RemoteAddr().String(), len(c.Data), c.Data)
+	if qn.tunWriteLog.allow() {
+		qn.logger.Errorf("Failed to write packet from %s to the tunnel interface: %v", c.RemoteAddr(), err)
+	}
+}
+
+// writeTunIface writes a packet to the tunnel interface. A write failing
+// because the interface itself failed closes it, the forwarding loop then
+// fails to read it and applies the TunFailure policy.
+func (qn *QuicWire) writeTunIface(packet []byte) error {
+	tun := qn.tun.Load()
+	_, err := tun.Write(packet)
+	if err != nil && tunIfaceFailed(err) && qn.ctx.Err() == nil {
+		// Only the first writer closes it, the interface may be recreated meanwhile
+		if tun.Close() == nil {
+			qn.logger.Errorf("Failed to write packet to TUN interface, closing it: %v", err)
+		}
+	}
+	return err
+}
+
+// tunIfaceFailed reports whether a tunnel write failed because of the
+// interface rather than of the packet
+func tunIfaceFailed(err error) bool {
+	return errors.Is(err, syscall.EBADFD) || errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.ENODEV) || errors.Is(err, syscall.ENXIO)
+}
+
+// rateLimiter allows an action at most once per interval
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+func newRateLimiter(interval time.Duration) *rateLimiter {
+	return &rateLimiter{interval: interval}
+}
+
+// allow reports whether the action may run now
+func (r *rateLimiter) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.last) < r.interval {
+		return false
+	}
+	r.last = now
+	return true
+}
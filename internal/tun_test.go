@@ -0,0 +1,161 @@
+package quicwire
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+	"github.com/songgao/water"
+	"go.uber.org/zap"
+)
+
+// stubTun is a tunnel device rejecting packets larger than its MTU. Reads
+// return the packets queued on reads and fail once it is closed.
+type stubTun struct {
+	mtu       int
+	reads     chan []byte
+	closeOnce sync.Once
+	mu        sync.Mutex
+	written   [][]byte
+}
+
+func (s *stubTun) Read(p []byte) (int, error) {
+	b, ok := <-s.reads
+	if !ok {
+		return 0, os.ErrClosed
+	}
+	return copy(p, b), nil
+}
+
+func (s *stubTun) Close() error {
+	s.closeOnce.Do(func() {
+		if s.reads != nil {
+			close(s.reads)
+		}
+	})
+	return nil
+}
+
+func (s *stubTun) Write(p []byte) (int, error) {
+	if len(p) > s.mtu {
+		return 0, syscall.EMSGSIZE
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestWriteTunHandsPacketsToHandler(t *testing.T) {
+	qn, sink := newTestQuicWire(t)
+	src := netip.MustParseAddrPort("10.1.0.1:4000")
+	dst := netip.MustParseAddrPort("10.0.0.1:5000")
+	packets := [][]byte{
+		packettest.UDP(src, dst, []byte("one")),
+		packettest.UDP(src, dst, []byte("two")),
+		packettest.UDP(src, dst, []byte("three")),
+	}
+	for _, packet := range packets {
+		qn.writeTun(packetContext{Data: packet})
+	}
+	got := sink.Packets()
+	if len(got) != len(packets) {
+		t.Fatalf("handled %d packets, want %d", len(got), len(packets))
+	}
+	for i := range packets {
+		if string(got[i]) != string(packets[i]) {
+			t.Errorf("packet %d = %v, want %v", i, got[i], packets[i])
+		}
+	}
+}
+
+func TestWriteTunSignalsTooBig(t *testing.T) {
+	src := netip.MustParseAddrPort("10.1.0.1:4000")
+	dst := netip.MustParseAddrPort("10.0.0.1:5000")
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "over the device MTU", size: 1100},
+		{name: "over the tunnel MTU", size: 1300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qn, err := NewQuicWire(zap.NewNop().Sugar(), "", true, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qn.qc.nodeInterface.mtu = 1200
+			stub := &stubTun{mtu: 1000}
+			qn.tun.Store(&water.Interface{ReadWriteCloser: stub})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			conn := &testConn{remote: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 55381}, ctx: ctx, cancel: cancel}
+
+			small := packettest.UDP(src, dst, make([]byte, 100))
+			big := packettest.UDP(src, dst, make([]byte, tt.size-28))
+			qn.writeTun(packetContext{Connection: conn, Data: small})
+			qn.writeTun(packetContext{Connection: conn, Data: big})
+
+			if len(stub.written) != 1 || len(stub.written[0]) != len(small) {
+				t.Errorf("device got %d packets, want only the small one", len(stub.written))
+			}
+			sent := conn.messages()
+			if len(sent) != 1 {
+				t.Fatalf("sent %d frames to the peer, want 1", len(sent))
+			}
+			f, err := decodeFrame(sent[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.typ != frameTooBig || len(f.payload) != 2 || binary.BigEndian.Uint16(f.payload) != 1200 {
+				t.Errorf("signal = type %#x payload %v, want too big with the MTU 1200", f.typ, f.payload)
+			}
+			drops := qn.RecentDrops()
+			if len(drops) != 1 || drops[0].Reason != dropTooBig || drops[0].Size != len(big) {
+				t.Errorf("drops = %+v, want one %s drop of %d bytes", drops, dropTooBig, len(big))
+			}
+		})
+	}
+}
+
+func TestTunReadsSkipShortPackets(t *testing.T) {
+	mesh := newMemNetwork()
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+	stub := &stubTun{mtu: 1500, reads: make(chan []byte, 3)}
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+		qn.SetTransport(mesh.transport())
+		qn.tun.Store(&water.Interface{ReadWriteCloser: stub})
+	}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("after"))
+	stub.reads <- nil
+	stub.reads <- packet[:10]
+	stub.reads <- packet
+	if err := a.enableTrafficForwarding(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The whole packet read last is forwarded, so the short reads did not stop the loop
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := b.sink.Wait(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || string(got[0]) != string(packet) {
+		t.Errorf("peer received %d packets, want only the whole one", len(got))
+	}
+	drops := a.RecentDrops()
+	if len(drops) != 1 || drops[0].Reason != dropRunt || drops[0].Size != 10 {
+		t.Errorf("drops = %+v, want one %s drop of 10 bytes", drops, dropRunt)
+	}
+}
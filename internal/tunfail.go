@@ -0,0 +1,131 @@
+package quicwire
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+const (
+	// tunFailureStop closes every peer connection and stops the node
+	tunFailureStop = "stop"
+	// tunFailureKeep keeps the peer connections up but stops forwarding
+	tunFailureKeep = "keep"
+	// tunFailureRecover recreates the tunnel interface and resumes forwarding
+	tunFailureRecover = "recover"
+)
+
+const (
+	// defaultTunRetryInterval is the first wait between attempts to create the tunnel interface at startup
+	defaultTunRetryInterval = time.Second
+	// maxTunRetryInterval caps the backoff between the attempts
+	maxTunRetryInterval = 30 * time.Second
+)
+
+// newTunIface creates and configures the tunnel interface of the node, replaced by tests
+var newTunIface = (*QuicWire).createTunIface
+
+// Done returns a channel closed once the node stopped, either by Stop or
+// after a fatal failure, see Err
+func (qn *QuicWire) Done() <-chan struct{} {
+	return qn.ctx.Done()
+}
+
+// Err returns the failure that stopped the node, nil while it runs or when it was stopped by Stop
+func (qn *QuicWire) Err() error {
+	qn.failMu.Lock()
+	defer qn.failMu.Unlock()
+	return qn.failErr
+}
+
+// fail stops the node after a failure it can not recover from, the first
+// failure is reported by Err
+func (qn *QuicWire) fail(err error) {
+	qn.failMu.Lock()
+	if qn.failErr == nil {
+		qn.failErr = err
+	}
+	qn.failMu.Unlock()
+	qn.logger.Errorf("Stopping: %v", err)
+	go qn.Stop()
+}
+
+// tunFailurePolicy returns what to do when the tunnel interface fails
+func (qn *QuicWire) tunFailurePolicy() string {
+	if qn.qc.nodeInterface.tunFailure != "" {
+		return qn.qc.nodeInterface.tunFailure
+	}
+	return tunFailureStop
+}
+
+// handleTunFailure applies the configured policy to a failed tunnel
+// interface, found by the forwarding loop failing to read it. Writes failing
+// because the interface itself failed close it, see writeTunIface, so every
+// failure reaches the policy through the read. It reports whether forwarding
+// can resume.
+func (qn *QuicWire) handleTunFailure(err error) bool {
+	switch qn.tunFailurePolicy() {
+	case tunFailureKeep:
+		qn.logger.Errorf("Failed to read packet from TUN interface, forwarding stopped, peer connections are kept: %v", err)
+		return false
+	case tunFailureRecover:
+		qn.logger.Errorf("Failed to read packet from TUN interface, recreating it: %v", err)
+		return qn.recoverTunIface()
+	default:
+		qn.fail(fmt.Errorf("failed to read packet from TUN interface: %w", err))
+		return false
+	}
+}
+
+// recoverTunIface replaces the failed tunnel interface, retrying with the
+// backoff of startTunIface. Without TunRetries it retries until the node is
+// stopped, otherwise a last failed attempt stops the node.
+func (qn *QuicWire) recoverTunIface() bool {
+	if old := qn.tun.Load(); old != nil {
+		old.Close()
+	}
+	retries := qn.qc.nodeInterface.tunRetries
+	if retries == 0 {
+		retries = -1
+	}
+	err := backoff.RetryNotify(func() error { return newTunIface(qn) }, qn.tunBackOff(qn.ctx, retries), func(err error, wait time.Duration) {
+		qn.logger.Warnf("Failed to recreate TUN interface, retrying in %s: %v", wait.Round(time.Millisecond), err)
+	})
+	if err != nil {
+		if qn.ctx.Err() == nil {
+			qn.fail(fmt.Errorf("failed to recreate TUN interface: %w", err))
+		}
+		return false
+	}
+	qn.logger.Infof("TUN interface %s recreated, forwarding resumed", qn.tun.Load().Name())
+	return true
+}
+
+// startTunIface creates the tunnel interface at startup, retrying up to
+// TunRetries times with an exponential backoff so a node started early in
+// boot can wait for the tun device to become available
+func (qn *QuicWire) startTunIface(ctx context.Context) error {
+	bo := qn.tunBackOff(ctx, qn.qc.nodeInterface.tunRetries)
+	return backoff.RetryNotify(func() error { return newTunIface(qn) }, bo, func(err error, wait time.Duration) {
+		qn.logger.Warnf("Failed to create TUN interface, retrying in %s: %v", wait.Round(time.Millisecond), err)
+	})
+}
+
+// tunBackOff returns the backoff between attempts to create the tunnel
+// interface, a negative number of retries retries until ctx is done
+func (qn *QuicWire) tunBackOff(ctx context.Context, retries int) backoff.BackOff {
+	interval := qn.qc.nodeInterface.tunRetryInterval
+	if interval <= 0 {
+		interval = defaultTunRetryInterval
+	}
+	eb := backoff.NewExponentialBackOff()
+	eb.InitialInterval = interval
+	eb.MaxInterval = maxTunRetryInterval
+	eb.MaxElapsedTime = 0
+	if retries < 0 {
+		return backoff.WithContext(eb, ctx)
+	}
+	return backoff.WithContext(backoff.WithMaxRetries(eb, uint64(retries)), ctx)
+}
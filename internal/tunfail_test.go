@@ -0,0 +1,148 @@
+package quicwire
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+	"github.com/songgao/water"
+	"go.uber.org/zap"
+)
+
+func TestTunFailurePolicies(t *testing.T) {
+	packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), []byte("after"))
+	tests := []struct {
+		policy string
+		// stopped is whether the node stops, resumed whether forwarding resumes
+		stopped bool
+		resumed bool
+	}{
+		{policy: tunFailureStop, stopped: true},
+		{policy: tunFailureKeep},
+		{policy: tunFailureRecover, resumed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			// Recovering replaces the failed device with a working one
+			recovered := &stubTun{mtu: 1500, reads: make(chan []byte, 1)}
+			orig := newTunIface
+			newTunIface = func(qn *QuicWire) error {
+				qn.tun.Store(&water.Interface{ReadWriteCloser: recovered})
+				return nil
+			}
+			t.Cleanup(func() { newTunIface = orig })
+
+			mesh := newMemNetwork()
+			b := startTestNode(t, "127.0.0.2", "10.0.0.2", func(qn *QuicWire) { qn.SetTransport(mesh.transport()) })
+			failing := &stubTun{mtu: 1500, reads: make(chan []byte)}
+			a := startTestNode(t, "127.0.0.1", "10.0.0.1", func(qn *QuicWire) {
+				qn.SetTransport(mesh.transport())
+				qn.tun.Store(&water.Interface{ReadWriteCloser: failing})
+				qn.qc.nodeInterface.tunFailure = tt.policy
+				qn.qc.nodeInterface.tunRetryInterval = 10 * time.Millisecond
+			}, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+			waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+			if err := a.enableTrafficForwarding(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Reads of the closed device fail
+			failing.Close()
+			recovered.reads <- packet
+			wait := 200 * time.Millisecond
+			if tt.resumed {
+				wait = 5 * time.Second
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), wait)
+			defer cancel()
+			_, err := b.sink.Wait(ctx, 1)
+			if resumed := err == nil; resumed != tt.resumed {
+				t.Errorf("forwarding resumed = %v, want %v", resumed, tt.resumed)
+			}
+
+			select {
+			case <-a.Done():
+				if !tt.stopped {
+					t.Fatalf("node stopped: %v", a.Err())
+				}
+				if !errors.Is(a.Err(), os.ErrClosed) {
+					t.Errorf("Err() = %v, want the read error", a.Err())
+				}
+				return
+			case <-time.After(100 * time.Millisecond):
+				if tt.stopped {
+					t.Fatal("node kept running")
+				}
+			}
+			// The peer connection outlives the failed device
+			waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+		})
+	}
+}
+
+func TestStartRetriesTunIface(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		retries  int
+		attempts int
+		wantErr  bool
+	}{
+		{name: "created on a retry", failures: 2, retries: 3, attempts: 3},
+		{name: "retries exhausted", failures: 5, retries: 2, attempts: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The device shows up after failures attempts, like the tun module loading late in boot
+			attempts := 0
+			orig := newTunIface
+			newTunIface = func(qn *QuicWire) error {
+				attempts++
+				if attempts <= tt.failures {
+					return os.ErrNotExist
+				}
+				qn.tun.Store(&water.Interface{ReadWriteCloser: &stubTun{mtu: 1500, reads: make(chan []byte)}})
+				return nil
+			}
+			t.Cleanup(func() { newTunIface = orig })
+
+			stunServers := startTestSTUN(t, nil) + "," + startTestSTUN(t, nil)
+			conf := filepath.Join(t.TempDir(), "quicwire.conf")
+			err := os.WriteFile(conf, []byte(`[Interface]
+LocalEndpoint = 10.0.0.1/24
+LocalNodeIp = 127.0.0.1
+StunServers = `+stunServers+`
+TunRetries = `+strconv.Itoa(tt.retries)+`
+TunRetryInterval = 10ms
+`), 0o600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qn, err := NewQuicWire(zap.NewNop().Sugar(), conf, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qn.SetTransport(newMemNetwork().transport())
+			var wg sync.WaitGroup
+			err = qn.Start(context.Background(), &wg)
+			t.Cleanup(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				qn.StopContext(ctx)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start() error = %v, want an error: %v", err, tt.wantErr)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("tried to create the interface %d times, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package quicwire
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"syscall"
+
+	"github.com/quic-go/quic-go"
+)
+
+// unbatchedConn hides the batched receive path of a UDP socket from quic-go,
+// which then reads a single datagram per syscall instead of a batch with
+// recvmmsg. It also gives up on ECN and the packet info of received datagrams.
+type unbatchedConn struct {
+	net.PacketConn
+	udp *net.UDPConn
+}
+
+// SyscallConn keeps quic-go setting the don't fragment bit on the socket
+func (c unbatchedConn) SyscallConn() (syscall.RawConn, error) {
+	return c.udp.SyscallConn()
+}
+
+func (c unbatchedConn) SetReadBuffer(bytes int) error {
+	return c.udp.SetReadBuffer(bytes)
+}
+
+func (c unbatchedConn) SetWriteBuffer(bytes int) error {
+	return c.udp.SetWriteBuffer(bytes)
+}
+
+// unbatched wraps UDP sockets in an unbatchedConn, other packet conns are returned as is
+func unbatched(conn net.PacketConn) net.PacketConn {
+	if udp, ok := conn.(*net.UDPConn); ok {
+		return unbatchedConn{PacketConn: udp, udp: udp}
+	}
+	return conn
+}
+
+// unbatchedTransport is a Transport reading the UDP sockets it is given one datagram at a time
+type unbatchedTransport struct {
+	Transport
+}
+
+func (t unbatchedTransport) Listen(conn net.PacketConn, tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error) {
+	return t.Transport.Listen(unbatched(conn), tlsConf, conf)
+}
+
+func (t unbatchedTransport) Dial(ctx context.Context, conn net.PacketConn, addr net.Addr, host string, tlsConf *tls.Config, conf *quic.Config) (quic.Connection, error) {
+	return t.Transport.Dial(ctx, unbatched(conn), addr, host, tlsConf, conf)
+}
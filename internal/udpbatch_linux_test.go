@@ -0,0 +1,47 @@
+//go:build linux
+
+package quicwire
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+// BenchmarkReceive forwards bursts of packets between two nodes reading the
+// shared socket with recvmmsg batches and one datagram per syscall
+func BenchmarkReceive(b *testing.B) {
+	for _, batched := range []bool{true, false} {
+		b.Run(fmt.Sprintf("batched=%v", batched), func(b *testing.B) {
+			configure := func(qn *QuicWire) {
+				if !batched {
+					qn.qc.nodeInterface.noUDPBatching = true
+					qn.SetTransport(unbatchedTransport{qn.transport})
+				}
+			}
+			dst := startTestNode(b, "127.0.0.2", "10.0.0.2", configure)
+			src := startTestNode(b, "127.0.0.1", "10.0.0.1", configure, newTestPeer(dst.udpConn.LocalAddr().String(), "10.0.0.2"))
+			waitPeerState(b, src.QuicWire, "10.0.0.2/32", PeerConnected)
+			packet := packettest.UDP(netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000"), make([]byte, 1000))
+
+			b.SetBytes(int64(len(packet)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := src.InjectPacket(packet); err != nil {
+					b.Fatal(err)
+				}
+			}
+			// Datagrams may be lost under load, wait until no more arrive
+			received := -1
+			for received != dst.sink.Len() && dst.sink.Len() < b.N {
+				received = dst.sink.Len()
+				time.Sleep(50 * time.Millisecond)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(dst.sink.Len())/float64(b.N), "delivered/op")
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package quicwire
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/packetdrop/quicwire/internal/packettest"
+)
+
+func TestUnbatchedHidesBatchedReads(t *testing.T) {
+	udp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	conn := unbatched(udp)
+	if _, ok := conn.(interface {
+		ReadMsgUDP(b, oob []byte) (int, int, int, *net.UDPAddr, error)
+	}); ok {
+		t.Error("unbatched socket still offers ReadMsgUDP")
+	}
+	if _, ok := conn.(interface{ SetReadBuffer(int) error }); !ok {
+		t.Error("unbatched socket lost SetReadBuffer")
+	}
+}
+
+func TestForwardingWithoutUDPBatching(t *testing.T) {
+	unbatchedNode := func(qn *QuicWire) {
+		qn.qc.nodeInterface.noUDPBatching = true
+		qn.SetTransport(unbatchedTransport{qn.transport})
+	}
+	b := startTestNode(t, "127.0.0.2", "10.0.0.2", unbatchedNode)
+	a := startTestNode(t, "127.0.0.1", "10.0.0.1", unbatchedNode, newTestPeer(b.udpConn.LocalAddr().String(), "10.0.0.2"))
+	waitPeerState(t, a.QuicWire, "10.0.0.2/32", PeerConnected)
+
+	src, dst := netip.MustParseAddrPort("10.0.0.1:4000"), netip.MustParseAddrPort("10.0.0.2:5000")
+	packets := [][]byte{
+		packettest.UDP(src, dst, []byte("one at a time")),
+		packettest.UDP(src, dst, []byte("still arrives")),
+	}
+	if _, err := packettest.NewSource(a.InjectPacket).Send(packets...); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	got, err := b.sink.Wait(ctx, len(packets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range packets {
+		if string(got[i]) != string(packets[i]) {
+			t.Errorf("packet %d = %v, want %v", i, got[i], packets[i])
+		}
+	}
+}
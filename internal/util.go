@@ -6,26 +6,34 @@ import (
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/pem"
 	"math/big"
 	"os"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
-	"github.com/quic-go/quic-go"
-	"github.com/songgao/water"
 )
 
 // Handler is a function that processes incoming packets
 type Handler func(packetContext) error
 
+// defaultALPN is the application protocol negotiated by quicwire peers
+const defaultALPN = "some-proto"
+
 // Setup a bare-bones TLS config for the server
 func getTLSConfig() *tls.Config {
 	key, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
 		panic(err)
 	}
-	template := x509.Certificate{SerialNumber: big.NewInt(1)}
+	// The validity period lets peers checking it detect a wrong clock
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certLifetime),
+	}
 	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
 	if err != nil {
 		panic(err)
@@ -39,35 +47,142 @@ func getTLSConfig() *tls.Config {
 	}
 	return &tls.Config{
 		Certificates: []tls.Certificate{tlsCert},
-		NextProtos:   []string{"some-proto"},
+		NextProtos:   []string{defaultALPN},
 	}
 }
 
-func handleMsg(tunIP *water.Interface, conn quic.Connection, handler Handler) error {
+func handleMsg(c *Client) error {
+	conn := c.connection
+	defer c.reassembler.reset()
 	for {
 		data, err := conn.ReceiveMessage()
 		if err != nil {
 			return err
 		}
-		err = handler(packetContext{
-			localIf:    tunIP,
-			Connection: conn,
-			Data:       data,
-		})
+		f, err := decodeFrame(data)
+		if err != nil {
+			// Drop malformed datagrams rather than tearing down the connection
+			continue
+		}
+		if !f.isData() {
+			c.countControl(false, len(data))
+		}
+		switch f.typ {
+		case frameAck:
+			c.window.ack(f.seq)
+			continue
+		case frameProbe:
+			reply := f.payload
+			if f.flags&frameFlagShortReply != 0 {
+				reply = binary.BigEndian.AppendUint16(nil, uint16(frameHeaderLen+len(f.payload)))
+			}
+			if err := c.sendControl(encodeFrame(frameProbeReply, 0, f.seq, reply)); err != nil {
+				return err
+			}
+			continue
+		case frameGoodbye:
+			if f.flags&frameFlagIdle != 0 {
+				c.logger.Infof("Peer %s is closing the idle connection", c.addr)
+			} else {
+				c.logger.Infof("Peer %s is shutting down", c.addr)
+			}
+			c.draining.Store(true)
+			if err := c.sendControl(encodeFrame(frameProbeReply, 0, f.seq, nil)); err != nil {
+				return err
+			}
+			continue
+		case frameProbeReply:
+			c.probeReplied(f.seq)
+			continue
+		case frameTooBig:
+			if len(f.payload) >= 2 {
+				c.setPathMTU(int(binary.BigEndian.Uint16(f.payload)))
+			}
+			continue
+		case framePathMTU:
+			if len(f.payload) >= 2 {
+				c.setRecvMTU(int(binary.BigEndian.Uint16(f.payload)))
+			}
+			continue
+		case frameData, frameFragment, frameMirror, frameBatch, frameCompressed, frameRelay:
+			if f.flags&frameFlagAckRequest != 0 {
+				if err := c.sendControl(encodeFrame(frameAck, 0, f.seq, nil)); err != nil {
+					return err
+				}
+			}
+		default:
+			continue
+		}
+
+		if f.typ == frameCompressed {
+			err = c.receiveCompressed(packetContext{
+				localIf:    c.tunnelInterface,
+				Connection: conn,
+				Data:       f.payload,
+			})
+		} else if f.typ == frameBatch {
+			err = splitBatch(f.payload, func(packet []byte) error {
+				return c.receive(packetContext{
+					localIf:    c.tunnelInterface,
+					Connection: conn,
+					Data:       packet,
+				})
+			})
+		} else if f.typ == frameFragment {
+			bufp, n, ok := c.reassembler.add(f)
+			if !ok {
+				continue
+			}
+			err = c.receive(packetContext{
+				localIf:    c.tunnelInterface,
+				Connection: conn,
+				Data:       (*bufp)[:n],
+			})
+			packetPool.Put(bufp)
+		} else if f.typ == frameRelay {
+			if len(f.payload) < 1 {
+				continue
+			}
+			err = c.receive(packetContext{
+				localIf:    c.tunnelInterface,
+				Connection: conn,
+				Data:       f.payload[1:],
+				relayed:    true,
+				hops:       int(f.payload[0]),
+			})
+		} else {
+			err = c.receive(packetContext{
+				localIf:    c.tunnelInterface,
+				Connection: conn,
+				Data:       f.payload,
+				mirrored:   f.typ == frameMirror,
+			})
+		}
 		if err != nil {
 			return err
 		}
 	}
 }
 
-// RetryOperation retries the operation with a backoff policy.
+// RetryOperation retries the operation with a backoff policy. Errors are
+// classified first: a lost connection is retried once right away before
+// backing off, and fatal errors such as TLS failures stop the retries.
 func RetryOperation(ctx context.Context, wait time.Duration, retries int, operation func() error) error {
 	bo := backoff.WithMaxRetries(
 		backoff.NewConstantBackOff(wait),
 		uint64(retries),
 	)
 	bo = backoff.WithContext(bo, ctx)
-	err := backoff.Retry(operation, bo)
+	err := backoff.Retry(func() error {
+		err := operation()
+		if err != nil && classifyError(err) == errClassRedial {
+			err = operation()
+		}
+		if err != nil && classifyError(err) == errClassFatal {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, bo)
 
 	return err
 }
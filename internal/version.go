@@ -0,0 +1,99 @@
+package quicwire
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Version is the version of quicwire, set at build time with
+// -ldflags "-X github.com/packetdrop/quicwire/internal.Version=v1.2.3"
+var Version = "dev"
+
+// quicGoModule is the module path of quic-go, its version is read from the build info
+const quicGoModule = "github.com/quic-go/quic-go"
+
+// VersionInfo describes the build and runtime of the node
+type VersionInfo struct {
+	Version       string    `json:"version"`
+	GoVersion     string    `json:"goVersion"`
+	QuicGoVersion string    `json:"quicGoVersion,omitempty"`
+	OS            string    `json:"os"`
+	Arch          string    `json:"arch"`
+	StartTime     time.Time `json:"startTime,omitempty"`
+	// Uptime is the time since Start in seconds
+	Uptime float64 `json:"uptime"`
+	// Features are the optional features enabled by the config
+	Features []string `json:"features"`
+}
+
+// buildVersions returns the version of the binary, falling back to the
+// module version when none was set at build time, and the quic-go version
+func buildVersions() (version string, quicGo string) {
+	version = Version
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return version, ""
+	}
+	if version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != quicGoModule {
+			continue
+		}
+		quicGo = dep.Version
+		if dep.Replace != nil && dep.Replace.Version != "" {
+			quicGo = dep.Replace.Version
+		}
+	}
+	return version, quicGo
+}
+
+// VersionInfo returns the build and runtime information of the node
+func (qn *QuicWire) VersionInfo() VersionInfo {
+	version, quicGo := buildVersions()
+	vi := VersionInfo{
+		Version:       version,
+		GoVersion:     runtime.Version(),
+		QuicGoVersion: quicGo,
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		StartTime:     qn.started,
+		Features:      qn.features(),
+	}
+	if !qn.started.IsZero() {
+		vi.Uptime = time.Since(qn.started).Seconds()
+	}
+	return vi
+}
+
+// features lists the optional features enabled by the config
+func (qn *QuicWire) features() []string {
+	ni := qn.qc.nodeInterface
+	features := []string{}
+	add := func(name string, enabled bool) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+	add("client", !qn.disableClient)
+	add("server", !qn.disableServer)
+	add("tap", qn.tapMode())
+	add("relay", ni.relay)
+	add("compression", len(ni.compression) > 0)
+	add("batching", ni.maxBatchBytes > 0)
+	add("egress-queues", ni.egressQueueBytes > 0)
+	add("idle-teardown", qn.idleTeardown())
+	add("masquerade", ni.masqueradeInterface != "")
+	add("announce-routes", len(ni.announceRoutes) > 0)
+	add("accept-announced-routes", ni.acceptAnnouncedRoutes)
+	add("stateless-retry", ni.statelessRetry)
+	add("connection-token", ni.connectionToken != "")
+	add("revocation", qn.revocation != nil)
+	add("mirror", qn.mirror != nil)
+	add("fwmark", ni.fwmark != 0)
+	add("dscp", ni.dscp > 0)
+	add("udp-batching", !ni.noUDPBatching)
+	return features
+}
@@ -0,0 +1,52 @@
+package quicwire
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	Version = "v1.2.3"
+	qn, _ := newTestQuicWire(t)
+	qn.started = time.Now().Add(-time.Minute)
+	qn.qc.nodeInterface.relay = true
+	qn.qc.nodeInterface.egressQueueBytes = 1 << 18
+	srv := httptest.NewServer(qn.controlHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var vi VersionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&vi); err != nil {
+		t.Fatal(err)
+	}
+	if vi.Version != "v1.2.3" {
+		t.Errorf("version = %q, want the one set at build time", vi.Version)
+	}
+	if vi.GoVersion != runtime.Version() || vi.OS != runtime.GOOS || vi.Arch != runtime.GOARCH {
+		t.Errorf("runtime = %s %s/%s, want %s %s/%s", vi.GoVersion, vi.OS, vi.Arch, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	}
+	if vi.QuicGoVersion == "" {
+		t.Error("quic-go version missing")
+	}
+	if !vi.StartTime.Equal(qn.started) || vi.Uptime < 60 {
+		t.Errorf("started %s, up %.0fs, want the start time a minute ago", vi.StartTime, vi.Uptime)
+	}
+	enabled := map[string]bool{}
+	for _, feature := range vi.Features {
+		enabled[feature] = true
+	}
+	for feature, want := range map[string]bool{"relay": true, "egress-queues": true, "tap": false, "batching": false} {
+		if enabled[feature] != want {
+			t.Errorf("feature %s enabled = %v, want %v", feature, enabled[feature], want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package quicwire
+
+import (
+	"sync"
+	"time"
+)
+
+// windowAckTimeout is how long a sender waits for an acknowledgement before
+// it treats the outstanding datagrams as lost and reopens the window
+const windowAckTimeout = 200 * time.Millisecond
+
+// sendWindow caps the number of unacknowledged datagrams in flight to a peer.
+// Datagrams are numbered with application sequence numbers, the receiver
+// acknowledges the highest sequence number it has seen whenever the sender
+// asks for it.
+type sendWindow struct {
+	mu     sync.Mutex
+	max    uint32
+	next   uint32
+	acked  uint32
+	notify chan struct{}
+}
+
+// newSendWindow creates a window allowing max datagrams in flight, 0 disables pacing
+func newSendWindow(max int) *sendWindow {
+	if max < 0 {
+		max = 0
+	}
+	return &sendWindow{
+		max:    uint32(max),
+		next:   1,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// acquire blocks until the window has room for another datagram and returns
+// its sequence number and whether the receiver should acknowledge it
+func (w *sendWindow) acquire() (uint32, bool) {
+	for {
+		w.mu.Lock()
+		inFlight := w.next - 1 - w.acked
+		if w.max == 0 || inFlight < w.max {
+			seq := w.next
+			w.next++
+			ackRequest := w.max > 0 && inFlight+1 >= (w.max+1)/2
+			w.mu.Unlock()
+			return seq, ackRequest
+		}
+		w.mu.Unlock()
+
+		select {
+		case <-w.notify:
+		case <-time.After(windowAckTimeout):
+			// Datagrams are unreliable, assume everything in flight was lost
+			w.mu.Lock()
+			w.acked = w.next - 1
+			w.mu.Unlock()
+		}
+	}
+}
+
+// ack records that the peer received every datagram up to seq
+func (w *sendWindow) ack(seq uint32) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	if int32(seq-w.acked) > 0 && int32(w.next-seq) > 0 {
+		w.acked = seq
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,97 @@
+package quicwire
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// acquireAsync acquires a sequence number of the window in a goroutine
+func acquireAsync(w *sendWindow) <-chan uint32 {
+	acquired := make(chan uint32, 1)
+	go func() {
+		seq, _ := w.acquire()
+		acquired <- seq
+	}()
+	return acquired
+}
+
+func TestSendWindowBlocksAtMax(t *testing.T) {
+	w := newSendWindow(4)
+	var requests int
+	for i := uint32(1); i <= 4; i++ {
+		seq, ackRequest := w.acquire()
+		if seq != i {
+			t.Fatalf("sequence number %d, want %d", seq, i)
+		}
+		if ackRequest {
+			requests++
+		}
+	}
+	// Acknowledgements are asked for once half the window is in flight
+	if requests != 3 {
+		t.Errorf("%d datagrams asked for an acknowledgement, want 3", requests)
+	}
+
+	acquired := acquireAsync(w)
+	select {
+	case seq := <-acquired:
+		t.Fatalf("acquired %d with the window full", seq)
+	case <-time.After(windowAckTimeout / 4):
+	}
+	w.ack(2)
+	select {
+	case seq := <-acquired:
+		if seq != 5 {
+			t.Errorf("sequence number %d after the ack, want 5", seq)
+		}
+	case <-time.After(windowAckTimeout / 2):
+		t.Fatal("window did not reopen on the ack")
+	}
+}
+
+func TestSendWindowReopensAfterAckTimeout(t *testing.T) {
+	w := newSendWindow(2)
+	w.acquire()
+	w.acquire()
+	start := time.Now()
+	acquired := acquireAsync(w)
+	select {
+	case <-acquired:
+		if elapsed := time.Since(start); elapsed < windowAckTimeout {
+			t.Errorf("window reopened after %s without an ack, want %s", elapsed, windowAckTimeout)
+		}
+	case <-time.After(5 * windowAckTimeout):
+		t.Fatal("window did not reopen after the ack timeout")
+	}
+}
+
+func TestSendWindowIgnoresStaleAcks(t *testing.T) {
+	w := newSendWindow(2)
+	w.acquire()
+	w.acquire()
+	w.ack(7) // never sent
+	w.ack(0) // before the window
+	select {
+	case <-acquireAsync(w):
+		t.Fatal("an ack outside of the window reopened it")
+	case <-time.After(windowAckTimeout / 4):
+	}
+}
+
+func TestSendWindowUnlimited(t *testing.T) {
+	w := newSendWindow(0)
+	for i := 0; i < 1000; i++ {
+		if _, ackRequest := w.acquire(); ackRequest {
+			t.Fatal("an unlimited window asked for an acknowledgement")
+		}
+	}
+}
+
+func TestParseConfMaxInFlight(t *testing.T) {
+	var qc QuicConf
+	err := readQuicConfReader(&qc, strings.NewReader("[Peer]\nMaxInFlight = -1\n"), confFormat, defaultConfLimits)
+	if err == nil || !strings.Contains(err.Error(), "MaxInFlight") {
+		t.Errorf("error = %v, want one naming MaxInFlight", err)
+	}
+}